@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/Yusufdot101/goBankBackend/internal/app"
+	"github.com/Yusufdot101/goBankBackend/internal/approval"
 	"github.com/Yusufdot101/goBankBackend/internal/jsonlog"
+	"github.com/Yusufdot101/goBankBackend/internal/metrics"
+	"github.com/Yusufdot101/goBankBackend/internal/mfa"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
+	"github.com/Yusufdot101/goBankBackend/internal/notify"
+	"github.com/Yusufdot101/goBankBackend/internal/token"
+	"github.com/Yusufdot101/goBankBackend/internal/tracing"
 )
 
 // declare the variables. we will use the -X linker flag of the go build to burn-in the
@@ -25,6 +38,7 @@ func main() {
 
 	// create command line flags to customize the application at runtime
 	flag.IntVar(&config.Port, "addr", mustPort(os.Getenv("PORT")), "API server port")
+	flag.IntVar(&config.Admin.Port, "admin-port", 4001, "Port /metrics is served on, 0 to turn it off")
 	flag.Float64Var(&config.DailyInterestRate, "interest-rate", 5, "Bank daily interest rate")
 
 	flag.StringVar(&config.DB.DSN, "db-dsn", "", "PostgreSQL DSN")
@@ -34,6 +48,22 @@ func main() {
 		&config.DB.IdleConnTimout, "db-idle-conn-timout", "15m",
 		"PostgreSQL idle connection timout",
 	)
+	flag.DurationVar(&config.DB.Timeout, "db-timeout", 3*time.Second, "PostgreSQL query timeout")
+
+	flag.StringVar(
+		&config.Tracing.Exporter, "tracing-exporter", tracing.ExporterNone,
+		"Where spans are sent (none|stdout|otlp)",
+	)
+	flag.StringVar(
+		&config.Tracing.Endpoint, "tracing-endpoint", "",
+		"host:port of the OTLP/HTTP collector, defaults to the OTEL_EXPORTER_OTLP_* variables",
+	)
+	flag.BoolVar(
+		&config.Tracing.Insecure, "tracing-insecure", false, "Send to the collector without TLS",
+	)
+	flag.Float64Var(
+		&config.Tracing.SampleRatio, "tracing-sample-ratio", 1, "Share of traces kept, 0 to 1",
+	)
 
 	flag.Float64Var(
 		&config.Limiter.RequestsPerSecond, "limiter-rps", 2,
@@ -42,6 +72,139 @@ func main() {
 	flag.IntVar(&config.Limiter.Burst, "limiter-burst", 4, "Rate limiter burst")
 	flag.BoolVar(&config.Limiter.Enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.DurationVar(
+		&config.Idempotency.TTL, "idempotency-ttl", 24*time.Hour,
+		"How long an Idempotency-Key is remembered for",
+	)
+
+	flag.BoolVar(&config.Accrual.Enabled, "accrual-enabled", true, "Enable the nightly loan accrual")
+	flag.IntVar(&config.Accrual.Hour, "accrual-hour", 1, "Hour of the day (UTC) to run loan accrual")
+	flag.DurationVar(
+		&config.Accrual.RetryInterval, "accrual-retry-interval", 15*time.Minute,
+		"How long to wait before retrying a loan accrual run that failed",
+	)
+	lateFee := flag.String("late-fee", "25.00", "Fee charged once on an overdue installment")
+	flag.IntVar(
+		&config.Accrual.Policy.GraceDays, "late-fee-grace-days", 5,
+		"Days an installment can be overdue before the late fee is charged",
+	)
+	flag.Float64Var(
+		&config.Accrual.Policy.DailyPenaltyRate, "penalty-rate", 0.1,
+		"Daily penalty interest rate on overdue installments",
+	)
+
+	flag.DurationVar(
+		&config.Tokens.AccessTTL, "access-token-ttl", token.DefaultAccessTTL,
+		"How long an access token lasts",
+	)
+	flag.DurationVar(
+		&config.Tokens.RefreshTTL, "refresh-token-ttl", token.DefaultRefreshTTL,
+		"How long a refresh token lasts",
+	)
+
+	flag.IntVar(
+		&config.Lockout.MaxFailures, "lockout-max-failures", 5,
+		"Failed logins that lock an account",
+	)
+	flag.IntVar(
+		&config.Lockout.IPMaxFailures, "lockout-ip-max-failures", 20,
+		"Failed logins that lock an IP",
+	)
+	flag.DurationVar(
+		&config.Lockout.Duration, "lockout-duration", 15*time.Minute,
+		"How long a lockout lasts and failed logins are remembered",
+	)
+	flag.DurationVar(
+		&config.Lockout.BaseDelay, "lockout-base-delay", time.Second,
+		"Wait after the first failed login, doubled after each one",
+	)
+	flag.DurationVar(
+		&config.Lockout.MaxDelay, "lockout-max-delay", 30*time.Second,
+		"Longest wait between failed logins",
+	)
+
+	flag.DurationVar(
+		&config.Approval.TTL, "approval-ttl", 24*time.Hour,
+		"How long an operation waits for a second approval before it expires",
+	)
+	// amounts in a currency missing from a list always need a second approval
+	depositThreshold := flag.String(
+		"approval-deposit-threshold", "USD:10000.00,EUR:10000.00,GBP:10000.00",
+		"Deposits above this, by currency, need a second approval",
+	)
+	loanThreshold := flag.String(
+		"approval-loan-threshold", "USD:10000.00,EUR:10000.00,GBP:10000.00",
+		"Loans above this, by currency, need a second approval to accept",
+	)
+	loanDeletionThreshold := flag.String(
+		"approval-loan-deletion-threshold", "USD:1000.00,EUR:1000.00,GBP:1000.00",
+		"Loans with more than this left, by currency, need a second approval to delete",
+	)
+
+	flag.IntVar(&config.Outbox.Workers, "outbox-workers", 2, "Workers sending queued emails")
+	flag.IntVar(
+		&config.Outbox.Policy.MaxAttempts, "outbox-max-attempts", 8,
+		"Attempts at sending an email before it is dead",
+	)
+	flag.DurationVar(
+		&config.Outbox.Policy.BaseDelay, "outbox-base-delay", 30*time.Second,
+		"Wait after the first failed attempt at sending an email, doubled after each one",
+	)
+	flag.DurationVar(
+		&config.Outbox.Policy.MaxDelay, "outbox-max-delay", time.Hour,
+		"Longest wait between attempts at sending an email",
+	)
+	flag.DurationVar(
+		&config.Outbox.Policy.Lease, "outbox-lease", time.Minute,
+		"How long a worker holds an email it is sending before others can try it",
+	)
+
+	flag.IntVar(&config.Webhooks.Workers, "webhook-workers", 2, "Workers sending webhook deliveries")
+	flag.IntVar(
+		&config.Webhooks.Policy.MaxAttempts, "webhook-max-attempts", 10,
+		"Attempts at a webhook delivery before it is dead",
+	)
+	flag.DurationVar(
+		&config.Webhooks.Policy.BaseDelay, "webhook-base-delay", 30*time.Second,
+		"Wait after the first failed webhook delivery, doubled after each one",
+	)
+	flag.DurationVar(
+		&config.Webhooks.Policy.MaxDelay, "webhook-max-delay", 6*time.Hour,
+		"Longest wait between attempts at a webhook delivery",
+	)
+	flag.DurationVar(
+		&config.Webhooks.Policy.Lease, "webhook-lease", time.Minute,
+		"How long a worker holds a webhook delivery it is sending before others can try it",
+	)
+	flag.DurationVar(
+		&config.Webhooks.Timeout, "webhook-timeout", 10*time.Second,
+		"How long a webhook endpoint has to respond",
+	)
+
+	notifyChannels := flag.String(
+		"notify-channels", "email,in_app",
+		"Channels events are sent on unless routed otherwise (email, sms, in_app, log)",
+	)
+	notifyRoutes := flag.String(
+		"notify-routes", "", "Channels of each event, e.g. DEPOSIT=in_app;TRANSFER_RECEIVED=email,sms",
+	)
+	notifyLogFile := flag.String(
+		"notify-log-file", "", "File the log channel writes notifications to, stdout if empty",
+	)
+	flag.StringVar(&config.Notify.SMS.URL, "sms-gateway-url", "", "HTTP gateway to send texts through")
+	flag.StringVar(&config.Notify.SMS.Token, "sms-gateway-token", "", "Token of the SMS gateway")
+	flag.StringVar(&config.Notify.SMS.Sender, "sms-sender", "goBank", "Who texts are sent from")
+
+	mfaKey := flag.String("mfa-key", "", "Base64 encoded 32 byte key to encrypt TOTP secrets with")
+	flag.StringVar(&config.MFA.Issuer, "mfa-issuer", "goBank", "Name shown in authenticator apps")
+
+	logLevel := flag.String(
+		"log-level", "info", "Least severe level logged (debug|info|warn|error|off)",
+	)
+	logTraceLevel := flag.String(
+		"log-trace-level", "error", "Least severe level logged with a stack trace, off for none",
+	)
+
 	displayVersion := flag.Bool("version", false, "Display application version and exit")
 	flag.Parse()
 
@@ -54,7 +217,92 @@ func main() {
 		os.Exit(0)
 	}
 
-	logger := jsonlog.New(os.Stdout, 0)
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	minLevel, err := jsonlog.ParseLevel(*logLevel)
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("invalid log level: %w", err), nil)
+	}
+	traceLevel, err := jsonlog.ParseLevel(*logTraceLevel)
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("invalid log trace level: %w", err), nil)
+	}
+	logger = jsonlog.New(os.Stdout, minLevel)
+	logger.SetTraceLevel(traceLevel)
+	// libraries logging through slog end up in the same log
+	slog.SetDefault(slog.New(jsonlog.NewHandler(logger)))
+
+	fee, err := money.Parse(*lateFee, money.DefaultCurrency)
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("invalid late fee: %w", err), nil)
+	}
+	config.Accrual.Policy.LateFee = fee
+
+	for _, threshold := range []struct {
+		name  string
+		value string
+		dst   *map[money.Currency]money.Amount
+	}{
+		{"deposit", *depositThreshold, &config.Approval.Thresholds.Deposit},
+		{"loan", *loanThreshold, &config.Approval.Thresholds.LoanApproval},
+		{"loan deletion", *loanDeletionThreshold, &config.Approval.Thresholds.LoanDeletion},
+	} {
+		*threshold.dst, err = approval.ParseThresholds(threshold.value)
+		if err != nil {
+			logger.PrintFatal(fmt.Errorf("invalid %s approval threshold: %w", threshold.name, err), nil)
+		}
+	}
+
+	if *mfaKey == "" {
+		*mfaKey = os.Getenv("MFA_KEY")
+	}
+	config.MFA.Key, err = base64.StdEncoding.DecodeString(*mfaKey)
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("invalid mfa key: %w", err), nil)
+	}
+	if len(config.MFA.Key) == 0 {
+		logger.PrintWarn("no mfa key set, two-factor authentication can't be enabled", nil)
+	} else if len(config.MFA.Key) != 32 {
+		logger.PrintFatal(mfa.ErrNoKey, nil)
+	}
+
+	config.Notify.Default, err = notify.ParseChannels(*notifyChannels)
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("invalid notify channels: %w", err), nil)
+	}
+	config.Notify.Routes, err = notify.ParseRoutes(*notifyRoutes)
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("invalid notify routes: %w", err), nil)
+	}
+	if config.Notify.SMS.Token == "" {
+		config.Notify.SMS.Token = os.Getenv("SMS_GATEWAY_TOKEN")
+	}
+	// an event routed to a channel that isn't set up would fail every time it is sent
+	routes := map[string][]string{"default": config.Notify.Default}
+	for event, channels := range config.Notify.Routes {
+		if !slices.Contains(notification.Events, event) {
+			logger.PrintFatal(fmt.Errorf("invalid notify routes: unknown event %q", event), nil)
+		}
+		routes[event] = channels
+	}
+	for event, channels := range routes {
+		if slices.Contains(channels, notify.ChannelSMS) && config.Notify.SMS.URL == "" {
+			logger.PrintFatal(fmt.Errorf("%s is sent by sms but no sms gateway is set", event), nil)
+		}
+	}
+
+	notifyLog := os.Stdout
+	if *notifyLogFile != "" {
+		notifyLog, err = os.OpenFile(*notifyLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+	config.Notify.Log = notify.NewLog(notifyLog)
+
+	shutdownTracing, err := tracing.Setup(config.Tracing, "gobank", version, os.Stdout)
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("invalid tracing: %w", err), nil)
+	}
 
 	db, err := app.OpenDB(config)
 	if err != nil {
@@ -64,15 +312,24 @@ func main() {
 	logger.PrintInfo("Connection to the database established", nil)
 
 	application := &app.Application{
-		Config: config,
-		Logger: logger,
-		DB:     db,
+		Config:  config,
+		Logger:  logger,
+		DB:      db,
+		Metrics: metrics.New(db),
 	}
 
 	err = application.Serve()
 	if err != nil {
 		application.Logger.PrintFatal(err, nil)
 	}
+
+	// send the spans that are still waiting before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = shutdownTracing(ctx)
+	if err != nil {
+		application.Logger.PrintError(err, nil)
+	}
 }
 
 func mustPort(port string) int {
@@ -0,0 +1,78 @@
+// verify-audit walks the audit log from its first event and checks that none of them were changed,
+// removed or put in since they were recorded. it exits with 1 if one was, and prints the hash of
+// the last event, the head, to keep somewhere else to compare against the next time
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/app"
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/jsonlog"
+)
+
+func main() {
+	var config app.Config
+
+	flag.StringVar(&config.DB.DSN, "db-dsn", "", "PostgreSQL DSN")
+	flag.DurationVar(&config.DB.Timeout, "db-timeout", 3*time.Second, "PostgreSQL query timeout")
+	batchSize := flag.Int("batch-size", 500, "Events read from the database at a time")
+	head := flag.String("head", "", "Head printed by an earlier run, to check it is still there")
+	flag.Parse()
+
+	if config.DB.DSN == "" {
+		config.DB.DSN = os.Getenv("DB_DSN")
+	}
+	config.DB.MaxOpenConns = 1
+	config.DB.MaxIdleConns = 1
+	config.DB.IdleConnTimout = "1m"
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	db, err := app.OpenDB(config)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	auditService := audit.Service{
+		Repo: &audit.Repository{DB: db, Timeout: config.DB.Timeout},
+	}
+	report, err := auditService.Verify(context.Background(), *batchSize)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	properties := map[string]string{
+		"checked": strconv.Itoa(report.Checked),
+		"head":    report.Head,
+	}
+	if !report.OK() {
+		properties["broken_at"] = strconv.FormatInt(report.BrokenAt, 10)
+		logger.PrintFatal(
+			fmt.Errorf("event %d %s", report.BrokenAt, report.Reason), properties,
+		)
+	}
+
+	// the chain checks out, but events removed from its end leave nothing behind to say so. the
+	// head of an earlier run has to still be in it
+	if *head != "" {
+		found, err := auditService.HasHash(context.Background(), *head)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		if !found {
+			logger.PrintFatal(
+				errors.New("the head of the earlier run is gone, events were removed"), properties,
+			)
+		}
+	}
+
+	logger.PrintInfo("the audit log checks out", properties)
+}
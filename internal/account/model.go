@@ -0,0 +1,122 @@
+package account
+
+import (
+	"crypto/rand"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// the kinds of accounts a user can open
+const (
+	TypeCurrent        = "CURRENT"
+	TypeSavings        = "SAVINGS"
+	TypeLoanSettlement = "LOAN_SETTLEMENT"
+)
+
+// money can only move in and out of active accounts, frozen accounts can be made active again while
+// closed ones can't
+const (
+	StatusActive = "ACTIVE"
+	StatusFrozen = "FROZEN"
+	StatusClosed = "CLOSED"
+)
+
+// SupportedCurrencies are the currencies accounts can be opened in
+var SupportedCurrencies = []money.Currency{"USD", "EUR", "GBP"}
+
+// an account number is 9 random digits followed by a Luhn check digit, so that most typos are
+// caught before any money is sent anywhere
+const (
+	numberLength = 10
+	baseLength   = numberLength - 1
+)
+
+type Account struct {
+	ID        int64          `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UserID    int64          `json:"user_id"`
+	Number    string         `json:"number"`
+	Type      string         `json:"type"`
+	Currency  money.Currency `json:"currency"`
+	Status    string         `json:"status"`
+	Balance   money.Amount   `json:"balance"`
+	Version   int32          `json:"version"`
+}
+
+func (a *Account) IsActive() bool {
+	return a.Status == StatusActive
+}
+
+// GenerateNumber returns a new random account number. the first digit is never 0, numbers starting
+// with 0 were given to the accounts created for existing users when accounts were introduced
+func GenerateNumber() (string, error) {
+	digits := make([]byte, baseLength, numberLength)
+	for i := range digits {
+		lowest := int64(0)
+		if i == 0 {
+			lowest = 1
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(10-lowest))
+		if err != nil {
+			return "", err
+		}
+		digits[i] = byte('0' + lowest + n.Int64())
+	}
+
+	return string(append(digits, checkDigit(string(digits)))), nil
+}
+
+// checkDigit returns the Luhn check digit for the digits
+func checkDigit(digits string) byte {
+	sum := 0
+	// going from the right, every other digit starting with the last one is doubled, because the
+	// check digit will take the rightmost place
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-1-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+// ValidNumber reports whether the number is well formed and its check digit matches
+func ValidNumber(number string) bool {
+	if len(number) != numberLength {
+		return false
+	}
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return checkDigit(number[:baseLength]) == number[baseLength]
+}
+
+func ValidateNumber(v *validator.Validator, number string) {
+	v.CheckAddError(number != "", "account number", "must be given")
+	v.CheckAddError(ValidNumber(number), "account number", "invalid")
+}
+
+func ValidateAccount(v *validator.Validator, account *Account) {
+	safeTypes := []string{TypeCurrent, TypeSavings, TypeLoanSettlement}
+	v.CheckAddError(validator.ValueInList(account.Type, safeTypes...), "type", "invalid")
+
+	v.CheckAddError(
+		slices.Contains(SupportedCurrencies, account.Currency), "currency", "not supported",
+	)
+
+	safeStatuses := []string{StatusActive, StatusFrozen, StatusClosed}
+	v.CheckAddError(validator.ValueInList(account.Status, safeStatuses...), "status", "invalid")
+}
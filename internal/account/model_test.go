@@ -0,0 +1,94 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestGenerateNumber(t *testing.T) {
+	for range 100 {
+		number, err := GenerateNumber()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		if !ValidNumber(number) {
+			t.Fatalf("generated number %s is not valid", number)
+		}
+		if number[0] == '0' {
+			t.Fatalf("generated number %s starts with 0", number)
+		}
+	}
+}
+
+func TestValidNumber(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   bool
+	}{
+		{name: "valid", number: "0000000018", want: true},
+		{name: "valid random", number: "7992739875", want: true},
+		{name: "wrong check digit", number: "7992739872", want: false},
+		{name: "swapped digits", number: "9792739875", want: false},
+		{name: "too short", number: "799273987", want: false},
+		{name: "not digits", number: "79927398a1", want: false},
+		{name: "empty", number: "", want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ValidNumber(tc.number); got != tc.want {
+				t.Errorf("expected valid=%v for %s, got valid=%v", tc.want, tc.number, got)
+			}
+		})
+	}
+}
+
+func TestValidateAccount(t *testing.T) {
+	tests := []struct {
+		name    string
+		account *Account
+		wantErr string
+	}{
+		{
+			name: "valid",
+			account: &Account{
+				Type: TypeSavings, Currency: "EUR", Status: StatusActive,
+			},
+		},
+		{
+			name: "invalid type",
+			account: &Account{
+				Type: "CHEQUE", Currency: "USD", Status: StatusActive,
+			},
+			wantErr: "type",
+		},
+		{
+			name: "unsupported currency",
+			account: &Account{
+				Type: TypeCurrent, Currency: "XYZ", Status: StatusActive,
+			},
+			wantErr: "currency",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateAccount(v, tc.account)
+
+			if tc.wantErr == "" {
+				if !v.IsValid() {
+					t.Fatalf("unexpected errors %v", v.Errors)
+				}
+				return
+			}
+
+			if _, ok := v.Errors[tc.wantErr]; !ok {
+				t.Fatalf("expected error for %s, got %v", tc.wantErr, v.Errors)
+			}
+		})
+	}
+}
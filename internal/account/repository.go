@@ -0,0 +1,189 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+)
+
+var ErrDuplicateNumber = errors.New("duplicate account number")
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (r *Repository) Insert(ctx context.Context, account *Account) error {
+	query := `
+		INSERT INTO accounts (user_id, number, type, currency, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, balance, version
+	`
+	args := []any{
+		account.UserID,
+		account.Number,
+		account.Type,
+		account.Currency,
+		account.Status,
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(
+		&account.ID,
+		&account.CreatedAt,
+		&account.Balance,
+		&account.Version,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "accounts_number_key"`:
+			return ErrDuplicateNumber
+		default:
+			return err
+		}
+	}
+
+	account.Balance = account.Balance.WithCurrency(account.Currency)
+	return nil
+}
+
+func (r *Repository) Get(ctx context.Context, accountID int64) (*Account, error) {
+	query := `
+		SELECT id, created_at, user_id, number, type, currency, status, balance, version
+		FROM accounts
+		WHERE id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	return scanAccount(r.DB.QueryRowContext(ctx, query, accountID))
+}
+
+func (r *Repository) GetByNumber(ctx context.Context, number string) (*Account, error) {
+	query := `
+		SELECT id, created_at, user_id, number, type, currency, status, balance, version
+		FROM accounts
+		WHERE number = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	return scanAccount(r.DB.QueryRowContext(ctx, query, number))
+}
+
+func (r *Repository) GetAllForUser(ctx context.Context, userID int64) ([]*Account, error) {
+	query := `
+		SELECT id, created_at, user_id, number, type, currency, status, balance, version
+		FROM accounts
+		WHERE user_id = $1
+		ORDER BY id
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// UpdatedFunc is work done inside the transaction that changes an account, with the account as it
+// was updated, before it is committed
+type UpdatedFunc func(ctx context.Context, tx *sql.Tx, account *Account) error
+
+// UpdateStatus sets the status of the account, then runs then in the same transaction if it is
+// given. the rules the service checks are repeated in the query so that they still hold if the
+// account changes in between: a closed account stays closed, and only an empty account can be
+// closed. ErrNoRecord is returned if either would be broken
+func (r *Repository) UpdateStatus(
+	ctx context.Context, accountID int64, status string, then UpdatedFunc,
+) (*Account, error) {
+	query := `
+		UPDATE accounts
+		SET status = $1, version = version + 1
+		WHERE id = $2 AND status <> $3 AND ($1 <> $3 OR balance = 0)
+		RETURNING id, created_at, user_id, number, type, currency, status, balance, version
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	account, err := scanAccount(tx.QueryRowContext(ctx, query, status, accountID, StatusClosed))
+	if err != nil {
+		return nil, err
+	}
+
+	if then != nil {
+		err = then(ctx, tx, account)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// scanAccount reads an account from a row of any of the queries above, they all select the same
+// columns in the same order
+func scanAccount(row interface{ Scan(dest ...any) error }) (*Account, error) {
+	var account Account
+	err := row.Scan(
+		&account.ID,
+		&account.CreatedAt,
+		&account.UserID,
+		&account.Number,
+		&account.Type,
+		&account.Currency,
+		&account.Status,
+		&account.Balance,
+		&account.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	// the balance column doesn't carry the currency, the account does
+	account.Balance = account.Balance.WithCurrency(account.Currency)
+	return &account, nil
+}
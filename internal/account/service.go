@@ -0,0 +1,161 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/account")
+
+type Repo interface {
+	Insert(ctx context.Context, account *Account) error
+	Get(ctx context.Context, accountID int64) (*Account, error)
+	GetByNumber(ctx context.Context, number string) (*Account, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*Account, error)
+	UpdateStatus(
+		ctx context.Context, accountID int64, status string, then UpdatedFunc,
+	) (*Account, error)
+}
+
+// Auditor records who froze, unfroze or closed an account, in the transaction that does it
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
+}
+
+type Service struct {
+	Repo    Repo
+	Auditor Auditor // optional
+}
+
+// Open creates a new active account for the user with a freshly generated number
+func (s *Service) Open(
+	ctx context.Context, v *validator.Validator, userID int64, accountType string,
+	currency money.Currency,
+) (*Account, error) {
+	ctx, span := tracer.Start(ctx, "account.Open")
+	defer span.End()
+
+	account := &Account{
+		UserID:   userID,
+		Type:     accountType,
+		Currency: currency,
+		Status:   StatusActive,
+	}
+	if ValidateAccount(v, account); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
+	}
+
+	// numbers are random, so one could already be taken. that is rare enough that a few tries
+	// will always do
+	var err error
+	for range 5 {
+		account.Number, err = GenerateNumber()
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.Repo.Insert(ctx, account)
+		if !errors.Is(err, ErrDuplicateNumber) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func (s *Service) GetAccount(ctx context.Context, accountID int64) (*Account, error) {
+	ctx, span := tracer.Start(ctx, "account.GetAccount")
+	defer span.End()
+
+	return s.Repo.Get(ctx, accountID)
+}
+
+func (s *Service) GetAccountByNumber(
+	ctx context.Context, v *validator.Validator, number string,
+) (*Account, error) {
+	ctx, span := tracer.Start(ctx, "account.GetAccountByNumber")
+	defer span.End()
+
+	if ValidateNumber(v, number); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetByNumber(ctx, number)
+}
+
+// GetUserAccount returns the account with the number only if it belongs to the user. someone else's
+// account is reported as not found, so that users can't find out which numbers are in use
+func (s *Service) GetUserAccount(
+	ctx context.Context, v *validator.Validator, userID int64, number string,
+) (*Account, error) {
+	ctx, span := tracer.Start(ctx, "account.GetUserAccount")
+	defer span.End()
+
+	account, err := s.GetAccountByNumber(ctx, v, number)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.UserID != userID {
+		return nil, user.ErrNoRecord
+	}
+
+	return account, nil
+}
+
+func (s *Service) GetAllForUser(ctx context.Context, userID int64) ([]*Account, error) {
+	ctx, span := tracer.Start(ctx, "account.GetAllForUser")
+	defer span.End()
+
+	return s.Repo.GetAllForUser(ctx, userID)
+}
+
+// UpdateStatus freezes, unfreezes or closes the account with the number
+func (s *Service) UpdateStatus(
+	ctx context.Context, v *validator.Validator, number, status string,
+) (*Account, error) {
+	ctx, span := tracer.Start(ctx, "account.UpdateStatus")
+	defer span.End()
+
+	account, err := s.GetAccountByNumber(ctx, v, number)
+	if err != nil {
+		return nil, err
+	}
+
+	v.CheckAddError(account.Status != StatusClosed, "status", "closed accounts cannot be changed")
+
+	before := *account
+	account.Status = status
+	ValidateAccount(v, account)
+	v.CheckAddError(
+		account.Status != StatusClosed || account.Balance.IsZero(), "balance",
+		"must be 0 to close the account",
+	)
+	if !v.IsValid() {
+		return nil, validator.ErrFailedValidation
+	}
+
+	var updated UpdatedFunc
+	if s.Auditor != nil {
+		updated = func(ctx context.Context, tx *sql.Tx, account *Account) error {
+			return s.Auditor.RecordTx(
+				ctx, tx, audit.ActionAccountStatus, audit.TargetAccount, account.Number, before,
+				account,
+			)
+		}
+	}
+
+	return s.Repo.UpdateStatus(ctx, account.ID, status, updated)
+}
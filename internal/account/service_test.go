@@ -0,0 +1,302 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+type MockRepo struct {
+	// InsertErrs are returned by the calls to Insert in order, nil once they run out
+	InsertErrs  []error
+	InsertCalls int
+
+	GetByNumberResult *Account
+	GetByNumberErr    error
+
+	UpdateStatusErr error
+}
+
+func (r *MockRepo) Insert(ctx context.Context, account *Account) error {
+	r.InsertCalls++
+	if len(r.InsertErrs) == 0 {
+		return nil
+	}
+
+	err := r.InsertErrs[0]
+	r.InsertErrs = r.InsertErrs[1:]
+	return err
+}
+
+func (r *MockRepo) Get(ctx context.Context, accountID int64) (*Account, error) {
+	return nil, nil
+}
+
+func (r *MockRepo) GetByNumber(ctx context.Context, number string) (*Account, error) {
+	if r.GetByNumberErr != nil {
+		return nil, r.GetByNumberErr
+	}
+	return r.GetByNumberResult, nil
+}
+
+func (r *MockRepo) GetAllForUser(ctx context.Context, userID int64) ([]*Account, error) {
+	return nil, nil
+}
+
+func (r *MockRepo) UpdateStatus(
+	ctx context.Context, accountID int64, status string, then UpdatedFunc,
+) (*Account, error) {
+	if r.UpdateStatusErr != nil {
+		return nil, r.UpdateStatusErr
+	}
+	account := &Account{ID: accountID, Status: status}
+	if then != nil {
+		err := then(ctx, nil, account)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return account, nil
+}
+
+var errAuditLog = errors.New("audit log unavailable")
+
+// MockAuditor records the actions audited
+type MockAuditor struct {
+	Actions   []string
+	Before    []any
+	RecordErr error
+}
+
+func (a *MockAuditor) RecordTx(
+	ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+) error {
+	if a.RecordErr != nil {
+		return a.RecordErr
+	}
+	a.Actions = append(a.Actions, action)
+	a.Before = append(a.Before, before)
+	return nil
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name        string
+		accountType string
+		currency    money.Currency
+		setupRepo   func(*MockRepo)
+		wantInserts int
+		expectedErr error
+	}{
+		{
+			name:        "valid",
+			accountType: TypeCurrent,
+			currency:    "USD",
+			setupRepo:   func(r *MockRepo) {},
+			wantInserts: 1,
+		},
+		{
+			name:        "number taken",
+			accountType: TypeSavings,
+			currency:    "EUR",
+			setupRepo: func(r *MockRepo) {
+				r.InsertErrs = []error{ErrDuplicateNumber, ErrDuplicateNumber}
+			},
+			wantInserts: 3,
+		},
+		{
+			name:        "invalid type",
+			accountType: "CHEQUE",
+			currency:    "USD",
+			setupRepo:   func(r *MockRepo) {},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:        "Insert failure",
+			accountType: TypeCurrent,
+			currency:    "USD",
+			setupRepo: func(r *MockRepo) {
+				r.InsertErrs = []error{errors.New("db Insert error")}
+			},
+			expectedErr: errors.New("db Insert error"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			svc := Service{Repo: repo}
+
+			account, gotErr := svc.Open(
+				context.Background(), validator.New(), 1, tc.accountType, tc.currency,
+			)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error %v", gotErr)
+			}
+
+			if repo.InsertCalls != tc.wantInserts {
+				t.Errorf("expected %d inserts, got %d", tc.wantInserts, repo.InsertCalls)
+			}
+			if !ValidNumber(account.Number) {
+				t.Errorf("expected a valid account number, got %s", account.Number)
+			}
+			if account.Status != StatusActive {
+				t.Errorf("expected status %s, got %s", StatusActive, account.Status)
+			}
+		})
+	}
+}
+
+func TestGetUserAccount(t *testing.T) {
+	tests := []struct {
+		name        string
+		number      string
+		setupRepo   func(*MockRepo)
+		expectedErr error
+	}{
+		{
+			name:   "own account",
+			number: "0000000018",
+			setupRepo: func(r *MockRepo) {
+				r.GetByNumberResult = &Account{ID: 1, UserID: 1}
+			},
+		},
+		{
+			name:   "someone else's account",
+			number: "0000000018",
+			setupRepo: func(r *MockRepo) {
+				r.GetByNumberResult = &Account{ID: 2, UserID: 2}
+			},
+			expectedErr: user.ErrNoRecord,
+		},
+		{
+			name:        "invalid number",
+			number:      "0000000019",
+			setupRepo:   func(r *MockRepo) {},
+			expectedErr: validator.ErrFailedValidation,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			svc := Service{Repo: repo}
+
+			_, gotErr := svc.GetUserAccount(context.Background(), validator.New(), 1, tc.number)
+			if !errors.Is(gotErr, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+			}
+		})
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		setupRepo   func(*MockRepo)
+		recordErr   error
+		expectedErr error
+	}{
+		{
+			name:   "freeze",
+			status: StatusFrozen,
+			setupRepo: func(r *MockRepo) {
+				r.GetByNumberResult = &Account{
+					Type: TypeCurrent, Currency: "USD", Status: StatusActive,
+					Balance: money.MustParse("10"),
+				}
+			},
+		},
+		{
+			// the status change is rolled back with the event that couldn't be appended
+			name:   "audit failure",
+			status: StatusFrozen,
+			setupRepo: func(r *MockRepo) {
+				r.GetByNumberResult = &Account{
+					Type: TypeCurrent, Currency: "USD", Status: StatusActive,
+				}
+			},
+			recordErr:   errAuditLog,
+			expectedErr: errAuditLog,
+		},
+		{
+			name:   "close with money left",
+			status: StatusClosed,
+			setupRepo: func(r *MockRepo) {
+				r.GetByNumberResult = &Account{
+					Type: TypeCurrent, Currency: "USD", Status: StatusActive,
+					Balance: money.MustParse("10"),
+				}
+			},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:   "reopen closed account",
+			status: StatusActive,
+			setupRepo: func(r *MockRepo) {
+				r.GetByNumberResult = &Account{
+					Type: TypeCurrent, Currency: "USD", Status: StatusClosed,
+				}
+			},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:   "invalid status",
+			status: "DORMANT",
+			setupRepo: func(r *MockRepo) {
+				r.GetByNumberResult = &Account{
+					Type: TypeCurrent, Currency: "USD", Status: StatusActive,
+				}
+			},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:   "account not found",
+			status: StatusFrozen,
+			setupRepo: func(r *MockRepo) {
+				r.GetByNumberErr = user.ErrNoRecord
+			},
+			expectedErr: user.ErrNoRecord,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			auditor := &MockAuditor{RecordErr: tc.recordErr}
+			svc := Service{Repo: repo, Auditor: auditor}
+
+			account, gotErr := svc.UpdateStatus(
+				context.Background(), validator.New(), "0000000018", tc.status,
+			)
+			if !errors.Is(gotErr, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+			}
+			if gotErr != nil {
+				return
+			}
+
+			if account.Status != tc.status {
+				t.Errorf("expected status %s, got %s", tc.status, account.Status)
+			}
+			// what the account looked like is kept, not the status it was changed to
+			if len(auditor.Actions) != 1 || auditor.Before[0].(Account).Status != StatusActive {
+				t.Errorf("expected the change audited from ACTIVE, got %+v", auditor)
+			}
+		})
+	}
+}
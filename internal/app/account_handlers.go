@@ -0,0 +1,113 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func (app *Application) OpenAccount(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Type     string         `json:"type"`
+		Currency money.Currency `json:"currency"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	accountService := account.Service{
+		Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	u := app.getUserContext(r)
+	a, err := accountService.Open(r.Context(), v, u.ID, input.Type, input.Currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusCreated, jsonutil.Envelope{
+		"message": "account opened successfully",
+		"account": a,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+func (app *Application) ListAccounts(w http.ResponseWriter, r *http.Request) {
+	accountService := account.Service{
+		Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	u := app.getUserContext(r)
+	accounts, err := accountService.GetAllForUser(r.Context(), u.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"accounts": accounts,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// UpdateAccountStatus freezes, unfreezes or closes an account
+func (app *Application) UpdateAccountStatus(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	accountService := account.Service{
+		Repo:    &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+
+	v := validator.New()
+	a, err := accountService.UpdateStatus(r.Context(), v, app.readNumberParam(r), input.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message": "account status updated successfully",
+		"account": a,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
@@ -6,8 +6,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/XSAM/otelsql"
+	"github.com/Yusufdot101/goBankBackend/internal/approval"
 	"github.com/Yusufdot101/goBankBackend/internal/jsonlog"
+	"github.com/Yusufdot101/goBankBackend/internal/loan"
+	"github.com/Yusufdot101/goBankBackend/internal/lockout"
+	"github.com/Yusufdot101/goBankBackend/internal/metrics"
+	"github.com/Yusufdot101/goBankBackend/internal/notify"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+	"github.com/Yusufdot101/goBankBackend/internal/tracing"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
 )
 
 type Config struct {
@@ -20,12 +29,59 @@ type Config struct {
 		MaxOpenConns   int
 		MaxIdleConns   int
 		IdleConnTimout string
+		Timeout        time.Duration // how long a query can take
 	}
 	Limiter struct {
 		Enabled           bool
 		RequestsPerSecond float64
 		Burst             int
 	}
+	Idempotency struct {
+		TTL time.Duration
+	}
+	Tokens struct {
+		AccessTTL  time.Duration
+		RefreshTTL time.Duration
+	}
+	Lockout  lockout.Policy
+	Approval struct {
+		TTL        time.Duration // how long an operation waits for a second approval
+		Thresholds approval.Thresholds
+	}
+	MFA struct {
+		Key    []byte // the AES-256 key TOTP secrets are encrypted with
+		Issuer string
+	}
+	Accrual struct {
+		Enabled bool
+		Hour    int // the hour of the day, in UTC, the nightly accrual runs at
+		// RetryInterval is how long after a run that didn't succeed it is tried again
+		RetryInterval time.Duration
+		Policy        loan.Policy
+	}
+	Outbox struct {
+		Workers int // how many workers send queued emails at once
+		Policy  outbox.Policy
+	}
+	Webhooks struct {
+		Workers int // how many workers send webhook deliveries at once
+		Policy  outbox.Policy
+		Timeout time.Duration // how long an endpoint has to respond
+	}
+	Notify struct {
+		Default []string // the channels of events that aren't in Routes
+		Routes  notify.Routes
+		SMS     struct {
+			URL    string // the HTTP gateway texts are sent through, SMS is off without one
+			Token  string
+			Sender string
+		}
+		Log *notify.Log // where the log channel writes to
+	}
+	Tracing tracing.Config
+	Admin   struct {
+		Port int // where /metrics is served, kept off the public port. 0 turns it off
+	}
 	SMTP struct {
 		Host     string
 		Port     int
@@ -36,14 +92,20 @@ type Config struct {
 }
 
 type Application struct {
-	Config Config
-	Logger *jsonlog.Logger
-	DB     *sql.DB
-	wg     sync.WaitGroup
+	Config  Config
+	Logger  *jsonlog.Logger
+	DB      *sql.DB
+	Metrics *metrics.Metrics // nil records nothing
+	wg      sync.WaitGroup
 }
 
 func OpenDB(cfg Config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.DB.DSN)
+	// every query made through the pool is traced, as a child of the span of the context it's given
+	db, err := otelsql.Open(
+		"postgres", cfg.DB.DSN,
+		otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -1,10 +1,15 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/tomasen/realip"
 )
 
 // LogError uses the app's loggger to log the error for debugging
@@ -12,6 +17,39 @@ func (app *Application) LogError(err error) {
 	app.Logger.PrintError(err, nil)
 }
 
+// logRequestError logs the error along with the request it happened during
+func (app *Application) logRequestError(r *http.Request, err error) {
+	app.Logger.PrintError(err, app.requestProperties(r))
+}
+
+// requestProperties returns what is logged about the request, the route and user only once the
+// request has been through instrument and authenticate
+func (app *Application) requestProperties(r *http.Request) map[string]string {
+	properties := app.contextProperties(r.Context())
+	properties["method"] = r.Method
+	properties["path"] = r.URL.Path
+	properties["ip"] = realip.FromRequest(r)
+
+	return properties
+}
+
+// contextProperties returns what is logged about the request ctx came from, for what is done
+// during a request without the request itself at hand. it's empty for ctx of no request
+func (app *Application) contextProperties(ctx context.Context) map[string]string {
+	properties := map[string]string{}
+	if requestID, _ := ctx.Value(requestIDContextKey).(string); requestID != "" {
+		properties["request_id"] = requestID
+	}
+	if log, _ := ctx.Value(requestLogContextKey).(*requestLog); log != nil {
+		properties["route"] = log.route
+		if log.userID != 0 {
+			properties["user_id"] = strconv.FormatInt(log.userID, 10)
+		}
+	}
+
+	return properties
+}
+
 // ErrorResponse is a function that writes an error to the response using WriteJSON
 func (app *Application) ErrorResponse(w http.ResponseWriter, statusCode int, message any) {
 	err := jsonutil.WriteJSON(w, statusCode, jsonutil.Envelope{"error": message})
@@ -22,7 +60,7 @@ func (app *Application) ErrorResponse(w http.ResponseWriter, statusCode int, mes
 
 // ServerError is for errors that aren't caused by the client
 func (app *Application) ServerError(w http.ResponseWriter, r *http.Request, err error) {
-	app.LogError(err)
+	app.logRequestError(r, err)
 
 	message := "the server encountered and error and could not resolve your request"
 	app.ErrorResponse(w, http.StatusInternalServerError, message)
@@ -46,6 +84,12 @@ func (app *Application) FailedValidationResponse(w http.ResponseWriter, err map[
 	app.ErrorResponse(w, http.StatusBadRequest, err)
 }
 
+// UnprocessableEntityResponse is for requests that are well formed but ask for something that
+// can't be done, like responding to a loan request with a status it can't be given
+func (app *Application) UnprocessableEntityResponse(w http.ResponseWriter, err map[string]string) {
+	app.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+}
+
 func (app *Application) InvalidCredentialsResponse(w http.ResponseWriter) {
 	message := "invaild credentials"
 	app.ErrorResponse(w, http.StatusBadRequest, message)
@@ -84,3 +128,26 @@ func (app *Application) RequirePermissionResponse(w http.ResponseWriter) {
 	message := "You do not have the necessary permission to access this resource"
 	app.ErrorResponse(w, http.StatusForbidden, message)
 }
+
+// LockedOutResponse is for logins held up by earlier failed ones, Retry-After says for how long
+func (app *Application) LockedOutResponse(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	message := "too many failed login attempts, please try again later"
+	app.ErrorResponse(w, http.StatusTooManyRequests, message)
+}
+
+func (app *Application) RequireMFAResponse(w http.ResponseWriter) {
+	message := "you need to enable two-factor authentication to access this resource"
+	app.ErrorResponse(w, http.StatusForbidden, message)
+}
+
+func (app *Application) IdempotencyKeyReusedResponse(w http.ResponseWriter) {
+	message := "the idempotency key was already used for a different request"
+	app.ErrorResponse(w, http.StatusUnprocessableEntity, message)
+}
+
+func (app *Application) IdempotencyKeyInProgressResponse(w http.ResponseWriter) {
+	message := "a request with this idempotency key is still being processed, try again later"
+	app.ErrorResponse(w, http.StatusConflict, message)
+}
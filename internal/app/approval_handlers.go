@@ -0,0 +1,265 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Yusufdot101/goBankBackend/internal/approval"
+	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/loanrequests"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/permission"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// approvalCodes are the permissions needed to approve each kind of operation, the same ones needed
+// to ask for it
+var approvalCodes = map[string][]string{
+	approval.KindDeposit:      {"DEPOSIT", "ADMIN", "SUPERUSER"},
+	approval.KindLoanApproval: {"APPROVE_LOANS", "ADMIN", "SUPERUSER"},
+	approval.KindLoanDeletion: {"DELETE_LOANS", "ADMIN", "SUPERUSER"},
+}
+
+// submitOperation holds the operation over its threshold for a second approval, instead of
+// carrying it out, and tells the user so
+func (app *Application) submitOperation(
+	w http.ResponseWriter, r *http.Request, kind string, amount money.Amount, payload any,
+) {
+	approvalService := approval.Service{
+		Repo:    &approval.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		TTL:     app.Config.Approval.TTL,
+		Auditor: app.auditor(r),
+	}
+
+	v := validator.New()
+	op, err := approvalService.Submit(r.Context(), v, kind, payload, amount, app.getUserContext(r).ID)
+	if err != nil {
+		// submitting is a single transaction, so nothing was submitted when it fails
+		app.nothingCommitted(r)
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusAccepted, jsonutil.Envelope{
+		"message":   "this needs a second approval, it will be carried out once someone else approves it",
+		"operation": op,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// operationErrorResponse responds to an error carrying out one of the operations that can need
+// approval
+func (app *Application) operationErrorResponse(
+	w http.ResponseWriter, r *http.Request, v *validator.Validator, err error,
+) {
+	switch {
+	case errors.Is(err, validator.ErrFailedValidation):
+		app.FailedValidationResponse(w, v.Errors)
+
+	case errors.Is(err, user.ErrNoRecord):
+		app.NotFoundResponse(w, r)
+
+	case errors.Is(err, loanrequests.ErrNotPending):
+		app.ErrorResponse(w, http.StatusConflict, err.Error())
+
+	default:
+		app.ServerError(w, r, err)
+	}
+}
+
+// runOperation carries out an approved operation as it was asked for, by the approver making the
+// request r. deposits are held to the limit of the approver, who is vouching for them
+func (app *Application) runOperation(
+	r *http.Request, v *validator.Validator, op *approval.Operation, scope *permission.Scope,
+) (any, error) {
+	switch op.Kind {
+	case approval.KindDeposit:
+		var input depositInput
+		if err := json.Unmarshal(op.Payload, &input); err != nil {
+			return nil, err
+		}
+		return app.deposit(r, v, input, scope.MaxAmounts)
+
+	case approval.KindLoanApproval:
+		var input loanResponseInput
+		if err := json.Unmarshal(op.Payload, &input); err != nil {
+			return nil, err
+		}
+		loanRequest, _, err := app.respondToLoanRequest(r, v, input)
+		return loanRequest, err
+
+	case approval.KindLoanDeletion:
+		var input loanDeletionInput
+		if err := json.Unmarshal(op.Payload, &input); err != nil {
+			return nil, err
+		}
+		return app.deleteLoan(r, v, input, op.RequestedBy)
+
+	default:
+		return nil, fmt.Errorf("unknown operation kind %q", op.Kind)
+	}
+}
+
+// ListApprovals returns a page of the operations held for approval, the pending ones unless asked
+// for another status
+func (app *Application) ListApprovals(w http.ResponseWriter, r *http.Request) {
+	approvalService := approval.Service{
+		Repo: &approval.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	f := app.readFilters(qs, approval.SortSafelist, v)
+	status := app.readString(qs, "status", approval.StatusPending)
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	operations, metadata, err := approvalService.GetAll(r.Context(), v, status, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"operations": operations,
+		"metadata":   metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// decideOperation approves or rejects the operation in the url for the user, who needs the same
+// permissions as whoever asked for it. the operation is returned with the scope the user has them
+// in, or nil if a response was already written
+func (app *Application) decideOperation(
+	w http.ResponseWriter, r *http.Request, approve bool,
+) (*approval.Operation, *permission.Scope) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return nil, nil
+	}
+
+	approvalService := approval.Service{
+		Repo:    &approval.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+	op, err := approvalService.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return nil, nil
+	}
+
+	u := app.getUserContext(r)
+	permissionService := permission.Service{
+		Repo: &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+	scope, err := permissionService.UserScope(
+		r.Context(), validator.New(), u, approvalCodes[op.Kind]...,
+	)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil, nil
+	}
+	if scope == nil {
+		app.RequirePermissionResponse(w)
+		return nil, nil
+	}
+
+	if approve {
+		op, err = approvalService.Approve(r.Context(), id, u.ID)
+	} else {
+		op, err = approvalService.Reject(r.Context(), id, u.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, approval.ErrSameUser):
+			app.ErrorResponse(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, approval.ErrNotPending):
+			app.ErrorResponse(w, http.StatusConflict, err.Error())
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return nil, nil
+	}
+	return op, scope
+}
+
+// ApproveOperation approves an operation held for a second approval and carries it out. the
+// approver can't be the user who asked for it
+func (app *Application) ApproveOperation(w http.ResponseWriter, r *http.Request) {
+	op, scope := app.decideOperation(w, r, true)
+	if op == nil {
+		return
+	}
+
+	v := validator.New()
+	result, err := app.runOperation(r, v, op, scope)
+	if err != nil {
+		// the operation was approved but couldn't be carried out, it's kept with the reason
+		cause := err
+		if errors.Is(err, validator.ErrFailedValidation) {
+			cause = fmt.Errorf("%w: %v", err, v.Errors)
+		}
+		approvalService := approval.Service{
+			Repo: &approval.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		}
+		if failErr := approvalService.Fail(r.Context(), op, cause); failErr != nil {
+			app.logRequestError(r, failErr)
+		}
+
+		app.operationErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message":   "operation approved and carried out",
+		"operation": op,
+		"result":    result,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// RejectOperation turns down an operation held for a second approval
+func (app *Application) RejectOperation(w http.ResponseWriter, r *http.Request) {
+	op, _ := app.decideOperation(w, r, false)
+	if op == nil {
+		return
+	}
+
+	err := jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message":   "operation rejected",
+		"operation": op,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
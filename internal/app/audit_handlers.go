@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// auditor records the privileged actions the user of the request does, in the transactions that
+// do them. an action whose event can't be appended is rolled back, nothing is done off the record
+type auditor struct {
+	app *Application
+	r   *http.Request
+}
+
+func (app *Application) auditor(r *http.Request) auditor {
+	return auditor{app: app, r: r}
+}
+
+// RecordTx records the action done to the target, with what it looked like before and after it
+func (a auditor) RecordTx(
+	ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+) error {
+	event := &audit.Event{
+		ActorID:    a.app.getUserContext(a.r).ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   fmt.Sprint(targetID),
+		IP:         realip.FromRequest(a.r),
+		RequestID:  a.app.getRequestID(a.r),
+	}
+
+	return audit.RecordTx(ctx, tx, event, before, after)
+}
+
+// ListAuditEvents returns a page of the audit log. it can be narrowed with ?actor_id=, ?action=,
+// and ?target_type= and ?target_id=
+func (app *Application) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	auditService := audit.Service{
+		Repo: &audit.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	f := app.readFilters(qs, audit.SortSafelist, v)
+	actorID := app.readInt(qs, "actor_id", 0, v)
+	action := app.readString(qs, "action", "")
+	targetType := app.readString(qs, "target_type", "")
+	targetID := app.readString(qs, "target_id", "")
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	events, metadata, err := auditService.GetAll(
+		r.Context(), v, int64(actorID), action, targetType, targetID, f,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"events":   events,
+		"metadata": metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
@@ -4,13 +4,37 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/Yusufdot101/goBankBackend/internal/permission"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 )
 
 // contextKey is custom type to avoid conflicts when setting request contexts
 type contextKey string
 
-var userContextKey = contextKey("user")
+var (
+	userContextKey  = contextKey("user")
+	tokenContextKey = contextKey("token")
+	scopeContextKey = contextKey("scope")
+
+	requestIDContextKey  = contextKey("request_id")
+	requestLogContextKey = contextKey("request_log")
+
+	idempotentRequestContextKey = contextKey("idempotent_request")
+)
+
+// requestLog holds what the access log needs to know about a request that is only found out
+// further down the chain than where it is logged, like who the user is
+type requestLog struct {
+	route  string
+	userID int64
+}
+
+// idempotentRequest is what a handler tells the idempotent middleware about how the request went
+type idempotentRequest struct {
+	// nothingCommitted is set when the request failed before any of its changes were committed,
+	// so that retrying it with the same key after a server error is safe
+	nothingCommitted bool
+}
 
 // get the user identity, whether anonymous or real, we panic in case the assertion fails because
 // we expect the key to be there by the time this is called
@@ -26,6 +50,86 @@ func (app *Application) getUserContext(r *http.Request) *user.User {
 // store the user, anonymous or otherwise, to the request context, so that other elements have
 // access to it
 func (app *Application) setUserContext(r *http.Request, u *user.User) *http.Request {
+	// the access log is written further up the chain, which only sees the user through this
+	if log := app.getRequestLog(r); log != nil {
+		log.userID = u.ID
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, u)
 	return r.WithContext(ctx)
 }
+
+// getTokenContext returns the plaintext of the authorization token the request was authenticated
+// with, empty for anonymous requests
+func (app *Application) getTokenContext(r *http.Request) string {
+	tokenPlaintext, _ := r.Context().Value(tokenContextKey).(string)
+	return tokenPlaintext
+}
+
+// setTokenContext stores the authorization token of the request, so the session it belongs to can
+// be found
+func (app *Application) setTokenContext(r *http.Request, tokenPlaintext string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, tokenPlaintext)
+	return r.WithContext(ctx)
+}
+
+// getScopeContext returns the scope of the permissions requirePermission checked the user for. like
+// the user, we panic if it's missing because handlers only ask for it behind requirePermission
+func (app *Application) getScopeContext(r *http.Request) *permission.Scope {
+	scope, ok := r.Context().Value(scopeContextKey).(*permission.Scope)
+	if !ok {
+		panic("scope key missing in request context")
+	}
+
+	return scope
+}
+
+// setScopeContext stores the scope of the permissions the user was let through with, so handlers
+// can hold them to it
+func (app *Application) setScopeContext(r *http.Request, scope *permission.Scope) *http.Request {
+	ctx := context.WithValue(r.Context(), scopeContextKey, scope)
+	return r.WithContext(ctx)
+}
+
+// getRequestID returns the ID the requestID middleware gave the request, empty if it didn't
+func (app *Application) getRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// setRequestID stores the ID of the request, so what is logged or recorded about it can be tied
+// back to it
+func (app *Application) setRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// getRequestLog returns what is known about the request for logging, nil if it isn't being logged
+func (app *Application) getRequestLog(r *http.Request) *requestLog {
+	log, _ := r.Context().Value(requestLogContextKey).(*requestLog)
+	return log
+}
+
+// setRequestLog stores what is known about the request for logging, for the rest of the chain to
+// fill in
+func (app *Application) setRequestLog(r *http.Request, log *requestLog) *http.Request {
+	ctx := context.WithValue(r.Context(), requestLogContextKey, log)
+	return r.WithContext(ctx)
+}
+
+// setIdempotentRequest stores what the idempotent middleware wants to know from the handler
+func (app *Application) setIdempotentRequest(
+	r *http.Request, idempotent *idempotentRequest,
+) *http.Request {
+	ctx := context.WithValue(r.Context(), idempotentRequestContextKey, idempotent)
+	return r.WithContext(ctx)
+}
+
+// nothingCommitted tells the idempotent middleware that the request failed without changing
+// anything, so its key can be given back. it does nothing for requests without a key
+func (app *Application) nothingCommitted(r *http.Request) {
+	idempotent, _ := r.Context().Value(idempotentRequestContextKey).(*idempotentRequest)
+	if idempotent != nil {
+		idempotent.nothingCommitted = true
+	}
+}
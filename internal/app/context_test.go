@@ -59,3 +59,36 @@ func TestGetUserContext(t *testing.T) {
 		t.Errorf("expected user email=%s, got email=%s", mockUser.Email, gotUser.Email)
 	}
 }
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "sent by the client", header: "abc-123", keep: true},
+		{name: "not sent", header: ""},
+		{name: "not usable", header: "abc 123\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = app.getRequestID(r)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Request-ID", tc.header)
+			rr := httptest.NewRecorder()
+			app.requestID(next).ServeHTTP(rr, req)
+
+			if got == "" || rr.Header().Get("X-Request-ID") != got {
+				t.Fatalf("expected the request id %q sent back, got %q", got, rr.Header())
+			}
+			if tc.keep != (got == tc.header) {
+				t.Errorf("expected the header kept %v, got %q", tc.keep, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// readIDParam reads the id parameter from the request URL, it must be a positive integer
+func (app *Application) readIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id parameter")
+	}
+
+	return id, nil
+}
+
+// readNumberParam reads the account number parameter from the request URL. it isn't checked here,
+// the account service validates numbers like any other input
+func (app *Application) readNumberParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return params.ByName("number")
+}
+
+// readString returns the value of the key in the query string, or defaultValue if it isn't there
+func (app *Application) readString(qs url.Values, key, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// readInt returns the value of the key in the query string as an integer, or defaultValue if it
+// isn't there. a value that isn't an integer is added to v
+func (app *Application) readInt(
+	qs url.Values, key string, defaultValue int, v *validator.Validator,
+) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+// readTime returns the value of the key in the query string as a time, or nil if it isn't there. it
+// can be a full RFC 3339 timestamp or just a date, endOfDay makes a date mean the end of that day
+// instead of its start, so that a range ending on a date includes the whole day
+func (app *Application) readTime(
+	qs url.Values, key string, endOfDay bool, v *validator.Validator,
+) *time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return &t
+	}
+
+	t, err = time.Parse(time.DateOnly, s)
+	if err != nil {
+		v.AddError(key, "must be a date or an RFC 3339 timestamp")
+		return nil
+	}
+
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t
+}
+
+// readAmount returns the value of the key in the query string as an amount, or nil if it isn't
+// there
+func (app *Application) readAmount(
+	qs url.Values, key string, v *validator.Validator,
+) *money.Amount {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	amount, err := money.Parse(s, money.DefaultCurrency)
+	if err != nil {
+		v.AddError(key, "must be an amount")
+		return nil
+	}
+
+	return &amount
+}
+
+// readFilters reads the pagination, sorting and filtering options every list endpoint takes from
+// the query string. the newest records come first unless asked otherwise
+func (app *Application) readFilters(
+	qs url.Values, sortSafelist []string, v *validator.Validator,
+) filter.Filters {
+	return filter.Filters{
+		Page:         app.readInt(qs, "page", filter.DefaultPage, v),
+		PageSize:     app.readInt(qs, "page_size", filter.DefaultPageSize, v),
+		Sort:         app.readString(qs, "sort", "-created_at"),
+		SortSafelist: sortSafelist,
+		From:         app.readTime(qs, "from", false, v),
+		To:           app.readTime(qs, "to", true, v),
+		MinAmount:    app.readAmount(qs, "min_amount", v),
+		MaxAmount:    app.readAmount(qs, "max_amount", v),
+		Direction:    app.readString(qs, "direction", ""),
+	}
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// GetAccountLedger rebuilds the balance of an account from the ledger, checks it against the
+// stored balance and returns the entries it was built from
+func (app *Application) GetAccountLedger(w http.ResponseWriter, r *http.Request) {
+	accountService := account.Service{
+		Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+	ledgerService := ledger.Service{
+		Repo: &ledger.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	a, err := accountService.GetAccountByNumber(r.Context(), v, app.readNumberParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation), errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	balance, err := ledgerService.Reconcile(r.Context(), a.ID)
+	if err != nil && !errors.Is(err, ledger.ErrBalanceMismatch) {
+		switch {
+		case errors.Is(err, ledger.ErrNoAccount):
+			app.NotFoundResponse(w, r)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+	reconciled := err == nil
+
+	entries, err := ledgerService.History(r.Context(), ledger.CustomerAccount(a.ID))
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"account":    a,
+		"balance":    balance,
+		"reconciled": reconciled,
+		"entries":    entries,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
@@ -4,16 +4,21 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/approval"
 	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
 	"github.com/Yusufdot101/goBankBackend/internal/loan"
+	"github.com/Yusufdot101/goBankBackend/internal/metrics"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
 func (app *Application) PayLoan(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		LoadID int64   `json:"loan_id"`
-		Amount float64 `json:"amount"`
+		LoadID        int64        `json:"loan_id"`
+		AccountNumber string       `json:"account_number"`
+		Amount        money.Amount `json:"amount"`
 	}
 	err := jsonutil.ReadJSON(w, r, &input)
 	if err != nil {
@@ -21,18 +26,24 @@ func (app *Application) PayLoan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userService := user.Service{
-		Repo: &user.Repository{DB: app.DB},
-	}
+	notifier := app.txNotifier()
 	loanService := loan.Service{
-		Repo:        &loan.Repository{DB: app.DB},
-		UserService: &userService,
+		Repo: &loan.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		AccountService: &account.Service{
+			Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		},
+		Webhooks: app.webhooks(),
+		Notifier: notifier,
 	}
 
 	v := validator.New()
 	u := app.getUserContext(r)
-	l, err := loanService.MakePayment(v, input.LoadID, u.ID, input.Amount)
+	l, err := loanService.MakePayment(
+		r.Context(), v, input.LoadID, u.ID, input.AccountNumber, input.Amount,
+	)
 	if err != nil {
+		// the payment is a single transaction, nothing is left of it when it fails
+		app.nothingCommitted(r)
 		switch {
 		case errors.Is(err, validator.ErrFailedValidation):
 			app.FailedValidationResponse(w, v.Errors)
@@ -46,6 +57,9 @@ func (app *Application) PayLoan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.Metrics.MoneyMoved(metrics.KindLoanPayment, l.Amount)
+	notifier.sendHeld(r.Context())
+
 	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
 		"message": "loan payment completed successfully",
 		"loan":    l,
@@ -55,12 +69,16 @@ func (app *Application) PayLoan(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// loanDeletionInput is the request to delete a loan, kept as it is while a deletion waits for
+// approval
+type loanDeletionInput struct {
+	LoanID   int64  `json:"loan_id"`
+	DebtorID int64  `json:"debtor_id"`
+	Reason   string `json:"reason"`
+}
+
 func (app *Application) DeleteLoan(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		LoanID   int64  `json:"loan_id"`
-		DebtorID int64  `json:"debtor_id"`
-		Reason   string `json:"reason"`
-	}
+	var input loanDeletionInput
 
 	err := jsonutil.ReadJSON(w, r, &input)
 	if err != nil {
@@ -68,20 +86,120 @@ func (app *Application) DeleteLoan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// deleting a loan forgives what is left of it, that's the amount the threshold is for
 	loanService := loan.Service{
-		Repo: &loan.Repository{DB: app.DB},
+		Repo: &loan.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+	l, err := loanService.GetByID(r.Context(), input.LoanID, input.DebtorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+	if app.Config.Approval.Thresholds.Requires(approval.KindLoanDeletion, l.RemainingAmount) {
+		app.submitOperation(w, r, approval.KindLoanDeletion, l.RemainingAmount, input)
+		return
 	}
 
 	u := app.getUserContext(r)
 	v := validator.New()
+	loanDeletion, err := app.deleteLoan(r, v, input, u.ID)
+	if err != nil {
+		app.operationErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(
+		w, http.StatusOK,
+		jsonutil.Envelope{
+			"message":       "loan deleted successfully",
+			"loan_deletion": loanDeletion,
+		})
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+}
+
+// deleteLoan deletes the loan, recording the user deletedByID as the one who did it. the audit log
+// has the user of r, who is the approver when the deletion was held for approval
+func (app *Application) deleteLoan(
+	r *http.Request, v *validator.Validator, input loanDeletionInput, deletedByID int64,
+) (*loan.LoanDeletion, error) {
+	notifier := app.txNotifier()
+	loanService := loan.Service{
+		Repo:     &loan.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Notifier: notifier,
+		Auditor:  app.auditor(r),
+	}
+
 	loanDeletion, err := loanService.DeleteLoan(
-		v, input.LoanID, input.DebtorID, u.ID, input.Reason,
+		r.Context(), v, input.LoanID, input.DebtorID, deletedByID, input.Reason,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier.sendHeld(r.Context())
+	return loanDeletion, nil
+}
+
+// ListLoans returns a page of the loans the user took and the payments they made
+func (app *Application) ListLoans(w http.ResponseWriter, r *http.Request) {
+	loanService := loan.Service{
+		Repo: &loan.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	f := app.readFilters(r.URL.Query(), loan.SortSafelist, v)
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	u := app.getUserContext(r)
+	loans, metadata, err := loanService.GetAllForUser(r.Context(), v, u.ID, f)
 	if err != nil {
 		switch {
 		case errors.Is(err, validator.ErrFailedValidation):
 			app.FailedValidationResponse(w, v.Errors)
 
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"loans":    loans,
+		"metadata": metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ShowLoanSchedule returns the installments of a loan of the user, what is due when and how much of
+// it has been paid
+func (app *Application) ShowLoanSchedule(w http.ResponseWriter, r *http.Request) {
+	loanID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	loanService := loan.Service{
+		Repo: &loan.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	u := app.getUserContext(r)
+	installments, err := loanService.GetSchedule(r.Context(), loanID, u.ID)
+	if err != nil {
+		switch {
 		case errors.Is(err, user.ErrNoRecord):
 			app.NotFoundResponse(w, r)
 
@@ -91,14 +209,30 @@ func (app *Application) DeleteLoan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = jsonutil.WriteJSON(
-		w, http.StatusOK,
-		jsonutil.Envelope{
-			"message":       "loan deleted successfully",
-			"loan_deletion": loanDeletion,
-		})
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"schedule": installments,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ListLoanProducts returns the kinds of loan that can be requested
+func (app *Application) ListLoanProducts(w http.ResponseWriter, r *http.Request) {
+	loanService := loan.Service{
+		Repo: &loan.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	products, err := loanService.GetAllProducts(r.Context())
 	if err != nil {
 		app.ServerError(w, r, err)
 		return
 	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"products": products,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
 }
@@ -4,16 +4,22 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/approval"
 	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
 	"github.com/Yusufdot101/goBankBackend/internal/loan"
 	"github.com/Yusufdot101/goBankBackend/internal/loanrequests"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/permission"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
 func (app *Application) NewLoanRequest(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Amount float64 `json:"amount"`
+		AccountNumber string       `json:"account_number"`
+		ProductID     int64        `json:"product_id"`
+		Amount        money.Amount `json:"amount"`
 	}
 
 	err := jsonutil.ReadJSON(w, r, &input)
@@ -23,17 +29,27 @@ func (app *Application) NewLoanRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	loanRequestService := loanrequests.Service{
-		Repo: &loanrequests.Repository{DB: app.DB},
+		Repo: &loanrequests.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		AccountService: &account.Service{
+			Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		},
+		LoanService: &loan.Service{Repo: &loan.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}},
 	}
 
 	v := validator.New()
 	u := app.getUserContext(r)
-	loanRequest, err := loanRequestService.New(v, u, input.Amount, app.Config.DailyInterestRate)
+	loanRequest, err := loanRequestService.New(
+		r.Context(), v, u, input.AccountNumber, input.ProductID, input.Amount,
+		app.Config.DailyInterestRate,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, validator.ErrFailedValidation):
 			app.FailedValidationResponse(w, v.Errors)
 
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+
 		default:
 			app.ServerError(w, r, err)
 		}
@@ -49,12 +65,17 @@ func (app *Application) NewLoanRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// loanResponseInput is the decision on a loan request, kept as it is while an acceptance waits for
+// approval
+type loanResponseInput struct {
+	LoanRequestID int64  `json:"loan_request_id"`
+	UserID        int64  `json:"user_id"`
+	Status        string `json:"status"`
+	Reason        string `json:"reason"` // why a request was declined, shown to the borrower
+}
+
 func (app *Application) RespondToLoanRequest(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		LoanRequestID int64  `json:"loan_request_id"`
-		UserID        int64  `json:"user_id"`
-		Status        string `json:"status"`
-	}
+	var input loanResponseInput
 
 	err := jsonutil.ReadJSON(w, r, &input)
 	if err != nil {
@@ -62,35 +83,181 @@ func (app *Application) RespondToLoanRequest(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	userService := user.Service{
-		Repo: &user.Repository{DB: app.DB},
+	v := validator.New()
+	if validateLoanResponse(v, input); !v.IsValid() {
+		app.UnprocessableEntityResponse(w, v.Errors)
+		return
+	}
+
+	// only accepting a loan pays anything out, declining one never needs a second approval
+	if input.Status == "ACCEPTED" {
+		loanRequestService := loanrequests.Service{
+			Repo: &loanrequests.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		}
+		loanRequest, err := loanRequestService.Get(r.Context(), input.LoanRequestID, input.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, user.ErrNoRecord):
+				app.NotFoundResponse(w, r)
+			default:
+				app.ServerError(w, r, err)
+			}
+			return
+		}
+
+		amount := loanRequest.Amount
+		if app.Config.Approval.Thresholds.Requires(approval.KindLoanApproval, amount) {
+			app.submitOperation(w, r, approval.KindLoanApproval, amount, input)
+			return
+		}
+	}
+
+	loanRequest, message, err := app.respondToLoanRequest(r, v, input)
+	if err != nil {
+		app.operationErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message":      message,
+		"loan_request": loanRequest,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
 	}
+}
+
+// validateLoanResponse checks the response is one respondToLoanRequest knows how to give
+func validateLoanResponse(v *validator.Validator, input loanResponseInput) {
+	v.CheckAddError(
+		validator.ValueInList(input.Status, "ACCEPTED", "DECLINED"), "status",
+		"must be ACCEPTED or DECLINED",
+	)
+}
+
+// respondToLoanRequest accepts or declines the loan request for the user of r, along with the
+// message for the borrower. any other status is a validation error
+func (app *Application) respondToLoanRequest(
+	r *http.Request, v *validator.Validator, input loanResponseInput,
+) (*loanrequests.LoanRequest, string, error) {
 	loanService := loan.Service{
-		Repo: &loan.Repository{DB: app.DB},
+		Repo: &loan.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
 	}
+	notifier := app.txNotifier()
 	loanRequestService := loanrequests.Service{
-		Repo:        &loanrequests.Repository{DB: app.DB},
-		UserService: &userService,
+		Repo: &loanrequests.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		AccountService: &account.Service{
+			Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		},
 		LoanService: &loanService,
+		Webhooks:    app.webhooks(),
+		Notifier:    notifier,
+		Auditor:     app.auditor(r),
 	}
 
-	var message string
-	var loanRequest *loanrequests.LoanRequest
 	switch input.Status {
 	case "ACCEPTED":
-		message = "your loan was accepted"
-		loanRequest, err = loanRequestService.AcceptLoanRequest(input.LoanRequestID, input.UserID)
+		loanRequest, err := loanRequestService.AcceptLoanRequest(
+			r.Context(), v, input.LoanRequestID, input.UserID,
+		)
+		if err != nil {
+			return nil, "", err
+		}
+		app.Metrics.LoanDecision(loanRequest.Status, loanRequest.Amount)
+		notifier.sendHeld(r.Context())
+		return loanRequest, "your loan was accepted", nil
 	case "DECLINED":
-		message = "your loan was declined"
-		loanRequest, err = loanRequestService.DeclineLoanRequest(input.LoanRequestID, input.UserID)
+		loanRequest, err := loanRequestService.DeclineLoanRequest(
+			r.Context(), v, input.LoanRequestID, input.UserID, input.Reason,
+		)
+		if err != nil {
+			return nil, "", err
+		}
+		app.Metrics.LoanDecision(loanRequest.Status, loanRequest.Amount)
+		notifier.sendHeld(r.Context())
+		return loanRequest, "your loan was declined", nil
 	default:
+		validateLoanResponse(v, input)
+		return nil, "", validator.ErrFailedValidation
+	}
+}
+
+// ListLoanRequests returns a page of loan requests. approvers see the requests of every user, or of
+// the one given by user_id, everyone else only sees their own
+func (app *Application) ListLoanRequests(w http.ResponseWriter, r *http.Request) {
+	permissionService := permission.Service{
+		Repo: &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+	loanRequestService := loanrequests.Service{
+		Repo: &loanrequests.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	f := app.readFilters(qs, loanrequests.SortSafelist, v)
+	status := app.readString(qs, "status", "")
+	userID := int64(app.readInt(qs, "user_id", 0, v))
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	u := app.getUserContext(r)
+	isApprover, err := permissionService.UserHas(
+		r.Context(), v, u, "APPROVE_LOANS", "ADMIN", "SUPERUSER",
+	)
+	if err != nil {
+		app.ServerError(w, r, err)
 		return
 	}
+	if !isApprover {
+		userID = u.ID
+	}
 
+	loanRequests, metadata, err := loanRequestService.GetAll(r.Context(), v, userID, status, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"loan_requests": loanRequests,
+		"metadata":      metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// WithdrawLoanRequest lets the user take back a loan request of theirs that is still pending
+func (app *Application) WithdrawLoanRequest(w http.ResponseWriter, r *http.Request) {
+	loanRequestID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	loanRequestService := loanrequests.Service{
+		Repo: &loanrequests.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	u := app.getUserContext(r)
+	loanRequest, err := loanRequestService.Withdraw(r.Context(), loanRequestID, u.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, user.ErrNoRecord):
 			app.NotFoundResponse(w, r)
+
+		case errors.Is(err, loanrequests.ErrNotPending):
+			app.ErrorResponse(w, http.StatusConflict, err.Error())
+
 		default:
 			app.ServerError(w, r, err)
 		}
@@ -98,11 +265,10 @@ func (app *Application) RespondToLoanRequest(w http.ResponseWriter, r *http.Requ
 	}
 
 	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
-		"message":      message,
+		"message":      "your loan request was withdrawn",
 		"loan_request": loanRequest,
 	})
 	if err != nil {
 		app.ServerError(w, r, err)
-		return
 	}
 }
@@ -0,0 +1,24 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRespondToLoanRequestUnknownStatus(t *testing.T) {
+	app := Application{}
+	body := strings.NewReader(`{"loan_request_id": 1, "user_id": 1, "status": "MAYBE"}`)
+	req := httptest.NewRequest(http.MethodPut, "/v1/loans/respond", body)
+	rr := httptest.NewRecorder()
+
+	app.RespondToLoanRequest(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "must be ACCEPTED or DECLINED") {
+		t.Errorf("expected the status to be explained, got %s", rr.Body.String())
+	}
+}
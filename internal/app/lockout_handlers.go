@@ -0,0 +1,96 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/lockout"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// ListLockouts returns a page of the accounts and IPs with recent failed logins or a lockout
+func (app *Application) ListLockouts(w http.ResponseWriter, r *http.Request) {
+	lockoutService := lockout.Service{
+		Repo:   &lockout.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Policy: app.Config.Lockout,
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	f := filter.Filters{
+		Page:         app.readInt(qs, "page", filter.DefaultPage, v),
+		PageSize:     app.readInt(qs, "page_size", filter.DefaultPageSize, v),
+		Sort:         app.readString(qs, "sort", "-last_failure_at"),
+		SortSafelist: lockout.SortSafelist,
+	}
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	entries, metadata, err := lockoutService.GetAll(r.Context(), v, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"lockouts": entries,
+		"metadata": metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ClearLockout forgets the failed logins of an account or an IP, lifting its lockout
+func (app *Application) ClearLockout(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Kind string `json:"kind"`
+		Key  string `json:"key"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	lockoutService := lockout.Service{
+		Repo:    &lockout.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Policy:  app.Config.Lockout,
+		Auditor: app.auditor(r),
+	}
+
+	v := validator.New()
+	cleared, err := lockoutService.Clear(r.Context(), v, input.Kind, input.Key)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+	if !cleared {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message": "lockout cleared successfully",
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/mfa"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// EnrolMFA starts setting up two-factor authentication for the user. the secret is returned to be
+// typed into an authenticator app, or the provisioning URI to be shown as a QR code
+func (app *Application) EnrolMFA(w http.ResponseWriter, r *http.Request) {
+	mfaService := mfa.Service{
+		Repo:   &mfa.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Key:    app.Config.MFA.Key,
+		Issuer: app.Config.MFA.Issuer,
+	}
+
+	u := app.getUserContext(r)
+	secret, uri, err := mfaService.Enrol(r.Context(), u)
+	if err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrAlreadyEnrolled):
+			app.ErrorResponse(w, http.StatusConflict, err.Error())
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusCreated, jsonutil.Envelope{
+		"message":          "send a code from your authenticator app to confirm",
+		"secret":           secret,
+		"provisioning_uri": uri,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ConfirmMFA turns two-factor authentication on once the user shows their app gives the right
+// codes. the recovery codes are only ever shown in this response
+func (app *Application) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	mfaService := mfa.Service{
+		Repo: &mfa.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Key:  app.Config.MFA.Key,
+	}
+
+	v := validator.New()
+	u := app.getUserContext(r)
+	recoveryCodes, err := mfaService.Confirm(r.Context(), v, u.ID, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		case errors.Is(err, mfa.ErrAlreadyEnrolled):
+			app.ErrorResponse(w, http.StatusConflict, err.Error())
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message":        "two-factor authentication enabled, keep the recovery codes somewhere safe",
+		"recovery_codes": recoveryCodes,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
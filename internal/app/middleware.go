@@ -1,17 +1,29 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/idempotency"
+	"github.com/Yusufdot101/goBankBackend/internal/mfa"
 	"github.com/Yusufdot101/goBankBackend/internal/permission"
 	"github.com/Yusufdot101/goBankBackend/internal/token"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/julienschmidt/httprouter"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 )
@@ -34,6 +46,132 @@ func (app *Application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// statusRecorder keeps the status code of the response passed through it
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	if sr.statusCode == 0 {
+		sr.statusCode = statusCode
+	}
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.statusCode == 0 {
+		sr.statusCode = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// routePattern returns the pattern of the route in router the request matches, e.g.
+// /v1/webhooks/:id, or "unmatched" for requests that match none
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+
+	pattern, ok := placeParams(router, r.Method, strings.Split(r.URL.Path, "/"), 0, params)
+	if !ok {
+		return "unmatched"
+	}
+
+	return pattern
+}
+
+// placeParams puts the names of params back in place of their values, in the segments from from
+// on. a value can be the same as a fixed part of the path, /v1/users/users/roles, so each place it
+// could go is tried until the pattern matches itself
+func placeParams(
+	router *httprouter.Router, method string, segments []string, from int, params httprouter.Params,
+) (string, bool) {
+	if len(params) == 0 {
+		pattern := strings.Join(segments, "/")
+		handle, patternParams, _ := router.Lookup(method, pattern)
+		if handle == nil {
+			return "", false
+		}
+		for _, param := range patternParams {
+			if param.Value != ":"+param.Key {
+				return "", false
+			}
+		}
+		return pattern, true
+	}
+
+	for i := from; i < len(segments); i++ {
+		if segments[i] != params[0].Value {
+			continue
+		}
+
+		placed := slices.Clone(segments)
+		placed[i] = ":" + params[0].Key
+		if pattern, ok := placeParams(router, method, placed, i+1, params[1:]); ok {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
+
+// instrument records the route, status code and duration of every request handled by next, the
+// routes being those of router, and writes a line to the access log for it
+func (app *Application) instrument(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		log := &requestLog{route: routePattern(router, r)}
+		r = app.setRequestLog(r, log)
+
+		defer func() {
+			// nothing written means nothing was sent but the default
+			if rec.statusCode == 0 {
+				rec.statusCode = http.StatusOK
+			}
+			duration := time.Since(start)
+			app.Metrics.ObserveRequest(r.Method, log.route, rec.statusCode, duration)
+
+			properties := app.requestProperties(r)
+			properties["status"] = strconv.Itoa(rec.statusCode)
+			properties["duration"] = duration.String()
+			app.Logger.PrintInfo("request", properties)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// requestIDPattern is what an X-Request-ID sent by the client has to look like to be kept, anything
+// else is replaced rather than written into logs and the audit trail as it is
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID gives every request an ID, the X-Request-ID it came with if it has a usable one, and
+// sends it back in the same header
+func (app *Application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(requestID) {
+			randomBytes := make([]byte, 16)
+			_, err := rand.Read(randomBytes)
+			if err != nil {
+				app.ServerError(w, r, err)
+				return
+			}
+			requestID = hex.EncodeToString(randomBytes)
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+		next.ServeHTTP(w, app.setRequestID(r, requestID))
+	})
+}
+
 func (app *Application) rateLimit(next http.Handler) http.Handler {
 	// client will hold client info used in rate limiting so that each IP has its own rate limit
 	type client struct {
@@ -83,6 +221,7 @@ func (app *Application) rateLimit(next http.Handler) http.Handler {
 
 		// if not permitted; rate limit exceeded, send appropriate message and info
 		if !clients[ip].limiter.Allow() {
+			app.Metrics.RateLimited()
 			app.RateLimitExceededResponse(w)
 			return
 		}
@@ -118,16 +257,24 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 		}
 
 		s := user.Service{
-			Repo: &user.Repository{DB: app.DB},
+			Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
 		}
 		// try to get the user for the provided token
-		u, err := s.GetUserForToken(authorizationToken, token.ScopeAuthorization)
+		u, err := s.GetUserForToken(r.Context(), authorizationToken, token.ScopeAuthorization)
 		if err != nil {
 			app.InvalidAuthorizationTokenResponse(w)
 			return
 		}
 
+		// keeping the session list up to date shouldn't fail the request, so errors are only logged
+		tokenService := token.Service{Repo: &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
+		err = tokenService.Touch(r.Context(), authorizationToken, realip.FromRequest(r), r.UserAgent())
+		if err != nil {
+			app.logRequestError(r, err)
+		}
+
 		r = app.setUserContext(r, u)
+		r = app.setTokenContext(r, authorizationToken)
 		next.ServeHTTP(w, r)
 	}
 
@@ -166,29 +313,206 @@ func (app *Application) requirePermission(next http.HandlerFunc, code ...string)
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		u := app.getUserContext(r)
 		permissionService := permission.Service{
-			Repo: &permission.Repository{DB: app.DB},
+			Repo: &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
 		}
 
+		// any one of the codes will do, whichever of the user's roles it comes through. the scope
+		// of the grants it comes through is passed on for the handler to enforce
 		v := validator.New()
-		for _, c := range code {
-			has, err := permissionService.UserHas(v, u, c)
-			if err != nil {
-				switch {
-				case errors.Is(err, validator.ErrFailedValidation):
-					app.FailedValidationResponse(w, v.Errors)
-				default:
-					app.ServerError(w, r, err)
-				}
-				return
-			}
-			if has {
-				next.ServeHTTP(w, r)
+		scope, err := permissionService.UserScope(r.Context(), v, u, code...)
+		if err != nil {
+			switch {
+			case errors.Is(err, validator.ErrFailedValidation):
+				app.FailedValidationResponse(w, v.Errors)
+			default:
+				app.ServerError(w, r, err)
 			}
+			return
+		}
+		if scope == nil {
+			app.RequirePermissionResponse(w)
+			return
 		}
 
-		app.RequirePermissionResponse(w)
+		app.requireAdminMFA(next).ServeHTTP(w, app.setScopeContext(r, scope))
 	}
 
 	// also needs to be authorized and activated
 	return app.requireActivatedUser(fn)
 }
+
+// requireAdminMFA only lets admins and superusers through once they have enabled two-factor
+// authentication, so that a stolen password alone can't be used to reach what only they can
+func (app *Application) requireAdminMFA(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := app.getUserContext(r)
+		permissionService := permission.Service{
+			Repo: &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		}
+
+		isAdmin, err := permissionService.UserHas(r.Context(), validator.New(), u, "ADMIN", "SUPERUSER")
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+		if !isAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mfaService := mfa.Service{
+			Repo: &mfa.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		}
+		enrolled, err := mfaService.IsEnrolled(r.Context(), u.ID)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+		if !enrolled {
+			app.RequireMFAResponse(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// responseRecorder passes the response through to the client while keeping a copy of it. header
+// is what the response was written with that wasn't already set before when it was made
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	before     http.Header
+	header     http.Header
+	body       bytes.Buffer
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, before: w.Header().Clone()}
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	if rr.statusCode == 0 {
+		rr.statusCode = statusCode
+		rr.header = http.Header{}
+		for key, values := range rr.Header() {
+			if !slices.Equal(rr.before[key], values) {
+				rr.header[key] = slices.Clone(values)
+			}
+		}
+	}
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.statusCode == 0 {
+		rr.WriteHeader(http.StatusOK)
+	}
+	rr.body.Write(b)
+
+	return rr.ResponseWriter.Write(b)
+}
+
+// idempotent lets clients safely retry a request by sending the same Idempotency-Key header. the
+// first request with a key is handled as usual and its response stored, any later request with the
+// key gets that response replayed instead of being handled again. keys are per user, so it has to
+// come after the user is authenticated
+func (app *Application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the body is needed for the fingerprint, so read it here and hand the handler a copy
+		const maxBytes = 1_048_576
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			app.BadRequestResponse(w, fmt.Errorf("body size cannot exceed %d bytes", maxBytes))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		u := app.getUserContext(r)
+		idempotencyService := idempotency.Service{
+			Repo: &idempotency.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		}
+
+		v := validator.New()
+		stored, err := idempotencyService.Begin(
+			r.Context(), v, u.ID, key, idempotency.Fingerprint(r.Method, r.URL.Path, body),
+			app.Config.Idempotency.TTL,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, validator.ErrFailedValidation):
+				app.FailedValidationResponse(w, v.Errors)
+			case errors.Is(err, idempotency.ErrKeyReused):
+				app.IdempotencyKeyReusedResponse(w)
+			case errors.Is(err, idempotency.ErrInProgress):
+				app.IdempotencyKeyInProgressResponse(w)
+			default:
+				app.ServerError(w, r, err)
+			}
+			return
+		}
+
+		if stored != nil {
+			for name, values := range stored.ResponseHeader {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.ResponseBody)
+			return
+		}
+
+		rec := newResponseRecorder(w)
+		idempotent := &idempotentRequest{}
+		r = app.setIdempotentRequest(r, idempotent)
+
+		defer func() {
+			// a panic might have come after the money moved, so it's answered here rather than in
+			// recoverPanic, for the response to be stored like any other
+			if err := recover(); err != nil {
+				app.ServerError(rec, r, fmt.Errorf("%s", err))
+			}
+
+			// the client may be gone by now, which mustn't stop the outcome from being recorded
+			ctx, cancel := database.WithTimeout(
+				context.WithoutCancel(r.Context()), app.Config.DB.Timeout,
+			)
+			defer cancel()
+
+			// the key is only given back for the client to retry with when the handler knows the
+			// request failed without changing anything. any other server error is replayed, as the
+			// request might have gone through
+			if idempotent.nothingCommitted && rec.statusCode >= http.StatusInternalServerError {
+				err := idempotencyService.Release(ctx, u.ID, key)
+				if err != nil {
+					app.logRequestError(r, err)
+				}
+				return
+			}
+
+			// a handler that wrote nothing was answered with an empty 200
+			if rec.statusCode == 0 {
+				rec.statusCode = http.StatusOK
+			}
+
+			// if this fails the key stays in progress until it expires. that turns retries away,
+			// which is better than letting them move the money again
+			err := idempotencyService.Complete(
+				ctx, u.ID, key, rec.statusCode, rec.header, rec.body.Bytes(),
+			)
+			if err != nil {
+				app.logRequestError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
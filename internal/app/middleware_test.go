@@ -0,0 +1,173 @@
+package app
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/jsonlog"
+	"github.com/Yusufdot101/goBankBackend/internal/metrics"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRoutePattern(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", noop)
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/roles", noop)
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/:number/loans/:id", noop)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", noop)
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodGet, "/v1/healthcheck", "/v1/healthcheck"},
+		{http.MethodGet, "/v1/webhooks/12", "/v1/webhooks/:id"},
+		{http.MethodGet, "/v1/users/users/roles", "/v1/users/:id/roles"},
+		{http.MethodGet, "/v1/accounts/7/loans/7", "/v1/accounts/:number/loans/:id"},
+		{http.MethodGet, "/v1/nothing/here", "unmatched"},
+		{http.MethodPost, "/v1/webhooks/12", "unmatched"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if got := routePattern(router, req); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	// opening doesn't connect, the pool is only there to report its stats
+	db, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	logs := new(bytes.Buffer)
+	app := Application{Metrics: metrics.New(db), Logger: jsonlog.New(logs, jsonlog.LevelInfo)}
+	router := httprouter.New()
+	teapot := func(w http.ResponseWriter, r *http.Request) {
+		// the user is only known once the request gets here, like it is after authenticate
+		app.setUserContext(r, &user.User{ID: 7})
+		w.WriteHeader(http.StatusTeapot)
+	}
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", teapot)
+	handler := app.requestID(app.instrument(router, router))
+
+	for _, path := range []string{"/v1/webhooks/1", "/v1/webhooks/2", "/v1/missing"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var entry struct {
+		Message    string            `json:"message"`
+		Properties map[string]string `json:"properties"`
+	}
+	line, _, _ := bytes.Cut(logs.Bytes(), []byte("\n"))
+	if err := json.Unmarshal(line, &entry); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, logs.String())
+	}
+	for key, want := range map[string]string{
+		"method":     http.MethodGet,
+		"route":      "/v1/webhooks/:id",
+		"status":     "418",
+		"user_id":    "7",
+		"request_id": "req-1",
+	} {
+		if got := entry.Properties[key]; got != want {
+			t.Errorf("expected %s=%s in the access log, got %v", key, want, entry.Properties)
+		}
+	}
+	if entry.Properties["duration"] == "" || entry.Properties["ip"] == "" {
+		t.Errorf("expected the duration and ip in the access log, got %v", entry.Properties)
+	}
+
+	rr := httptest.NewRecorder()
+	app.Metrics.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rr.Body)
+
+	for _, want := range []string{
+		`gobank_http_requests_total{method="GET",route="/v1/webhooks/:id",status="418"} 2`,
+		`gobank_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected %s in\n%s", want, body)
+		}
+	}
+}
+
+func TestRoutesTraced(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	// a request that is part of a trace the caller started
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	app := &Application{Logger: jsonlog.New(io.Discard, jsonlog.LevelInfo)}
+	app.Routes().ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if got := spans[0].Name(); got != "GET /v1/healthcheck" {
+		t.Errorf("expected the span named after the route, got %q", got)
+	}
+	if got := spans[0].SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("expected the span in the trace of the caller %s, got %s", traceID, got)
+	}
+}
+
+func TestResponseRecorderHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Request-ID", "req-1")
+
+	rec := newResponseRecorder(w)
+	rec.Header().Set("Content-Type", "application/json")
+	rec.Write([]byte(`{}`))
+	rec.Header().Set("X-Too-Late", "1")
+
+	if rec.statusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rec.statusCode)
+	}
+	want := http.Header{"Content-Type": {"application/json"}}
+	if len(rec.header) != len(want) || rec.header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected the header %v, got %v", want, rec.header)
+	}
+	if rec.body.String() != `{}` {
+		t.Errorf("expected the body to be kept, got %q", rec.body.String())
+	}
+}
+
+func TestNothingCommitted(t *testing.T) {
+	app := Application{}
+
+	// without a key there is nothing to tell
+	app.nothingCommitted(httptest.NewRequest(http.MethodPut, "/v1/transfer", nil))
+
+	idempotent := &idempotentRequest{}
+	r := httptest.NewRequest(http.MethodPut, "/v1/transfer", nil)
+	app.nothingCommitted(app.setIdempotentRequest(r, idempotent))
+	if !idempotent.nothingCommitted {
+		t.Error("expected the middleware to be told nothing was committed")
+	}
+}
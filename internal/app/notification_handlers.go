@@ -0,0 +1,276 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/mailer"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
+	"github.com/Yusufdot101/goBankBackend/internal/notify"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// notifier returns the service that sends notifications on the channels that are set up, emails
+// are queued in the outbox
+func (app *Application) notifier() *notify.Service {
+	channels := map[string]notify.Channel{
+		notify.ChannelEmail: &notify.Email{
+			Mailer: &outbox.Service{Repo: &outbox.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}},
+		},
+		notify.ChannelInApp: &notify.InApp{
+			Repo: &notify.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		},
+	}
+	if sms := app.Config.Notify.SMS; sms.URL != "" {
+		channels[notify.ChannelSMS] = notify.NewSMS(sms.URL, sms.Token, sms.Sender)
+	}
+	if app.Config.Notify.Log != nil {
+		channels[notify.ChannelLog] = app.Config.Notify.Log
+	}
+
+	return &notify.Service{
+		Channels: channels,
+		Routes:   app.Config.Notify.Routes,
+		Default:  app.Config.Notify.Default,
+		Render:   mailer.Render,
+	}
+}
+
+// txNotifier notifies users of what happens in the transactions of one request, inside them. the
+// channels that can't be written in a transaction, SMS and the log, are held until sendHeld
+type txNotifier struct {
+	app  *Application
+	held []*notify.Message
+}
+
+func (app *Application) txNotifier() *txNotifier {
+	return &txNotifier{app: app}
+}
+
+// NotifyTx tells the user about the event in tx, unless they turned it off. an error rolls tx back,
+// nothing happens that the user isn't told about
+func (n *txNotifier) NotifyTx(
+	ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+) error {
+	app := n.app
+	notificationService := notification.Service{
+		Repo:        &notification.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Notifier:    app.notifier(),
+		UserService: &user.Service{Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}},
+	}
+
+	msg, err := notificationService.SendTx(ctx, tx, userID, event, data)
+	if err != nil {
+		return err
+	}
+	if msg != nil {
+		n.held = append(n.held, msg)
+	}
+
+	return nil
+}
+
+// sendHeld sends what NotifyTx held back, once the transactions are committed. a notification that
+// can't be sent is logged, what it was about is done already
+func (n *txNotifier) sendHeld(ctx context.Context) {
+	// the client going away doesn't undo what was committed
+	ctx = context.WithoutCancel(ctx)
+	notifier := n.app.notifier()
+	for _, msg := range n.held {
+		err := notifier.SendAfterCommit(ctx, msg)
+		if err != nil {
+			properties := n.app.contextProperties(ctx)
+			properties["notification_event"] = msg.Event
+			n.app.Logger.PrintError(err, properties)
+		}
+	}
+	n.held = nil
+}
+
+// ShowNotificationPreferences returns which events the user is notified about and the number they
+// are texted on
+func (app *Application) ShowNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	notificationService := notification.Service{
+		Repo: &notification.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	u := app.getUserContext(r)
+	preferences, err := notificationService.Preferences(r.Context(), u.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	phone, err := notificationService.Phone(r.Context(), u.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"preferences": preferences,
+		"phone":       phone,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// UpdateNotificationPreferences turns the events given on or off for the user, e.g.
+// {"preferences": {"DEPOSIT": false}}, and sets the number they are texted on if phone is given, an
+// empty one stops them being texted. events left out keep their current setting
+func (app *Application) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Preferences notification.Preferences `json:"preferences"`
+		Phone       *string                  `json:"phone"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	notificationService := notification.Service{
+		Repo: &notification.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	u := app.getUserContext(r)
+	preferences, phone, err := notificationService.UpdatePreferences(
+		r.Context(), v, u.ID, input.Preferences, input.Phone,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message":     "notification preferences updated",
+		"preferences": preferences,
+		"phone":       phone,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ListNotifications returns a page of the in-app notifications of the user, newest first unless
+// sorted otherwise, with how many are unread. ?read=false only returns the unread ones
+func (app *Application) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	notifyService := notify.Service{
+		Repo: &notify.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	f := app.readFilters(qs, notify.SortSafelist, v)
+	read := app.readString(qs, "read", "")
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	u := app.getUserContext(r)
+	notifications, unread, metadata, err := notifyService.GetAll(r.Context(), v, u.ID, read, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"notifications": notifications,
+		"unread":        unread,
+		"metadata":      metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// UpdateNotification marks an in-app notification of the user as read, or as unread again, e.g.
+// {"read": true}
+func (app *Application) UpdateNotification(w http.ResponseWriter, r *http.Request) {
+	notificationID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Read *bool `json:"read"`
+	}
+
+	err = jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	notifyService := notify.Service{
+		Repo: &notify.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	u := app.getUserContext(r)
+	n, err := notifyService.SetRead(r.Context(), v, notificationID, u.ID, input.Read)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"notification": n,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// MarkAllNotificationsRead marks every in-app notification of the user as read
+func (app *Application) MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	notifyService := notify.Service{
+		Repo: &notify.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	u := app.getUserContext(r)
+	marked, err := notifyService.MarkAllRead(r.Context(), u.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message": "notifications marked as read",
+		"marked":  marked,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// ListOutboxMessages returns a page of the queued emails, the dead ones unless asked for another
+// status. what the emails were rendered with is never shown, it can hold tokens
+func (app *Application) ListOutboxMessages(w http.ResponseWriter, r *http.Request) {
+	outboxService := outbox.Service{
+		Repo: &outbox.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	f := app.readFilters(qs, outbox.SortSafelist, v)
+	status := app.readString(qs, "status", outbox.StatusDead)
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	messages, metadata, err := outboxService.GetAll(r.Context(), v, status, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"messages": messages,
+		"metadata": metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// RetryOutboxMessage puts a dead email back in the queue, to be sent again as if it was new
+func (app *Application) RetryOutboxMessage(w http.ResponseWriter, r *http.Request) {
+	messageID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	outboxService := outbox.Service{
+		Repo:    &outbox.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+
+	msg, err := outboxService.Retry(r.Context(), messageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, outbox.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+
+		case errors.Is(err, outbox.ErrNotDead):
+			app.ErrorResponse(w, http.StatusConflict, err.Error())
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message":        "the email was queued again",
+		"outbox_message": msg,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
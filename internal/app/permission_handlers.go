@@ -3,17 +3,156 @@ package app
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/permission"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
-func (app *Application) GrantPermission(w http.ResponseWriter, r *http.Request) {
+func (app *Application) AddNewPermisison(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		UserID int64  `json:"user_id"`
-		Code   string `json:"code"`
+		Code        string `json:"code"`
+		Description string `json:"description"`
+	}
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	permissionService := permission.Service{
+		Repo:    &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+
+	v := validator.New()
+	err = permissionService.AddNewPermission(r.Context(), v, input.Code, input.Description)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		case errors.Is(err, permission.ErrDuplicateCode):
+			v.AddError("code", "a permission with this code already exists")
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(
+		w, http.StatusCreated, jsonutil.Envelope{
+			"message": "new permisison add",
+			"code":    input.Code,
+		},
+	)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+}
+
+// ListPermissions returns the permission catalog
+func (app *Application) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	permissionService := permission.Service{
+		Repo: &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	definitions, err := permissionService.Permissions(r.Context())
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{"permissions": definitions})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+func (app *Application) ListRoles(w http.ResponseWriter, r *http.Request) {
+	permissionService := permission.Service{
+		Repo: &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	roles, err := permissionService.Roles(r.Context())
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{"roles": roles})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+func (app *Application) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+		Inherits    []string `json:"inherits"`
+	}
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	role := &permission.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Inherits:    input.Inherits,
+	}
+	for _, code := range input.Permissions {
+		role.Permissions = append(role.Permissions, permission.Permission(code))
+	}
+
+	permissionService := permission.Service{
+		Repo:    &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+
+	v := validator.New()
+	err = permissionService.CreateRole(r.Context(), v, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		case errors.Is(err, permission.ErrDuplicateRole):
+			v.AddError("role", "a role with this name already exists")
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusCreated, jsonutil.Envelope{"role": role})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// AssignRole gives a user a role, along with everything the role and the roles it inherits allow.
+// the grant can be made to expire and to cap the amounts the user can move with it, in the currency
+// given, or the default one
+func (app *Application) AssignRole(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID    int64          `json:"user_id"`
+		Role      string         `json:"role"`
+		Reason    string         `json:"reason"`
+		ExpiresAt *time.Time     `json:"expires_at"`
+		MaxAmount *money.Amount  `json:"max_amount"`
+		Currency  money.Currency `json:"currency"`
 	}
 	err := jsonutil.ReadJSON(w, r, &input)
 	if err != nil {
@@ -22,15 +161,27 @@ func (app *Application) GrantPermission(w http.ResponseWriter, r *http.Request)
 	}
 
 	userService := &user.Service{
-		Repo: &user.Repository{DB: app.DB},
+		Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
 	}
 	permissionService := permission.Service{
-		Repo:        &permission.Repository{DB: app.DB},
+		Repo:        &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
 		UserService: userService,
+		Auditor:     app.auditor(r),
+	}
+
+	grantedBy := app.getUserContext(r).ID
+	grant := &permission.Grant{
+		UserID:    input.UserID,
+		Role:      input.Role,
+		GrantedBy: &grantedBy,
+		Reason:    input.Reason,
+		ExpiresAt: input.ExpiresAt,
+		MaxAmount: input.MaxAmount,
+		Currency:  input.Currency,
 	}
 
 	v := validator.New()
-	err = permissionService.GrantUser(v, input.UserID, input.Code)
+	err = permissionService.AssignRole(r.Context(), v, grant)
 	if err != nil {
 		switch {
 		case errors.Is(err, validator.ErrFailedValidation):
@@ -45,22 +196,21 @@ func (app *Application) GrantPermission(w http.ResponseWriter, r *http.Request)
 	}
 
 	err = jsonutil.WriteJSON(
-		w, http.StatusCreated,
+		w, http.StatusOK,
 		jsonutil.Envelope{
-			"message":    "permisison granted",
-			"user_id":    input.UserID,
-			"permisison": input.Code,
+			"message": "role assigned",
+			"grant":   grant,
 		},
 	)
 	if err != nil {
 		app.ServerError(w, r, err)
-		return
 	}
 }
 
-func (app *Application) AddNewPermisison(w http.ResponseWriter, r *http.Request) {
+func (app *Application) RevokeRole(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Code string `json:"code"`
+		UserID int64  `json:"user_id"`
+		Role   string `json:"role"`
 	}
 	err := jsonutil.ReadJSON(w, r, &input)
 	if err != nil {
@@ -68,19 +218,26 @@ func (app *Application) AddNewPermisison(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	userService := &user.Service{
+		Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
 	permissionService := permission.Service{
-		Repo: &permission.Repository{DB: app.DB},
+		Repo:        &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		UserService: userService,
+		Auditor:     app.auditor(r),
 	}
 
 	v := validator.New()
-	err = permissionService.AddNewPermission(v, input.Code)
-	switch {
-	}
+	err = permissionService.RevokeRole(
+		r.Context(), v, input.UserID, input.Role, app.getUserContext(r).ID,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, validator.ErrFailedValidation):
 			app.FailedValidationResponse(w, v.Errors)
 
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
 		default:
 			app.ServerError(w, r, err)
 		}
@@ -88,13 +245,48 @@ func (app *Application) AddNewPermisison(w http.ResponseWriter, r *http.Request)
 	}
 
 	err = jsonutil.WriteJSON(
-		w, http.StatusCreated, jsonutil.Envelope{
-			"message": "new permisison add",
-			"code":    input.Code,
+		w, http.StatusOK,
+		jsonutil.Envelope{
+			"message": "role revoked",
+			"user_id": input.UserID,
+			"role":    input.Role,
 		},
 	)
 	if err != nil {
 		app.ServerError(w, r, err)
+	}
+}
+
+// ListUserRoles returns the roles a user has been granted, with who granted them, why, and their
+// expiry and limits
+func (app *Application) ListUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	userService := &user.Service{
+		Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+	permissionService := permission.Service{
+		Repo:        &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		UserService: userService,
+	}
+
+	grants, err := permissionService.Grants(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+		default:
+			app.ServerError(w, r, err)
+		}
 		return
 	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{"roles": grants})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
 }
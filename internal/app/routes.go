@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func (app *Application) Routes() http.Handler {
@@ -19,14 +20,172 @@ func (app *Application) Routes() http.Handler {
 
 	router.HandlerFunc(http.MethodPut, "/v1/users/activation", app.ActivateUser)
 
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.ResetPassword)
+
 	// get authorization token for an account
 	router.HandlerFunc(http.MethodPut, "/v1/tokens/authorization", app.GetAuthorizationToken)
 
-	router.HandlerFunc(http.MethodPut, "/v1/transfer", app.requireActivatedUser(app.TransferMoney))
+	router.HandlerFunc(http.MethodPut, "/v1/tokens/mfa", app.GetMFAAuthorizationToken)
+
+	router.HandlerFunc(http.MethodPut, "/v1/tokens/refresh", app.RefreshAuthorizationToken)
+
+	router.HandlerFunc(
+		http.MethodDelete, "/v1/tokens/authorization",
+		app.requireAuthorizedUser(app.DeleteAuthorizationToken),
+	)
+
+	router.HandlerFunc(
+		http.MethodPost, "/v1/tokens/password-reset", app.CreatePasswordResetToken,
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/tokens/sessions", app.requireAuthorizedUser(app.ListSessions),
+	)
+
+	router.HandlerFunc(
+		http.MethodDelete, "/v1/tokens/sessions", app.requireAuthorizedUser(app.RevokeAllSessions),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/lockouts", app.requirePermission(app.ListLockouts, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodDelete, "/v1/lockouts",
+		app.requirePermission(app.ClearLockout, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/outbox",
+		app.requirePermission(app.ListOutboxMessages, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodPut, "/v1/outbox/:id/retry",
+		app.requirePermission(app.RetryOutboxMessage, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodPost, "/v1/webhooks",
+		app.requirePermission(app.CreateWebhook, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/webhooks", app.requirePermission(app.ListWebhooks, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodPatch, "/v1/webhooks/:id",
+		app.requirePermission(app.UpdateWebhook, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodDelete, "/v1/webhooks/:id",
+		app.requirePermission(app.DeleteWebhook, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/webhook-deliveries",
+		app.requirePermission(app.ListWebhookDeliveries, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodPost, "/v1/webhook-deliveries/:id/replay",
+		app.requirePermission(app.ReplayWebhookDelivery, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/users/:id/roles", app.requirePermission(app.ListUserRoles, "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/users/:id/sessions",
+		app.requirePermission(app.ListUserSessions, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodDelete, "/v1/users/:id/sessions",
+		app.requirePermission(app.RevokeUserSessions, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(http.MethodPut, "/v1/users/unlock", app.UnlockUser)
+
+	router.HandlerFunc(http.MethodPost, "/v1/users/mfa", app.requireActivatedUser(app.EnrolMFA))
+
+	router.HandlerFunc(
+		http.MethodPut, "/v1/users/mfa/confirm", app.requireActivatedUser(app.ConfirmMFA),
+	)
+
+	router.HandlerFunc(http.MethodGet, "/v1/me", app.requireActivatedUser(app.ShowCurrentUser))
+
+	router.HandlerFunc(http.MethodPost, "/v1/accounts", app.requireActivatedUser(app.OpenAccount))
+
+	router.HandlerFunc(http.MethodGet, "/v1/accounts", app.requireActivatedUser(app.ListAccounts))
+
+	router.HandlerFunc(
+		http.MethodPut, "/v1/accounts/:number/status",
+		app.requirePermission(app.UpdateAccountStatus, "ADMIN", "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodPut, "/v1/transfer", app.requireActivatedUser(app.idempotent(app.TransferMoney)),
+	)
+
+	router.HandlerFunc(http.MethodGet, "/v1/transfers", app.requireActivatedUser(app.ListTransfers))
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/notification-preferences",
+		app.requireActivatedUser(app.ShowNotificationPreferences),
+	)
+
+	router.HandlerFunc(
+		http.MethodPut, "/v1/notification-preferences",
+		app.requireActivatedUser(app.UpdateNotificationPreferences),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/notifications", app.requireActivatedUser(app.ListNotifications),
+	)
+
+	router.HandlerFunc(
+		http.MethodPatch, "/v1/notifications/:id", app.requireActivatedUser(app.UpdateNotification),
+	)
+
+	router.HandlerFunc(
+		http.MethodPost, "/v1/notifications/read",
+		app.requireActivatedUser(app.MarkAllNotificationsRead),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/transactions", app.requireActivatedUser(app.ListTransactions),
+	)
+
+	router.HandlerFunc(http.MethodGet, "/v1/loans", app.requireActivatedUser(app.ListLoans))
+
+	// the schedule used to be at /v1/loans/:id/schedule, which the router can't have next to
+	// /v1/loans/requests. the id comes last now
+	router.HandlerFunc(
+		http.MethodGet, "/v1/loans/schedules/:id", app.requireActivatedUser(app.ShowLoanSchedule),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/loan-products", app.requireActivatedUser(app.ListLoanProducts),
+	)
 
 	router.HandlerFunc(http.MethodPut, "/v1/loans/get", app.requireActivatedUser(app.NewLoanRequest))
 
-	router.HandlerFunc(http.MethodPut, "/v1/loans/pay", app.requireActivatedUser(app.PayLoan))
+	router.HandlerFunc(
+		http.MethodGet, "/v1/loans/requests", app.requireActivatedUser(app.ListLoanRequests),
+	)
+
+	router.HandlerFunc(
+		http.MethodDelete, "/v1/loans/requests/:id",
+		app.requireActivatedUser(app.WithdrawLoanRequest),
+	)
+
+	router.HandlerFunc(
+		http.MethodPut, "/v1/loans/pay", app.requireActivatedUser(app.idempotent(app.PayLoan)),
+	)
 
 	router.HandlerFunc(
 		http.MethodPut, "/v1/loans/respond",
@@ -39,8 +198,11 @@ func (app *Application) Routes() http.Handler {
 	)
 
 	router.HandlerFunc(
-		http.MethodPut, "/v1/permissions/grant",
-		app.requirePermission(app.GrantPermission, "SUPERUSER"),
+		http.MethodGet, "/v1/audit-events", app.requirePermission(app.ListAuditEvents, "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/permissions", app.requirePermission(app.ListPermissions, "SUPERUSER"),
 	)
 
 	router.HandlerFunc(
@@ -48,15 +210,62 @@ func (app *Application) Routes() http.Handler {
 		app.requirePermission(app.AddNewPermisison, "SUPERUSER"),
 	)
 
+	router.HandlerFunc(http.MethodGet, "/v1/roles", app.requirePermission(app.ListRoles, "SUPERUSER"))
+
+	router.HandlerFunc(
+		http.MethodPost, "/v1/roles", app.requirePermission(app.CreateRole, "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodPut, "/v1/roles/assign", app.requirePermission(app.AssignRole, "SUPERUSER"),
+	)
+
+	router.HandlerFunc(
+		http.MethodPut, "/v1/roles/revoke", app.requirePermission(app.RevokeRole, "SUPERUSER"),
+	)
+
 	router.HandlerFunc(
 		http.MethodPut, "/v1/deposit",
-		app.requirePermission(app.DepositMoney, "DEPOSIT", "ADMIN", "SUPERUSER"),
+		app.requirePermission(app.idempotent(app.DepositMoney), "DEPOSIT", "ADMIN", "SUPERUSER"),
 	)
 
 	router.HandlerFunc(
 		http.MethodPut, "/v1/withdraw",
-		app.requirePermission(app.WithdrawMoney, "WITHDDRAW", "ADMIN", "SUPERUSER"),
+		app.requirePermission(app.idempotent(app.WithdrawMoney), "WITHDRAW", "ADMIN", "SUPERUSER"),
+	)
+
+	// anyone who can do one of the operations held for approval can see them, approving one takes
+	// the same permissions as asking for it, which the handlers check
+	approverCodes := []string{"DEPOSIT", "APPROVE_LOANS", "DELETE_LOANS", "ADMIN", "SUPERUSER"}
+	router.HandlerFunc(
+		http.MethodGet, "/v1/approvals", app.requirePermission(app.ListApprovals, approverCodes...),
+	)
+
+	router.HandlerFunc(
+		http.MethodPut, "/v1/approvals/:id/approve",
+		app.requirePermission(app.ApproveOperation, approverCodes...),
 	)
 
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+	router.HandlerFunc(
+		http.MethodPut, "/v1/approvals/:id/reject",
+		app.requirePermission(app.RejectOperation, approverCodes...),
+	)
+
+	router.HandlerFunc(
+		http.MethodGet, "/v1/accounts/:number/ledger",
+		app.requirePermission(app.GetAccountLedger, "ADMIN", "SUPERUSER"),
+	)
+
+	// the request ID comes first so that the access log and every error logged can carry it
+	handler := app.requestID(app.instrument(
+		router, app.recoverPanic(app.rateLimit(app.authenticate(router))),
+	))
+
+	// the span of a request is named after its route, not its path, for the same reason metrics
+	// are labelled with it
+	return otelhttp.NewHandler(handler, "http", otelhttp.WithSpanNameFormatter(
+		func(_ string, r *http.Request) string {
+			return r.Method + " " + routePattern(router, r)
+		},
+	))
 }
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/jobs"
+	"github.com/Yusufdot101/goBankBackend/internal/loan"
+)
+
+// the name the nightly accrual is recorded and locked under
+const loanAccrualJob = "loan-accrual"
+
+// nextRun returns the first time after now that the clock reads hour o'clock in UTC
+func nextRun(now time.Time, hour int) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// nextAttempt returns when the accrual is run next after now: the next run if the last one was
+// done, otherwise after retryInterval, unless the next run comes sooner. a retryInterval of 0 never
+// retries
+func nextAttempt(now time.Time, hour int, retryInterval time.Duration, done bool) time.Time {
+	next := nextRun(now, hour)
+	if retry := now.Add(retryInterval); !done && retryInterval > 0 && retry.Before(next) {
+		return retry
+	}
+	return next
+}
+
+// runLoanAccrual runs the loan accrual once on startup, to catch up on a night the server was down
+// for, and then every day at the configured hour. a run that failed, or found another replica
+// running it, is tried again on the next tick instead of the next day. every replica runs the
+// loop, the advisory lock and the recorded runs make sure the work is only done once a day
+func (app *Application) runLoanAccrual() {
+	for {
+		done := app.accrueLoansFor(time.Now())
+		next := nextAttempt(
+			time.Now(), app.Config.Accrual.Hour, app.Config.Accrual.RetryInterval, done,
+		)
+		time.Sleep(time.Until(next))
+	}
+}
+
+// accrueLoansFor runs the loan accrual for the day of now and logs how it went. it reports whether
+// the accrual is done for the day, either by this run or an earlier one
+func (app *Application) accrueLoansFor(now time.Time) (done bool) {
+	// a run in progress is finished before the server shuts down
+	app.wg.Add(1)
+	defer app.wg.Done()
+
+	jobService := jobs.Service{
+		Repo: &jobs.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	run, err := jobService.Run(context.Background(), loanAccrualJob, now, app.accrueLoans)
+	if err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrLocked), errors.Is(err, jobs.ErrAlreadyRun):
+			app.Logger.PrintInfo("skipped loan accrual", map[string]string{
+				"reason": err.Error(),
+			})
+
+		default:
+			app.LogError(err)
+		}
+		return errors.Is(err, jobs.ErrAlreadyRun)
+	}
+
+	app.Logger.PrintInfo("finished loan accrual", map[string]string{
+		"day":       run.Day.Format(time.DateOnly),
+		"status":    run.Status,
+		"processed": strconv.Itoa(run.Processed),
+		"failed":    strconv.Itoa(run.Failed),
+	})
+	return run.Status == jobs.StatusSucceeded
+}
+
+// accrueLoans brings every loan up to date for the day. a loan that fails is logged and left for
+// the retry, it doesn't stop the rest
+func (app *Application) accrueLoans(
+	ctx context.Context, day time.Time,
+) (processed, failed int, err error) {
+	loanService := loan.Service{
+		Repo: &loan.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	loanIDs, err := loanService.LoansToAccrue(ctx, day)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, loanID := range loanIDs {
+		processed++
+		err = loanService.Accrue(ctx, loanID, day, app.Config.Accrual.Policy)
+		if err != nil {
+			failed++
+			app.Logger.PrintError(err, map[string]string{
+				"loan_id": strconv.FormatInt(loanID, 10),
+			})
+		}
+	}
+
+	return processed, failed, nil
+}
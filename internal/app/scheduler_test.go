@@ -0,0 +1,71 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRun(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "later today",
+			now:  time.Date(2025, time.March, 10, 0, 30, 0, 0, time.UTC),
+			want: time.Date(2025, time.March, 10, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "already ran today",
+			now:  time.Date(2025, time.March, 10, 1, 0, 0, 0, time.UTC),
+			want: time.Date(2025, time.March, 11, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "end of the month",
+			now:  time.Date(2025, time.March, 31, 23, 0, 0, 0, time.UTC),
+			want: time.Date(2025, time.April, 1, 1, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := nextRun(tc.now, 1)
+			if !got.Equal(tc.want) {
+				t.Errorf("expected next run at %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNextAttempt(t *testing.T) {
+	now := time.Date(2025, time.March, 10, 1, 0, 0, 0, time.UTC)
+	tomorrow := time.Date(2025, time.March, 11, 1, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		now           time.Time
+		retryInterval time.Duration
+		done          bool
+		want          time.Time
+	}{
+		{name: "done", now: now, retryInterval: time.Hour, done: true, want: tomorrow},
+		{name: "failed", now: now, retryInterval: time.Hour, want: now.Add(time.Hour)},
+		{
+			name:          "failed just before the next run",
+			now:           tomorrow.Add(-time.Minute),
+			retryInterval: time.Hour,
+			want:          tomorrow,
+		},
+		{name: "failed without retries", now: now, want: tomorrow},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := nextAttempt(tc.now, 1, tc.retryInterval, tc.done)
+			if !got.Equal(tc.want) {
+				t.Errorf("expected the next attempt at %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
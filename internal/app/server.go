@@ -3,11 +3,21 @@ package app
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/approval"
+	"github.com/Yusufdot101/goBankBackend/internal/idempotency"
+	"github.com/Yusufdot101/goBankBackend/internal/jsonlog"
+	"github.com/Yusufdot101/goBankBackend/internal/mailer"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+	"github.com/Yusufdot101/goBankBackend/internal/permission"
+	"github.com/Yusufdot101/goBankBackend/internal/webhook"
 )
 
 func (app *Application) Serve() error {
@@ -17,8 +27,12 @@ func (app *Application) Serve() error {
 		IdleTimeout:  1 * time.Minute,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 10 * time.Second,
+		// what the server itself has to say, like failed TLS handshakes, goes to our log too
+		ErrorLog: slog.NewLogLogger(jsonlog.NewHandler(app.Logger), slog.LevelError),
 	}
 
+	admin := app.adminServer()
+
 	// channel to hold the error, if an error occured durinng shutdown
 	shutdownError := make(chan error)
 	go func() {
@@ -40,11 +54,37 @@ func (app *Application) Serve() error {
 		if err != nil {
 			shutdownError <- err
 		}
+		if admin != nil {
+			admin.Shutdown(ctx)
+		}
 
 		app.Logger.PrintInfo("finishing background tasks", nil)
 		app.wg.Wait()
 		shutdownError <- err
 	}()
+	go app.deleteExpiredIdempotencyKeys()
+	go app.deleteExpiredGrants()
+	go app.expireOperations()
+	for range app.Config.Outbox.Workers {
+		go app.deliverOutbox()
+	}
+	for range app.Config.Webhooks.Workers {
+		go app.deliverWebhooks()
+	}
+	if app.Config.Accrual.Enabled {
+		go app.runLoanAccrual()
+	}
+
+	if admin != nil {
+		go func() {
+			app.Logger.PrintInfo("admin server running", map[string]string{"addr": admin.Addr})
+			err := admin.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				app.LogError(err)
+			}
+		}()
+	}
+
 	app.Logger.PrintInfo("server running", map[string]string{"addr": srv.Addr})
 
 	err := srv.ListenAndServe()
@@ -61,3 +101,172 @@ func (app *Application) Serve() error {
 	app.Logger.PrintInfo("stopped server", nil)
 	return nil
 }
+
+// adminServer returns the server for /metrics, on a port of its own so it can be kept off the
+// internet. it's nil if the port is 0 or there are no metrics to serve
+func (app *Application) adminServer() *http.Server {
+	if app.Config.Admin.Port == 0 || app.Metrics == nil {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", app.Metrics.Handler())
+
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.Config.Admin.Port),
+		Handler:      mux,
+		IdleTimeout:  1 * time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		ErrorLog:     slog.NewLogLogger(jsonlog.NewHandler(app.Logger), slog.LevelError),
+	}
+}
+
+// deleteExpiredIdempotencyKeys clears out expired idempotency keys every hour. expired keys can
+// already be reused, this only stops the table from growing forever
+func (app *Application) deleteExpiredIdempotencyKeys() {
+	idempotencyService := idempotency.Service{
+		Repo: &idempotency.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	for {
+		time.Sleep(1 * time.Hour)
+
+		deleted, err := idempotencyService.DeleteExpired(context.Background())
+		if err != nil {
+			app.LogError(err)
+			continue
+		}
+
+		app.Logger.PrintInfo("deleted expired idempotency keys", map[string]string{
+			"count": strconv.FormatInt(deleted, 10),
+		})
+	}
+}
+
+// deleteExpiredGrants sweeps role grants that have expired every minute. an expired grant stops
+// counting as soon as it expires, sweeping it logs that it's gone
+func (app *Application) deleteExpiredGrants() {
+	permissionService := permission.Service{
+		Repo: &permission.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	for {
+		time.Sleep(1 * time.Minute)
+
+		deleted, err := permissionService.DeleteExpiredGrants(context.Background())
+		if err != nil {
+			app.LogError(err)
+			continue
+		}
+		if deleted == 0 {
+			continue
+		}
+
+		app.Logger.PrintInfo("deleted expired role grants", map[string]string{
+			"count": strconv.FormatInt(deleted, 10),
+		})
+	}
+}
+
+// expireOperations marks the operations that waited too long for a second approval as expired,
+// every minute
+func (app *Application) expireOperations() {
+	approvalService := approval.Service{
+		Repo: &approval.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	for {
+		time.Sleep(1 * time.Minute)
+
+		expired, err := approvalService.Expire(context.Background())
+		if err != nil {
+			app.LogError(err)
+			continue
+		}
+		if expired == 0 {
+			continue
+		}
+
+		app.Logger.PrintInfo("expired operations waiting for approval", map[string]string{
+			"count": strconv.FormatInt(expired, 10),
+		})
+	}
+}
+
+// outboxBatchSize is how many queued emails a worker claims at once, and outboxPollInterval how
+// long it waits before looking again once the queue is empty
+const (
+	outboxBatchSize    = 10
+	outboxPollInterval = 5 * time.Second
+)
+
+// deliverOutbox sends queued emails as long as there are any due, and otherwise checks for new ones
+// every outboxPollInterval. any number of workers can run it, they never claim the same email
+func (app *Application) deliverOutbox() {
+	outboxService := outbox.Service{
+		Repo:   &outbox.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Mailer: mailer.NewMailerFromEnv(),
+		Policy: app.Config.Outbox.Policy,
+	}
+
+	for {
+		// a batch being sent is finished before the server shuts down
+		app.wg.Add(1)
+		sent, failed, err := outboxService.Deliver(context.Background(), outboxBatchSize, time.Now())
+		app.wg.Done()
+		app.Metrics.EmailsSent(sent, failed)
+		if err != nil {
+			app.LogError(err)
+		}
+		if failed > 0 {
+			app.Logger.PrintWarn("failed to send queued emails", map[string]string{
+				"sent":   strconv.Itoa(sent),
+				"failed": strconv.Itoa(failed),
+			})
+		}
+
+		if err != nil || sent+failed < outboxBatchSize {
+			time.Sleep(outboxPollInterval)
+		}
+	}
+}
+
+// webhookBatchSize is how many deliveries a worker claims at once, and webhookPollInterval how long
+// it waits before looking again once there are none due
+const (
+	webhookBatchSize    = 10
+	webhookPollInterval = 5 * time.Second
+)
+
+// deliverWebhooks sends queued webhook deliveries the same way deliverOutbox sends emails
+func (app *Application) deliverWebhooks() {
+	webhookService := webhook.Service{
+		Repo:   &webhook.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Client: &http.Client{Timeout: app.Config.Webhooks.Timeout},
+		Policy: app.Config.Webhooks.Policy,
+	}
+
+	for {
+		// a batch being sent is finished before the server shuts down
+		app.wg.Add(1)
+		delivered, failed, err := webhookService.Deliver(
+			context.Background(), webhookBatchSize, time.Now(),
+		)
+		app.wg.Done()
+		app.Metrics.WebhooksDelivered(delivered, failed)
+		if err != nil {
+			app.LogError(err)
+		}
+		if failed > 0 {
+			app.Logger.PrintWarn("failed to deliver webhooks", map[string]string{
+				"delivered": strconv.Itoa(delivered),
+				"failed":    strconv.Itoa(failed),
+			})
+		}
+
+		if err != nil || delivered+failed < webhookBatchSize {
+			time.Sleep(webhookPollInterval)
+		}
+	}
+}
@@ -1,14 +1,82 @@
 package app
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"net/http"
+	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/database"
 	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/lockout"
+	"github.com/Yusufdot101/goBankBackend/internal/mfa"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
 	"github.com/Yusufdot101/goBankBackend/internal/token"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/tomasen/realip"
 )
 
+// tokenEnvelope is the response to signing in, the access token is sent as "token" like it was
+// before there were refresh tokens
+func tokenEnvelope(access, refresh *token.Token) jsonutil.Envelope {
+	return jsonutil.Envelope{
+		"token":                access.Plaintext,
+		"expiry":               access.Expiry,
+		"refresh_token":        refresh.Plaintext,
+		"refresh_token_expiry": refresh.Expiry,
+	}
+}
+
+// unlockTokenTTL is how long the unlock link emailed with a lockout can be used for
+const unlockTokenTTL = 24 * time.Hour
+
+// failLogin counts a failed login and responds with invalid credentials. u is nil when the email
+// doesn't belong to anyone. if this failure locked the account its user is emailed about it, with
+// a token to unlock it
+func (app *Application) failLogin(
+	w http.ResponseWriter, r *http.Request, u *user.User, email, ip string,
+) {
+	lockoutService := lockout.Service{
+		Repo:   &lockout.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Policy: app.Config.Lockout,
+	}
+
+	// the unlock token and the email are made in the transaction that locks the account
+	var onLock database.TxFunc
+	if u != nil {
+		onLock = func(ctx context.Context, tx *sql.Tx) error {
+			t, err := token.NewTx(ctx, tx, u.ID, unlockTokenTTL, token.ScopeUnlock)
+			if err != nil {
+				return err
+			}
+
+			lockedUntil := time.Now().Add(app.Config.Lockout.Duration)
+			return outbox.InsertTx(ctx, tx, &outbox.Message{
+				Recipient: u.Email,
+				Template:  "account_locked.html",
+				Data: map[string]any{
+					"userName":    u.Name,
+					"token":       t.Plaintext,
+					"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+				},
+			})
+		}
+	}
+
+	_, err := lockoutService.Fail(r.Context(), email, ip, onLock)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	app.InvalidCredentialsResponse(w)
+}
+
+// mfaPendingTTL is how long a user has to enter their code after their password was checked
+const mfaPendingTTL = 5 * time.Minute
+
 func (app *Application) GetAuthorizationToken(w http.ResponseWriter, r *http.Request) {
 	// the inputs expected from the client
 	var input struct {
@@ -22,13 +90,32 @@ func (app *Application) GetAuthorizationToken(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	userService := user.Service{Repo: &user.Repository{DB: app.DB}}
+	lockoutService := lockout.Service{
+		Repo:   &lockout.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Policy: app.Config.Lockout,
+	}
+
+	// earlier failures from the account or the IP hold up the next attempt, before the password is
+	// even looked at
+	ip := realip.FromRequest(r)
+	retryAfter, err := lockoutService.Check(r.Context(), input.Email, ip)
+	if err != nil {
+		switch {
+		case errors.Is(err, lockout.ErrLocked):
+			app.LockedOutResponse(w, retryAfter)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	userService := user.Service{Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
 
-	u, err := userService.GetUserByEmail(input.Email)
+	u, err := userService.GetUserByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, user.ErrNoRecord):
-			app.InvalidCredentialsResponse(w)
+			app.failLogin(w, r, nil, input.Email, ip)
 		default:
 			app.ServerError(w, r, err)
 		}
@@ -43,22 +130,350 @@ func (app *Application) GetAuthorizationToken(w http.ResponseWriter, r *http.Req
 	}
 
 	if !mathes {
-		app.InvalidCredentialsResponse(w)
+		app.failLogin(w, r, u, input.Email, ip)
+		return
+	}
+
+	tokenService := token.Service{
+		Repo:       &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		AccessTTL:  app.Config.Tokens.AccessTTL,
+		RefreshTTL: app.Config.Tokens.RefreshTTL,
+	}
+	mfaService := mfa.Service{
+		Repo: &mfa.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	// users with two-factor authentication get a short lived token that has to be sent back with a
+	// code from their app before they are signed in
+	enrolled, err := mfaService.IsEnrolled(r.Context(), u.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if enrolled {
+		pending, err := tokenService.New(r.Context(), u.ID, mfaPendingTTL, token.ScopeMFAPending)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+
+		err = jsonutil.WriteJSON(
+			w, http.StatusAccepted,
+			jsonutil.Envelope{
+				"mfa_required": true,
+				"mfa_token":    pending.Plaintext,
+				"expiry":       pending.Expiry,
+			},
+		)
+		if err != nil {
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = lockoutService.Reset(r.Context(), u.Email)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	access, refresh, err := tokenService.AuthorizationToken(r.Context(), u.ID, ip, r.UserAgent())
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusCreated, tokenEnvelope(access, refresh))
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// GetMFAAuthorizationToken is the second step of signing in with two-factor authentication, the
+// token from the first step and a code from the app, or a recovery code, are swapped for an
+// authorization token
+func (app *Application) GetMFAAuthorizationToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	v := validator.New()
+	if token.ValidateToken(v, input.MFAToken); !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	userService := user.Service{Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
+	u, err := userService.GetUserForToken(r.Context(), input.MFAToken, token.ScopeMFAPending)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			app.InvalidCredentialsResponse(w)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	// codes are guessed at the same way passwords are, so they count towards the same lockout
+	lockoutService := lockout.Service{
+		Repo:   &lockout.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Policy: app.Config.Lockout,
+	}
+	ip := realip.FromRequest(r)
+	retryAfter, err := lockoutService.Check(r.Context(), u.Email, ip)
+	if err != nil {
+		switch {
+		case errors.Is(err, lockout.ErrLocked):
+			app.LockedOutResponse(w, retryAfter)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	mfaService := mfa.Service{
+		Repo: &mfa.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Key:  app.Config.MFA.Key,
+	}
+	err = mfaService.Verify(r.Context(), v, u.ID, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.failLogin(w, r, u, u.Email, ip)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = lockoutService.Reset(r.Context(), u.Email)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	tokenService := token.Service{
+		Repo:       &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		AccessTTL:  app.Config.Tokens.AccessTTL,
+		RefreshTTL: app.Config.Tokens.RefreshTTL,
+	}
+	err = tokenService.DeleteAllForUser(r.Context(), u.ID, token.ScopeMFAPending)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	access, refresh, err := tokenService.AuthorizationToken(r.Context(), u.ID, ip, r.UserAgent())
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusCreated, tokenEnvelope(access, refresh))
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// RefreshAuthorizationToken swaps a refresh token for a new access token and refresh token. a
+// refresh token that was already used signs out the session it belongs to
+func (app *Application) RefreshAuthorizationToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	v := validator.New()
+	if token.ValidateToken(v, input.RefreshToken); !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	tokenService := token.Service{
+		Repo:       &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		AccessTTL:  app.Config.Tokens.AccessTTL,
+		RefreshTTL: app.Config.Tokens.RefreshTTL,
+	}
+	access, refresh, err := tokenService.Refresh(
+		r.Context(), input.RefreshToken, realip.FromRequest(r), r.UserAgent(),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, token.ErrInvaildToken), errors.Is(err, token.ErrTokenReused):
+			app.InvalidAuthorizationTokenResponse(w)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusCreated, tokenEnvelope(access, refresh))
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// DeleteAuthorizationToken signs the user out of the session the request was made with, its
+// refresh token stops working as well
+func (app *Application) DeleteAuthorizationToken(w http.ResponseWriter, r *http.Request) {
+	tokenService := token.Service{Repo: &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
+
+	u := app.getUserContext(r)
+	err := tokenService.Revoke(r.Context(), u.ID, app.getTokenContext(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, token.ErrInvaildToken):
+			app.InvalidAuthorizationTokenResponse(w)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message": "signed out successfully",
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ListSessions returns the sessions the user is signed in with, marking the one the request was
+// made with
+func (app *Application) ListSessions(w http.ResponseWriter, r *http.Request) {
+	tokenService := token.Service{Repo: &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
+
+	u := app.getUserContext(r)
+	sessions, err := tokenService.Sessions(r.Context(), u.ID, app.getTokenContext(r))
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"sessions": sessions,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// RevokeAllSessions signs the user out everywhere, including the session the request was made with
+func (app *Application) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	tokenService := token.Service{Repo: &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
+
+	u := app.getUserContext(r)
+	err := tokenService.RevokeAll(r.Context(), u.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
 		return
 	}
 
-	tokenService := token.Service{Repo: &token.Repository{DB: app.DB}}
-	t, err := tokenService.AuthorizationToken(u.ID)
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message": "signed out of all sessions successfully",
+	})
 	if err != nil {
 		app.ServerError(w, r, err)
+	}
+}
+
+// ListUserSessions returns the sessions of any user, for admins
+func (app *Application) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	tokenService := token.Service{Repo: &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
+	sessions, err := tokenService.Sessions(r.Context(), userID, "")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"sessions": sessions,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// RevokeUserSessions signs any user out everywhere, for admins
+func (app *Application) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	tokenService := token.Service{
+		Repo:    &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+	err = tokenService.RevokeAll(r.Context(), userID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message": "user signed out of all sessions successfully",
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// CreatePasswordResetToken emails a password reset token to the user with the email. the response
+// is the same whether or not the email belongs to a user, so it can't be used to find out who has
+// an account
+func (app *Application) CreatePasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	userService := user.Service{Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
+
+	v := validator.New()
+	_, _, err = userService.RequestPasswordReset(r.Context(), v, input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
 		return
 	}
 
 	err = jsonutil.WriteJSON(
-		w, http.StatusCreated,
+		w, http.StatusAccepted,
 		jsonutil.Envelope{
-			"token":  t.Plaintext,
-			"expiry": t.Expiry,
+			"message": "if an account with this email exists, you will get an email with instructions to reset your password",
 		},
 	)
 	if err != nil {
@@ -4,43 +4,56 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/approval"
 	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/metrics"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/transaction"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
+// depositInput is the request to deposit money, kept as it is while a deposit waits for approval.
+// PerformedBy is the email of the user who asked for it, whatever the request says
+type depositInput struct {
+	AccountNumber string       `json:"account_number"`
+	Amount        money.Amount `json:"amount"`
+	PerformedBy   string       `json:"performed_by"`
+}
+
 func (app *Application) DepositMoney(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		UserID      int64   `json:"user_id"`
-		Amount      float64 `json:"amount"`
-		PerformedBy string  `json:"performed_by"`
-	}
+	var input depositInput
 
 	err := jsonutil.ReadJSON(w, r, &input)
 	if err != nil {
 		app.BadRequestResponse(w, err)
 		return
 	}
+	input.PerformedBy = app.getUserContext(r).Email
 
+	// the threshold is the one of the currency the deposit would be made in, that of the account
 	v := validator.New()
-	transactionService := transaction.Service{
-		Repo: &transaction.Repository{DB: app.DB},
+	accountService := account.Service{
+		Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
 	}
-	tr, err := transactionService.Deposit(
-		v, input.UserID, input.Amount, input.PerformedBy,
-	)
+	a, err := accountService.GetAccountByNumber(r.Context(), v, input.AccountNumber)
 	if err != nil {
-		switch {
-		case errors.Is(err, validator.ErrFailedValidation):
-			app.FailedValidationResponse(w, v.Errors)
-
-		case errors.Is(err, user.ErrNoRecord):
-			app.NotFoundResponse(w, r)
+		app.nothingCommitted(r)
+		app.operationErrorResponse(w, r, v, err)
+		return
+	}
+	amount := input.Amount.WithCurrency(a.Currency)
+	if app.Config.Approval.Thresholds.Requires(approval.KindDeposit, amount) {
+		app.submitOperation(w, r, approval.KindDeposit, amount, input)
+		return
+	}
 
-		default:
-			app.ServerError(w, r, err)
-		}
+	tr, err := app.deposit(r, v, input, app.getScopeContext(r).MaxAmounts)
+	if err != nil {
+		// deposits are made in a single transaction, nothing is left of one that failed
+		app.nothingCommitted(r)
+		app.operationErrorResponse(w, r, v, err)
 		return
 	}
 
@@ -55,11 +68,40 @@ func (app *Application) DepositMoney(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// deposit carries out the deposit for the user of r, within maxAmounts if it isn't nil
+func (app *Application) deposit(
+	r *http.Request, v *validator.Validator, input depositInput,
+	maxAmounts map[money.Currency]money.Amount,
+) (*transaction.Transaction, error) {
+	notifier := app.txNotifier()
+	transactionService := transaction.Service{
+		Repo: &transaction.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		AccountService: &account.Service{
+			Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		},
+		Webhooks:   app.webhooks(),
+		Notifier:   notifier,
+		Auditor:    app.auditor(r),
+		MaxAmounts: maxAmounts,
+	}
+
+	tr, err := transactionService.Deposit(
+		r.Context(), v, input.AccountNumber, input.Amount, input.PerformedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	app.Metrics.MoneyMoved(metrics.KindDeposit, tr.Amount)
+	notifier.sendHeld(r.Context())
+	return tr, nil
+}
+
 func (app *Application) WithdrawMoney(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		UserID      int64   `json:"user_id"`
-		Amount      float64 `json:"amount"`
-		PerformedBy string  `json:"performed_by"`
+		AccountNumber string       `json:"account_number"`
+		Amount        money.Amount `json:"amount"`
+		PerformedBy   string       `json:"performed_by"` // ignored, it's the user's email
 	}
 
 	err := jsonutil.ReadJSON(w, r, &input)
@@ -67,15 +109,25 @@ func (app *Application) WithdrawMoney(w http.ResponseWriter, r *http.Request) {
 		app.BadRequestResponse(w, err)
 		return
 	}
+	input.PerformedBy = app.getUserContext(r).Email
 
 	v := validator.New()
+	notifier := app.txNotifier()
 	transactionService := transaction.Service{
-		Repo: &transaction.Repository{DB: app.DB},
+		Repo: &transaction.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		AccountService: &account.Service{
+			Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		},
+		Webhooks:   app.webhooks(),
+		Notifier:   notifier,
+		Auditor:    app.auditor(r),
+		MaxAmounts: app.getScopeContext(r).MaxAmounts,
 	}
 	tr, err := transactionService.Withdraw(
-		v, input.UserID, input.Amount, input.PerformedBy,
+		r.Context(), v, input.AccountNumber, input.Amount, input.PerformedBy,
 	)
 	if err != nil {
+		app.nothingCommitted(r)
 		switch {
 		case errors.Is(err, validator.ErrFailedValidation):
 			app.FailedValidationResponse(w, v.Errors)
@@ -89,6 +141,9 @@ func (app *Application) WithdrawMoney(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.Metrics.MoneyMoved(metrics.KindWithdrawal, tr.Amount)
+	notifier.sendHeld(r.Context())
+
 	err = jsonutil.WriteJSON(
 		w, http.StatusCreated, jsonutil.Envelope{
 			"message":     "transaction completed successfully",
@@ -99,3 +154,38 @@ func (app *Application) WithdrawMoney(w http.ResponseWriter, r *http.Request) {
 		app.ServerError(w, r, err)
 	}
 }
+
+// ListTransactions returns a page of the deposits and withdrawals on the user's accounts
+func (app *Application) ListTransactions(w http.ResponseWriter, r *http.Request) {
+	transactionService := transaction.Service{
+		Repo: &transaction.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	f := app.readFilters(r.URL.Query(), transaction.SortSafelist, v)
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	u := app.getUserContext(r)
+	transactions, metadata, err := transactionService.GetAllForUser(r.Context(), v, u.ID, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"transactions": transactions,
+		"metadata":     metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
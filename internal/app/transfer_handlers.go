@@ -4,7 +4,9 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
 	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/transfer"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
@@ -12,8 +14,9 @@ import (
 
 func (app *Application) TransferMoney(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		ToEmail string  `json:"to_email"`
-		Amount  float64 `json:"amount"`
+		FromAccount string       `json:"from_account"`
+		ToAccount   string       `json:"to_account"`
+		Amount      money.Amount `json:"amount"`
 	}
 
 	err := jsonutil.ReadJSON(w, r, &input)
@@ -22,25 +25,30 @@ func (app *Application) TransferMoney(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userService := user.Service{
-		Repo: &user.Repository{DB: app.DB},
-	}
-
+	notifier := app.txNotifier()
 	transferService := transfer.Service{
-		Repo:        &transfer.Repository{DB: app.DB},
-		UserService: &userService,
+		Repo: &transfer.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		AccountService: &account.Service{
+			Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		},
+		Webhooks: app.webhooks(),
+		Notifier: notifier,
 	}
 
 	fromUser := app.getUserContext(r)
 	v := validator.New()
-	tr, fromUser, err := transferService.TransferMoney(v, fromUser, input.ToEmail, input.Amount)
+	tr, fromAccount, err := transferService.TransferMoney(
+		r.Context(), v, fromUser, input.FromAccount, input.ToAccount, input.Amount,
+	)
 	if err != nil {
+		// the transfer is a single transaction, nothing is left of it when it fails
+		app.nothingCommitted(r)
 		switch {
 		case errors.Is(err, validator.ErrFailedValidation):
 			app.FailedValidationResponse(w, v.Errors)
 
 		case errors.Is(err, user.ErrNoRecord):
-			app.TransferFailedResponse(w, http.StatusNotFound, "to email not found")
+			app.TransferFailedResponse(w, http.StatusNotFound, "account not found")
 
 		default:
 			app.ServerError(w, r, err)
@@ -48,10 +56,48 @@ func (app *Application) TransferMoney(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.Metrics.Transfer(tr.Amount)
+	notifier.sendHeld(r.Context())
+
 	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
 		"message":  "money transferred successfuly",
 		"transfer": tr,
-		"user":     fromUser,
+		"account":  fromAccount,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ListTransfers returns a page of the transfers the user sent or received
+func (app *Application) ListTransfers(w http.ResponseWriter, r *http.Request) {
+	transferService := transfer.Service{
+		Repo: &transfer.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	f := app.readFilters(r.URL.Query(), transfer.SortSafelist, v)
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	u := app.getUserContext(r)
+	transfers, metadata, err := transferService.GetAllForUser(r.Context(), v, u.ID, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"transfers": transfers,
+		"metadata":  metadata,
 	})
 	if err != nil {
 		app.ServerError(w, r, err)
@@ -2,10 +2,11 @@ package app
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
 	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/lockout"
 	"github.com/Yusufdot101/goBankBackend/internal/mailer"
 	"github.com/Yusufdot101/goBankBackend/internal/token"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
@@ -24,15 +25,13 @@ func (app *Application) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenService := token.Service{Repo: &token.Repository{DB: app.DB}}
 	userService := user.Service{
-		Mailer:       mailer.NewMailerFromEnv(),
-		Repo:         &user.Repository{DB: app.DB},
-		TokenService: &tokenService,
+		Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
 	}
 
+	// the welcome email with the activation token is queued with the user, the outbox sends it
 	v := validator.New()
-	u, token, err := userService.Register(v, input.Name, input.Email, input.Password)
+	u, _, err := userService.Register(r.Context(), v, input.Name, input.Email, input.Password)
 	if err != nil {
 		switch {
 		case errors.Is(err, validator.ErrFailedValidation):
@@ -48,23 +47,6 @@ func (app *Application) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// send the email to the user the token
-	app.wg.Add(1)
-	go func() {
-		defer app.wg.Done()
-		defer func() {
-			if err := recover(); err != nil {
-				app.ServerError(w, r, fmt.Errorf("%s", err))
-			}
-		}()
-		data := map[string]any{
-			"userName": u.Name,
-			"userID":   u.ID,
-			"token":    token.Plaintext,
-		}
-		_ = userService.Mailer.Send(u.Email, "user_welcome.html", data)
-	}()
-
 	err = jsonutil.WriteJSON(
 		w, http.StatusAccepted, jsonutil.Envelope{
 			"message": "account created successfully, please follow the instructions sent to your email to activate your account",
@@ -95,7 +77,7 @@ func (app *Application) ActivateUser(w http.ResponseWriter, r *http.Request) {
 			app.Config.SMTP.Password,
 			app.Config.SMTP.Sender,
 		),
-		Repo: &user.Repository{DB: app.DB},
+		Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
 	}
 
 	v := validator.New()
@@ -104,7 +86,7 @@ func (app *Application) ActivateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := s.Activate(input.TokenPlaintext)
+	u, err := s.Activate(r.Context(), input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, token.ErrInvaildToken):
@@ -126,3 +108,123 @@ func (app *Application) ActivateUser(w http.ResponseWriter, r *http.Request) {
 		app.ServerError(w, r, err)
 	}
 }
+
+// ResetPassword sets a new password for the user the password reset token was emailed to, signing
+// them out everywhere
+func (app *Application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Password       string `json:"password"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	userService := user.Service{
+		Repo:         &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		TokenService: &token.Service{Repo: &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}},
+	}
+
+	v := validator.New()
+	_, err = userService.ResetPassword(r.Context(), v, input.TokenPlaintext, input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(
+		w, http.StatusOK,
+		jsonutil.Envelope{
+			"message": "password reset successfully",
+		},
+	)
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// UnlockUser lifts the lockout of a user with the token they were emailed when it was locked
+func (app *Application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	v := validator.New()
+	if token.ValidateToken(v, input.TokenPlaintext); !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	userService := user.Service{Repo: &user.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
+	u, err := userService.GetUserForToken(r.Context(), input.TokenPlaintext, token.ScopeUnlock)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			app.BadRequestResponse(w, token.ErrInvaildToken)
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	lockoutService := lockout.Service{
+		Repo:   &lockout.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Policy: app.Config.Lockout,
+	}
+	err = lockoutService.Reset(r.Context(), u.Email)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	tokenService := token.Service{Repo: &token.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout}}
+	err = tokenService.DeleteAllForUser(r.Context(), u.ID, token.ScopeUnlock)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message": "account unlocked successfully",
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ShowCurrentUser returns the signed in user together with their accounts and balances
+func (app *Application) ShowCurrentUser(w http.ResponseWriter, r *http.Request) {
+	accountService := account.Service{
+		Repo: &account.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	u := app.getUserContext(r)
+	accounts, err := accountService.GetAllForUser(r.Context(), u.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"user":     u,
+		"accounts": accounts,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
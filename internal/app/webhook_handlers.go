@@ -0,0 +1,283 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/Yusufdot101/goBankBackend/internal/jsonutil"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/Yusufdot101/goBankBackend/internal/webhook"
+)
+
+// webhookPublisher queues webhooks for the services that move money, in the transaction that moves
+// it. a webhook that can't be queued fails the transaction, there is never a delivery missing for
+// what happened or one for what didn't
+type webhookPublisher struct{}
+
+func (webhookPublisher) PublishTx(
+	ctx context.Context, tx *sql.Tx, event string, data map[string]any,
+) error {
+	_, err := webhook.PublishTx(ctx, tx, event, data)
+	return err
+}
+
+func (app *Application) webhooks() webhookPublisher {
+	return webhookPublisher{}
+}
+
+// CreateWebhook registers an endpoint to be sent the events given, e.g.
+// {"url": "https://crm.example.com/hooks", "events": ["TRANSFER", "DEPOSIT"]}. the secret the
+// deliveries are signed with is only ever returned here
+func (app *Application) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL         string   `json:"url"`
+		Description string   `json:"description"`
+		Events      []string `json:"events"`
+	}
+
+	err := jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	webhookService := webhook.Service{
+		Repo:    &webhook.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+
+	v := validator.New()
+	endpoint := &webhook.Endpoint{
+		CreatedBy:   app.getUserContext(r).ID,
+		URL:         input.URL,
+		Description: input.Description,
+		Events:      input.Events,
+	}
+	secret, err := webhookService.CreateEndpoint(r.Context(), v, endpoint)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusCreated, jsonutil.Envelope{
+		"message": "keep the secret, it won't be shown again",
+		"webhook": endpoint,
+		"secret":  secret,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ListWebhooks returns a page of the registered endpoints
+func (app *Application) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhookService := webhook.Service{
+		Repo: &webhook.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	f := app.readFilters(r.URL.Query(), webhook.SortSafelist, v)
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	endpoints, metadata, err := webhookService.GetAllEndpoints(r.Context(), v, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"webhooks": endpoints,
+		"metadata": metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// UpdateWebhook changes the url, description, events or active of an endpoint, whichever are
+// given. an endpoint that isn't active is sent nothing, its deliveries wait until it is again
+func (app *Application) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		URL         *string  `json:"url"`
+		Description *string  `json:"description"`
+		Events      []string `json:"events"`
+		Active      *bool    `json:"active"`
+	}
+
+	err = jsonutil.ReadJSON(w, r, &input)
+	if err != nil {
+		app.BadRequestResponse(w, err)
+		return
+	}
+
+	webhookService := webhook.Service{
+		Repo:    &webhook.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+
+	v := validator.New()
+	update := webhook.EndpointUpdate{
+		URL:         input.URL,
+		Description: input.Description,
+		Events:      input.Events,
+		Active:      input.Active,
+	}
+	endpoint, err := webhookService.UpdateEndpoint(r.Context(), v, endpointID, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message": "webhook updated",
+		"webhook": endpoint,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// DeleteWebhook removes an endpoint along with its delivery log
+func (app *Application) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	webhookService := webhook.Service{
+		Repo:    &webhook.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+
+	err = webhookService.DeleteEndpoint(r.Context(), endpointID)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message": "webhook deleted",
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ListWebhookDeliveries returns a page of the delivery log, newest first unless sorted otherwise.
+// it can be narrowed to an endpoint with ?endpoint_id=, and by ?status= and ?event=
+func (app *Application) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhookService := webhook.Service{
+		Repo: &webhook.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	f := app.readFilters(qs, webhook.SortSafelist, v)
+	endpointID := app.readInt(qs, "endpoint_id", 0, v)
+	status := app.readString(qs, "status", "")
+	event := app.readString(qs, "event", "")
+	if !v.IsValid() {
+		app.FailedValidationResponse(w, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := webhookService.GetAllDeliveries(
+		r.Context(), v, int64(endpointID), status, event, f,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, validator.ErrFailedValidation):
+			app.FailedValidationResponse(w, v.Errors)
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"deliveries": deliveries,
+		"metadata":   metadata,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
+
+// ReplayWebhookDelivery sends a delivered or dead delivery to its endpoint again, as a new delivery
+// with the same payload
+func (app *Application) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	deliveryID, err := app.readIDParam(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	webhookService := webhook.Service{
+		Repo:    &webhook.Repository{DB: app.DB, Timeout: app.Config.DB.Timeout},
+		Auditor: app.auditor(r),
+	}
+
+	delivery, err := webhookService.Replay(r.Context(), deliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			app.NotFoundResponse(w, r)
+
+		case errors.Is(err, webhook.ErrNotFinished):
+			app.ErrorResponse(w, http.StatusConflict, err.Error())
+
+		default:
+			app.ServerError(w, r, err)
+		}
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, jsonutil.Envelope{
+		"message":  "the delivery was queued again",
+		"delivery": delivery,
+	})
+	if err != nil {
+		app.ServerError(w, r, err)
+	}
+}
@@ -0,0 +1,108 @@
+package approval
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// the operations that need a second user to approve them once they are over their threshold
+const (
+	KindDeposit      = "DEPOSIT"
+	KindLoanApproval = "LOAN_APPROVAL"
+	KindLoanDeletion = "LOAN_DELETION"
+)
+
+// what becomes of an operation. an approved operation is carried out right away, it is FAILED if
+// that didn't work
+const (
+	StatusPending  = "PENDING"
+	StatusApproved = "APPROVED"
+	StatusRejected = "REJECTED"
+	StatusExpired  = "EXPIRED"
+	StatusFailed   = "FAILED"
+)
+
+var Statuses = []string{StatusPending, StatusApproved, StatusRejected, StatusExpired, StatusFailed}
+
+// SortSafelist is what operations can be listed by
+var SortSafelist = []string{
+	"created_at", "-created_at", "amount", "-amount", "expires_at", "-expires_at",
+}
+
+// Operation is an action held until a user other than the one who asked for it approves it.
+// Payload is the request it was asked for with, to carry it out once approved
+type Operation struct {
+	ID          int64           `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Amount      money.Amount    `json:"amount"`
+	RequestedBy int64           `json:"requested_by"`
+	Status      string          `json:"status"`
+	DecidedBy   *int64          `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time      `json:"decided_at,omitempty"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// Thresholds are the amounts above which each kind of operation needs a second approval, by
+// currency. amounts in different currencies aren't comparable, so each has its own
+type Thresholds struct {
+	Deposit      map[money.Currency]money.Amount
+	LoanApproval map[money.Currency]money.Amount
+	LoanDeletion map[money.Currency]money.Amount
+}
+
+// Requires reports whether an operation of the kind for the amount needs a second approval. an
+// amount in a currency without a threshold always does
+func (t Thresholds) Requires(kind string, amount money.Amount) bool {
+	var thresholds map[money.Currency]money.Amount
+	switch kind {
+	case KindDeposit:
+		thresholds = t.Deposit
+	case KindLoanApproval:
+		thresholds = t.LoanApproval
+	case KindLoanDeletion:
+		thresholds = t.LoanDeletion
+	default:
+		return false
+	}
+
+	threshold, ok := thresholds[amount.Currency()]
+	if !ok {
+		return true
+	}
+	return threshold.LessThan(amount)
+}
+
+// ParseThresholds reads the thresholds of a kind of operation from a comma separated list of
+// currencies and amounts, like USD:10000.00,EUR:9000.00
+func ParseThresholds(s string) (map[money.Currency]money.Amount, error) {
+	thresholds := make(map[money.Currency]money.Amount)
+	for _, field := range strings.Split(s, ",") {
+		currency, value, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok || currency == "" {
+			return nil, fmt.Errorf("%q must be a currency and an amount, like USD:100.00", field)
+		}
+
+		threshold, err := money.Parse(value, money.Currency(currency))
+		if err != nil {
+			return nil, err
+		}
+		thresholds[money.Currency(currency)] = threshold
+	}
+
+	return thresholds, nil
+}
+
+func ValidateOperation(v *validator.Validator, op *Operation) {
+	kinds := []string{KindDeposit, KindLoanApproval, KindLoanDeletion}
+	v.CheckAddError(validator.ValueInList(op.Kind, kinds...), "kind", "invalid")
+	v.CheckAddError(len(op.Payload) > 0, "payload", "must be given")
+	v.CheckAddError(op.RequestedBy > 0, "requested_by", "must be given")
+}
@@ -0,0 +1,70 @@
+package approval
+
+import (
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+)
+
+func TestThresholdsRequires(t *testing.T) {
+	thresholds := Thresholds{
+		Deposit: map[money.Currency]money.Amount{
+			"USD": money.MustParse("10000"),
+			"EUR": money.MustParse("100").WithCurrency("EUR"),
+		},
+		LoanApproval: map[money.Currency]money.Amount{"USD": money.MustParse("5000")},
+		LoanDeletion: map[money.Currency]money.Amount{"USD": money.MustParse("0")},
+	}
+
+	tests := []struct {
+		name   string
+		kind   string
+		amount money.Amount
+		want   bool
+	}{
+		{name: "deposit at the threshold", kind: KindDeposit, amount: money.MustParse("10000")},
+		{
+			name: "deposit over the threshold", kind: KindDeposit,
+			amount: money.MustParse("10000.01"), want: true,
+		},
+		{name: "small loan", kind: KindLoanApproval, amount: money.MustParse("4999.99")},
+		{
+			name: "any loan deletion", kind: KindLoanDeletion, amount: money.MustParse("0.01"),
+			want: true,
+		},
+		{name: "unknown kind", kind: "TRANSFER", amount: money.MustParse("1000000")},
+		{
+			name: "deposit over the threshold of its currency", kind: KindDeposit,
+			amount: money.MustParse("100.01").WithCurrency("EUR"), want: true,
+		},
+		{
+			name: "currency without a threshold", kind: KindLoanApproval,
+			amount: money.MustParse("0.01").WithCurrency("GBP"), want: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := thresholds.Requires(tc.kind, tc.amount); got != tc.want {
+				t.Errorf("expected requires=%v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	thresholds, err := ParseThresholds("USD:10000.00, EUR:9000")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(thresholds) != 2 || thresholds["USD"].String() != "10000.00" ||
+		thresholds["EUR"].String() != "9000.00" || thresholds["EUR"].Currency() != "EUR" {
+		t.Errorf("unexpected thresholds %v", thresholds)
+	}
+
+	for _, s := range []string{"", "10000.00", "USD:", ":10000.00", "USD:ten"} {
+		if _, err := ParseThresholds(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
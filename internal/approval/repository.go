@@ -0,0 +1,234 @@
+package approval
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+const operationColumns = `
+	id, created_at, kind, payload, amount, requested_by, status, decided_by, decided_at,
+	expires_at, error
+`
+
+// scanOperation reads a row of operationColumns, after any columns selected before them into
+// leading
+func scanOperation(row interface{ Scan(...any) error }, leading ...any) (*Operation, error) {
+	var op Operation
+	var payload []byte
+	err := row.Scan(append(
+		leading,
+		&op.ID,
+		&op.CreatedAt,
+		&op.Kind,
+		&payload,
+		&op.Amount,
+		&op.RequestedBy,
+		&op.Status,
+		&op.DecidedBy,
+		&op.DecidedAt,
+		&op.ExpiresAt,
+		&op.Error,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	op.Payload = payload
+
+	return &op, nil
+}
+
+// Insert holds the operation, then runs then in the same transaction if it is given
+func (r *Repository) Insert(ctx context.Context, op *Operation, then database.TxFunc) error {
+	query := `
+		INSERT INTO pending_operations (kind, payload, amount, requested_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, status
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := []any{op.Kind, []byte(op.Payload), op.Amount, op.RequestedBy, op.ExpiresAt}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&op.ID, &op.CreatedAt, &op.Status)
+	if err != nil {
+		return err
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) Get(ctx context.Context, id int64) (*Operation, error) {
+	query := `SELECT ` + operationColumns + `
+		FROM pending_operations
+		WHERE id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	op, err := scanOperation(r.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return op, nil
+}
+
+// DecidedFunc is work done inside the transaction that decides an operation, with the operation
+// as it was decided, before it is committed
+type DecidedFunc func(ctx context.Context, tx *sql.Tx, op *Operation) error
+
+// Decide moves the operation on from pending to status, as long as it's still pending, hasn't
+// expired and the one deciding didn't ask for it, then runs then in the same transaction if it is
+// given. ErrNotPending or ErrSameUser is returned otherwise, the database refuses a decision by
+// the requester as well
+func (r *Repository) Decide(
+	ctx context.Context, id, decidedBy int64, status string, now time.Time, then DecidedFunc,
+) (*Operation, error) {
+	query := `
+		UPDATE pending_operations
+		SET status = $3, decided_by = $2, decided_at = $4
+		WHERE id = $1 AND status = 'PENDING' AND expires_at > $4 AND requested_by <> $2
+		RETURNING ` + operationColumns
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	op, err := scanOperation(tx.QueryRowContext(ctx, query, id, decidedBy, status, now))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		// work out why it couldn't be decided
+		op, err = r.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if op.RequestedBy == decidedBy {
+			return nil, ErrSameUser
+		}
+		return nil, ErrNotPending
+	}
+
+	if then != nil {
+		err = then(ctx, tx, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// Fail marks an approved operation as failed, with the reason it couldn't be carried out
+func (r *Repository) Fail(ctx context.Context, id int64, reason string) error {
+	query := `
+		UPDATE pending_operations
+		SET status = 'FAILED', error = $2
+		WHERE id = $1 AND status = 'APPROVED'
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, id, reason)
+	return err
+}
+
+// Expire marks the operations still pending at their expiry as expired and returns how many
+func (r *Repository) Expire(ctx context.Context, now time.Time) (int64, error) {
+	query := `
+		UPDATE pending_operations
+		SET status = 'EXPIRED'
+		WHERE status = 'PENDING' AND expires_at <= $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, query, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GetAll returns a page of the operations with the status, or of all of them if status is empty
+func (r *Repository) GetAll(
+	ctx context.Context, status string, f filter.Filters,
+) ([]*Operation, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM pending_operations
+		WHERE (status = $1 OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3
+	`, operationColumns, f.SortColumn(), f.SortDirection())
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, status, f.Limit(), f.Offset())
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	operations := []*Operation{}
+	for rows.Next() {
+		op, err := scanOperation(rows, &totalRecords)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		operations = append(operations, op)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return operations, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
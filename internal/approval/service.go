@@ -0,0 +1,169 @@
+package approval
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/approval")
+
+var (
+	ErrSameUser   = errors.New("an operation must be decided by someone other than who asked for it")
+	ErrNotPending = errors.New("operation is no longer pending")
+)
+
+type Repo interface {
+	Insert(ctx context.Context, op *Operation, then database.TxFunc) error
+	Get(ctx context.Context, id int64) (*Operation, error)
+	Decide(
+		ctx context.Context, id, decidedBy int64, status string, now time.Time, then DecidedFunc,
+	) (*Operation, error)
+	Fail(ctx context.Context, id int64, reason string) error
+	Expire(ctx context.Context, now time.Time) (int64, error)
+	GetAll(ctx context.Context, status string, f filter.Filters) ([]*Operation, filter.Metadata, error)
+}
+
+// Auditor records who asked for an operation and who decided it, in the transactions that do so
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
+}
+
+type Service struct {
+	Repo    Repo
+	TTL     time.Duration // how long an operation waits for approval before it expires
+	Auditor Auditor       // optional
+}
+
+// Submit holds an operation of the kind for the amount, asked for by the user requestedBy with the
+// payload, until someone else approves it
+func (s *Service) Submit(
+	ctx context.Context, v *validator.Validator, kind string, payload any, amount money.Amount,
+	requestedBy int64,
+) (*Operation, error) {
+	ctx, span := tracer.Start(ctx, "approval.Submit")
+	defer span.End()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &Operation{
+		Kind:        kind,
+		Payload:     data,
+		Amount:      amount,
+		RequestedBy: requestedBy,
+		ExpiresAt:   time.Now().Add(s.TTL),
+	}
+	if ValidateOperation(v, op); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
+	}
+
+	var submitted database.TxFunc
+	if s.Auditor != nil {
+		submitted = func(ctx context.Context, tx *sql.Tx) error {
+			return s.Auditor.RecordTx(
+				ctx, tx, audit.ActionOperationSubmitted, audit.TargetOperation, op.ID, nil, op,
+			)
+		}
+	}
+	err = s.Repo.Insert(ctx, op, submitted)
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+func (s *Service) Get(ctx context.Context, id int64) (*Operation, error) {
+	ctx, span := tracer.Start(ctx, "approval.Get")
+	defer span.End()
+
+	return s.Repo.Get(ctx, id)
+}
+
+// Approve approves the operation for the user approvedBy, who has to be someone other than who
+// asked for it. the caller carries the operation out and calls Fail if that doesn't work
+func (s *Service) Approve(ctx context.Context, id, approvedBy int64) (*Operation, error) {
+	ctx, span := tracer.Start(ctx, "approval.Approve")
+	defer span.End()
+
+	return s.decide(ctx, id, approvedBy, StatusApproved, audit.ActionOperationApproved)
+}
+
+// Reject turns the operation down, again by someone other than who asked for it
+func (s *Service) Reject(ctx context.Context, id, rejectedBy int64) (*Operation, error) {
+	ctx, span := tracer.Start(ctx, "approval.Reject")
+	defer span.End()
+
+	return s.decide(ctx, id, rejectedBy, StatusRejected, audit.ActionOperationRejected)
+}
+
+// decide moves the operation on to status for the user decidedBy, auditing it as action
+func (s *Service) decide(
+	ctx context.Context, id, decidedBy int64, status, action string,
+) (*Operation, error) {
+	var decided DecidedFunc
+	if s.Auditor != nil {
+		decided = func(ctx context.Context, tx *sql.Tx, op *Operation) error {
+			// only a pending operation can be decided, and it wasn't decided by anyone then
+			pending := *op
+			pending.Status = StatusPending
+			pending.DecidedBy = nil
+			pending.DecidedAt = nil
+			return s.Auditor.RecordTx(
+				ctx, tx, action, audit.TargetOperation, op.ID, pending, op,
+			)
+		}
+	}
+
+	return s.Repo.Decide(ctx, id, decidedBy, status, time.Now(), decided)
+}
+
+// Fail records that the approved operation couldn't be carried out, and why
+func (s *Service) Fail(ctx context.Context, op *Operation, cause error) error {
+	ctx, span := tracer.Start(ctx, "approval.Fail")
+	defer span.End()
+
+	op.Status = StatusFailed
+	op.Error = cause.Error()
+	return s.Repo.Fail(ctx, op.ID, op.Error)
+}
+
+// Expire marks the operations that waited too long for approval as expired. they can't be
+// approved once expired either way, this only shows it
+func (s *Service) Expire(ctx context.Context) (int64, error) {
+	ctx, span := tracer.Start(ctx, "approval.Expire")
+	defer span.End()
+
+	return s.Repo.Expire(ctx, time.Now())
+}
+
+// GetAll returns a page of the operations with the status, or of all of them if status is empty
+func (s *Service) GetAll(
+	ctx context.Context, v *validator.Validator, status string, f filter.Filters,
+) ([]*Operation, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "approval.GetAll")
+	defer span.End()
+
+	if status != "" {
+		v.CheckAddError(validator.ValueInList(status, Statuses...), "status", "invalid")
+	}
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetAll(ctx, status, f)
+}
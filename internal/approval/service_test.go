@@ -0,0 +1,162 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+type MockRepo struct {
+	InsertErr error
+	Inserted  *Operation
+
+	DecideResult *Operation
+	DecideErr    error
+	DecidedBy    int64
+	DecidedTo    string
+
+	FailErr    error
+	FailReason string
+}
+
+func (r *MockRepo) Insert(ctx context.Context, op *Operation, then database.TxFunc) error {
+	r.Inserted = op
+	if r.InsertErr != nil {
+		return r.InsertErr
+	}
+	if then != nil {
+		return then(ctx, nil)
+	}
+	return nil
+}
+
+func (r *MockRepo) Get(ctx context.Context, id int64) (*Operation, error) {
+	return r.DecideResult, nil
+}
+
+func (r *MockRepo) Decide(
+	ctx context.Context, id, decidedBy int64, status string, now time.Time, then DecidedFunc,
+) (*Operation, error) {
+	r.DecidedBy, r.DecidedTo = decidedBy, status
+	if r.DecideErr != nil {
+		return nil, r.DecideErr
+	}
+	if then != nil {
+		err := then(ctx, nil, r.DecideResult)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.DecideResult, nil
+}
+
+func (r *MockRepo) Fail(ctx context.Context, id int64, reason string) error {
+	r.FailReason = reason
+	return r.FailErr
+}
+
+func (r *MockRepo) Expire(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (r *MockRepo) GetAll(
+	ctx context.Context, status string, f filter.Filters,
+) ([]*Operation, filter.Metadata, error) {
+	return []*Operation{}, filter.Metadata{}, nil
+}
+
+func TestSubmit(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		kind        string
+		requestedBy int64
+		expectedErr error
+	}{
+		{
+			name:        "valid",
+			setupRepo:   func(r *MockRepo) {},
+			kind:        KindDeposit,
+			requestedBy: 1,
+		},
+		{
+			name:        "unknown kind",
+			setupRepo:   func(r *MockRepo) {},
+			kind:        "TRANSFER",
+			requestedBy: 1,
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "Insert failure",
+			setupRepo: func(r *MockRepo) {
+				r.InsertErr = errors.New("db Insert error")
+			},
+			kind:        KindDeposit,
+			requestedBy: 1,
+			expectedErr: errors.New("db Insert error"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+
+			svc := Service{Repo: repo, TTL: time.Hour}
+			payload := map[string]string{"account_number": "1000000009"}
+			op, gotErr := svc.Submit(
+				context.Background(), validator.New(), tc.kind, payload, money.MustParse(
+					"20000",
+				), tc.requestedBy,
+			)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error %v", gotErr)
+			}
+
+			if string(op.Payload) != `{"account_number":"1000000009"}` {
+				t.Errorf("expected the payload kept as JSON, got %s", op.Payload)
+			}
+			if until := time.Until(op.ExpiresAt); until < 59*time.Minute || until > time.Hour {
+				t.Errorf("expected the operation to expire in an hour, got %v", until)
+			}
+		})
+	}
+}
+
+func TestApproveAndFail(t *testing.T) {
+	repo := &MockRepo{DecideResult: &Operation{ID: 1, Status: StatusApproved}}
+	svc := Service{Repo: repo}
+
+	op, err := svc.Approve(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if repo.DecidedBy != 2 || repo.DecidedTo != StatusApproved {
+		t.Errorf("expected approved by 2, got %s by %d", repo.DecidedTo, repo.DecidedBy)
+	}
+
+	err = svc.Fail(context.Background(), op, errors.New("insufficient funds"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if op.Status != StatusFailed || repo.FailReason != "insufficient funds" {
+		t.Errorf("expected the operation failed with its reason, got %s %q", op.Status, op.Error)
+	}
+
+	repo.DecideErr = ErrSameUser
+	_, err = svc.Reject(context.Background(), 1, 1)
+	if !errors.Is(err, ErrSameUser) {
+		t.Errorf("expected %v, got %v", ErrSameUser, err)
+	}
+}
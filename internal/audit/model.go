@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// the privileged actions that are recorded
+const (
+	ActionDeposit             = "DEPOSIT"
+	ActionWithdrawal          = "WITHDRAWAL"
+	ActionLoanRequestAccepted = "LOAN_REQUEST_ACCEPTED"
+	ActionLoanRequestDeclined = "LOAN_REQUEST_DECLINED"
+	ActionLoanDeleted         = "LOAN_DELETED"
+	ActionOperationSubmitted  = "OPERATION_SUBMITTED"
+	ActionOperationApproved   = "OPERATION_APPROVED"
+	ActionOperationRejected   = "OPERATION_REJECTED"
+	ActionPermissionCreated   = "PERMISSION_CREATED"
+	ActionRoleCreated         = "ROLE_CREATED"
+	ActionRoleAssigned        = "ROLE_ASSIGNED"
+	ActionRoleRevoked         = "ROLE_REVOKED"
+	ActionAccountStatus       = "ACCOUNT_STATUS_CHANGED"
+	ActionLockoutCleared      = "LOCKOUT_CLEARED"
+	ActionSessionsRevoked     = "SESSIONS_REVOKED"
+	ActionOutboxRetried       = "OUTBOX_RETRIED"
+	ActionWebhookCreated      = "WEBHOOK_CREATED"
+	ActionWebhookUpdated      = "WEBHOOK_UPDATED"
+	ActionWebhookDeleted      = "WEBHOOK_DELETED"
+	ActionWebhookReplayed     = "WEBHOOK_REPLAYED"
+)
+
+var Actions = []string{
+	ActionDeposit, ActionWithdrawal, ActionLoanRequestAccepted, ActionLoanRequestDeclined,
+	ActionLoanDeleted, ActionOperationSubmitted, ActionOperationApproved, ActionOperationRejected,
+	ActionPermissionCreated, ActionRoleCreated, ActionRoleAssigned, ActionRoleRevoked,
+	ActionAccountStatus, ActionLockoutCleared, ActionSessionsRevoked, ActionOutboxRetried,
+	ActionWebhookCreated, ActionWebhookUpdated, ActionWebhookDeleted, ActionWebhookReplayed,
+}
+
+// what an action can be done to
+const (
+	TargetAccount         = "account"
+	TargetLoan            = "loan"
+	TargetLoanRequest     = "loan_request"
+	TargetOperation       = "operation"
+	TargetPermission      = "permission"
+	TargetRole            = "role"
+	TargetUser            = "user"
+	TargetLockout         = "lockout"
+	TargetOutboxMessage   = "outbox_message"
+	TargetWebhook         = "webhook"
+	TargetWebhookDelivery = "webhook_delivery"
+)
+
+// SortSafelist is what events can be listed by
+var SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+// Event is a privileged action, who did it to what and what it changed. every event carries the
+// hash of the one before it, so one that is changed, removed or put in out of order breaks the
+// chain from there on
+type Event struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    int64           `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Changes    json.RawMessage `json:"changes"` // the fields that changed, see Diff
+	IP         string          `json:"ip"`
+	RequestID  string          `json:"request_id"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// Change is what a field was before the action and what it is after it, null on the side it
+// didn't exist on
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// toFields turns what a target looked like into its fields, by way of its JSON. nil has none and
+// something that isn't a JSON object is a single field called value
+func toFields(v any) (map[string]any, error) {
+	if v == nil {
+		return map[string]any{}, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.UseNumber()
+	if err = decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return map[string]any{"value": value}, nil
+	}
+	return fields, nil
+}
+
+// Diff returns the fields, by name, that differ between what the target looked like before and
+// after the action. either can be nil, for things that were created or removed
+func Diff(before, after any) (json.RawMessage, error) {
+	beforeFields, err := toFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := toFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]Change{}
+	for name, value := range beforeFields {
+		changes[name] = Change{Before: value}
+	}
+	for name, value := range afterFields {
+		change := changes[name]
+		change.After = value
+		changes[name] = change
+	}
+
+	for name, change := range changes {
+		beforeJSON, _ := json.Marshal(change.Before)
+		afterJSON, _ := json.Marshal(change.After)
+		if bytes.Equal(beforeJSON, afterJSON) {
+			delete(changes, name)
+		}
+	}
+
+	return json.Marshal(changes)
+}
+
+// canonical returns the JSON in one form, however it was spaced or its keys ordered, so it hashes
+// the same after a round trip through a JSONB column
+func canonical(js json.RawMessage) ([]byte, error) {
+	if len(js) == 0 {
+		return []byte("null"), nil
+	}
+
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value)
+}
+
+// ComputeHash returns the hex SHA-256 of the event and the hash of the one before it. each field
+// is prefixed with its length, so moving text from one field to the next changes the hash
+func (e *Event) ComputeHash() (string, error) {
+	changes, err := canonical(e.Changes)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, field := range []string{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		strconv.FormatInt(e.ActorID, 10),
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		string(changes),
+		e.IP,
+		e.RequestID,
+	} {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	type account struct {
+		Number string `json:"number"`
+		Status string `json:"status"`
+	}
+
+	tests := []struct {
+		name   string
+		before any
+		after  any
+		want   string
+	}{
+		{
+			name:   "changed",
+			before: account{Number: "1", Status: "ACTIVE"},
+			after:  account{Number: "1", Status: "FROZEN"},
+			want:   `{"status":{"before":"ACTIVE","after":"FROZEN"}}`,
+		},
+		{
+			name:  "created",
+			after: account{Number: "1", Status: "ACTIVE"},
+			want: `{"number":{"before":null,"after":"1"},` +
+				`"status":{"before":null,"after":"ACTIVE"}}`,
+		},
+		{
+			name:   "removed",
+			before: map[string]any{"role": "ADMIN"},
+			want:   `{"role":{"before":"ADMIN","after":null}}`,
+		},
+		{
+			name:   "nothing changed",
+			before: account{Number: "1"},
+			after:  account{Number: "1"},
+			want:   `{}`,
+		},
+		{
+			name:  "not an object",
+			after: "ADMIN",
+			want:  `{"value":{"before":null,"after":"ADMIN"}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Diff(tc.before, tc.after)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestComputeHash(t *testing.T) {
+	event := &Event{
+		CreatedAt:  time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+		ActorID:    1,
+		Action:     ActionDeposit,
+		TargetType: TargetAccount,
+		TargetID:   "4000001234567899",
+		Changes:    json.RawMessage(`{"amount":{"before":null,"after":"10.00"},"id":{"after":1}}`),
+		IP:         "127.0.0.1",
+		RequestID:  "abc",
+	}
+	hash, err := event.ComputeHash()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the same event read back from postgres, which spaces JSONB and orders its keys its own way,
+	// in the time zone of the connection
+	readBack := *event
+	readBack.Changes = json.RawMessage(
+		`{"id": {"after": 1}, "amount": {"after": "10.00", "before": null}}`,
+	)
+	readBack.CreatedAt = event.CreatedAt.In(time.FixedZone("EAT", 3*60*60))
+	got, err := readBack.ComputeHash()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got != hash {
+		t.Errorf("expected the same hash read back, got %s and %s", hash, got)
+	}
+
+	// fields are length prefixed, so moving text between them isn't the same event
+	moved := *event
+	moved.TargetType, moved.TargetID = TargetAccount+"4", "000001234567899"
+	got, _ = moved.ComputeHash()
+	if got == hash {
+		t.Error("expected a different hash with text moved between fields")
+	}
+
+	chained := *event
+	chained.PrevHash = "abc"
+	got, _ = chained.ComputeHash()
+	if got == hash {
+		t.Error("expected a different hash following another event")
+	}
+}
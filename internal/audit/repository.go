@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// the columns of an event, in the order scanEvent reads them
+const eventColumns = `id, created_at, actor_id, action, target_type, target_id, changes, ip,
+	request_id, prev_hash, hash`
+
+// scanEvent reads a row of eventColumns, after any columns selected before them into leading
+func scanEvent(row interface{ Scan(...any) error }, leading ...any) (*Event, error) {
+	var event Event
+	var changes []byte
+	err := row.Scan(append(
+		leading,
+		&event.ID,
+		&event.CreatedAt,
+		&event.ActorID,
+		&event.Action,
+		&event.TargetType,
+		&event.TargetID,
+		&changes,
+		&event.IP,
+		&event.RequestID,
+		&event.PrevHash,
+		&event.Hash,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	event.Changes = changes
+
+	return &event, nil
+}
+
+// AppendTx adds the event to the end of the chain inside tx, the transaction of the action it is
+// about, setting its time and hashes. the action and its event are committed or rolled back
+// together. events are appended one at a time, under a lock held until tx ends, so no two of them
+// can follow the same one
+func AppendTx(ctx context.Context, tx *sql.Tx, event *Event) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('audit_events'))`)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(
+		ctx, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`,
+	).Scan(&event.PrevHash)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		// the first event follows nothing
+		event.PrevHash = ""
+	}
+
+	// postgres keeps microseconds, anything finer would be lost and the hash wouldn't match
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	event.Hash, err = event.ComputeHash()
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_events (
+			created_at, actor_id, action, target_type, target_id, changes, ip, request_id,
+			prev_hash, hash
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`
+	args := []any{
+		event.CreatedAt,
+		event.ActorID,
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		[]byte(event.Changes),
+		event.IP,
+		event.RequestID,
+		event.PrevHash,
+		event.Hash,
+	}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&event.ID)
+}
+
+// GetAll returns a page of the events, of the actor if actorID isn't 0 and with the action and of
+// the target if they are given
+func (r *Repository) GetAll(
+	ctx context.Context, actorID int64, action, targetType, targetID string, f filter.Filters,
+) ([]*Event, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM audit_events
+		WHERE (actor_id = $1 OR $1 = 0)
+		AND (action = $2 OR $2 = '')
+		AND (target_type = $3 OR $3 = '')
+		AND (target_id = $4 OR $4 = '')
+		AND ($5::timestamptz IS NULL OR created_at >= $5)
+		AND ($6::timestamptz IS NULL OR created_at < $6)
+		ORDER BY %s %s, id ASC
+		LIMIT $7 OFFSET $8
+	`, eventColumns, f.SortColumn(), f.SortDirection())
+	args := []any{actorID, action, targetType, targetID, f.From, f.To, f.Limit(), f.Offset()}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows, &totalRecords)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return events, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+// GetAfter returns up to limit events, in the order they were appended, starting after the event
+// afterID. it's how the chain is walked
+func (r *Repository) GetAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM audit_events
+		WHERE id > $1
+		ORDER BY id ASC
+		LIMIT $2
+	`, eventColumns)
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// HasHash returns whether an event with the hash is in the log
+func (r *Repository) HasHash(ctx context.Context, hash string) (bool, error) {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var exists bool
+	err := r.DB.QueryRowContext(
+		ctx, `SELECT EXISTS(SELECT 1 FROM audit_events WHERE hash = $1)`, hash,
+	).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/audit")
+
+type Repo interface {
+	GetAll(
+		ctx context.Context, actorID int64, action, targetType, targetID string, f filter.Filters,
+	) ([]*Event, filter.Metadata, error)
+	GetAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error)
+	HasHash(ctx context.Context, hash string) (bool, error)
+}
+
+type Service struct {
+	Repo Repo
+}
+
+// RecordTx appends the event inside tx, the transaction of the action, with what changed between
+// before and after, what the target looked like either side of the action. either can be nil. the
+// event is only in the log if the action is committed, and one that can't be appended fails it
+func RecordTx(ctx context.Context, tx *sql.Tx, event *Event, before, after any) error {
+	ctx, span := tracer.Start(ctx, "audit.RecordTx")
+	defer span.End()
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		return err
+	}
+	event.Changes = changes
+
+	return AppendTx(ctx, tx, event)
+}
+
+// GetAll returns a page of the events, of the actor if actorID isn't 0 and with the action and of
+// the target if they are given
+func (s *Service) GetAll(
+	ctx context.Context, v *validator.Validator, actorID int64, action, targetType, targetID string,
+	f filter.Filters,
+) ([]*Event, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "audit.GetAll")
+	defer span.End()
+
+	if action != "" {
+		v.CheckAddError(validator.ValueInList(action, Actions...), "action", "invalid")
+	}
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetAll(ctx, actorID, action, targetType, targetID, f)
+}
+
+// Report is the result of walking the chain. BrokenAt is the first event that doesn't check out,
+// 0 if they all did, and Head the hash of the last event that did. keeping the head somewhere
+// else is how events removed from the end are noticed, nothing after them is left to say so
+type Report struct {
+	Checked  int    `json:"checked"`
+	Head     string `json:"head"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func (r *Report) OK() bool {
+	return r.BrokenAt == 0
+}
+
+// Verify walks the chain from the first event, batchSize events at a time, checking that each
+// one hashes to what it says and follows the one before it. it stops at the first that doesn't
+func (s *Service) Verify(ctx context.Context, batchSize int) (*Report, error) {
+	ctx, span := tracer.Start(ctx, "audit.Verify")
+	defer span.End()
+
+	report := &Report{}
+	var lastID int64
+
+	for {
+		events, err := s.Repo.GetAfter(ctx, lastID, batchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			return report, nil
+		}
+
+		for _, event := range events {
+			if event.PrevHash != report.Head {
+				report.BrokenAt = event.ID
+				report.Reason = fmt.Sprintf(
+					"doesn't follow event %d, events were removed or put in before it", lastID,
+				)
+				if lastID == 0 {
+					report.Reason = "doesn't start the chain, events before it were removed"
+				}
+				return report, nil
+			}
+
+			hash, err := event.ComputeHash()
+			if err != nil {
+				return nil, err
+			}
+			if hash != event.Hash {
+				report.BrokenAt = event.ID
+				report.Reason = "was changed after it was recorded"
+				return report, nil
+			}
+
+			report.Checked++
+			report.Head = event.Hash
+			lastID = event.ID
+		}
+	}
+}
+
+// HasHash returns whether the event with the hash is still in the log. a head kept from an earlier
+// Verify that isn't means events were removed from the end
+func (s *Service) HasHash(ctx context.Context, hash string) (bool, error) {
+	ctx, span := tracer.Start(ctx, "audit.HasHash")
+	defer span.End()
+
+	return s.Repo.HasHash(ctx, hash)
+}
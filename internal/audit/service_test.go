@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// ---MOCKS---
+
+// MockRepo chains the events appended to it the same way AppendTx does
+type MockRepo struct {
+	Events []*Event
+}
+
+func (r *MockRepo) Append(ctx context.Context, event *Event) error {
+	event.ID = int64(len(r.Events) + 1)
+	if len(r.Events) > 0 {
+		event.PrevHash = r.Events[len(r.Events)-1].Hash
+	}
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	var err error
+	event.Hash, err = event.ComputeHash()
+	if err != nil {
+		return err
+	}
+	r.Events = append(r.Events, event)
+	return nil
+}
+
+func (r *MockRepo) GetAll(
+	ctx context.Context, actorID int64, action, targetType, targetID string, f filter.Filters,
+) ([]*Event, filter.Metadata, error) {
+	return []*Event{}, filter.Metadata{}, nil
+}
+
+func (r *MockRepo) GetAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	events := []*Event{}
+	for _, event := range r.Events {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (r *MockRepo) HasHash(ctx context.Context, hash string) (bool, error) {
+	for _, event := range r.Events {
+		if event.Hash == hash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// record appends n events to the repo
+func record(t *testing.T, repo *MockRepo, n int) {
+	t.Helper()
+	for i := range n {
+		changes, err := Diff(nil, map[string]any{"role": "ADMIN", "n": i})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		event := &Event{
+			ActorID: 1, Action: ActionRoleAssigned, TargetType: TargetUser, TargetID: "2",
+			Changes: changes,
+		}
+		err = repo.Append(context.Background(), event)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name           string
+		tamper         func(repo *MockRepo)
+		expectedBroken int64
+	}{
+		{name: "untouched", tamper: func(repo *MockRepo) {}},
+		{
+			name: "changed",
+			tamper: func(repo *MockRepo) {
+				repo.Events[2].Changes = []byte(`{"role":{"before":null,"after":"SUPERUSER"}}`)
+			},
+			expectedBroken: 3,
+		},
+		{
+			name: "changed and hashed again",
+			tamper: func(repo *MockRepo) {
+				repo.Events[2].ActorID = 7
+				repo.Events[2].Hash, _ = repo.Events[2].ComputeHash()
+			},
+			expectedBroken: 4,
+		},
+		{
+			name: "removed",
+			tamper: func(repo *MockRepo) {
+				repo.Events = append(repo.Events[:1], repo.Events[2:]...)
+			},
+			expectedBroken: 3,
+		},
+		{
+			name: "first removed",
+			tamper: func(repo *MockRepo) {
+				repo.Events = repo.Events[1:]
+			},
+			expectedBroken: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			svc := Service{Repo: repo}
+			record(t, repo, 5)
+			tc.tamper(repo)
+
+			// a small batch so the chain is checked across batches
+			report, err := svc.Verify(context.Background(), 2)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if report.BrokenAt != tc.expectedBroken {
+				t.Fatalf(
+					"expected broken at %d, got %d: %s", tc.expectedBroken, report.BrokenAt,
+					report.Reason,
+				)
+			}
+			if report.OK() && (report.Checked != 5 || report.Head != repo.Events[4].Hash) {
+				t.Errorf("expected 5 checked up to the last event, got %+v", report)
+			}
+		})
+	}
+}
+
+func TestVerifyHeadRemoved(t *testing.T) {
+	repo := &MockRepo{}
+	svc := Service{Repo: repo}
+	record(t, repo, 3)
+
+	report, err := svc.Verify(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the rest of the chain still checks out without its last event, only the head says otherwise
+	repo.Events = repo.Events[:2]
+	after, err := svc.Verify(context.Background(), 10)
+	if err != nil || !after.OK() {
+		t.Fatalf("expected what is left to check out, got %+v, %v", after, err)
+	}
+	found, err := svc.HasHash(context.Background(), report.Head)
+	if err != nil || found {
+		t.Errorf("expected the old head gone, got %v, %v", found, err)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      string
+		expectedErr error
+	}{
+		{name: "any action"},
+		{name: "deposits", action: ActionDeposit},
+		{name: "unknown action", action: "HACK", expectedErr: validator.ErrFailedValidation},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := Service{Repo: &MockRepo{}}
+			f := filter.Filters{
+				Page: 1, PageSize: 20, Sort: "-created_at", SortSafelist: SortSafelist,
+			}
+
+			_, _, err := svc.GetAll(context.Background(), validator.New(), 0, tc.action, "", "", f)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
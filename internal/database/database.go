@@ -0,0 +1,24 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DefaultTimeout is how long a query can take when its repository wasn't given a timeout
+const DefaultTimeout = 3 * time.Second
+
+// WithTimeout returns a copy of ctx that is cancelled after timeout, DefaultTimeout if it's 0. a
+// query made with it is still cancelled sooner if ctx is, when the client of a request goes away
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// TxFunc is work done inside a transaction owned by someone else, before they commit it. returning
+// an error rolls the whole transaction back
+type TxFunc func(ctx context.Context, tx *sql.Tx) error
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{name: "given", timeout: time.Second, want: time.Second},
+		{name: "not given", timeout: 0, want: DefaultTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			ctx, cancel := WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("expected a deadline")
+			}
+			if got := deadline.Sub(start); got < tc.want || got > tc.want+time.Second {
+				t.Errorf("expected a deadline %v away, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWithTimeoutParentCancelled(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := WithTimeout(parent, time.Hour)
+	defer cancel()
+
+	cancelParent()
+	if ctx.Err() == nil {
+		t.Error("expected the query to be cancelled with the request")
+	}
+}
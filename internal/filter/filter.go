@@ -0,0 +1,119 @@
+package filter
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// the directions money can move in, seen from the user listing it. what counts as in or out depends
+// on the list, e.g. deposits are in and withdrawals are out
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+const (
+	DefaultPage     = 1
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Filters holds the pagination, sorting and filtering options of a list request. the bounds are
+// pointers so that a missing bound can be passed to the queries as NULL, which they treat as no
+// bound at all
+type Filters struct {
+	Page     int
+	PageSize int
+
+	// Sort is a column name, prefixed with "-" to sort in descending order. it must be in
+	// SortSafelist, because it ends up in the query as is
+	Sort         string
+	SortSafelist []string
+
+	// From is inclusive and To is exclusive
+	From *time.Time
+	To   *time.Time
+
+	MinAmount *money.Amount
+	MaxAmount *money.Amount
+
+	Direction string
+}
+
+// Metadata describes the page that was returned so clients can ask for the next one
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records"`
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.CheckAddError(f.Page > 0, "page", "must be greater than 0")
+	v.CheckAddError(f.Page <= 10_000_000, "page", "must be at most 10 million")
+	v.CheckAddError(f.PageSize > 0, "page_size", "must be greater than 0")
+	v.CheckAddError(f.PageSize <= MaxPageSize, "page_size", "must be at most 100")
+
+	v.CheckAddError(validator.ValueInList(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	if f.From != nil && f.To != nil {
+		v.CheckAddError(f.From.Before(*f.To), "from", "must be before to")
+	}
+
+	if f.MinAmount != nil && f.MaxAmount != nil {
+		v.CheckAddError(
+			!f.MaxAmount.LessThan(*f.MinAmount), "min_amount", "must not be more than max_amount",
+		)
+	}
+
+	v.CheckAddError(
+		validator.ValueInList(f.Direction, "", DirectionIn, DirectionOut), "direction", "invalid",
+	)
+}
+
+// SortColumn returns the column to sort by. it panics if the sort value is not in the safelist,
+// which can only happen if the filters were never validated
+func (f Filters) SortColumn() string {
+	if slices.Contains(f.SortSafelist, f.Sort) {
+		return strings.TrimPrefix(f.Sort, "-")
+	}
+
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+func (f Filters) SortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+
+	return "ASC"
+}
+
+func (f Filters) Limit() int {
+	return f.PageSize
+}
+
+func (f Filters) Offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// CalculateMetadata works out the metadata of a page, an empty result has empty metadata
+func CalculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
@@ -0,0 +1,156 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestValidateFilters(t *testing.T) {
+	valid := func() Filters {
+		return Filters{
+			Page:         DefaultPage,
+			PageSize:     DefaultPageSize,
+			Sort:         "-created_at",
+			SortSafelist: []string{"created_at", "-created_at"},
+		}
+	}
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	nextDay := day.Add(24 * time.Hour)
+	small, big := money.MustParse("10"), money.MustParse("100")
+
+	tests := []struct {
+		name      string
+		change    func(*Filters)
+		wantValid bool
+		wantKey   string
+	}{
+		{
+			name:      "defaults",
+			change:    func(f *Filters) {},
+			wantValid: true,
+		},
+		{
+			name: "all bounds",
+			change: func(f *Filters) {
+				f.From, f.To = &day, &nextDay
+				f.MinAmount, f.MaxAmount = &small, &big
+				f.Direction = DirectionIn
+			},
+			wantValid: true,
+		},
+		{
+			name:    "page 0",
+			change:  func(f *Filters) { f.Page = 0 },
+			wantKey: "page",
+		},
+		{
+			name:    "page size too big",
+			change:  func(f *Filters) { f.PageSize = MaxPageSize + 1 },
+			wantKey: "page_size",
+		},
+		{
+			name:    "sort not in safelist",
+			change:  func(f *Filters) { f.Sort = "password_hash" },
+			wantKey: "sort",
+		},
+		{
+			name:    "from after to",
+			change:  func(f *Filters) { f.From, f.To = &nextDay, &day },
+			wantKey: "from",
+		},
+		{
+			name:    "min more than max",
+			change:  func(f *Filters) { f.MinAmount, f.MaxAmount = &big, &small },
+			wantKey: "min_amount",
+		},
+		{
+			name:    "unknown direction",
+			change:  func(f *Filters) { f.Direction = "sideways" },
+			wantKey: "direction",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := valid()
+			tc.change(&f)
+
+			v := validator.New()
+			ValidateFilters(v, f)
+			if v.IsValid() != tc.wantValid {
+				t.Fatalf("expected valid=%v, got errors %v", tc.wantValid, v.Errors)
+			}
+			if _, ok := v.Errors[tc.wantKey]; tc.wantKey != "" && !ok {
+				t.Errorf("expected an error for %s, got %v", tc.wantKey, v.Errors)
+			}
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	f := Filters{Sort: "-amount", SortSafelist: []string{"amount", "-amount"}}
+	if f.SortColumn() != "amount" {
+		t.Errorf("expected column amount, got %s", f.SortColumn())
+	}
+	if f.SortDirection() != "DESC" {
+		t.Errorf("expected direction DESC, got %s", f.SortDirection())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected an unsafe sort value to panic")
+		}
+	}()
+	f.Sort = "amount; DROP TABLE users"
+	f.SortColumn()
+}
+
+func TestCalculateMetadata(t *testing.T) {
+	tests := []struct {
+		name                     string
+		totalRecords, page, size int
+		want                     Metadata
+	}{
+		{
+			name: "no records",
+			page: 1, size: 20,
+			want: Metadata{},
+		},
+		{
+			name:         "partial last page",
+			totalRecords: 45, page: 2, size: 20,
+			want: Metadata{
+				CurrentPage: 2, PageSize: 20, FirstPage: 1, LastPage: 3, TotalRecords: 45,
+			},
+		},
+		{
+			name:         "exact pages",
+			totalRecords: 40, page: 1, size: 20,
+			want: Metadata{
+				CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 2, TotalRecords: 40,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := CalculateMetadata(tc.totalRecords, tc.page, tc.size)
+			if got != tc.want {
+				t.Errorf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestOffset(t *testing.T) {
+	f := Filters{Page: 3, PageSize: 20}
+	if f.Offset() != 40 {
+		t.Errorf("expected offset 40, got %d", f.Offset())
+	}
+	if f.Limit() != 20 {
+		t.Errorf("expected limit 20, got %d", f.Limit())
+	}
+}
@@ -0,0 +1,46 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// Key is an Idempotency-Key sent by a user, along with the request it was first sent with and the
+// response that request got
+type Key struct {
+	UserID      int64
+	Key         string
+	CreatedAt   time.Time
+	Expiry      time.Time
+	RequestHash []byte
+	StatusCode  int // 0 while the first request is still being handled
+	// ResponseHeader holds the headers the handler set on the response, not those set for every
+	// request further up the chain
+	ResponseHeader http.Header
+	ResponseBody   []byte
+}
+
+// IsComplete reports whether the first request with the key has a response to replay
+func (k *Key) IsComplete() bool {
+	return k.StatusCode != 0
+}
+
+// Fingerprint hashes the parts of a request that have to match for it to count as a retry
+func Fingerprint(method, path string, body []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(path))
+	hash.Write([]byte{0})
+	hash.Write(body)
+
+	return hash.Sum(nil)
+}
+
+func ValidateKey(v *validator.Validator, key string) {
+	v.CheckAddError(key != "", "idempotency key", "must be given")
+	v.CheckAddError(len(key) <= 255, "idempotency key", "must not be more than 255 bytes long")
+}
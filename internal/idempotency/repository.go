@@ -0,0 +1,149 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Claim inserts the key if the user doesn't have a live one with the same value, an expired key is
+// taken over as if it was never there. claimed is false when a live key already exists
+func (r *Repository) Claim(ctx context.Context, key *Key) (bool, error) {
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, expiry, request_hash)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET created_at = NOW(), expiry = EXCLUDED.expiry, request_hash = EXCLUDED.request_hash,
+			status_code = NULL, response_header = NULL, response_body = NULL
+		WHERE idempotency_keys.expiry <= NOW()
+		RETURNING created_at
+	`
+
+	args := []any{
+		key.UserID,
+		key.Key,
+		key.Expiry,
+		key.RequestHash,
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&key.CreatedAt)
+	if err != nil {
+		switch {
+		// the conflicting key is still live, so nothing was inserted or updated
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (r *Repository) Get(ctx context.Context, userID int64, key string) (*Key, error) {
+	query := `
+		SELECT user_id, key, created_at, expiry, request_hash, COALESCE(status_code, 0),
+			COALESCE(response_header, '{}'), response_body
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var k Key
+	var header []byte
+	err := r.DB.QueryRowContext(ctx, query, userID, key).Scan(
+		&k.UserID,
+		&k.Key,
+		&k.CreatedAt,
+		&k.Expiry,
+		&k.RequestHash,
+		&k.StatusCode,
+		&header,
+		&k.ResponseBody,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(header, &k.ResponseHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+// Complete stores the response the first request with the key got
+func (r *Repository) Complete(
+	ctx context.Context, userID int64, key string, statusCode int, header http.Header,
+	body []byte,
+) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $1, response_header = $2, response_body = $3
+		WHERE user_id = $4 AND key = $5
+	`
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err = r.DB.ExecContext(ctx, query, statusCode, headerJSON, body, userID, key)
+	return err
+}
+
+func (r *Repository) Delete(ctx context.Context, userID int64, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// DeleteExpired removes the keys past their expiry and returns how many there were
+func (r *Repository) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expiry <= NOW()
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	result, err := r.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,112 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/idempotency")
+
+var (
+	ErrKeyReused  = errors.New("idempotency key reused with a different request")
+	ErrInProgress = errors.New("request with idempotency key still in progress")
+)
+
+type Repo interface {
+	Claim(ctx context.Context, key *Key) (bool, error)
+	Get(ctx context.Context, userID int64, key string) (*Key, error)
+	Complete(
+		ctx context.Context, userID int64, key string, statusCode int, header http.Header,
+		body []byte,
+	) error
+	Delete(ctx context.Context, userID int64, key string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type Service struct {
+	Repo Repo
+}
+
+// Begin claims the key for the request. a nil key is returned when the request is new, the caller
+// should then handle it and either Complete or Release the key. when the key was already used for
+// the same request the stored key is returned, so that its response can be replayed
+func (s *Service) Begin(
+	ctx context.Context, v *validator.Validator, userID int64, key string, requestHash []byte,
+	timeToLive time.Duration,
+) (*Key, error) {
+	ctx, span := tracer.Start(ctx, "idempotency.Begin")
+	defer span.End()
+
+	if ValidateKey(v, key); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
+	}
+
+	claimed, err := s.Repo.Claim(ctx, &Key{
+		UserID:      userID,
+		Key:         key,
+		Expiry:      time.Now().Add(timeToLive),
+		RequestHash: requestHash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	stored, err := s.Repo.Get(ctx, userID, key)
+	if err != nil {
+		switch {
+		// the key was released between the claim and now, which only happens while the first
+		// request is finishing, the client can retry
+		case errors.Is(err, user.ErrNoRecord):
+			return nil, ErrInProgress
+		default:
+			return nil, err
+		}
+	}
+
+	if !bytes.Equal(stored.RequestHash, requestHash) {
+		return nil, ErrKeyReused
+	}
+
+	if !stored.IsComplete() {
+		return nil, ErrInProgress
+	}
+
+	return stored, nil
+}
+
+// Complete stores the response to replay for later requests with the key
+func (s *Service) Complete(
+	ctx context.Context, userID int64, key string, statusCode int, header http.Header,
+	body []byte,
+) error {
+	ctx, span := tracer.Start(ctx, "idempotency.Complete")
+	defer span.End()
+
+	return s.Repo.Complete(ctx, userID, key, statusCode, header, body)
+}
+
+// Release gives up the key so that the request can be retried with it, only for when handling the
+// request failed without committing anything. a failure that might have is Completed instead
+func (s *Service) Release(ctx context.Context, userID int64, key string) error {
+	ctx, span := tracer.Start(ctx, "idempotency.Release")
+	defer span.End()
+
+	return s.Repo.Delete(ctx, userID, key)
+}
+
+func (s *Service) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, span := tracer.Start(ctx, "idempotency.DeleteExpired")
+	defer span.End()
+
+	return s.Repo.DeleteExpired(ctx)
+}
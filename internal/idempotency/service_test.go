@@ -0,0 +1,140 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+type MockRepo struct {
+	ClaimResult bool
+	ClaimErr    error
+
+	GetResult *Key
+	GetErr    error
+}
+
+func (r *MockRepo) Claim(ctx context.Context, key *Key) (bool, error) {
+	return r.ClaimResult, r.ClaimErr
+}
+
+func (r *MockRepo) Get(ctx context.Context, userID int64, key string) (*Key, error) {
+	if r.GetErr != nil {
+		return nil, r.GetErr
+	}
+	return r.GetResult, nil
+}
+
+func (r *MockRepo) Complete(
+	ctx context.Context, userID int64, key string, statusCode int, header http.Header,
+	body []byte,
+) error {
+	return nil
+}
+
+func (r *MockRepo) Delete(ctx context.Context, userID int64, key string) error {
+	return nil
+}
+
+func (r *MockRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestBegin(t *testing.T) {
+	requestHash := Fingerprint("PUT", "/v1/transfer", []byte(`{"amount": 10}`))
+
+	tests := []struct {
+		name        string
+		key         string
+		setupRepo   func(*MockRepo)
+		wantReplay  bool
+		expectedErr error
+	}{
+		{
+			name: "new key",
+			key:  "abc",
+			setupRepo: func(r *MockRepo) {
+				r.ClaimResult = true
+			},
+		},
+		{
+			name: "repeated request",
+			key:  "abc",
+			setupRepo: func(r *MockRepo) {
+				r.GetResult = &Key{RequestHash: requestHash, StatusCode: 200}
+			},
+			wantReplay: true,
+		},
+		{
+			name: "different request",
+			key:  "abc",
+			setupRepo: func(r *MockRepo) {
+				r.GetResult = &Key{
+					RequestHash: Fingerprint("PUT", "/v1/transfer", []byte(`{"amount": 20}`)),
+					StatusCode:  200,
+				}
+			},
+			expectedErr: ErrKeyReused,
+		},
+		{
+			name: "first request still running",
+			key:  "abc",
+			setupRepo: func(r *MockRepo) {
+				r.GetResult = &Key{RequestHash: requestHash}
+			},
+			expectedErr: ErrInProgress,
+		},
+		{
+			name: "released after the claim",
+			key:  "abc",
+			setupRepo: func(r *MockRepo) {
+				r.GetErr = user.ErrNoRecord
+			},
+			expectedErr: ErrInProgress,
+		},
+		{
+			name:        "key too long",
+			key:         strings.Repeat("a", 256),
+			setupRepo:   func(r *MockRepo) {},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "Claim failure",
+			key:  "abc",
+			setupRepo: func(r *MockRepo) {
+				r.ClaimErr = errors.New("db Claim error")
+			},
+			expectedErr: errors.New("db Claim error"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			svc := Service{Repo: repo}
+
+			stored, gotErr := svc.Begin(
+				context.Background(), validator.New(), 1, tc.key, requestHash, time.Hour,
+			)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error %v", gotErr)
+			}
+
+			if gotReplay := stored != nil; gotReplay != tc.wantReplay {
+				t.Errorf("expected replay=%v, got replay=%v", tc.wantReplay, gotReplay)
+			}
+		})
+	}
+}
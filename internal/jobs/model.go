@@ -0,0 +1,30 @@
+package jobs
+
+import "time"
+
+// the states a run can be in. a run left RUNNING by a crashed process is picked up again by the
+// next attempt, as is a FAILED one, only a SUCCEEDED run is never repeated
+const (
+	StatusRunning   = "RUNNING"
+	StatusSucceeded = "SUCCEEDED"
+	StatusFailed    = "FAILED"
+)
+
+// Run is one run of a job for one day
+type Run struct {
+	ID         int64      `json:"id"`
+	Job        string     `json:"job"`
+	Day        time.Time  `json:"day"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Day truncates t to the start of its day in UTC, the days jobs run for are always UTC dates
+func Day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// TryLock takes the session level advisory lock for the job, so that only one replica runs it at a
+// time. the lock belongs to a single connection, which is held until unlock is called. ok is false
+// if another session has the lock, in which case there is nothing to unlock
+func (r *Repository) TryLock(ctx context.Context, job string) (unlock func(), ok bool, err error) {
+	lockCtx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	conn, err := r.DB.Conn(lockCtx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = conn.QueryRowContext(lockCtx, "SELECT pg_try_advisory_lock(hashtext($1))", job).Scan(&ok)
+	if err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+
+	unlock = func() {
+		// the lock is released even if ctx was cancelled while it was held
+		ctx, cancel := database.WithTimeout(context.WithoutCancel(ctx), r.Timeout)
+		defer cancel()
+
+		// closing the connection would release the lock as well, but the pool might keep the
+		// session open, so it is released explicitly first
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", job)
+		conn.Close()
+	}
+
+	return unlock, true, nil
+}
+
+// Start records the start of a run of the job for the day. a day that already has a run is only
+// started again if that run didn't succeed, ErrAlreadyRun is returned if it did
+func (r *Repository) Start(ctx context.Context, run *Run) error {
+	query := `
+		INSERT INTO job_runs (job, day, status)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (job, day) DO UPDATE
+		SET status = EXCLUDED.status, started_at = NOW(), finished_at = NULL, processed = 0,
+			failed = 0, error = ''
+		WHERE job_runs.status <> 'SUCCEEDED'
+		RETURNING id, started_at
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRowContext(ctx, query, run.Job, run.Day, run.Status).Scan(
+		&run.ID,
+		&run.StartedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrAlreadyRun
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Finish records how the run went
+func (r *Repository) Finish(ctx context.Context, run *Run) error {
+	query := `
+		UPDATE job_runs
+		SET status = $1, finished_at = NOW(), processed = $2, failed = $3, error = $4
+		WHERE id = $5
+		RETURNING finished_at
+	`
+	args := []any{
+		run.Status,
+		run.Processed,
+		run.Failed,
+		run.Error,
+		run.ID,
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	return r.DB.QueryRowContext(ctx, query, args...).Scan(&run.FinishedAt)
+}
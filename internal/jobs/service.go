@@ -0,0 +1,77 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/jobs")
+
+var (
+	ErrLocked     = errors.New("job is running somewhere else")
+	ErrAlreadyRun = errors.New("job already ran for the day")
+)
+
+type Repo interface {
+	TryLock(ctx context.Context, job string) (unlock func(), ok bool, err error)
+	Start(ctx context.Context, run *Run) error
+	Finish(ctx context.Context, run *Run) error
+}
+
+// Func does the work of a job for the day. it returns how many items it processed and how many of
+// those failed, a failed item doesn't stop the others. err is for failures that stop the job as a
+// whole. the work has to be safe to repeat for the same day, as a run that didn't succeed is tried
+// again
+type Func func(ctx context.Context, day time.Time) (processed, failed int, err error)
+
+type Service struct {
+	Repo Repo
+}
+
+// Run runs the job for the day, unless another replica holds its lock (ErrLocked) or it already
+// succeeded for the day (ErrAlreadyRun). the run is recorded either way it goes, it counts as
+// failed if any item failed, so that the next attempt picks up what was left
+func (s *Service) Run(ctx context.Context, job string, day time.Time, fn Func) (*Run, error) {
+	ctx, span := tracer.Start(ctx, "jobs.Run")
+	defer span.End()
+
+	unlock, ok, err := s.Repo.TryLock(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLocked
+	}
+	defer unlock()
+
+	run := &Run{
+		Job:    job,
+		Day:    Day(day),
+		Status: StatusRunning,
+	}
+	err = s.Repo.Start(ctx, run)
+	if err != nil {
+		return nil, err
+	}
+
+	run.Processed, run.Failed, err = fn(ctx, run.Day)
+	switch {
+	case err != nil:
+		run.Status = StatusFailed
+		run.Error = err.Error()
+	case run.Failed > 0:
+		run.Status = StatusFailed
+	default:
+		run.Status = StatusSucceeded
+	}
+
+	finishErr := s.Repo.Finish(ctx, run)
+	if err != nil {
+		return run, err
+	}
+
+	return run, finishErr
+}
@@ -0,0 +1,130 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type MockRepo struct {
+	Locked     bool
+	TryLockErr error
+	Unlocked   bool
+
+	StartErr error
+	Finished *Run
+}
+
+func (r *MockRepo) TryLock(ctx context.Context, job string) (func(), bool, error) {
+	if r.TryLockErr != nil {
+		return nil, false, r.TryLockErr
+	}
+	if r.Locked {
+		return nil, false, nil
+	}
+
+	return func() { r.Unlocked = true }, true, nil
+}
+
+func (r *MockRepo) Start(ctx context.Context, run *Run) error {
+	return r.StartErr
+}
+
+func (r *MockRepo) Finish(ctx context.Context, run *Run) error {
+	r.Finished = run
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	day := time.Date(2025, time.March, 1, 23, 59, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		fn          Func
+		wantStatus  string
+		expectedErr error
+	}{
+		{
+			name:      "succeeded",
+			setupRepo: func(r *MockRepo) {},
+			fn: func(_ context.Context, d time.Time) (int, int, error) {
+				if !d.Equal(Day(day)) {
+					t.Errorf("expected day %v, got %v", Day(day), d)
+				}
+				return 3, 0, nil
+			},
+			wantStatus: StatusSucceeded,
+		},
+		{
+			name:       "some items failed",
+			setupRepo:  func(r *MockRepo) {},
+			fn:         func(context.Context, time.Time) (int, int, error) { return 3, 1, nil },
+			wantStatus: StatusFailed,
+		},
+		{
+			name:      "job failed",
+			setupRepo: func(r *MockRepo) {},
+			fn: func(context.Context, time.Time) (int, int, error) {
+				return 0, 0, errors.New("db error")
+			},
+			wantStatus:  StatusFailed,
+			expectedErr: errors.New("db error"),
+		},
+		{
+			name: "locked by another replica",
+			setupRepo: func(r *MockRepo) {
+				r.Locked = true
+			},
+			expectedErr: ErrLocked,
+		},
+		{
+			name: "already ran",
+			setupRepo: func(r *MockRepo) {
+				r.StartErr = ErrAlreadyRun
+			},
+			expectedErr: ErrAlreadyRun,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			svc := Service{Repo: repo}
+
+			called := false
+			fn := func(ctx context.Context, d time.Time) (int, int, error) {
+				called = true
+				return tc.fn(ctx, d)
+			}
+
+			run, gotErr := svc.Run(context.Background(), "test", day, fn)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error %v", gotErr)
+			}
+
+			if tc.fn == nil {
+				if called {
+					t.Errorf("expected the job not to run")
+				}
+				return
+			}
+
+			if run.Status != tc.wantStatus {
+				t.Errorf("expected status %s, got %s", tc.wantStatus, run.Status)
+			}
+			if repo.Finished != run {
+				t.Errorf("expected the run to be recorded as finished")
+			}
+			if !repo.Unlocked {
+				t.Errorf("expected the lock to be released")
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -14,7 +17,9 @@ type Level int8
 
 // Enum of different levels of severity
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -23,8 +28,12 @@ const (
 // String method makes it human readible
 func (level Level) ToString() string {
 	switch level {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
@@ -34,26 +43,61 @@ func (level Level) ToString() string {
 	}
 }
 
+// ErrUnknownLevel is returned by ParseLevel for a name that isn't one of the levels
+var ErrUnknownLevel = errors.New("unknown log level")
+
+// ParseLevel returns the level with the name, in any case, e.g. "warn" for LevelWarn or "off" for
+// LevelOff
+func ParseLevel(name string) (Level, error) {
+	if strings.EqualFold(name, "off") {
+		return LevelOff, nil
+	}
+	for level := LevelDebug; level < LevelOff; level++ {
+		if strings.EqualFold(name, level.ToString()) {
+			return level, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrUnknownLevel, name)
+}
+
 // Logger is a custom struct to hold the output destination, where the logs will be written to,
 // minLevel, the minimum level of severity to log
+// traceLevel, the minimum level of severity to attach the stack trace to
 // mu to coordinate writes
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	out        io.Writer
+	minLevel   Level
+	traceLevel Level
+	mu         sync.Mutex
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		out:        out,
+		minLevel:   minLevel,
+		traceLevel: LevelError,
 	}
 }
 
+// SetTraceLevel sets the level from which entries get the stack trace, LevelError unless set.
+// LevelOff leaves it out of every entry
+func (logger *Logger) SetTraceLevel(level Level) {
+	logger.traceLevel = level
+}
+
+func (logger *Logger) PrintDebug(message string, properties map[string]string) {
+	logger.print(LevelDebug, message, properties)
+}
+
 func (logger *Logger) PrintInfo(message string, properties map[string]string) {
 	logger.print(LevelInfo, message, properties)
 }
 
+func (logger *Logger) PrintWarn(message string, properties map[string]string) {
+	logger.print(LevelWarn, message, properties)
+}
+
 func (logger *Logger) PrintError(err error, properties map[string]string) {
 	logger.print(LevelError, err.Error(), properties)
 }
@@ -67,6 +111,13 @@ func (logger *Logger) PrintFatal(err error, properties map[string]string) {
 // print is an internal method on the Logger struct to do the actual logging
 func (logger *Logger) print(
 	level Level, message string, properties map[string]string,
+) (int, error) {
+	return logger.printAt(level, time.Now(), message, properties)
+}
+
+// printAt logs the entry as having happened at t, the time is left out if t is zero
+func (logger *Logger) printAt(
+	level Level, t time.Time, message string, properties map[string]string,
 ) (int, error) {
 	// dont do anything if the level is less than the minimum level of severity to log
 	if level < logger.minLevel {
@@ -79,16 +130,18 @@ func (logger *Logger) print(
 		Message    string            `json:"message"`
 		Properties map[string]string `json:"properties,omitempty"`
 		Trace      string            `json:"trace,omitempty"`
-		Time       string            `json:"time"`
+		Time       string            `json:"time,omitempty"`
 	}{
 		Level:      level.ToString(),
 		Message:    message,
 		Properties: properties,
-		Time:       time.Now().UTC().Format(time.RFC3339),
+	}
+	if !t.IsZero() {
+		aux.Time = t.UTC().Format(time.RFC3339)
 	}
 
-	// include the error stuck trace if its above or equal to the error level of severity
-	if level >= LevelError {
+	// include the stack trace if its above or equal to the level of severity set for it
+	if level >= logger.traceLevel {
 		aux.Trace = string(debug.Stack())
 	}
 
@@ -3,6 +3,8 @@ package jsonlog
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -12,11 +14,21 @@ func TestToString(t *testing.T) {
 		level       Level
 		expectedStr string
 	}{
+		{
+			name:        "level debug",
+			level:       LevelDebug,
+			expectedStr: "DEBUG",
+		},
 		{
 			name:        "level info",
 			level:       LevelInfo,
 			expectedStr: "INFO",
 		},
+		{
+			name:        "level warn",
+			level:       LevelWarn,
+			expectedStr: "WARN",
+		},
 		{
 			name:        "level error",
 			level:       LevelError,
@@ -44,6 +56,33 @@ func TestToString(t *testing.T) {
 	}
 }
 
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectedLevel Level
+		wantErr       error
+	}{
+		{name: "debug", expectedLevel: LevelDebug},
+		{name: "WARN", expectedLevel: LevelWarn},
+		{name: "Error", expectedLevel: LevelError},
+		{name: "off", expectedLevel: LevelOff},
+		{name: "", wantErr: ErrUnknownLevel},
+		{name: "verbose", wantErr: ErrUnknownLevel},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gotLevel, err := ParseLevel(tc.name)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if gotLevel != tc.expectedLevel {
+				t.Errorf("expected level %d, got %d", tc.expectedLevel, gotLevel)
+			}
+		})
+	}
+}
+
 func TestPrint(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -71,6 +110,13 @@ func TestPrint(t *testing.T) {
 			},
 			wantLog: true,
 		},
+		{
+			name:     "warning without trace",
+			minLevel: LevelDebug,
+			level:    LevelWarn,
+			message:  "warn msg",
+			wantLog:  true,
+		},
 		{
 			name:      "error message includes trace",
 			minLevel:  LevelInfo,
@@ -127,3 +173,20 @@ func TestPrint(t *testing.T) {
 		})
 	}
 }
+
+func TestSetTraceLevel(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := New(buf, LevelInfo)
+	l.SetTraceLevel(LevelOff)
+	l.PrintError(errors.New("error msg"), nil)
+	if strings.Contains(buf.String(), `"trace"`) {
+		t.Errorf("expected no trace with the trace level off, got %s", buf.String())
+	}
+
+	buf.Reset()
+	l.SetTraceLevel(LevelWarn)
+	l.PrintWarn("warn msg", nil)
+	if !strings.Contains(buf.String(), `"trace"`) {
+		t.Errorf("expected a trace with the trace level at warn, got %s", buf.String())
+	}
+}
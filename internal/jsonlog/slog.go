@@ -0,0 +1,98 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+)
+
+// Handler lets the logger sit behind the standard library's, slog.New(jsonlog.NewHandler(logger)).
+// attributes become properties, those in groups keyed by the names of the groups and their own
+// joined with dots, e.g. request.method
+type Handler struct {
+	logger *Logger
+	// properties holds the attributes added with WithAttrs, prefix the groups opened with WithGroup
+	properties map[string]string
+	prefix     string
+}
+
+func NewHandler(logger *Logger) *Handler {
+	return &Handler{logger: logger}
+}
+
+// slogLevel returns the level a record of the slog level is logged at. slog levels can be anywhere
+// between the named ones, so they are rounded down to the nearest, slog.LevelWarn+2 is a warning
+func slogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return slogLevel(level) >= h.logger.minLevel
+}
+
+func (h *Handler) Handle(_ context.Context, record slog.Record) error {
+	properties := maps.Clone(h.properties)
+	if properties == nil {
+		properties = make(map[string]string, record.NumAttrs())
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		addAttr(properties, h.prefix, attr)
+		return true
+	})
+	if len(properties) == 0 {
+		properties = nil
+	}
+
+	_, err := h.logger.printAt(slogLevel(record.Level), record.Time, record.Message, properties)
+	return err
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	properties := make(map[string]string, len(h.properties)+len(attrs))
+	maps.Copy(properties, h.properties)
+	for _, attr := range attrs {
+		addAttr(properties, h.prefix, attr)
+	}
+
+	return &Handler{logger: h.logger, properties: properties, prefix: h.prefix}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &Handler{logger: h.logger, properties: h.properties, prefix: h.prefix + name + "."}
+}
+
+// addAttr adds the attribute to properties with its key after prefix, and each attribute of a group
+// after the group's key. empty attributes are left out, as slog expects of handlers
+func addAttr(properties map[string]string, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		// a group without a key has its attributes added as if they weren't in one
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = prefix + attr.Key + "."
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			addAttr(properties, groupPrefix, groupAttr)
+		}
+		return
+	}
+
+	properties[prefix+attr.Key] = attr.Value.String()
+}
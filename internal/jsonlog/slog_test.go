@@ -0,0 +1,88 @@
+package jsonlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/slogtest"
+)
+
+func TestHandler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := New(buf, LevelInfo)
+	logger.SetTraceLevel(LevelOff)
+
+	// slogtest wants each entry the way slog.JSONHandler would write it, so the properties are
+	// put back in their groups
+	results := func() []map[string]any {
+		var entries []map[string]any
+		scanner := bufio.NewScanner(buf)
+		for scanner.Scan() {
+			var entry struct {
+				Level      string            `json:"level"`
+				Message    string            `json:"message"`
+				Properties map[string]string `json:"properties"`
+				Time       string            `json:"time"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				t.Fatalf("invalid json: %v\n%s", err, scanner.Text())
+			}
+
+			result := map[string]any{
+				slog.LevelKey:   entry.Level,
+				slog.MessageKey: entry.Message,
+			}
+			if entry.Time != "" {
+				result[slog.TimeKey] = entry.Time
+			}
+			for key, value := range entry.Properties {
+				group := result
+				path := strings.Split(key, ".")
+				for _, name := range path[:len(path)-1] {
+					if _, ok := group[name]; !ok {
+						group[name] = map[string]any{}
+					}
+					group = group[name].(map[string]any)
+				}
+				group[path[len(path)-1]] = value
+			}
+			entries = append(entries, result)
+		}
+
+		return entries
+	}
+
+	err := slogtest.TestHandler(NewHandler(logger), results)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandlerLevels(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := slog.New(NewHandler(New(buf, LevelWarn)))
+
+	log.Info("info msg")
+	if buf.Len() != 0 {
+		t.Fatalf("expected info below the min level to be ignored, got %s", buf.String())
+	}
+
+	log.Log(t.Context(), slog.LevelWarn+2, "warn msg", "user_id", 7)
+	var entry struct {
+		Level      string            `json:"level"`
+		Message    string            `json:"message"`
+		Properties map[string]string `json:"properties"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, buf.String())
+	}
+	if entry.Level != "WARN" {
+		t.Errorf("expected level WARN, got %s", entry.Level)
+	}
+	if entry.Properties["user_id"] != "7" {
+		t.Errorf("expected property user_id=7, got %v", entry.Properties)
+	}
+}
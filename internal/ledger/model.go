@@ -0,0 +1,140 @@
+package ledger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// the kinds of movements recorded on the ledger. interest accruals and late charges add to what a
+// borrower owes, they are booked when they are charged rather than when they are paid. a write-off
+// clears what is left of a loan that was forgiven
+const (
+	KindOpeningBalance  = "OPENING_BALANCE"
+	KindTransfer        = "TRANSFER"
+	KindDeposit         = "DEPOSIT"
+	KindWithdrawal      = "WITHDRAWAL"
+	KindLoanPayout      = "LOAN_PAYOUT"
+	KindLoanPayment     = "LOAN_PAYMENT"
+	KindInterestAccrual = "INTEREST_ACCRUAL"
+	KindLateCharge      = "LATE_CHARGE"
+	KindLoanWriteOff    = "LOAN_WRITE_OFF"
+)
+
+// Account identifies what a posting moves money in or out of. customer balances use the
+// "account:<id>" form, the bank's own accounts use the "bank:<name>" form
+type Account string
+
+// the bank's own accounts, they are the other side of every deposit, withdrawal and loan. write
+// offs are what the bank lost on the loans it forgave
+const (
+	AccountCash      Account = "bank:cash"
+	AccountLoans     Account = "bank:loans"
+	AccountInterest  Account = "bank:interest"
+	AccountFees      Account = "bank:fees"
+	AccountEquity    Account = "bank:equity"
+	AccountWriteOffs Account = "bank:write_offs"
+)
+
+const customerAccountPrefix = "account:"
+
+// CustomerAccount returns the ledger account holding the balance of the customer account with the
+// given ID
+func CustomerAccount(accountID int64) Account {
+	return Account(fmt.Sprintf("%s%d", customerAccountPrefix, accountID))
+}
+
+// AccountID returns the ID of the customer account, ok is false for the bank's accounts
+func (a Account) AccountID() (int64, bool) {
+	id, found := strings.CutPrefix(string(a), customerAccountPrefix)
+	if !found {
+		return 0, false
+	}
+
+	accountID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return accountID, true
+}
+
+// Entry is a single movement of money. its postings always sum to zero, so money is never created
+// or destroyed, only moved between accounts
+type Entry struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Kind        string    `json:"kind"`
+	Description string    `json:"description"`
+	Postings    []Posting `json:"postings"`
+}
+
+// Posting is one side of an entry. a negative amount debits (takes money out of) the account and a
+// positive amount credits it
+type Posting struct {
+	ID      int64        `json:"id"`
+	EntryID int64        `json:"entry_id"`
+	Account Account      `json:"account"`
+	Amount  money.Amount `json:"amount"`
+}
+
+// NewEntry creates an entry, dropping any zero postings so that callers splitting an amount
+// between accounts don't have to check for empty parts
+func NewEntry(kind, description string, postings ...Posting) *Entry {
+	entry := &Entry{
+		Kind:        kind,
+		Description: description,
+	}
+
+	for _, p := range postings {
+		if !p.Amount.IsZero() {
+			entry.Postings = append(entry.Postings, p)
+		}
+	}
+
+	return entry
+}
+
+// Move creates an entry taking amount from one account and putting it in the other
+func Move(kind, description string, from, to Account, amount money.Amount) *Entry {
+	return NewEntry(
+		kind, description,
+		Posting{Account: from, Amount: amount.Neg()},
+		Posting{Account: to, Amount: amount},
+	)
+}
+
+func ValidateEntry(v *validator.Validator, entry *Entry) {
+	safeKinds := []string{
+		KindOpeningBalance,
+		KindTransfer,
+		KindDeposit,
+		KindWithdrawal,
+		KindLoanPayout,
+		KindLoanPayment,
+		KindInterestAccrual,
+		KindLateCharge,
+		KindLoanWriteOff,
+	}
+	v.CheckAddError(validator.ValueInList(entry.Kind, safeKinds...), "kind", "invalid")
+
+	v.CheckAddError(len(entry.Postings) >= 2, "postings", "must have at least 2 postings")
+
+	var sum money.Amount
+	for _, p := range entry.Postings {
+		v.CheckAddError(p.Account != "", "account", "must be given")
+		v.CheckAddError(!p.Amount.IsZero(), "amount", "cannot be 0")
+
+		var err error
+		sum, err = sum.Add(p.Amount)
+		if err != nil {
+			v.AddError("postings", err.Error())
+			return
+		}
+	}
+	v.CheckAddError(sum.IsZero(), "postings", "must sum to 0")
+}
@@ -0,0 +1,127 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestAccountAccountID(t *testing.T) {
+	tests := []struct {
+		name          string
+		account       Account
+		wantAccountID int64
+		wantOK        bool
+	}{
+		{
+			name:          "customer account",
+			account:       CustomerAccount(42),
+			wantAccountID: 42,
+			wantOK:        true,
+		},
+		{
+			name:    "bank account",
+			account: AccountCash,
+			wantOK:  false,
+		},
+		{
+			name:    "malformed customer account",
+			account: Account("account:abc"),
+			wantOK:  false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gotAccountID, gotOK := tc.account.AccountID()
+			if gotOK != tc.wantOK {
+				t.Fatalf("expected ok=%v, got ok=%v", tc.wantOK, gotOK)
+			}
+			if gotAccountID != tc.wantAccountID {
+				t.Errorf("expected account id=%d, got id=%d", tc.wantAccountID, gotAccountID)
+			}
+		})
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	entry := NewEntry(
+		KindLoanPayment, "payment",
+		Posting{Account: CustomerAccount(1), Amount: money.MustParse("-50")},
+		Posting{Account: AccountLoans, Amount: money.MustParse("50")},
+		Posting{Account: AccountInterest, Amount: money.MustParse("0")},
+	)
+
+	if len(entry.Postings) != 2 {
+		t.Fatalf("expected zero postings to be dropped, got %d postings", len(entry.Postings))
+	}
+}
+
+func TestValidateEntry(t *testing.T) {
+	tests := []struct {
+		name           string
+		entry          *Entry
+		wantValid      bool
+		expectedErrMsg map[string]string
+	}{
+		{
+			name:      "valid",
+			entry:     Move(KindDeposit, "", AccountCash, CustomerAccount(1), money.MustParse("100")),
+			wantValid: true,
+		},
+		{
+			name: "split amount",
+			entry: NewEntry(
+				KindLoanPayment, "",
+				Posting{Account: CustomerAccount(1), Amount: money.MustParse("-100.1")},
+				Posting{Account: AccountLoans, Amount: money.MustParse("90.07")},
+				Posting{Account: AccountInterest, Amount: money.MustParse("10.03")},
+			),
+			wantValid: true,
+		},
+		{
+			name: "unbalanced",
+			entry: NewEntry(
+				KindDeposit, "",
+				Posting{Account: AccountCash, Amount: money.MustParse("-100")},
+				Posting{Account: CustomerAccount(1), Amount: money.MustParse("90")},
+			),
+			wantValid:      false,
+			expectedErrMsg: map[string]string{"postings": "must sum to 0"},
+		},
+		{
+			name: "single posting",
+			entry: NewEntry(
+				KindDeposit, "",
+				Posting{Account: CustomerAccount(1), Amount: money.MustParse("90")},
+			),
+			wantValid:      false,
+			expectedErrMsg: map[string]string{"postings": "must have at least 2 postings"},
+		},
+		{
+			name:           "unknown kind",
+			entry:          Move("GIFT", "", AccountCash, CustomerAccount(1), money.MustParse("100")),
+			wantValid:      false,
+			expectedErrMsg: map[string]string{"kind": "invalid"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateEntry(v, tc.entry)
+			if v.IsValid() != tc.wantValid {
+				t.Fatalf("expected valid=%v, got valid=%v (%v)", tc.wantValid, v.IsValid(), v.Errors)
+			}
+
+			for key, val := range tc.expectedErrMsg {
+				if v.Errors[key] != val {
+					t.Errorf(
+						"expected message=%s for key=%v, got message=%s", val, key, v.Errors[key],
+					)
+				}
+			}
+		})
+	}
+}
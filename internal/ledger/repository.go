@@ -0,0 +1,289 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrNoAccount         = errors.New("no account")
+	ErrAccountInactive   = errors.New("account is not active")
+	ErrCurrencyMismatch  = errors.New("currency does not match account")
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert records the entry and its postings, and applies the postings to the balances of the
+// customer accounts they touch, all in one transaction. the balance on the accounts table is only a
+// cache of the postings, so they are never allowed to disagree
+func (r *Repository) Insert(ctx context.Context, entry *Entry) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = InsertTx(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertTx is Insert inside a transaction owned by the caller, so that the entry commits or rolls
+// back together with whatever else the caller writes, e.g. the transfers row of a transfer
+func InsertTx(ctx context.Context, tx *sql.Tx, entry *Entry) error {
+	err := lockAccounts(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO ledger_entries (kind, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at
+	`
+
+	err = tx.QueryRowContext(ctx, query, entry.Kind, entry.Description).Scan(
+		&entry.ID,
+		&entry.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	postingQuery := `
+		INSERT INTO ledger_postings (entry_id, account, amount, currency)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	balanceQuery := `
+		UPDATE accounts
+		SET balance = balance + $1, version = version + 1
+		WHERE id = $2
+		RETURNING balance
+	`
+
+	for i := range entry.Postings {
+		posting := &entry.Postings[i]
+		posting.EntryID = entry.ID
+
+		err = tx.QueryRowContext(
+			ctx, postingQuery, posting.EntryID, posting.Account, posting.Amount,
+			posting.Amount.Currency(),
+		).Scan(&posting.ID)
+		if err != nil {
+			return err
+		}
+
+		accountID, ok := posting.Account.AccountID()
+		if !ok {
+			continue
+		}
+
+		var balance money.Amount
+		err = tx.QueryRowContext(ctx, balanceQuery, posting.Amount, accountID).Scan(&balance)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNoAccount
+			default:
+				return err
+			}
+		}
+
+		// customers can't go into overdraft, the bank's accounts can
+		if balance.IsNegative() {
+			return ErrInsufficientFunds
+		}
+	}
+
+	return nil
+}
+
+// lockAccounts locks the rows of every customer account the entry touches before any of them is
+// changed. the rows are always locked in ID order, so two entries moving money in opposite
+// directions between the same accounts queue up behind each other instead of deadlocking. once
+// locked, the accounts can't be frozen or closed under the entry, so their status and currency are
+// checked here too
+func lockAccounts(ctx context.Context, tx *sql.Tx, entry *Entry) error {
+	currencies := map[int64]money.Currency{}
+	accountIDs := []int64{}
+	for _, p := range entry.Postings {
+		accountID, ok := p.Account.AccountID()
+		if ok && !slices.Contains(accountIDs, accountID) {
+			accountIDs = append(accountIDs, accountID)
+			currencies[accountID] = p.Amount.Currency()
+		}
+	}
+	if len(accountIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		SELECT id, status, currency
+		FROM accounts
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR UPDATE
+	`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(accountIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	locked := 0
+	for rows.Next() {
+		var accountID int64
+		var status string
+		var currency money.Currency
+		err = rows.Scan(&accountID, &status, &currency)
+		if err != nil {
+			return err
+		}
+		locked++
+
+		// the status values belong to the account package, which can't be imported from here
+		// without a cycle
+		if status != "ACTIVE" {
+			return ErrAccountInactive
+		}
+		if currency != currencies[accountID] {
+			return ErrCurrencyMismatch
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if locked != len(accountIDs) {
+		return ErrNoAccount
+	}
+
+	return nil
+}
+
+// Balance rebuilds the balance of the account from its postings
+func (r *Repository) Balance(ctx context.Context, account Account) (money.Amount, error) {
+	query := `
+		SELECT COALESCE(SUM(amount), 0)
+		FROM ledger_postings
+		WHERE account = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var balance money.Amount
+	err := r.DB.QueryRowContext(ctx, query, account).Scan(&balance)
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	return balance, nil
+}
+
+// CachedBalance returns the balance stored on the accounts table for the account, in the
+// currency of the account
+func (r *Repository) CachedBalance(ctx context.Context, accountID int64) (money.Amount, error) {
+	query := `
+		SELECT balance, currency
+		FROM accounts
+		WHERE id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var balance money.Amount
+	var currency money.Currency
+	err := r.DB.QueryRowContext(ctx, query, accountID).Scan(&balance, &currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return money.Amount{}, ErrNoAccount
+		default:
+			return money.Amount{}, err
+		}
+	}
+
+	return balance.WithCurrency(currency), nil
+}
+
+// GetEntriesForAccount returns every entry that touched the account, oldest first, so the balance
+// can be followed through its history
+func (r *Repository) GetEntriesForAccount(ctx context.Context, account Account) ([]*Entry, error) {
+	query := `
+		SELECT ledger_entries.id, ledger_entries.created_at, ledger_entries.kind,
+			ledger_entries.description, ledger_postings.id, ledger_postings.account,
+			ledger_postings.amount, ledger_postings.currency
+		FROM ledger_entries
+		INNER JOIN ledger_postings
+		ON ledger_postings.entry_id = ledger_entries.id
+		WHERE ledger_entries.id IN (
+			SELECT entry_id FROM ledger_postings WHERE account = $1
+		)
+		ORDER BY ledger_entries.id, ledger_postings.id
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*Entry{}
+	for rows.Next() {
+		var entry Entry
+		var posting Posting
+		var currency money.Currency
+		err = rows.Scan(
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.Kind,
+			&entry.Description,
+			&posting.ID,
+			&posting.Account,
+			&posting.Amount,
+			&currency,
+		)
+		if err != nil {
+			return nil, err
+		}
+		posting.EntryID = entry.ID
+		posting.Amount = posting.Amount.WithCurrency(currency)
+
+		// rows come ordered by entry, so a new entry starts whenever the ID changes
+		if len(entries) == 0 || entries[len(entries)-1].ID != entry.ID {
+			entries = append(entries, &entry)
+		}
+		last := entries[len(entries)-1]
+		last.Postings = append(last.Postings, posting)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,103 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/ledger")
+
+var ErrBalanceMismatch = errors.New("balance does not match ledger")
+
+type Repo interface {
+	Insert(ctx context.Context, entry *Entry) error
+	Balance(ctx context.Context, account Account) (money.Amount, error)
+	CachedBalance(ctx context.Context, accountID int64) (money.Amount, error)
+	GetEntriesForAccount(ctx context.Context, account Account) ([]*Entry, error)
+}
+
+type Service struct {
+	Repo Repo
+}
+
+// Post validates and records the entry. running out of funds, or moving money through an account
+// that is frozen, closed or in another currency, is reported as a failed validation so that callers
+// can show it to the client like any other invalid input
+func (s *Service) Post(ctx context.Context, v *validator.Validator, entry *Entry) error {
+	ctx, span := tracer.Start(ctx, "ledger.Post")
+	defer span.End()
+
+	if ValidateEntry(v, entry); !v.IsValid() {
+		return validator.ErrFailedValidation
+	}
+
+	err := s.Repo.Insert(ctx, entry)
+	if err != nil {
+		if AddInsertError(v, err) {
+			return validator.ErrFailedValidation
+		}
+		return err
+	}
+
+	return nil
+}
+
+// AddInsertError adds the errors from inserting an entry that are the client's fault to v, and
+// reports whether it did. it is shared by the services that insert entries with their own rows
+func AddInsertError(v *validator.Validator, err error) bool {
+	switch {
+	case errors.Is(err, ErrInsufficientFunds):
+		v.AddError("account balance", "insufficient funds")
+	case errors.Is(err, ErrAccountInactive):
+		v.AddError("account", "not active")
+	case errors.Is(err, ErrCurrencyMismatch):
+		v.AddError("currency", "does not match account")
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (s *Service) Balance(ctx context.Context, account Account) (money.Amount, error) {
+	ctx, span := tracer.Start(ctx, "ledger.Balance")
+	defer span.End()
+
+	return s.Repo.Balance(ctx, account)
+}
+
+func (s *Service) History(ctx context.Context, account Account) ([]*Entry, error) {
+	ctx, span := tracer.Start(ctx, "ledger.History")
+	defer span.End()
+
+	return s.Repo.GetEntriesForAccount(ctx, account)
+}
+
+// Reconcile rebuilds the balance of the customer account from the ledger and checks it against the
+// balance stored on the account. the rebuilt balance is returned either way
+func (s *Service) Reconcile(ctx context.Context, accountID int64) (money.Amount, error) {
+	ctx, span := tracer.Start(ctx, "ledger.Reconcile")
+	defer span.End()
+
+	cached, err := s.Repo.CachedBalance(ctx, accountID)
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	balance, err := s.Repo.Balance(ctx, CustomerAccount(accountID))
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	// every posting to a customer account is in the currency of the account
+	balance = balance.WithCurrency(cached.Currency())
+	if balance.Cmp(cached) != 0 {
+		return balance, ErrBalanceMismatch
+	}
+
+	return balance, nil
+}
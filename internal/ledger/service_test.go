@@ -0,0 +1,190 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+type MockRepo struct {
+	InsertErr error
+	Inserted  []*Entry
+
+	BalanceResult money.Amount
+	BalanceErr    error
+
+	CachedBalanceResult money.Amount
+	CachedBalanceErr    error
+}
+
+func (r *MockRepo) Insert(ctx context.Context, entry *Entry) error {
+	if r.InsertErr != nil {
+		return r.InsertErr
+	}
+
+	r.Inserted = append(r.Inserted, entry)
+	return nil
+}
+
+func (r *MockRepo) Balance(ctx context.Context, account Account) (money.Amount, error) {
+	return r.BalanceResult, r.BalanceErr
+}
+
+func (r *MockRepo) CachedBalance(ctx context.Context, accountID int64) (money.Amount, error) {
+	return r.CachedBalanceResult, r.CachedBalanceErr
+}
+
+func (r *MockRepo) GetEntriesForAccount(ctx context.Context, account Account) ([]*Entry, error) {
+	return nil, nil
+}
+
+func TestPost(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		entry       *Entry
+		expectedErr error
+		expectedMsg map[string]string
+	}{
+		{
+			name:      "valid",
+			setupRepo: func(r *MockRepo) {},
+			entry: Move(
+				KindTransfer, "", CustomerAccount(1), CustomerAccount(2), money.MustParse("10"),
+			),
+		},
+		{
+			name:      "unbalanced entry",
+			setupRepo: func(r *MockRepo) {},
+			entry: NewEntry(
+				KindTransfer, "",
+				Posting{Account: CustomerAccount(1), Amount: money.MustParse("-10")},
+			),
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "insufficient funds",
+			setupRepo: func(r *MockRepo) {
+				r.InsertErr = ErrInsufficientFunds
+			},
+			entry: Move(
+				KindTransfer, "", CustomerAccount(1), CustomerAccount(2), money.MustParse("10"),
+			),
+			expectedErr: validator.ErrFailedValidation,
+			expectedMsg: map[string]string{"account balance": "insufficient funds"},
+		},
+		{
+			name: "frozen account",
+			setupRepo: func(r *MockRepo) {
+				r.InsertErr = ErrAccountInactive
+			},
+			entry: Move(
+				KindTransfer, "", CustomerAccount(1), CustomerAccount(2), money.MustParse("10"),
+			),
+			expectedErr: validator.ErrFailedValidation,
+			expectedMsg: map[string]string{"account": "not active"},
+		},
+		{
+			name: "Insert failure",
+			setupRepo: func(r *MockRepo) {
+				r.InsertErr = errors.New("db Insert error")
+			},
+			entry: Move(
+				KindTransfer, "", CustomerAccount(1), CustomerAccount(2), money.MustParse("10"),
+			),
+			expectedErr: errors.New("db Insert error"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			svc := Service{Repo: repo}
+
+			v := validator.New()
+			gotErr := svc.Post(context.Background(), v, tc.entry)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				for key, val := range tc.expectedMsg {
+					if v.Errors[key] != val {
+						t.Errorf(
+							"expected message=%s for key=%v, got message=%s", val, key, v.Errors[key],
+						)
+					}
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error %v", gotErr)
+			}
+
+			if len(repo.Inserted) != 1 {
+				t.Fatalf("expected 1 entry inserted, got %d", len(repo.Inserted))
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		wantBalance money.Amount
+		expectedErr error
+	}{
+		{
+			name: "matching",
+			setupRepo: func(r *MockRepo) {
+				r.BalanceResult = money.MustParse("100")
+				r.CachedBalanceResult = money.MustParse("100")
+			},
+			wantBalance: money.MustParse("100"),
+		},
+		{
+			name: "drifted",
+			setupRepo: func(r *MockRepo) {
+				r.BalanceResult = money.MustParse("100")
+				r.CachedBalanceResult = money.MustParse("99.99")
+			},
+			wantBalance: money.MustParse("100"),
+			expectedErr: ErrBalanceMismatch,
+		},
+		{
+			name: "other currency",
+			setupRepo: func(r *MockRepo) {
+				r.BalanceResult = money.MustParse("100")
+				r.CachedBalanceResult = money.MustParse("100").WithCurrency("EUR")
+			},
+			wantBalance: money.MustParse("100").WithCurrency("EUR"),
+		},
+		{
+			name: "unknown account",
+			setupRepo: func(r *MockRepo) {
+				r.CachedBalanceErr = ErrNoAccount
+			},
+			expectedErr: ErrNoAccount,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			svc := Service{Repo: repo}
+
+			gotBalance, gotErr := svc.Reconcile(context.Background(), 1)
+			if !errors.Is(gotErr, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+			}
+			if gotBalance.Currency() != tc.wantBalance.Currency() ||
+				gotBalance.Cmp(tc.wantBalance) != 0 {
+				t.Errorf("expected balance %v, got %v", tc.wantBalance, gotBalance)
+			}
+		})
+	}
+}
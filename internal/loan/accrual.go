@@ -0,0 +1,165 @@
+package loan
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+)
+
+// Policy is what the nightly accrual charges on overdue installments. the late fee is charged once
+// per installment, after it has been overdue for GraceDays. penalty interest is charged every day
+// an installment is overdue, at DailyPenaltyRate percent of the principal and interest still owed
+// on it
+type Policy struct {
+	LateFee          money.Amount
+	GraceDays        int
+	DailyPenaltyRate float64
+}
+
+// AccrueInterest returns the interest a loan without a schedule has built up on what is left of its
+// principal since it was last updated, worked out exactly and rounded once to the nearest cent the
+// same way a payment does. interest already accrued is not charged interest on
+func AccrueInterest(loan *Loan, now time.Time) (money.Amount, error) {
+	elapsed := now.Sub(loan.LastUpdatedAt)
+	if elapsed <= 0 {
+		return money.New(0, loan.RemainingAmount.Currency()), nil
+	}
+
+	elapsedDays := big.NewRat(int64(elapsed), int64(24*time.Hour))
+	factor := new(big.Rat).Mul(money.Rate(loan.DailyInterestRate), elapsedDays)
+	return loan.RemainingAmount.Mul(factor, money.RoundHalfEven)
+}
+
+// Settlement is how a payment on a loan without a schedule is split up
+type Settlement struct {
+	// Paid is what is taken from the account, never more than is owed
+	Paid money.Amount
+	// Interest is what the loan built up since it was last updated. it is booked as the bank's
+	// income with the payment whether the payment covers it or not, what it doesn't is accrued on
+	// the loan the same as the nightly accrual does
+	Interest money.Amount
+	// Remaining is what is left of the principal after the payment, AccruedInterest the interest
+	// left unpaid
+	Remaining       money.Amount
+	AccruedInterest money.Amount
+}
+
+// SettlePayment splits the payment on a loan without a schedule between the interest it owes, both
+// accrued and built up since it was last updated, and what is left of its principal, interest first
+func SettlePayment(loan *Loan, payment money.Amount, now time.Time) (Settlement, error) {
+	interest, err := AccrueInterest(loan, now)
+	if err != nil {
+		return Settlement{}, err
+	}
+	interestOwed, err := interest.Add(loan.AccruedInterest)
+	if err != nil {
+		return Settlement{}, err
+	}
+	totalOwed, err := loan.RemainingAmount.Add(interestOwed)
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	settlement := Settlement{Paid: money.Min(payment, totalOwed), Interest: interest}
+	interestPaid := money.Min(settlement.Paid, interestOwed)
+	principalPaid, err := settlement.Paid.Sub(interestPaid)
+	if err != nil {
+		return Settlement{}, err
+	}
+	settlement.Remaining, err = loan.RemainingAmount.Sub(principalPaid)
+	if err != nil {
+		return Settlement{}, err
+	}
+	settlement.AccruedInterest, err = interestOwed.Sub(interestPaid)
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	return settlement, nil
+}
+
+// daysBetween returns the number of whole days from one date to the other
+func daysBetween(from, to time.Time) int64 {
+	return int64(to.Sub(from).Hours() / 24)
+}
+
+// ChargeOverdue charges penalty interest and late fees on the installments overdue on day, adding
+// them to the fees of each installment. accruedThrough is the last day charges were worked out for
+// the loan, if ever, penalty interest is charged from then or the due date, whichever is later, so
+// a day is never charged twice and days missed by the nightly run are caught up. the installments
+// charged are returned with the penalty interest and the late fees charged on all of them
+func ChargeOverdue(
+	installments []*Installment, accruedThrough *time.Time, day time.Time, policy Policy,
+	now time.Time,
+) (charged []*Installment, penalty, lateFees money.Amount, err error) {
+	for _, installment := range installments {
+		if installment.PaidAt != nil || !installment.DueDate.Before(day) {
+			continue
+		}
+		currency := installment.Principal.Currency()
+		if penalty.Currency() == "" {
+			penalty = money.New(0, currency)
+			lateFees = money.New(0, currency)
+		}
+
+		from := installment.DueDate
+		if accruedThrough != nil && accruedThrough.After(from) {
+			from = *accruedThrough
+		}
+
+		// penalty interest is charged on the schedule that wasn't paid, never on fees
+		overdue := money.Amount{}
+		for _, amount := range []money.Amount{
+			installment.Principal, installment.Interest, installment.PrincipalPaid.Neg(),
+			installment.InterestPaid.Neg(),
+		} {
+			overdue, err = overdue.Add(amount)
+			if err != nil {
+				return nil, money.Amount{}, money.Amount{}, err
+			}
+		}
+
+		charge := money.New(0, currency)
+		if days := daysBetween(from, day); days > 0 {
+			factor := new(big.Rat).Mul(
+				money.Rate(policy.DailyPenaltyRate), big.NewRat(days, 1),
+			)
+			charge, err = overdue.Mul(factor, money.RoundHalfEven)
+			if err != nil {
+				return nil, money.Amount{}, money.Amount{}, err
+			}
+			penalty, err = penalty.Add(charge)
+			if err != nil {
+				return nil, money.Amount{}, money.Amount{}, err
+			}
+		}
+
+		lateFeeDue := installment.DueDate.AddDate(0, 0, policy.GraceDays)
+		if installment.LateFeeChargedAt == nil && policy.LateFee.IsPositive() &&
+			!day.Before(lateFeeDue) {
+			fee := policy.LateFee.WithCurrency(currency)
+			charge, err = charge.Add(fee)
+			if err != nil {
+				return nil, money.Amount{}, money.Amount{}, err
+			}
+			lateFees, err = lateFees.Add(fee)
+			if err != nil {
+				return nil, money.Amount{}, money.Amount{}, err
+			}
+			chargedAt := now
+			installment.LateFeeChargedAt = &chargedAt
+		}
+
+		if charge.IsZero() {
+			continue
+		}
+		installment.Fees, err = installment.Fees.Add(charge)
+		if err != nil {
+			return nil, money.Amount{}, money.Amount{}, err
+		}
+		charged = append(charged, installment)
+	}
+
+	return charged, penalty, lateFees, nil
+}
@@ -0,0 +1,241 @@
+package loan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+)
+
+func TestAccrueInterest(t *testing.T) {
+	now := time.Date(2025, time.March, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		loan         *Loan
+		wantInterest string
+	}{
+		{
+			name: "one day",
+			loan: &Loan{
+				DailyInterestRate: 5, RemainingAmount: money.MustParse("100"),
+				LastUpdatedAt: now.AddDate(0, 0, -1),
+			},
+			wantInterest: "5.00",
+		},
+		{
+			name: "half a day",
+			loan: &Loan{
+				DailyInterestRate: 5, RemainingAmount: money.MustParse("100"),
+				LastUpdatedAt: now.Add(-12 * time.Hour),
+			},
+			wantInterest: "2.50",
+		},
+		{
+			name: "updated in the future",
+			loan: &Loan{
+				DailyInterestRate: 5, RemainingAmount: money.MustParse("100"),
+				LastUpdatedAt: now.Add(time.Hour),
+			},
+			wantInterest: "0.00",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			interest, err := AccrueInterest(tc.loan, now)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if interest.String() != tc.wantInterest {
+				t.Errorf("expected interest %s, got %s", tc.wantInterest, interest)
+			}
+		})
+	}
+}
+
+func TestSettlePayment(t *testing.T) {
+	now := time.Date(2025, time.March, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		accrued       money.Amount
+		payment       money.Amount
+		wantPaid      string
+		wantInterest  string
+		wantRemaining string
+		wantAccrued   string
+	}{
+		{
+			name:          "less than the interest",
+			payment:       money.MustParse("2"),
+			wantPaid:      "2.00",
+			wantInterest:  "5.00",
+			wantRemaining: "100.00",
+			wantAccrued:   "3.00",
+		},
+		{
+			name:          "interest and some principal",
+			payment:       money.MustParse("50"),
+			wantPaid:      "50.00",
+			wantInterest:  "5.00",
+			wantRemaining: "55.00",
+			wantAccrued:   "0.00",
+		},
+		{
+			name:          "accrued interest is paid before the principal",
+			accrued:       money.MustParse("10"),
+			payment:       money.MustParse("20"),
+			wantPaid:      "20.00",
+			wantInterest:  "5.00",
+			wantRemaining: "95.00",
+			wantAccrued:   "0.00",
+		},
+		{
+			name:          "more than is owed",
+			accrued:       money.MustParse("10"),
+			payment:       money.MustParse("200"),
+			wantPaid:      "115.00",
+			wantInterest:  "5.00",
+			wantRemaining: "0.00",
+			wantAccrued:   "0.00",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// interest is only charged on the principal, never on what has accrued
+			loan := &Loan{
+				DailyInterestRate: 5, RemainingAmount: money.MustParse("100"),
+				AccruedInterest: tc.accrued, LastUpdatedAt: now.AddDate(0, 0, -1),
+			}
+			settlement, err := SettlePayment(loan, tc.payment, now)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			for _, got := range []struct {
+				name        string
+				got, wanted string
+			}{
+				{"paid", settlement.Paid.String(), tc.wantPaid},
+				{"interest", settlement.Interest.String(), tc.wantInterest},
+				{"remaining", settlement.Remaining.String(), tc.wantRemaining},
+				{"accrued interest", settlement.AccruedInterest.String(), tc.wantAccrued},
+			} {
+				if got.got != got.wanted {
+					t.Errorf("expected %s %s, got %s", got.name, got.wanted, got.got)
+				}
+			}
+		})
+	}
+}
+
+func TestChargeOverdue(t *testing.T) {
+	now := time.Now()
+	day := time.Date(2025, time.March, 10, 0, 0, 0, 0, time.UTC)
+	policy := Policy{
+		LateFee:          money.MustParse("25"),
+		GraceDays:        3,
+		DailyPenaltyRate: 0.1,
+	}
+	newInstallment := func(due time.Time) *Installment {
+		return &Installment{
+			DueDate:       due,
+			Principal:     money.MustParse("900"),
+			Interest:      money.MustParse("100"),
+			Fees:          money.MustParse("0"),
+			PrincipalPaid: money.MustParse("0"),
+			InterestPaid:  money.MustParse("0"),
+			FeesPaid:      money.MustParse("0"),
+		}
+	}
+	accruedThrough := func(d time.Time) *time.Time { return &d }
+
+	tests := []struct {
+		name           string
+		installment    *Installment
+		accruedThrough *time.Time
+		wantPenalty    string
+		wantLateFees   string
+		wantCharged    int
+	}{
+		{
+			name:        "not due yet",
+			installment: newInstallment(day),
+			wantCharged: 0,
+		},
+		{
+			name:         "first day overdue, within grace",
+			installment:  newInstallment(day.AddDate(0, 0, -1)),
+			wantPenalty:  "1.00",
+			wantLateFees: "0.00",
+			wantCharged:  1,
+		},
+		{
+			name:         "past grace",
+			installment:  newInstallment(day.AddDate(0, 0, -3)),
+			wantPenalty:  "3.00",
+			wantLateFees: "25.00",
+			wantCharged:  1,
+		},
+		{
+			name:           "caught up since the last run",
+			installment:    newInstallment(day.AddDate(0, 0, -10)),
+			accruedThrough: accruedThrough(day.AddDate(0, 0, -2)),
+			wantPenalty:    "2.00",
+			wantLateFees:   "25.00",
+			wantCharged:    1,
+		},
+		{
+			name: "late fee only charged once",
+			installment: func() *Installment {
+				installment := newInstallment(day.AddDate(0, 0, -10))
+				installment.LateFeeChargedAt = &now
+				return installment
+			}(),
+			accruedThrough: accruedThrough(day.AddDate(0, 0, -1)),
+			wantPenalty:    "1.00",
+			wantLateFees:   "0.00",
+			wantCharged:    1,
+		},
+		{
+			name: "paid",
+			installment: func() *Installment {
+				installment := newInstallment(day.AddDate(0, 0, -10))
+				installment.PaidAt = &now
+				return installment
+			}(),
+			wantCharged: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			charged, penalty, lateFees, err := ChargeOverdue(
+				[]*Installment{tc.installment}, tc.accruedThrough, day, policy, now,
+			)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if len(charged) != tc.wantCharged {
+				t.Fatalf("expected %d installments charged, got %d", tc.wantCharged, len(charged))
+			}
+			if tc.wantCharged == 0 {
+				return
+			}
+
+			if penalty.String() != tc.wantPenalty {
+				t.Errorf("expected penalty %s, got %s", tc.wantPenalty, penalty)
+			}
+			if lateFees.String() != tc.wantLateFees {
+				t.Errorf("expected late fees %s, got %s", tc.wantLateFees, lateFees)
+			}
+
+			wantFees, _ := penalty.Add(lateFees)
+			if tc.installment.Fees.Cmp(wantFees) != 0 {
+				t.Errorf("expected installment fees %s, got %s", wantFees, tc.installment.Fees)
+			}
+		})
+	}
+}
@@ -3,19 +3,28 @@ package loan
 import (
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
+// SortSafelist are the values the loans and loan payments of a user can be sorted by
+var SortSafelist = []string{"id", "created_at", "amount", "-id", "-created_at", "-amount"}
+
 type Loan struct {
 	ID                int64
 	CreatedAt         time.Time
 	UserID            int64
-	Amount            float64
+	AccountID         int64
+	ProductID         int64 // 0 for loans taken before there were products
+	Amount            money.Amount
 	Action            string
 	DailyInterestRate float64
-	RemainingAmount   float64
-	LastUpdatedAt     time.Time
-	Version           int32
+	RemainingAmount   money.Amount
+	// AccruedInterest is the interest the nightly accrual charged on a loan without a schedule
+	// that hasn't been paid yet, it is owed on top of RemainingAmount
+	AccruedInterest money.Amount
+	LastUpdatedAt   time.Time
+	Version         int32
 }
 
 type LoanDeletion struct {
@@ -26,15 +35,15 @@ type LoanDeletion struct {
 	LoanID            int64
 	DebtorID          int64
 	DeletedByID       int64
-	Amount            float64
+	Amount            money.Amount
 	DailyInterestRate float64
-	RemainingAmount   float64
+	RemainingAmount   money.Amount
 	Reason            string
 }
 
 func ValidateLoan(v *validator.Validator, loan *Loan) {
-	v.CheckAddError(loan.Amount != 0, "amount", "must be given")
-	v.CheckAddError(loan.Amount > 0, "amount", "must be more than 0")
+	v.CheckAddError(!loan.Amount.IsZero(), "amount", "must be given")
+	v.CheckAddError(loan.Amount.IsPositive(), "amount", "must be more than 0")
 
 	// v.CheckAddError(loan.DailyInterestRate != 0, "daily interest rate", "must be given")
 	v.CheckAddError(loan.DailyInterestRate >= 0, "daily interest rate", "must be more than 0")
@@ -3,6 +3,7 @@ package loan
 import (
 	"testing"
 
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
@@ -10,7 +11,7 @@ func TestValidateLoan(t *testing.T) {
 	mockLoan := &Loan{
 		Action:            "took",
 		DailyInterestRate: 5,
-		Amount:            100,
+		Amount:            money.MustParse("100"),
 	}
 
 	tests := []struct {
@@ -30,7 +31,7 @@ func TestValidateLoan(t *testing.T) {
 		},
 		{
 			name:      "amount = 0",
-			setupLoan: func(l *Loan) { mockLoan.Amount = 0 },
+			setupLoan: func(l *Loan) { mockLoan.Amount = money.MustParse("0") },
 			wantValid: false,
 			expectedErrMsg: map[string]string{
 				"amount": "must be given",
@@ -38,7 +39,7 @@ func TestValidateLoan(t *testing.T) {
 		},
 		{
 			name:      "amount < 0",
-			setupLoan: func(l *Loan) { mockLoan.Amount = -100 },
+			setupLoan: func(l *Loan) { mockLoan.Amount = money.MustParse("-100") },
 			wantValid: false,
 			expectedErrMsg: map[string]string{
 				"amount": "must be more than 0",
@@ -65,7 +66,7 @@ func TestValidateLoan(t *testing.T) {
 	resetLoan := func(loan *Loan) {
 		loan.Action = "took"
 		loan.DailyInterestRate = 5
-		loan.Amount = 100
+		loan.Amount = money.MustParse("100")
 	}
 
 	for _, tc := range tests {
@@ -4,61 +4,139 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"math"
+	"fmt"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 )
 
 type Repository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (r *Repository) Insert(loan *Loan) error {
-	query := `
-		INSERT INTO loans 
-			(user_id, amount, action, daily_interest_rate, remaining_amount, last_updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, created_at
-	`
-	args := []any{
+// insertQuery is shared by Insert and the transactions that record a loan together with other rows
+const insertQuery = `
+	INSERT INTO loans (
+		user_id, account_id, product_id, amount, action, daily_interest_rate, remaining_amount,
+		last_updated_at
+	)
+	VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7, $8)
+	RETURNING id, created_at
+`
+
+func insertArgs(loan *Loan) []any {
+	return []any{
 		loan.UserID,
+		loan.AccountID,
+		loan.ProductID,
 		loan.Amount,
 		loan.Action,
 		loan.DailyInterestRate,
 		loan.RemainingAmount,
 		loan.LastUpdatedAt,
 	}
+}
+
+func (r *Repository) Insert(ctx context.Context, loan *Loan) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	return r.DB.QueryRowContext(ctx, insertQuery, insertArgs(loan)...).Scan(
+		&loan.ID,
+		&loan.CreatedAt,
+	)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertWithSchedule records the loan together with its installments, in one transaction
+func (r *Repository) InsertWithSchedule(
+	ctx context.Context, loan *Loan, installments []*Installment,
+) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	return r.DB.QueryRowContext(ctx, query, args...).Scan(
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = InsertTx(ctx, tx, loan, installments)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertTx records the loan and its installments, if it has any, inside a transaction owned by the
+// caller, e.g. the one that accepts the loan request and pays it out
+func InsertTx(ctx context.Context, tx *sql.Tx, loan *Loan, installments []*Installment) error {
+	err := tx.QueryRowContext(ctx, insertQuery, insertArgs(loan)...).Scan(
 		&loan.ID,
 		&loan.CreatedAt,
 	)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO loan_installments (loan_id, number, due_date, principal, interest)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	for _, installment := range installments {
+		installment.LoanID = loan.ID
+		args := []any{
+			installment.LoanID,
+			installment.Number,
+			installment.DueDate,
+			installment.Principal,
+			installment.Interest,
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&installment.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (r *Repository) GetByID(loanID, userID int64) (*Loan, error) {
+// GetByID returns the loan with its amounts in the currency of the account it was paid out to
+func (r *Repository) GetByID(ctx context.Context, loanID, userID int64) (*Loan, error) {
 	query := `
-		SELECT id, created_at, user_id, amount, action, daily_interest_rate, remaining_amount, 
-			last_updated_at, version
+		SELECT loans.id, loans.created_at, loans.user_id, loans.account_id,
+			COALESCE(loans.product_id, 0), accounts.currency, loans.amount, loans.action,
+			loans.daily_interest_rate, loans.remaining_amount, loans.accrued_interest,
+			loans.last_updated_at, loans.version
 		FROM loans
-		WHERE id = $1 AND user_id = $2
+		INNER JOIN accounts ON accounts.id = loans.account_id
+		WHERE loans.id = $1 AND loans.user_id = $2
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	var loan Loan
+	var currency money.Currency
 	err := r.DB.QueryRowContext(ctx, query, loanID, userID).Scan(
 		&loan.ID,
 		&loan.CreatedAt,
 		&loan.UserID,
+		&loan.AccountID,
+		&loan.ProductID,
+		&currency,
 		&loan.Amount,
 		&loan.Action,
 		&loan.DailyInterestRate,
 		&loan.RemainingAmount,
+		&loan.AccruedInterest,
 		&loan.LastUpdatedAt,
 		&loan.Version,
 	)
@@ -71,81 +149,127 @@ func (r *Repository) GetByID(loanID, userID int64) (*Loan, error) {
 		}
 	}
 
+	loan.Amount = loan.Amount.WithCurrency(currency)
+	loan.RemainingAmount = loan.RemainingAmount.WithCurrency(currency)
+	loan.AccruedInterest = loan.AccruedInterest.WithCurrency(currency)
 	return &loan, nil
 }
 
-// GetForUserByUserID gets all loans and payments for a give user by their ID
-func (r *Repository) GetForUserByUserID(userID int64) ([]*Loan, error) {
-	query := `
-		SELECT id, created_at, user_id, amount, action, daily_interest_rate, remaining_amount, 
-			version
-		WHERE user_id = $1
-	`
+// GetAllForUser returns a page of the loans the user took and the payments they made on them.
+// loans taken are in and payments are out. amounts are in the currency of the account of each row
+func (r *Repository) GetAllForUser(
+	ctx context.Context, userID int64, f filter.Filters,
+) ([]*Loan, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), loans.id, loans.created_at, loans.user_id, loans.account_id,
+			COALESCE(loans.product_id, 0), accounts.currency, loans.amount, loans.action,
+			loans.daily_interest_rate, loans.remaining_amount, loans.accrued_interest,
+			loans.last_updated_at, loans.version
+		FROM loans
+		INNER JOIN accounts ON accounts.id = loans.account_id
+		WHERE loans.user_id = $1
+		AND ($2::timestamptz IS NULL OR loans.created_at >= $2)
+		AND ($3::timestamptz IS NULL OR loans.created_at < $3)
+		AND ($4::decimal IS NULL OR loans.amount >= $4)
+		AND ($5::decimal IS NULL OR loans.amount <= $5)
+		AND (
+			$6 = '' OR ($6 = 'in' AND loans.action = 'took')
+			OR ($6 = 'out' AND loans.action = 'paid')
+		)
+		ORDER BY loans.%s %s, loans.id ASC
+		LIMIT $7 OFFSET $8
+	`, f.SortColumn(), f.SortDirection())
+	args := []any{
+		userID,
+		f.From,
+		f.To,
+		f.MinAmount,
+		f.MaxAmount,
+		f.Direction,
+		f.Limit(),
+		f.Offset(),
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	rows, err := r.DB.QueryContext(ctx, query, userID)
+	rows, err := r.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, filter.Metadata{}, err
 	}
 	defer rows.Close()
 
-	var loans []*Loan
+	totalRecords := 0
+	loans := []*Loan{}
 	for rows.Next() {
 		var loan Loan
+		var currency money.Currency
 		err = rows.Scan(
+			&totalRecords,
 			&loan.ID,
 			&loan.CreatedAt,
 			&loan.UserID,
+			&loan.AccountID,
+			&loan.ProductID,
+			&currency,
 			&loan.Amount,
 			&loan.Action,
 			&loan.DailyInterestRate,
-			&loan.Amount,
+			&loan.RemainingAmount,
+			&loan.AccruedInterest,
+			&loan.LastUpdatedAt,
 			&loan.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, filter.Metadata{}, err
 		}
 
+		loan.Amount = loan.Amount.WithCurrency(currency)
+		loan.RemainingAmount = loan.RemainingAmount.WithCurrency(currency)
+		loan.AccruedInterest = loan.AccruedInterest.WithCurrency(currency)
 		loans = append(loans, &loan)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, filter.Metadata{}, err
 	}
 
-	return loans, nil
+	return loans, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
 }
 
-func (r *Repository) MakePaymentTx(loanID, userID int64, payment, totalOwed float64) (*Loan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// PaidFunc is work done inside the transaction of a payment on a loan, with the payment recorded,
+// before it is committed
+type PaidFunc func(ctx context.Context, tx *sql.Tx, loanPayment *Loan) error
+
+// MakePaymentTx pays the loan without a schedule from the account. the loan is locked while the
+// interest owed on it is worked out, and the ledger entry, the loan and the payment record are
+// written in one transaction, then is run last in it if given. ErrPaidOff is returned if nothing
+// is owed on the loan any more
+func (r *Repository) MakePaymentTx(
+	ctx context.Context, loan *Loan, accountID int64, payment money.Amount, then PaidFunc,
+) (*Loan, error) {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	// start transaction
 	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-
-	// rollback if anything goes wrong
 	defer tx.Rollback()
 
-	// fetch loan with FOR UPDATE to lock the row
-	loan := &Loan{}
+	// the loan is locked before the entry locks the account, like the other writers to a loan
+	locked := &Loan{ID: loan.ID, UserID: loan.UserID}
 	query := `
-		SELECT id, user_id, remaining_amount, daily_interest_rate, last_updated_at
+		SELECT remaining_amount, accrued_interest, daily_interest_rate, last_updated_at
 		FROM loans
 		WHERE id = $1 AND user_id = $2
-		FOR UPDATE 
+		FOR UPDATE
 	`
-
-	err = tx.QueryRowContext(ctx, query, loanID, userID).Scan(
-		&loan.ID,
-		&loan.UserID,
-		&loan.RemainingAmount,
-		&loan.DailyInterestRate,
-		&loan.LastUpdatedAt,
+	err = tx.QueryRowContext(ctx, query, loan.ID, loan.UserID).Scan(
+		&locked.RemainingAmount,
+		&locked.AccruedInterest,
+		&locked.DailyInterestRate,
+		&locked.LastUpdatedAt,
 	)
 	if err != nil {
 		switch {
@@ -155,63 +279,149 @@ func (r *Repository) MakePaymentTx(loanID, userID int64, payment, totalOwed floa
 			return nil, err
 		}
 	}
+	locked.RemainingAmount = locked.RemainingAmount.WithCurrency(payment.Currency())
+	locked.AccruedInterest = locked.AccruedInterest.WithCurrency(payment.Currency())
+
+	now := time.Now().UTC()
+	settlement, err := SettlePayment(locked, payment, now)
+	if err != nil {
+		return nil, err
+	}
+	if !settlement.Paid.IsPositive() {
+		return nil, ErrPaidOff
+	}
 
-	loan.RemainingAmount = math.Max(0, totalOwed-payment)
-	loan.LastUpdatedAt = time.Now().UTC()
+	// the accrued interest was booked already, paying it repays the loan like the principal does
+	repaid, err := settlement.Paid.Sub(settlement.Interest)
+	if err != nil {
+		return nil, err
+	}
+	entry := paymentEntry(loan.ID, accountID, settlement.Paid, settlement.Interest, repaid)
+	err = ledger.InsertTx(ctx, tx, entry)
+	if err != nil {
+		return nil, err
+	}
 
-	// update the row in the database
+	// moving last_updated_at on means the next payment only charges the interest since now
 	updateQuery := `
 		UPDATE loans
-		SET remaining_amount = $1, last_updated_at = $2, version = version + 1
-		WHERE id = $3 AND user_id = $4
+		SET remaining_amount = $1, accrued_interest = $2, last_updated_at = $3,
+			version = version + 1
+		WHERE id = $4
 	`
-	args := []any{
-		loan.RemainingAmount,
-		loan.LastUpdatedAt,
-		loan.ID,
-		loan.UserID,
+	_, err = tx.ExecContext(
+		ctx, updateQuery, settlement.Remaining, settlement.AccruedInterest, now, loan.ID,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = tx.ExecContext(ctx, updateQuery, args...)
+	loanPayment := &Loan{
+		UserID:            loan.UserID,
+		AccountID:         accountID,
+		Amount:            settlement.Paid,
+		Action:            "paid",
+		DailyInterestRate: locked.DailyInterestRate,
+		RemainingAmount:   settlement.Remaining,
+		AccruedInterest:   settlement.AccruedInterest,
+		LastUpdatedAt:     now,
+	}
+	err = tx.QueryRowContext(ctx, insertQuery, insertArgs(loanPayment)...).Scan(
+		&loanPayment.ID,
+		&loanPayment.CreatedAt,
+	)
 	if err != nil {
 		return nil, err
 	}
 
+	if then != nil {
+		err = then(ctx, tx, loanPayment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
 
-	return loan, nil
+	return loanPayment, nil
 }
 
-func (r *Repository) DeleteLoan(loanID, userID int64) error {
-	query := `
-		DELETE FROM loans
-		WHERE id = $1 AND user_id = $2
-	`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// DeleteTx forgives the loan of loanDeletion. what is left of it is written off on the ledger, the
+// deletion is recorded and the loan removed, in one transaction. the remaining amount recorded is
+// the one read under the lock, a payment made since the loan was last read is taken into account.
+// then, if given, is run last in the transaction
+func (r *Repository) DeleteTx(
+	ctx context.Context, loanDeletion *LoanDeletion, then database.TxFunc,
+) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	res, err := r.DB.ExecContext(ctx, query, loanID, userID)
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsEffected, err := res.RowsAffected()
+	query := `
+		SELECT accounts.currency, loans.remaining_amount, loans.accrued_interest
+		FROM loans
+		INNER JOIN accounts ON accounts.id = loans.account_id
+		WHERE loans.id = $1 AND loans.user_id = $2
+		FOR UPDATE OF loans
+	`
+	var currency money.Currency
+	var accruedInterest money.Amount
+	err = tx.QueryRowContext(ctx, query, loanDeletion.LoanID, loanDeletion.DebtorID).Scan(
+		&currency,
+		&loanDeletion.RemainingAmount,
+		&accruedInterest,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return user.ErrNoRecord
+		default:
+			return err
+		}
+	}
+	loanDeletion.RemainingAmount = loanDeletion.RemainingAmount.WithCurrency(currency)
+
+	// late fees and accrued interest were booked on the loan when they were charged, so they are
+	// written off with it. interest on a schedule is only booked when it is paid, there is nothing
+	// to write off for it
+	var unpaidFees money.Amount
+	feesQuery := `
+		SELECT COALESCE(SUM(fees - fees_paid), 0)
+		FROM loan_installments
+		WHERE loan_id = $1
+	`
+	err = tx.QueryRowContext(ctx, feesQuery, loanDeletion.LoanID).Scan(&unpaidFees)
 	if err != nil {
 		return err
 	}
-
-	if rowsEffected == 0 {
-		return user.ErrNoRecord
+	writeOff, err := loanDeletion.RemainingAmount.Add(unpaidFees.WithCurrency(currency))
+	if err != nil {
+		return err
+	}
+	writeOff, err = writeOff.Add(accruedInterest.WithCurrency(currency))
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	if writeOff.IsPositive() {
+		entry := ledger.Move(
+			ledger.KindLoanWriteOff, fmt.Sprintf("write-off of loan %d", loanDeletion.LoanID),
+			ledger.AccountWriteOffs, ledger.AccountLoans, writeOff,
+		)
+		err = ledger.InsertTx(ctx, tx, entry)
+		if err != nil {
+			return err
+		}
+	}
 
-func (r *Repository) InsertDeletion(loanDeletion *LoanDeletion) error {
-	query := `
+	insertQuery := `
 		INSERT INTO deleted_loans 
 		(
 			loan_created_at, loan_last_updated_at, loan_id, debtor_id, deleted_by_id, amount, 
@@ -231,12 +441,507 @@ func (r *Repository) InsertDeletion(loanDeletion *LoanDeletion) error {
 		loanDeletion.RemainingAmount,
 		loanDeletion.Reason,
 	}
+	err = tx.QueryRowContext(ctx, insertQuery, args...).Scan(
+		&loanDeletion.ID,
+		&loanDeletion.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	deleteQuery := `
+		DELETE FROM loans
+		WHERE id = $1 AND user_id = $2
+	`
+	_, err = tx.ExecContext(ctx, deleteQuery, loanDeletion.LoanID, loanDeletion.DebtorID)
+	if err != nil {
+		return err
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) GetProduct(ctx context.Context, productID int64) (*Product, error) {
+	query := `
+		SELECT id, created_at, name, annual_interest_rate, term, frequency, amortization
+		FROM loan_products
+		WHERE id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	return r.DB.QueryRowContext(ctx, query, args...).Scan(
-		&loanDeletion.ID,
-		&loanDeletion.CreatedAt,
+	var product Product
+	err := r.DB.QueryRowContext(ctx, query, productID).Scan(
+		&product.ID,
+		&product.CreatedAt,
+		&product.Name,
+		&product.AnnualInterestRate,
+		&product.Term,
+		&product.Frequency,
+		&product.Amortization,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return &product, nil
+}
+
+func (r *Repository) GetAllProducts(ctx context.Context) ([]*Product, error) {
+	query := `
+		SELECT id, created_at, name, annual_interest_rate, term, frequency, amortization
+		FROM loan_products
+		ORDER BY id
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []*Product{}
+	for rows.Next() {
+		var product Product
+		err = rows.Scan(
+			&product.ID,
+			&product.CreatedAt,
+			&product.Name,
+			&product.AnnualInterestRate,
+			&product.Term,
+			&product.Frequency,
+			&product.Amortization,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, &product)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// querier is what *sql.DB and *sql.Tx have in common, so that reads can run in or out of a
+// transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// getInstallments returns the installments of the loan in order, in the currency of the account the
+// loan was paid out to. lock takes a row lock on them, it needs q to be a transaction
+func getInstallments(
+	ctx context.Context, q querier, loanID int64, lock bool,
+) ([]*Installment, error) {
+	query := `
+		SELECT loan_installments.id, loan_installments.loan_id, loan_installments.number,
+			loan_installments.due_date, loan_installments.principal, loan_installments.interest,
+			loan_installments.fees, loan_installments.principal_paid,
+			loan_installments.interest_paid, loan_installments.fees_paid,
+			loan_installments.late_fee_charged_at, loan_installments.paid_at, accounts.currency
+		FROM loan_installments
+		INNER JOIN loans ON loans.id = loan_installments.loan_id
+		INNER JOIN accounts ON accounts.id = loans.account_id
+		WHERE loan_installments.loan_id = $1
+		ORDER BY loan_installments.number
+	`
+	if lock {
+		query += "FOR UPDATE OF loan_installments"
+	}
+
+	rows, err := q.QueryContext(ctx, query, loanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	installments := []*Installment{}
+	for rows.Next() {
+		var installment Installment
+		var currency money.Currency
+		err = rows.Scan(
+			&installment.ID,
+			&installment.LoanID,
+			&installment.Number,
+			&installment.DueDate,
+			&installment.Principal,
+			&installment.Interest,
+			&installment.Fees,
+			&installment.PrincipalPaid,
+			&installment.InterestPaid,
+			&installment.FeesPaid,
+			&installment.LateFeeChargedAt,
+			&installment.PaidAt,
+			&currency,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		installment.Principal = installment.Principal.WithCurrency(currency)
+		installment.Interest = installment.Interest.WithCurrency(currency)
+		installment.Fees = installment.Fees.WithCurrency(currency)
+		installment.PrincipalPaid = installment.PrincipalPaid.WithCurrency(currency)
+		installment.InterestPaid = installment.InterestPaid.WithCurrency(currency)
+		installment.FeesPaid = installment.FeesPaid.WithCurrency(currency)
+		installments = append(installments, &installment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return installments, nil
+}
+
+func (r *Repository) GetInstallments(ctx context.Context, loanID int64) ([]*Installment, error) {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	return getInstallments(ctx, r.DB, loanID, false)
+}
+
+// PayInstallmentsTx pays the installments of the loan from the account, oldest first and fees and
+// interest before principal. the installments are locked while the payment is allocated, and the
+// ledger entry, the installments, the loan and the payment record are written in one transaction,
+// then is run last in it if given. ErrPaidOff is returned if nothing is owed on the loan any more
+func (r *Repository) PayInstallmentsTx(
+	ctx context.Context, loan *Loan, accountID int64, payment money.Amount, then PaidFunc,
+) (*Loan, error) {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// lock the loan before its installments, the same order the other writers to a loan use
+	var remaining money.Amount
+	query := `
+		SELECT remaining_amount
+		FROM loans
+		WHERE id = $1 AND user_id = $2
+		FOR UPDATE
+	`
+	err = tx.QueryRowContext(ctx, query, loan.ID, loan.UserID).Scan(&remaining)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	remaining = remaining.WithCurrency(payment.Currency())
+
+	installments, err := getInstallments(ctx, tx, loan.ID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	allocation, err := AllocatePayment(installments, payment, now)
+	if err != nil {
+		return nil, err
+	}
+	paid, err := allocation.Total()
+	if err != nil {
+		return nil, err
+	}
+	if !paid.IsPositive() {
+		return nil, ErrPaidOff
+	}
+
+	// fees were booked when they were charged, so paying them only settles what the loan owes
+	repaid, err := allocation.Principal.Add(allocation.Fees)
+	if err != nil {
+		return nil, err
+	}
+	entry := paymentEntry(loan.ID, accountID, paid, allocation.Interest, repaid)
+	err = ledger.InsertTx(ctx, tx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	updateInstallmentQuery := `
+		UPDATE loan_installments
+		SET principal_paid = $1, interest_paid = $2, fees_paid = $3, paid_at = $4
+		WHERE id = $5
+	`
+	for _, installment := range allocation.Installments {
+		args := []any{
+			installment.PrincipalPaid,
+			installment.InterestPaid,
+			installment.FeesPaid,
+			installment.PaidAt,
+			installment.ID,
+		}
+
+		_, err = tx.ExecContext(ctx, updateInstallmentQuery, args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	remaining, err = remaining.Sub(allocation.Principal)
+	if err != nil {
+		return nil, err
+	}
+	updateLoanQuery := `
+		UPDATE loans
+		SET remaining_amount = $1, last_updated_at = $2, version = version + 1
+		WHERE id = $3
+	`
+	_, err = tx.ExecContext(ctx, updateLoanQuery, remaining, now, loan.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	loanPayment := &Loan{
+		UserID:            loan.UserID,
+		AccountID:         accountID,
+		ProductID:         loan.ProductID,
+		Amount:            paid,
+		Action:            "paid",
+		DailyInterestRate: loan.DailyInterestRate,
+		RemainingAmount:   remaining,
+		LastUpdatedAt:     now,
+	}
+	err = tx.QueryRowContext(ctx, insertQuery, insertArgs(loanPayment)...).Scan(
+		&loanPayment.ID,
+		&loanPayment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if then != nil {
+		err = then(ctx, tx, loanPayment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return loanPayment, nil
+}
+
+// GetIDsToAccrue returns the ids of the loans with something left to pay that the nightly accrual
+// hasn't been through for the day yet
+func (r *Repository) GetIDsToAccrue(ctx context.Context, day time.Time) ([]int64, error) {
+	query := `
+		SELECT id
+		FROM loans
+		WHERE action = 'took' AND remaining_amount > 0
+		AND (accrued_through IS NULL OR accrued_through < $1)
+		ORDER BY id
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// AccrueTx brings the loan up to date for the day. a loan without a schedule has the interest it
+// built up added to its accrued interest, a loan with a schedule is charged penalty interest and
+// late fees on its overdue installments. the charges are booked on the ledger and the loan is
+// marked as accrued through the day in the same transaction, a loan that already is is left
+// alone, so running the accrual twice for a day never charges twice
+func (r *Repository) AccrueTx(
+	ctx context.Context, loanID int64, day time.Time, policy Policy,
+) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT loans.id, loans.user_id, loans.account_id, COALESCE(loans.product_id, 0),
+			accounts.currency, loans.daily_interest_rate, loans.remaining_amount,
+			loans.accrued_interest, loans.last_updated_at, loans.accrued_through
+		FROM loans
+		INNER JOIN accounts ON accounts.id = loans.account_id
+		WHERE loans.id = $1
+		FOR UPDATE OF loans
+	`
+
+	var loan Loan
+	var currency money.Currency
+	var accruedThrough *time.Time
+	err = tx.QueryRowContext(ctx, query, loanID).Scan(
+		&loan.ID,
+		&loan.UserID,
+		&loan.AccountID,
+		&loan.ProductID,
+		&currency,
+		&loan.DailyInterestRate,
+		&loan.RemainingAmount,
+		&loan.AccruedInterest,
+		&loan.LastUpdatedAt,
+		&accruedThrough,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return user.ErrNoRecord
+		default:
+			return err
+		}
+	}
+	loan.RemainingAmount = loan.RemainingAmount.WithCurrency(currency)
+	loan.AccruedInterest = loan.AccruedInterest.WithCurrency(currency)
+
+	if accruedThrough != nil && !accruedThrough.Before(day) {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	if loan.ProductID == 0 {
+		err = accrueInterestTx(ctx, tx, &loan, now)
+	} else {
+		err = chargeOverdueTx(ctx, tx, &loan, accruedThrough, day, policy, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE loans SET accrued_through = $1 WHERE id = $2", day, loanID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func accrueInterestTx(ctx context.Context, tx *sql.Tx, loan *Loan, now time.Time) error {
+	interest, err := AccrueInterest(loan, now)
+	if err != nil {
+		return err
+	}
+	if interest.IsZero() {
+		return nil
+	}
+
+	entry := ledger.Move(
+		ledger.KindInterestAccrual, fmt.Sprintf("interest on loan %d", loan.ID),
+		ledger.AccountLoans, ledger.AccountInterest, interest,
+	)
+	err = ledger.InsertTx(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+
+	// the interest is kept apart from what is left of the principal, so tomorrow's is only
+	// charged on the principal
+	accruedInterest, err := loan.AccruedInterest.Add(interest)
+	if err != nil {
+		return err
+	}
+
+	// moving last_updated_at on means a payment only charges the interest since now
+	query := `
+		UPDATE loans
+		SET accrued_interest = $1, last_updated_at = $2, version = version + 1
+		WHERE id = $3
+	`
+	_, err = tx.ExecContext(ctx, query, accruedInterest, now, loan.ID)
+	return err
+}
+
+func chargeOverdueTx(
+	ctx context.Context, tx *sql.Tx, loan *Loan, accruedThrough *time.Time, day time.Time,
+	policy Policy, now time.Time,
+) error {
+	installments, err := getInstallments(ctx, tx, loan.ID, true)
+	if err != nil {
+		return err
+	}
+
+	charged, penalty, lateFees, err := ChargeOverdue(
+		installments, accruedThrough, day, policy, now,
+	)
+	if err != nil || len(charged) == 0 {
+		return err
+	}
+
+	total, err := penalty.Add(lateFees)
+	if err != nil {
+		return err
+	}
+	entry := ledger.NewEntry(
+		ledger.KindLateCharge, fmt.Sprintf("late charges on loan %d", loan.ID),
+		ledger.Posting{Account: ledger.AccountLoans, Amount: total.Neg()},
+		ledger.Posting{Account: ledger.AccountInterest, Amount: penalty},
+		ledger.Posting{Account: ledger.AccountFees, Amount: lateFees},
 	)
+	err = ledger.InsertTx(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE loan_installments
+		SET fees = $1, late_fee_charged_at = $2
+		WHERE id = $3
+	`
+	for _, installment := range charged {
+		_, err = tx.ExecContext(
+			ctx, query, installment.Fees, installment.LateFeeChargedAt, installment.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,281 @@
+package loan
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// how often the installments of a loan product fall due
+const (
+	FrequencyWeekly   = "WEEKLY"
+	FrequencyBiweekly = "BIWEEKLY"
+	FrequencyMonthly  = "MONTHLY"
+)
+
+// how the principal of a loan product is spread over its installments. equal installments keep
+// every payment the same, with interest making up less of each one as the principal goes down.
+// equal principal pays the same principal every time, so the payments shrink with the interest
+const (
+	AmortizationEqualInstallment = "EQUAL_INSTALLMENT"
+	AmortizationEqualPrincipal   = "EQUAL_PRINCIPAL"
+)
+
+// MaxTerm is the most installments a loan product can have, 30 years of monthly payments
+const MaxTerm = 360
+
+// Product is a kind of loan customers can ask for, it decides the schedule the loan is paid back on
+type Product struct {
+	ID                 int64     `json:"id"`
+	CreatedAt          time.Time `json:"created_at"`
+	Name               string    `json:"name"`
+	AnnualInterestRate float64   `json:"annual_interest_rate"`
+	Term               int       `json:"term"`
+	Frequency          string    `json:"frequency"`
+	Amortization       string    `json:"amortization"`
+}
+
+// Installment is one payment on the schedule of a loan. fees are the late fees and penalty
+// interest charged once the installment is overdue, they are not part of the schedule itself
+type Installment struct {
+	ID               int64        `json:"-"`
+	LoanID           int64        `json:"-"`
+	Number           int          `json:"number"`
+	DueDate          time.Time    `json:"due_date"`
+	Principal        money.Amount `json:"principal"`
+	Interest         money.Amount `json:"interest"`
+	Fees             money.Amount `json:"fees"`
+	PrincipalPaid    money.Amount `json:"principal_paid"`
+	InterestPaid     money.Amount `json:"interest_paid"`
+	FeesPaid         money.Amount `json:"fees_paid"`
+	LateFeeChargedAt *time.Time   `json:"late_fee_charged_at,omitempty"`
+	PaidAt           *time.Time   `json:"paid_at,omitempty"`
+}
+
+func ValidateProduct(v *validator.Validator, product *Product) {
+	v.CheckAddError(product.Name != "", "name", "must be given")
+	v.CheckAddError(product.AnnualInterestRate >= 0, "annual interest rate", "cannot be negative")
+	v.CheckAddError(product.Term > 0, "term", "must be more than 0")
+	v.CheckAddError(product.Term <= MaxTerm, "term", "must not be more than 360")
+
+	frequencies := []string{FrequencyWeekly, FrequencyBiweekly, FrequencyMonthly}
+	v.CheckAddError(validator.ValueInList(product.Frequency, frequencies...), "frequency", "invalid")
+
+	methods := []string{AmortizationEqualInstallment, AmortizationEqualPrincipal}
+	v.CheckAddError(
+		validator.ValueInList(product.Amortization, methods...), "amortization", "invalid",
+	)
+}
+
+// periodRate returns the interest charged every installment period as an exact fraction
+func (p *Product) periodRate() *big.Rat {
+	periodsPerYear := int64(12)
+	switch p.Frequency {
+	case FrequencyWeekly:
+		periodsPerYear = 52
+	case FrequencyBiweekly:
+		periodsPerYear = 26
+	}
+
+	rate := money.Rate(p.AnnualInterestRate)
+	return rate.Quo(rate, big.NewRat(periodsPerYear, 1))
+}
+
+// dueDate returns the date installment n falls due on, counting from the day the loan was taken.
+// monthly installments keep the day of the month, or the last day of shorter months, so a loan
+// taken on the 31st is due on the 28th of February and not in March
+func (p *Product) dueDate(start time.Time, n int) time.Time {
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	switch p.Frequency {
+	case FrequencyWeekly:
+		return start.AddDate(0, 0, 7*n)
+	case FrequencyBiweekly:
+		return start.AddDate(0, 0, 14*n)
+	default:
+		firstOfMonth := time.Date(start.Year(), start.Month()+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+		lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+		return firstOfMonth.AddDate(0, 0, min(start.Day(), lastDay)-1)
+	}
+}
+
+// BuildSchedule works out the installments of a loan of principal taken at start. interest is
+// charged on the principal still owed at the start of every period and rounded to the cent, any
+// rounding left over is settled by the last installment so the principals always add up exactly
+func BuildSchedule(
+	product *Product, principal money.Amount, start time.Time,
+) ([]*Installment, error) {
+	rate := product.periodRate()
+	n := int64(product.Term)
+
+	// for equal installments the payment is principal * r / (1 - (1 + r)^-n), for equal principal
+	// it's just the principal over the term
+	var payment, principalPart money.Amount
+	var err error
+	switch {
+	case product.Amortization == AmortizationEqualPrincipal:
+		principalPart, err = principal.Mul(big.NewRat(1, n), money.RoundDown)
+	case rate.Sign() == 0:
+		payment, err = principal.Mul(big.NewRat(1, n), money.RoundUp)
+	default:
+		growth := new(big.Rat).Add(big.NewRat(1, 1), rate)
+		compound := big.NewRat(1, 1)
+		for range n {
+			compound.Mul(compound, growth)
+		}
+		factor := new(big.Rat).Mul(rate, compound)
+		factor.Quo(factor, compound.Sub(compound, big.NewRat(1, 1)))
+		payment, err = principal.Mul(factor, money.RoundHalfUp)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	owed := principal
+	installments := make([]*Installment, 0, n)
+	for i := 1; i <= product.Term; i++ {
+		interest, err := owed.Mul(rate, money.RoundHalfEven)
+		if err != nil {
+			return nil, err
+		}
+
+		if product.Amortization == AmortizationEqualInstallment {
+			principalPart, err = payment.Sub(interest)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if i == product.Term || owed.LessThan(principalPart) {
+			principalPart = owed
+		}
+
+		owed, err = owed.Sub(principalPart)
+		if err != nil {
+			return nil, err
+		}
+
+		installments = append(installments, &Installment{
+			Number:        i,
+			DueDate:       product.dueDate(start, i),
+			Principal:     principalPart,
+			Interest:      interest,
+			Fees:          money.New(0, principal.Currency()),
+			PrincipalPaid: money.New(0, principal.Currency()),
+			InterestPaid:  money.New(0, principal.Currency()),
+			FeesPaid:      money.New(0, principal.Currency()),
+		})
+	}
+
+	return installments, nil
+}
+
+// Owed returns what is still to be paid on the installment
+func (i *Installment) Owed() (money.Amount, error) {
+	total := money.Amount{}
+	for _, amount := range []money.Amount{
+		i.Principal, i.Interest, i.Fees, i.PrincipalPaid.Neg(), i.InterestPaid.Neg(),
+		i.FeesPaid.Neg(),
+	} {
+		var err error
+		total, err = total.Add(amount)
+		if err != nil {
+			return money.Amount{}, err
+		}
+	}
+
+	return total, nil
+}
+
+// Allocation is how a payment was split between the parts of what is owed on a loan
+type Allocation struct {
+	Installments []*Installment // the installments the payment went to
+	Fees         money.Amount
+	Interest     money.Amount
+	Principal    money.Amount
+}
+
+// Total returns the whole of the payment that was allocated
+func (a *Allocation) Total() (money.Amount, error) {
+	total, err := a.Fees.Add(a.Interest)
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	return total.Add(a.Principal)
+}
+
+// payPart takes what it can of owed - paid out of left, adding it to paid and to total. left is
+// returned with it taken out
+func payPart(left, owed money.Amount, paid, total *money.Amount) (money.Amount, error) {
+	outstanding, err := owed.Sub(*paid)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	part := money.Min(left, outstanding)
+
+	*paid, err = paid.Add(part)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	*total, err = total.Add(part)
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	return left.Sub(part)
+}
+
+// AllocatePayment spreads the payment over the installments, which must be in order. the oldest
+// installment that isn't paid off is paid first, its fees, then its interest and then its
+// principal, and whatever is left goes on to the next one. the installments are updated in place.
+// anything left over once every installment is paid is not allocated
+func AllocatePayment(
+	installments []*Installment, payment money.Amount, now time.Time,
+) (*Allocation, error) {
+	left := payment
+	allocation := &Allocation{
+		Fees:      money.New(0, payment.Currency()),
+		Interest:  money.New(0, payment.Currency()),
+		Principal: money.New(0, payment.Currency()),
+	}
+	for _, installment := range installments {
+		if !left.IsPositive() {
+			break
+		}
+		if installment.PaidAt != nil {
+			continue
+		}
+
+		var err error
+		left, err = payPart(left, installment.Fees, &installment.FeesPaid, &allocation.Fees)
+		if err != nil {
+			return nil, err
+		}
+		left, err = payPart(
+			left, installment.Interest, &installment.InterestPaid, &allocation.Interest,
+		)
+		if err != nil {
+			return nil, err
+		}
+		left, err = payPart(
+			left, installment.Principal, &installment.PrincipalPaid, &allocation.Principal,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		owed, err := installment.Owed()
+		if err != nil {
+			return nil, err
+		}
+		if owed.IsZero() {
+			paidAt := now
+			installment.PaidAt = &paidAt
+		}
+
+		allocation.Installments = append(allocation.Installments, installment)
+	}
+
+	return allocation, nil
+}
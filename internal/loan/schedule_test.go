@@ -0,0 +1,252 @@
+package loan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestBuildSchedule(t *testing.T) {
+	start := time.Date(2025, time.January, 31, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		product       *Product
+		principal     money.Amount
+		wantFirst     [2]string // principal and interest of the first installment
+		wantLast      [2]string
+		wantFirstDue  time.Time
+		wantInterest  string // over the whole loan
+		wantInstalled int
+	}{
+		{
+			name: "equal installments",
+			product: &Product{
+				AnnualInterestRate: 12, Term: 12, Frequency: FrequencyMonthly,
+				Amortization: AmortizationEqualInstallment,
+			},
+			principal:     money.MustParse("1000"),
+			wantFirst:     [2]string{"78.85", "10.00"},
+			wantLast:      [2]string{"87.96", "0.88"},
+			wantFirstDue:  time.Date(2025, time.February, 28, 0, 0, 0, 0, time.UTC),
+			wantInterest:  "66.19",
+			wantInstalled: 12,
+		},
+		{
+			name: "equal principal",
+			product: &Product{
+				AnnualInterestRate: 12, Term: 12, Frequency: FrequencyMonthly,
+				Amortization: AmortizationEqualPrincipal,
+			},
+			principal:     money.MustParse("1000"),
+			wantFirst:     [2]string{"83.33", "10.00"},
+			wantLast:      [2]string{"83.37", "0.83"},
+			wantFirstDue:  time.Date(2025, time.February, 28, 0, 0, 0, 0, time.UTC),
+			wantInterest:  "65.00",
+			wantInstalled: 12,
+		},
+		{
+			name: "no interest",
+			product: &Product{
+				AnnualInterestRate: 0, Term: 3, Frequency: FrequencyWeekly,
+				Amortization: AmortizationEqualInstallment,
+			},
+			principal:     money.MustParse("100"),
+			wantFirst:     [2]string{"33.34", "0.00"},
+			wantLast:      [2]string{"33.32", "0.00"},
+			wantFirstDue:  time.Date(2025, time.February, 7, 0, 0, 0, 0, time.UTC),
+			wantInterest:  "0.00",
+			wantInstalled: 3,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			installments, err := BuildSchedule(tc.product, tc.principal, start)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if len(installments) != tc.wantInstalled {
+				t.Fatalf("expected %d installments, got %d", tc.wantInstalled, len(installments))
+			}
+
+			first, last := installments[0], installments[len(installments)-1]
+			got := [2]string{first.Principal.String(), first.Interest.String()}
+			if got != tc.wantFirst {
+				t.Errorf("expected first installment %v, got %v", tc.wantFirst, got)
+			}
+			got = [2]string{last.Principal.String(), last.Interest.String()}
+			if got != tc.wantLast {
+				t.Errorf("expected last installment %v, got %v", tc.wantLast, got)
+			}
+			if !first.DueDate.Equal(tc.wantFirstDue) {
+				t.Errorf("expected first due date %v, got %v", tc.wantFirstDue, first.DueDate)
+			}
+
+			// the principals have to add up to exactly what was lent
+			principal, interest := money.Amount{}, money.Amount{}
+			for _, installment := range installments {
+				principal, _ = principal.Add(installment.Principal)
+				interest, _ = interest.Add(installment.Interest)
+			}
+			if principal.Cmp(tc.principal) != 0 {
+				t.Errorf("expected principals to add up to %s, got %s", tc.principal, principal)
+			}
+			if interest.String() != tc.wantInterest {
+				t.Errorf("expected total interest %s, got %s", tc.wantInterest, interest)
+			}
+		})
+	}
+}
+
+func TestAllocatePayment(t *testing.T) {
+	now := time.Now()
+	newInstallments := func() []*Installment {
+		return []*Installment{
+			{
+				Number: 1, Principal: money.MustParse("90"), Interest: money.MustParse("10"),
+				Fees: money.MustParse("0"), PrincipalPaid: money.MustParse("90"),
+				InterestPaid: money.MustParse("10"), FeesPaid: money.MustParse("0"), PaidAt: &now,
+			},
+			{
+				Number: 2, Principal: money.MustParse("95"), Interest: money.MustParse("5"),
+				Fees: money.MustParse("3"), PrincipalPaid: money.MustParse("0"),
+				InterestPaid: money.MustParse("2"), FeesPaid: money.MustParse("0"),
+			},
+			{
+				Number: 3, Principal: money.MustParse("98"), Interest: money.MustParse("2"),
+				Fees: money.MustParse("0"), PrincipalPaid: money.MustParse("0"),
+				InterestPaid: money.MustParse("0"), FeesPaid: money.MustParse("0"),
+			},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		payment       money.Amount
+		wantTouched   []int
+		wantFees      string
+		wantInterest  string
+		wantPrincipal string
+		wantPaidOff   []int
+	}{
+		{
+			name:          "fees first",
+			payment:       money.MustParse("2"),
+			wantTouched:   []int{2},
+			wantFees:      "2.00",
+			wantInterest:  "0.00",
+			wantPrincipal: "0.00",
+		},
+		{
+			name:          "then interest",
+			payment:       money.MustParse("5"),
+			wantTouched:   []int{2},
+			wantFees:      "3.00",
+			wantInterest:  "2.00",
+			wantPrincipal: "0.00",
+		},
+		{
+			name:          "finishes one and starts the next",
+			payment:       money.MustParse("103"),
+			wantTouched:   []int{2, 3},
+			wantFees:      "3.00",
+			wantInterest:  "5.00",
+			wantPrincipal: "95.00",
+			wantPaidOff:   []int{2},
+		},
+		{
+			name:          "more than is owed",
+			payment:       money.MustParse("500"),
+			wantTouched:   []int{2, 3},
+			wantFees:      "3.00",
+			wantInterest:  "5.00",
+			wantPrincipal: "193.00",
+			wantPaidOff:   []int{2, 3},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			installments := newInstallments()
+			allocation, err := AllocatePayment(installments, tc.payment, now)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			touched := allocation.Installments
+			if len(touched) != len(tc.wantTouched) {
+				t.Fatalf("expected %d installments paid, got %d", len(tc.wantTouched), len(touched))
+			}
+			for i, installment := range touched {
+				if installment.Number != tc.wantTouched[i] {
+					t.Errorf(
+						"expected installment %d paid, got %d", tc.wantTouched[i], installment.Number,
+					)
+				}
+			}
+			if allocation.Fees.String() != tc.wantFees {
+				t.Errorf("expected fees paid %s, got %s", tc.wantFees, allocation.Fees)
+			}
+			if allocation.Interest.String() != tc.wantInterest {
+				t.Errorf("expected interest paid %s, got %s", tc.wantInterest, allocation.Interest)
+			}
+			if allocation.Principal.String() != tc.wantPrincipal {
+				t.Errorf("expected principal paid %s, got %s", tc.wantPrincipal, allocation.Principal)
+			}
+
+			paidOff := []int{}
+			for _, installment := range installments[1:] {
+				if installment.PaidAt != nil {
+					paidOff = append(paidOff, installment.Number)
+				}
+			}
+			if len(paidOff) != len(tc.wantPaidOff) {
+				t.Errorf("expected installments %v paid off, got %v", tc.wantPaidOff, paidOff)
+			}
+		})
+	}
+}
+
+func TestValidateProduct(t *testing.T) {
+	tests := []struct {
+		name      string
+		product   *Product
+		wantValid bool
+	}{
+		{
+			name: "valid",
+			product: &Product{
+				Name: "personal", AnnualInterestRate: 12, Term: 12, Frequency: FrequencyMonthly,
+				Amortization: AmortizationEqualInstallment,
+			},
+			wantValid: true,
+		},
+		{
+			name: "term too long",
+			product: &Product{
+				Name: "personal", AnnualInterestRate: 12, Term: 361, Frequency: FrequencyMonthly,
+				Amortization: AmortizationEqualInstallment,
+			},
+		},
+		{
+			name: "unknown frequency",
+			product: &Product{
+				Name: "personal", AnnualInterestRate: 12, Term: 12, Frequency: "DAILY",
+				Amortization: AmortizationEqualPrincipal,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateProduct(v, tc.product)
+			if v.IsValid() != tc.wantValid {
+				t.Errorf("expected valid=%v, got errors %v", tc.wantValid, v.Errors)
+			}
+		})
+	}
+}
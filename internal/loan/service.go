@@ -1,38 +1,173 @@
 package loan
 
 import (
-	"math"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/Yusufdot101/goBankBackend/internal/webhook"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/loan")
+
+// ErrPaidOff is returned for a payment on a loan that has nothing left to pay
+var ErrPaidOff = errors.New("loan is already paid off")
+
 type Repo interface {
-	Insert(*Loan) error
-	GetByID(loanID, userID int64) (*Loan, error)
-	InsertDeletion(loan *LoanDeletion) error
-	MakePaymentTx(loanID, userID int64, payment, totalOwed float64) (*Loan, error)
-	DeleteLoan(loanID, debtorID int64) error
+	Insert(ctx context.Context, loan *Loan) error
+	InsertWithSchedule(ctx context.Context, loan *Loan, installments []*Installment) error
+	GetByID(ctx context.Context, loanID, userID int64) (*Loan, error)
+	MakePaymentTx(
+		ctx context.Context, loan *Loan, accountID int64, payment money.Amount, then PaidFunc,
+	) (*Loan, error)
+	DeleteTx(ctx context.Context, loanDeletion *LoanDeletion, then database.TxFunc) error
+	GetAllForUser(
+		ctx context.Context, userID int64, f filter.Filters,
+	) ([]*Loan, filter.Metadata, error)
+	GetProduct(ctx context.Context, productID int64) (*Product, error)
+	GetAllProducts(ctx context.Context) ([]*Product, error)
+	GetInstallments(ctx context.Context, loanID int64) ([]*Installment, error)
+	PayInstallmentsTx(
+		ctx context.Context, loan *Loan, accountID int64, payment money.Amount, then PaidFunc,
+	) (*Loan, error)
+	GetIDsToAccrue(ctx context.Context, day time.Time) ([]int64, error)
+	AccrueTx(ctx context.Context, loanID int64, day time.Time, policy Policy) error
+}
+
+type AccountService interface {
+	GetUserAccount(
+		ctx context.Context, v *validator.Validator, userID int64, number string,
+	) (*account.Account, error)
+}
+
+// Publisher queues the webhooks of loan payments, in the transaction of the payment
+type Publisher interface {
+	PublishTx(ctx context.Context, tx *sql.Tx, event string, data map[string]any) error
 }
 
-type UserService interface {
-	GetUser(userID int64) (*user.User, error)
-	UpdateUser(
-		userID int64, userName, userEmail string, userPasswordHash []byte,
-		userAccountBalance float64, userActivated bool) (*user.User, error)
+// Notifier tells borrowers about payments on their loans and loans forgiven, in their transactions
+type Notifier interface {
+	NotifyTx(
+		ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+	) error
+}
+
+// Auditor records who forgave a loan, in the transaction that deletes it
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
 }
 
 type Service struct {
-	Repo        Repo
-	UserService UserService
+	Repo           Repo
+	AccountService AccountService
+	Webhooks       Publisher // optional
+	Notifier       Notifier  // optional
+	Auditor        Auditor   // optional
 }
 
+// paidTx tells the user about their payment of loanID from the account a and queues its
+// webhooks, in the transaction of the payment
+func (s *Service) paidTx(loanID int64, a *account.Account) PaidFunc {
+	return func(ctx context.Context, tx *sql.Tx, loanPayment *Loan) error {
+		if s.Notifier != nil {
+			data := notification.AmountData(loanPayment.Amount, a.Number)
+			data["remainingAmount"] = loanPayment.RemainingAmount.String()
+			data["paidOff"] = !loanPayment.RemainingAmount.IsPositive()
+			err := s.Notifier.NotifyTx(
+				ctx, tx, loanPayment.UserID, notification.EventLoanPayment, data,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if s.Webhooks == nil {
+			return nil
+		}
+		return s.Webhooks.PublishTx(ctx, tx, webhook.EventLoanPayment, map[string]any{
+			"loan_id":          loanID,
+			"payment_id":       loanPayment.ID,
+			"created_at":       loanPayment.CreatedAt,
+			"user_id":          loanPayment.UserID,
+			"account_number":   a.Number,
+			"amount":           loanPayment.Amount.String(),
+			"currency":         loanPayment.Amount.Currency(),
+			"remaining_amount": loanPayment.RemainingAmount.String(),
+		})
+	}
+}
+
+// deletedTx tells the debtor their loan was forgiven and audits it, in the transaction that
+// deletes it
+func (s *Service) deletedTx(loanDeletion *LoanDeletion) database.TxFunc {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		if s.Notifier != nil {
+			data := notification.AmountData(loanDeletion.RemainingAmount, "")
+			data["reason"] = loanDeletion.Reason
+			err := s.Notifier.NotifyTx(
+				ctx, tx, loanDeletion.DebtorID, notification.EventLoanForgiven, data,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if s.Auditor == nil {
+			return nil
+		}
+		// the loan is gone, the deletion is all that's left of what it was
+		return s.Auditor.RecordTx(
+			ctx, tx, audit.ActionLoanDeleted, audit.TargetLoan, loanDeletion.LoanID, loanDeletion,
+			nil,
+		)
+	}
+}
+
+// GetLoan records a loan paid out to the account a. a loan taken under a product gets its
+// installment schedule worked out and stored with it, productID 0 is a loan that accrues daily
+// interest on whatever is left until it is paid
 func (s *Service) GetLoan(
-	u *user.User, amount, dailyInterestRate float64,
+	ctx context.Context, a *account.Account, productID int64, amount money.Amount,
+	dailyInterestRate float64,
 ) error {
-	loan := Loan{
-		UserID:            u.ID,
+	ctx, span := tracer.Start(ctx, "loan.GetLoan")
+	defer span.End()
+
+	loan, installments, err := s.NewLoan(ctx, a, productID, amount, dailyInterestRate)
+	if err != nil {
+		return err
+	}
+
+	if productID == 0 {
+		return s.Repo.Insert(ctx, loan)
+	}
+	return s.Repo.InsertWithSchedule(ctx, loan, installments)
+}
+
+// NewLoan returns the loan GetLoan would record, with its installments, for a caller that records
+// it with InsertTx in a transaction of its own
+func (s *Service) NewLoan(
+	ctx context.Context, a *account.Account, productID int64, amount money.Amount,
+	dailyInterestRate float64,
+) (*Loan, []*Installment, error) {
+	loan := &Loan{
+		UserID:            a.UserID,
+		AccountID:         a.ID,
+		ProductID:         productID,
 		Amount:            amount,
 		Action:            "took",
 		DailyInterestRate: dailyInterestRate,
@@ -40,83 +175,102 @@ func (s *Service) GetLoan(
 		LastUpdatedAt:     time.Now(),
 	}
 
-	err := s.Repo.Insert(&loan)
+	if productID == 0 {
+		return loan, nil, nil
+	}
+
+	product, err := s.Repo.GetProduct(ctx, productID)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	return nil
+	installments, err := BuildSchedule(product, amount, loan.LastUpdatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return loan, installments, nil
 }
 
+// MakePayment pays the loan from the account of the user with the number accountNumber. the account
+// has to be in the currency of the loan, the payment is taken to be in that currency as well
 func (s *Service) MakePayment(
-	v *validator.Validator, loanID, userID int64, payment float64,
+	ctx context.Context, v *validator.Validator, loanID, userID int64, accountNumber string,
+	payment money.Amount,
 ) (*Loan, error) {
-	if payment <= 0 {
+	ctx, span := tracer.Start(ctx, "loan.MakePayment")
+	defer span.End()
+
+	if !payment.IsPositive() {
 		v.AddError("amount", "must be more than 0")
 		return nil, validator.ErrFailedValidation
 	}
-	loan, err := s.Repo.GetByID(loanID, userID)
+	loan, err := s.Repo.GetByID(ctx, loanID, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if loan.RemainingAmount == 0 {
+	if loan.RemainingAmount.IsZero() {
 		v.AddError("loan", "is already paid off")
 		return nil, validator.ErrFailedValidation
 	}
 
-	// get the user
-	u, err := s.UserService.GetUser(userID)
+	// get the account the payment comes from
+	a, err := s.AccountService.GetUserAccount(ctx, v, userID, accountNumber)
 	if err != nil {
 		return nil, err
 	}
 
-	// check if he has enough funds
-	if u.AccountBalance < payment {
-		v.AddError("account_balance", "insufficient funds")
+	if a.Currency != loan.RemainingAmount.Currency() {
+		v.AddError("account", "must be in the currency of the loan")
 		return nil, validator.ErrFailedValidation
 	}
+	payment = payment.WithCurrency(a.Currency)
 
-	// get the time since last payment was made, we use LastUpdatedAt instead of created_at to
-	// avoid over-charging in partial payments.
-	elapsedTimeDays := time.Since(loan.LastUpdatedAt).Hours() / 24
-	interest := elapsedTimeDays * (loan.RemainingAmount * (loan.DailyInterestRate / 100))
-	totalOwed := loan.RemainingAmount + interest
-
-	loan, err = s.Repo.MakePaymentTx(loan.ID, userID, payment, totalOwed)
-	if err != nil {
-		return nil, err
+	// check if it has enough funds
+	if a.Balance.LessThan(payment) {
+		v.AddError("account_balance", "insufficient funds")
+		return nil, validator.ErrFailedValidation
 	}
 
-	loanPayment := Loan{
-		UserID:            loan.UserID,
-		Amount:            math.Min(payment, totalOwed),
-		Action:            "paid",
-		RemainingAmount:   loan.RemainingAmount,
-		DailyInterestRate: loan.DailyInterestRate,
-		LastUpdatedAt:     loan.LastUpdatedAt,
+	// loans with a schedule are paid by installment, the interest is already on the schedule.
+	// loans without one pay the interest they built up first, then what is left of them
+	var loanPayment *Loan
+	if loan.ProductID != 0 {
+		loanPayment, err = s.Repo.PayInstallmentsTx(ctx, loan, a.ID, payment, s.paidTx(loanID, a))
+	} else {
+		loanPayment, err = s.Repo.MakePaymentTx(ctx, loan, a.ID, payment, s.paidTx(loanID, a))
 	}
-
-	err = s.Repo.Insert(&loanPayment)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, ErrPaidOff):
+			v.AddError("loan", "is already paid off")
+			return nil, validator.ErrFailedValidation
+		case ledger.AddInsertError(v, err):
+			return nil, validator.ErrFailedValidation
+		default:
+			return nil, err
+		}
 	}
 
-	// deduct the payment from the users account
-	u.AccountBalance -= loanPayment.Amount
-	_, err = s.UserService.UpdateUser(
-		u.ID, u.Name, u.Email, u.Password.Hash, u.AccountBalance, u.Activated,
-	)
-	if err != nil {
-		return nil, err
-	}
+	return loanPayment, nil
+}
+
+// GetByID returns the loan with the id taken by the user
+func (s *Service) GetByID(ctx context.Context, loanID, userID int64) (*Loan, error) {
+	ctx, span := tracer.Start(ctx, "loan.GetByID")
+	defer span.End()
 
-	return &loanPayment, nil
+	return s.Repo.GetByID(ctx, loanID, userID)
 }
 
+// DeleteLoan forgives the loan of the debtor, recording who deleted it and why
 func (s *Service) DeleteLoan(
-	v *validator.Validator, loanID, debtorID, deletedByID int64, reason string,
+	ctx context.Context, v *validator.Validator, loanID, debtorID, deletedByID int64, reason string,
 ) (*LoanDeletion, error) {
+	ctx, span := tracer.Start(ctx, "loan.DeleteLoan")
+	defer span.End()
+
 	loanDeletion := &LoanDeletion{
 		LoanID:      loanID,
 		DebtorID:    debtorID,
@@ -127,7 +281,7 @@ func (s *Service) DeleteLoan(
 		return nil, validator.ErrFailedValidation
 	}
 
-	loan, err := s.Repo.GetByID(loanID, debtorID)
+	loan, err := s.Repo.GetByID(ctx, loanID, debtorID)
 	if err != nil {
 		return nil, err
 	}
@@ -142,34 +296,98 @@ func (s *Service) DeleteLoan(
 	loanDeletion.DailyInterestRate = loan.DailyInterestRate
 	loanDeletion.Reason = reason
 
-	// first record the deletion before deleting the loan because we could get an error before
-	// recording it after removing the loan from the system. if we get an error removing the loan
-	// but the deletion is recorded we could handle it as the loan with the id exists and deal with
-	// it. we retry 5 times to record the entry
-	err = nil // clean the err var before
-	for range 5 {
-		err = s.Repo.InsertDeletion(loanDeletion)
-		if err == nil {
-			break
-		}
-		time.Sleep(100 * time.Millisecond) // wait for 100ms before retrying.
-	}
+	// what is left of the loan is written off in the same transaction that removes it
+	err = s.Repo.DeleteTx(ctx, loanDeletion, s.deletedTx(loanDeletion))
 	if err != nil {
 		return nil, err
 	}
 
-	// delete the actual loan. we retry 5 times
-	err = nil
-	for range 5 {
-		err = s.Repo.DeleteLoan(loanID, debtorID)
-		if err == nil {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
+	return loanDeletion, nil
+}
+
+// GetAllForUser returns a page of the loans and loan payments of the user
+func (s *Service) GetAllForUser(
+	ctx context.Context, v *validator.Validator, userID int64, f filter.Filters,
+) ([]*Loan, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "loan.GetAllForUser")
+	defer span.End()
+
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
 	}
+
+	return s.Repo.GetAllForUser(ctx, userID, f)
+}
+
+// paymentEntry moves a payment on the loan out of the account. interest is the bank's income, the
+// rest of the payment repays what the loan owes: its principal and anything that was charged to it
+// and booked already
+func paymentEntry(
+	loanID, accountID int64, paid, interestPaid, repaid money.Amount,
+) *ledger.Entry {
+	return ledger.NewEntry(
+		ledger.KindLoanPayment, fmt.Sprintf("payment on loan %d", loanID),
+		ledger.Posting{Account: ledger.CustomerAccount(accountID), Amount: paid.Neg()},
+		ledger.Posting{Account: ledger.AccountLoans, Amount: repaid},
+		ledger.Posting{Account: ledger.AccountInterest, Amount: interestPaid},
+	)
+}
+
+// GetSchedule returns the installments of the loan of the user, a loan without a product has none
+func (s *Service) GetSchedule(ctx context.Context, loanID, userID int64) ([]*Installment, error) {
+	ctx, span := tracer.Start(ctx, "loan.GetSchedule")
+	defer span.End()
+
+	loan, err := s.Repo.GetByID(ctx, loanID, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return loanDeletion, nil
+	return s.Repo.GetInstallments(ctx, loan.ID)
+}
+
+// GetProduct returns the loan product with the id, a product that doesn't exist is a validation
+// error as the id comes from the customer
+func (s *Service) GetProduct(
+	ctx context.Context, v *validator.Validator, productID int64,
+) (*Product, error) {
+	ctx, span := tracer.Start(ctx, "loan.GetProduct")
+	defer span.End()
+
+	product, err := s.Repo.GetProduct(ctx, productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			v.AddError("product", "does not exist")
+			return nil, validator.ErrFailedValidation
+		default:
+			return nil, err
+		}
+	}
+
+	return product, nil
+}
+
+func (s *Service) GetAllProducts(ctx context.Context) ([]*Product, error) {
+	ctx, span := tracer.Start(ctx, "loan.GetAllProducts")
+	defer span.End()
+
+	return s.Repo.GetAllProducts(ctx)
+}
+
+// LoansToAccrue returns the ids of the loans the nightly accrual still has to go through for the
+// day
+func (s *Service) LoansToAccrue(ctx context.Context, day time.Time) ([]int64, error) {
+	ctx, span := tracer.Start(ctx, "loan.LoansToAccrue")
+	defer span.End()
+
+	return s.Repo.GetIDsToAccrue(ctx, day)
+}
+
+// Accrue brings the loan up to date for the day, it is safe to call more than once for a day
+func (s *Service) Accrue(ctx context.Context, loanID int64, day time.Time, policy Policy) error {
+	ctx, span := tracer.Start(ctx, "loan.Accrue")
+	defer span.End()
+
+	return s.Repo.AccrueTx(ctx, loanID, day, policy)
 }
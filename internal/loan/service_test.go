@@ -1,9 +1,18 @@
 package loan
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
@@ -11,167 +20,243 @@ import (
 type mockRepo struct {
 	InsertErr error
 
-	InsertDeletionErr error
-
 	GetByIDResult *Loan
 	GetByIDErr    error
 
-	DeleteLoanErr error
+	DeleteTxErr error
 
 	MakePaymentTxResult *Loan
 	MakePaymentTxErr    error
-}
 
-func (m *mockRepo) Insert(loan *Loan) error {
-	return m.InsertErr
+	PayInstallmentsTxResult *Loan
+	PayInstallmentsTxErr    error
 }
 
-func (m *mockRepo) InsertDeletion(loan *LoanDeletion) error {
-	return m.InsertDeletionErr
+func (m *mockRepo) Insert(ctx context.Context, loan *Loan) error {
+	return m.InsertErr
 }
 
-func (m *mockRepo) GetByID(loanID, userID int64) (*Loan, error) {
+func (m *mockRepo) GetByID(ctx context.Context, loanID, userID int64) (*Loan, error) {
 	if m.GetByIDErr != nil {
 		return nil, m.GetByIDErr
 	}
 	return m.GetByIDResult, nil
 }
 
-func (m *mockRepo) DeleteLoan(loanID, debtorID int64) error {
-	return m.DeleteLoanErr
+func (m *mockRepo) DeleteTx(
+	ctx context.Context, loanDeletion *LoanDeletion, then database.TxFunc,
+) error {
+	if m.DeleteTxErr != nil {
+		return m.DeleteTxErr
+	}
+	if then != nil {
+		return then(ctx, nil)
+	}
+	return nil
+}
+
+func (m *mockRepo) GetAllForUser(
+	ctx context.Context, userID int64, f filter.Filters,
+) ([]*Loan, filter.Metadata, error) {
+	return nil, filter.Metadata{}, nil
 }
 
-func (m *mockRepo) MakePaymentTx(loanID, userID int64, payment, totalOwed float64) (*Loan, error) {
+func (m *mockRepo) MakePaymentTx(
+	ctx context.Context, loan *Loan, accountID int64, payment money.Amount, then PaidFunc,
+) (*Loan, error) {
 	if m.MakePaymentTxErr != nil {
 		return nil, m.MakePaymentTxErr
 	}
+	if then != nil {
+		err := then(ctx, nil, m.MakePaymentTxResult)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return m.MakePaymentTxResult, nil
 }
 
-type mockUserService struct {
-	GetUserResult *user.User
-	GetUserErr    error
+func (m *mockRepo) InsertWithSchedule(
+	ctx context.Context, loan *Loan, installments []*Installment,
+) error {
+	return m.InsertErr
+}
 
-	UpdateUserErr error
+func (m *mockRepo) GetProduct(ctx context.Context, productID int64) (*Product, error) {
+	return nil, user.ErrNoRecord
 }
 
-func (us *mockUserService) GetUser(userID int64) (*user.User, error) {
-	if us.GetUserErr != nil {
-		return nil, us.GetUserErr
-	}
+func (m *mockRepo) GetAllProducts(ctx context.Context) ([]*Product, error) {
+	return nil, nil
+}
 
-	return us.GetUserResult, nil
+func (m *mockRepo) GetInstallments(ctx context.Context, loanID int64) ([]*Installment, error) {
+	return nil, nil
 }
 
-func (us *mockUserService) UpdateUser(
-	userID int64, userName, userEmail string, userPasswordHash []byte,
-	userAccountBalance float64, userActivated bool,
-) (*user.User, error) {
-	if us.UpdateUserErr != nil {
-		return nil, us.UpdateUserErr
+func (m *mockRepo) PayInstallmentsTx(
+	ctx context.Context, loan *Loan, accountID int64, payment money.Amount, then PaidFunc,
+) (*Loan, error) {
+	if m.PayInstallmentsTxErr != nil {
+		return nil, m.PayInstallmentsTxErr
 	}
-	u, err := us.GetUser(userID)
-	if err != nil {
-		return nil, err
+	if then != nil {
+		err := then(ctx, nil, m.PayInstallmentsTxResult)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m.PayInstallmentsTxResult, nil
+}
+
+func (m *mockRepo) GetIDsToAccrue(ctx context.Context, day time.Time) ([]int64, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) AccrueTx(ctx context.Context, loanID int64, day time.Time, policy Policy) error {
+	return nil
+}
+
+type mockAccountService struct {
+	GetUserAccountResult *account.Account
+	GetUserAccountErr    error
+}
+
+func (as *mockAccountService) GetUserAccount(
+	ctx context.Context, v *validator.Validator, userID int64, number string,
+) (*account.Account, error) {
+	if as.GetUserAccountErr != nil {
+		return nil, as.GetUserAccountErr
 	}
-	u.AccountBalance = userAccountBalance
-	u.Name = userName
-	u.Email = userEmail
-	u.Password.Hash = userPasswordHash
-	u.Activated = userActivated
-	return u, nil
+
+	return as.GetUserAccountResult, nil
+}
+
+// mockNotifier records the events users are notified of
+type mockNotifier struct {
+	Events []string
+}
+
+func (n *mockNotifier) NotifyTx(
+	ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+) error {
+	n.Events = append(n.Events, event)
+	return nil
 }
 
 func TestMakepayment(t *testing.T) {
 	mockLoan := &Loan{
 		ID:              1,
 		UserID:          1,
-		Amount:          200,
+		Amount:          money.MustParse("200"),
 		Action:          "took",
-		RemainingAmount: 200,
+		RemainingAmount: money.MustParse("200"),
 	}
-	mockUser := &user.User{
-		ID:             1,
-		Name:           "yusuf",
-		Email:          "ym@gmail.com",
-		AccountBalance: 100,
+	mockAccount := &account.Account{
+		ID:       1,
+		UserID:   1,
+		Number:   "1000000009",
+		Currency: "USD",
+		Status:   account.StatusActive,
+		Balance:  money.MustParse("100"),
 	}
 
 	tests := []struct {
-		name         string
-		setupRepo    func(*mockRepo)
-		setupUserSvc func(*mockUserService)
-		input        struct {
+		name            string
+		setupRepo       func(*mockRepo)
+		setupAccountSvc func(*mockAccountService)
+		input           struct {
 			v              *validator.Validator
 			loanID, userID int64
-			payment        float64
+			payment        money.Amount
 		}
-		finalLoanRemainingAmount float64
+		finalLoanRemainingAmount money.Amount
 		expectedErr              error
 	}{
 		{
 			name: "vaild input",
 			setupRepo: func(r *mockRepo) {
 				r.GetByIDResult = mockLoan
-				r.MakePaymentTxResult = &Loan{RemainingAmount: 150}
+				r.MakePaymentTxResult = &Loan{RemainingAmount: money.MustParse("150")}
 			},
-			setupUserSvc: func(us *mockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *mockAccountService) {
+				as.GetUserAccountResult = mockAccount
 			},
 			input: struct {
 				v       *validator.Validator
 				loanID  int64
 				userID  int64
-				payment float64
-			}{v: validator.New(), loanID: 1, userID: 1, payment: 50},
-			finalLoanRemainingAmount: 150,
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("50")},
+			finalLoanRemainingAmount: money.MustParse("150"),
 		},
 		{
 			name: "insufficient funds",
 			setupRepo: func(r *mockRepo) {
 				r.GetByIDResult = mockLoan
 			},
-			setupUserSvc: func(us *mockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *mockAccountService) {
+				as.GetUserAccountResult = mockAccount
+			},
+			input: struct {
+				v       *validator.Validator
+				loanID  int64
+				userID  int64
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("200")},
+			finalLoanRemainingAmount: money.MustParse("200"),
+			expectedErr:              validator.ErrFailedValidation,
+		},
+		{
+			name: "account in another currency",
+			setupRepo: func(r *mockRepo) {
+				r.GetByIDResult = mockLoan
+			},
+			setupAccountSvc: func(as *mockAccountService) {
+				as.GetUserAccountResult = &account.Account{
+					ID: 2, UserID: 1, Currency: "EUR", Status: account.StatusActive,
+					Balance: money.MustParse("100").WithCurrency("EUR"),
+				}
 			},
 			input: struct {
 				v       *validator.Validator
 				loanID  int64
 				userID  int64
-				payment float64
-			}{v: validator.New(), loanID: 1, userID: 1, payment: 200},
-			finalLoanRemainingAmount: 200,
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("50")},
+			finalLoanRemainingAmount: money.MustParse("200"),
 			expectedErr:              validator.ErrFailedValidation,
 		},
 		{
 			name: "loan already paid off",
 			setupRepo: func(r *mockRepo) {
-				r.GetByIDResult = &Loan{RemainingAmount: 0}
+				r.GetByIDResult = &Loan{RemainingAmount: money.MustParse("0")}
 			},
-			setupUserSvc: func(us *mockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *mockAccountService) {
+				as.GetUserAccountResult = mockAccount
 			},
 			input: struct {
 				v       *validator.Validator
 				loanID  int64
 				userID  int64
-				payment float64
-			}{v: validator.New(), loanID: 1, userID: 1, payment: 200},
-			finalLoanRemainingAmount: 200,
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("200")},
+			finalLoanRemainingAmount: money.MustParse("200"),
 			expectedErr:              validator.ErrFailedValidation,
 		},
 		{
-			name:         "negative amount",
-			setupRepo:    func(r *mockRepo) {},
-			setupUserSvc: func(us *mockUserService) {},
+			name:            "negative amount",
+			setupRepo:       func(r *mockRepo) {},
+			setupAccountSvc: func(as *mockAccountService) {},
 			input: struct {
 				v       *validator.Validator
 				loanID  int64
 				userID  int64
-				payment float64
-			}{v: validator.New(), loanID: 1, userID: 1, payment: -100},
-			finalLoanRemainingAmount: 200,
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("-100")},
+			finalLoanRemainingAmount: money.MustParse("200"),
 			expectedErr:              validator.ErrFailedValidation,
 		},
 		{
@@ -179,31 +264,31 @@ func TestMakepayment(t *testing.T) {
 			setupRepo: func(r *mockRepo) {
 				r.GetByIDErr = user.ErrNoRecord
 			},
-			setupUserSvc: func(us *mockUserService) {},
+			setupAccountSvc: func(as *mockAccountService) {},
 			input: struct {
 				v       *validator.Validator
 				loanID  int64
 				userID  int64
-				payment float64
-			}{v: validator.New(), loanID: 1, userID: 1, payment: 100},
-			finalLoanRemainingAmount: 200,
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("100")},
+			finalLoanRemainingAmount: money.MustParse("200"),
 			expectedErr:              user.ErrNoRecord,
 		},
 		{
-			name: "GetUser failure",
+			name: "GetUserAccount failure",
 			setupRepo: func(r *mockRepo) {
 				r.GetByIDResult = mockLoan
 			},
-			setupUserSvc: func(us *mockUserService) {
-				us.GetUserErr = user.ErrNoRecord
+			setupAccountSvc: func(as *mockAccountService) {
+				as.GetUserAccountErr = user.ErrNoRecord
 			},
 			input: struct {
 				v       *validator.Validator
 				loanID  int64
 				userID  int64
-				payment float64
-			}{v: validator.New(), loanID: 1, userID: 1, payment: 100},
-			finalLoanRemainingAmount: 200,
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("100")},
+			finalLoanRemainingAmount: money.MustParse("200"),
 			expectedErr:              user.ErrNoRecord,
 		},
 		{
@@ -212,74 +297,75 @@ func TestMakepayment(t *testing.T) {
 				r.GetByIDResult = mockLoan
 				r.MakePaymentTxErr = errors.New("db MakePaymentTx error")
 			},
-			setupUserSvc: func(us *mockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *mockAccountService) {
+				as.GetUserAccountResult = mockAccount
 			},
 			input: struct {
 				v       *validator.Validator
 				loanID  int64
 				userID  int64
-				payment float64
-			}{v: validator.New(), loanID: 1, userID: 1, payment: 100},
-			finalLoanRemainingAmount: 200,
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("100")},
+			finalLoanRemainingAmount: money.MustParse("200"),
 			expectedErr:              errors.New("db MakePaymentTx error"),
 		},
 		{
-			name: "Insert failure",
+			name: "account frozen before the payment",
 			setupRepo: func(r *mockRepo) {
 				r.GetByIDResult = mockLoan
-				r.MakePaymentTxResult = mockLoan
-				r.InsertErr = errors.New("db Insert error")
+				r.MakePaymentTxErr = ledger.ErrAccountInactive
 			},
-			setupUserSvc: func(us *mockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *mockAccountService) {
+				as.GetUserAccountResult = mockAccount
 			},
 			input: struct {
 				v       *validator.Validator
 				loanID  int64
 				userID  int64
-				payment float64
-			}{v: validator.New(), loanID: 1, userID: 1, payment: 100},
-			finalLoanRemainingAmount: 200,
-			expectedErr:              errors.New("db Insert error"),
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("100")},
+			finalLoanRemainingAmount: money.MustParse("200"),
+			expectedErr:              validator.ErrFailedValidation,
 		},
 		{
-			name: "UpdateUser failure",
+			name: "paid off before the payment",
 			setupRepo: func(r *mockRepo) {
 				r.GetByIDResult = mockLoan
-				r.MakePaymentTxResult = mockLoan
+				r.MakePaymentTxErr = ErrPaidOff
 			},
-			setupUserSvc: func(us *mockUserService) {
-				us.GetUserResult = mockUser
-				us.UpdateUserErr = errors.New("db UpdateUser error")
+			setupAccountSvc: func(as *mockAccountService) {
+				as.GetUserAccountResult = mockAccount
 			},
 			input: struct {
 				v       *validator.Validator
 				loanID  int64
 				userID  int64
-				payment float64
-			}{v: validator.New(), loanID: 1, userID: 1, payment: 100},
-			finalLoanRemainingAmount: 200,
-			expectedErr:              errors.New("db UpdateUser error"),
+				payment money.Amount
+			}{v: validator.New(), loanID: 1, userID: 1, payment: money.MustParse("100")},
+			finalLoanRemainingAmount: money.MustParse("200"),
+			expectedErr:              validator.ErrFailedValidation,
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			// reset the user AccountBalance to avoid confusion and unexpected behaviour
-			mockUser.AccountBalance = 100
+			// reset the account balance to avoid confusion and unexpected behaviour
+			mockAccount.Balance = money.MustParse("100")
 			repo := &mockRepo{}
-			userSvc := &mockUserService{}
+			accountSvc := &mockAccountService{}
 			tc.setupRepo(repo)
-			tc.setupUserSvc(userSvc)
+			tc.setupAccountSvc(accountSvc)
 
+			notifier := &mockNotifier{}
 			svc := Service{
-				Repo:        repo,
-				UserService: userSvc,
+				Repo:           repo,
+				AccountService: accountSvc,
+				Notifier:       notifier,
 			}
 
 			gotLoan, gotErr := svc.MakePayment(
-				tc.input.v, tc.input.loanID, tc.input.userID, tc.input.payment,
+				context.Background(), tc.input.v, tc.input.loanID, tc.input.userID, mockAccount.Number,
+				tc.input.payment,
 			)
 			if tc.expectedErr != nil {
 				if gotErr.Error() != tc.expectedErr.Error() {
@@ -289,9 +375,12 @@ func TestMakepayment(t *testing.T) {
 			} else if gotErr != nil {
 				t.Fatalf("unexpected error %v", gotErr)
 			}
-			if gotLoan.RemainingAmount != tc.finalLoanRemainingAmount {
+			if len(notifier.Events) != 1 || notifier.Events[0] != notification.EventLoanPayment {
+				t.Errorf("expected the user notified of the payment, got %v", notifier.Events)
+			}
+			if gotLoan.RemainingAmount.Cmp(tc.finalLoanRemainingAmount) != 0 {
 				t.Fatalf(
-					"expected remaining amount %f, got %f", tc.finalLoanRemainingAmount,
+					"expected remaining amount %s, got %s", tc.finalLoanRemainingAmount,
 					gotLoan.RemainingAmount,
 				)
 			}
@@ -299,13 +388,108 @@ func TestMakepayment(t *testing.T) {
 	}
 }
 
+func TestMakePaymentScheduled(t *testing.T) {
+	scheduledLoan := &Loan{
+		ID:              1,
+		UserID:          1,
+		ProductID:       1,
+		Amount:          money.MustParse("200"),
+		Action:          "took",
+		RemainingAmount: money.MustParse("200"),
+	}
+	mockAccount := &account.Account{
+		ID:       1,
+		UserID:   1,
+		Number:   "1000000009",
+		Currency: "USD",
+		Status:   account.StatusActive,
+		Balance:  money.MustParse("100"),
+	}
+
+	tests := []struct {
+		name          string
+		setupRepo     func(*mockRepo)
+		wantRemaining money.Amount
+		expectedErr   error
+		expectedMsg   map[string]string
+	}{
+		{
+			name: "valid",
+			setupRepo: func(r *mockRepo) {
+				r.PayInstallmentsTxResult = &Loan{RemainingAmount: money.MustParse("150")}
+			},
+			wantRemaining: money.MustParse("150"),
+		},
+		{
+			name: "paid off in the meantime",
+			setupRepo: func(r *mockRepo) {
+				r.PayInstallmentsTxErr = ErrPaidOff
+			},
+			expectedErr: validator.ErrFailedValidation,
+			expectedMsg: map[string]string{"loan": "is already paid off"},
+		},
+		{
+			name: "insufficient funds",
+			setupRepo: func(r *mockRepo) {
+				r.PayInstallmentsTxErr = ledger.ErrInsufficientFunds
+			},
+			expectedErr: validator.ErrFailedValidation,
+			expectedMsg: map[string]string{"account balance": "insufficient funds"},
+		},
+		{
+			name: "PayInstallmentsTx failure",
+			setupRepo: func(r *mockRepo) {
+				r.PayInstallmentsTxErr = errors.New("db PayInstallmentsTx error")
+			},
+			expectedErr: errors.New("db PayInstallmentsTx error"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &mockRepo{GetByIDResult: scheduledLoan}
+			tc.setupRepo(repo)
+			svc := Service{
+				Repo:           repo,
+				AccountService: &mockAccountService{GetUserAccountResult: mockAccount},
+			}
+
+			v := validator.New()
+			gotLoan, gotErr := svc.MakePayment(
+				context.Background(), v, 1, 1, mockAccount.Number, money.MustParse("50"),
+			)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				for key, val := range tc.expectedMsg {
+					if v.Errors[key] != val {
+						t.Errorf(
+							"expected message=%s for key=%v, got message=%s", val, key, v.Errors[key],
+						)
+					}
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error %v", gotErr)
+			}
+
+			if gotLoan.RemainingAmount.Cmp(tc.wantRemaining) != 0 {
+				t.Errorf(
+					"expected remaining amount %s, got %s", tc.wantRemaining, gotLoan.RemainingAmount,
+				)
+			}
+		})
+	}
+}
+
 func TestDeleteLoan(t *testing.T) {
 	mockLoan := &Loan{
 		ID:              1,
 		UserID:          1,
-		Amount:          200,
+		Amount:          money.MustParse("200"),
 		Action:          "took",
-		RemainingAmount: 200,
+		RemainingAmount: money.MustParse("200"),
 	}
 
 	tests := []struct {
@@ -358,10 +542,10 @@ func TestDeleteLoan(t *testing.T) {
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
-			name: "InsertDeletion failure",
+			name: "DeleteTx failure",
 			setupRepo: func(r *mockRepo) {
 				r.GetByIDResult = mockLoan
-				r.InsertDeletionErr = errors.New("db InsertDeletion error")
+				r.DeleteTxErr = errors.New("db DeleteTx error")
 			},
 			input: struct {
 				v           *validator.Validator
@@ -370,22 +554,7 @@ func TestDeleteLoan(t *testing.T) {
 				deletedByID int64
 				reason      string
 			}{v: validator.New(), loanID: 1, debtorID: 1, deletedByID: 1, reason: "some reason"},
-			expectedErr: errors.New("db InsertDeletion error"),
-		},
-		{
-			name: "DeleteLoan failure",
-			setupRepo: func(r *mockRepo) {
-				r.GetByIDResult = mockLoan
-				r.DeleteLoanErr = errors.New("db DeleteLoan error")
-			},
-			input: struct {
-				v           *validator.Validator
-				loanID      int64
-				debtorID    int64
-				deletedByID int64
-				reason      string
-			}{v: validator.New(), loanID: 1, debtorID: 1, deletedByID: 1, reason: "some reason"},
-			expectedErr: errors.New("db DeleteLoan error"),
+			expectedErr: errors.New("db DeleteTx error"),
 		},
 	}
 
@@ -393,10 +562,11 @@ func TestDeleteLoan(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			repo := &mockRepo{}
 			tc.setupRepo(repo)
-			svc := Service{Repo: repo}
+			notifier := &mockNotifier{}
+			svc := Service{Repo: repo, Notifier: notifier}
 
 			gotLoan, gotErr := svc.DeleteLoan(
-				tc.input.v, tc.input.loanID, tc.input.debtorID, tc.input.deletedByID,
+				context.Background(), tc.input.v, tc.input.loanID, tc.input.debtorID, tc.input.deletedByID,
 				tc.input.reason,
 			)
 
@@ -409,13 +579,16 @@ func TestDeleteLoan(t *testing.T) {
 				t.Fatalf("unexpected error %v", gotErr)
 			}
 
-			if gotLoan.Amount != mockLoan.Amount {
-				t.Errorf("expected amount %f, got %f", mockLoan.Amount, gotLoan.Amount)
+			if gotLoan.Amount.Cmp(mockLoan.Amount) != 0 {
+				t.Errorf("expected amount %s, got %s", mockLoan.Amount, gotLoan.Amount)
 			}
 
 			if gotLoan.LoanID != mockLoan.ID {
 				t.Errorf("expected loan ID %d, got %d", mockLoan.ID, gotLoan.LoanID)
 			}
+			if len(notifier.Events) != 1 || notifier.Events[0] != notification.EventLoanForgiven {
+				t.Errorf("expected the debtor notified, got %v", notifier.Events)
+			}
 		})
 	}
 }
@@ -1,25 +1,55 @@
 package loanrequests
 
 import (
+	"errors"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
+// the states a loan request moves through. a request only leaves PENDING once, by being accepted or
+// declined by an approver or withdrawn by the borrower
+const (
+	StatusPending   = "PENDING"
+	StatusAccepted  = "ACCEPTED"
+	StatusDeclined  = "DECLINED"
+	StatusWithdrawn = "WITHDRAWN"
+)
+
+var Statuses = []string{StatusPending, StatusAccepted, StatusDeclined, StatusWithdrawn}
+
+// SortSafelist are the values loan requests can be sorted by
+var SortSafelist = []string{"id", "created_at", "amount", "-id", "-created_at", "-amount"}
+
+// MaxDeclineReasonLength is the most bytes the reason given for declining a request can have
+const MaxDeclineReasonLength = 500
+
+var ErrNotPending = errors.New("the loan request has already been responded to or withdrawn")
+
 type LoanRequest struct {
 	ID                int64
 	CreatedAt         time.Time
 	UserID            int64
-	Amount            float64
+	AccountID         int64
+	ProductID         int64 // 0 for a loan without a schedule
+	Amount            money.Amount
 	DailyInterestRate float64
 	Status            string
+	DeclineReason     string // only set on declined requests, and only if a reason was given
 }
 
 func ValidateLoanRequest(v *validator.Validator, loanRequest *LoanRequest) {
-	v.CheckAddError(loanRequest.Amount != 0, "amount", "must be given")
-	v.CheckAddError(loanRequest.Amount > 0, "amount", "must be more than 0")
+	v.CheckAddError(!loanRequest.Amount.IsZero(), "amount", "must be given")
+	v.CheckAddError(loanRequest.Amount.IsPositive(), "amount", "must be more than 0")
 
 	v.CheckAddError(loanRequest.DailyInterestRate >= 0, "dialy interest rate", "cannot be negative")
 	// v.CheckAddError(loanRequest.DailyInterestRate != 0, "amount", "must be given")
 	// v.CheckAddError(loanRequest.DailyInterestRate >= 0, "amount", "cannot be less than 0")
 }
+
+func ValidateDeclineReason(v *validator.Validator, reason string) {
+	v.CheckAddError(
+		len(reason) <= MaxDeclineReasonLength, "reason", "must not be more than 500 bytes long",
+	)
+}
@@ -4,30 +4,71 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/loan"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 )
 
 type Repository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (r *Repository) Insert(loanRequest *LoanRequest) error {
+// the columns of a loan request, in the order scanLoanRequest reads them
+const loanRequestColumns = `id, created_at, user_id, account_id, COALESCE(product_id, 0), amount,
+	daily_interest_rate, status, decline_reason`
+
+// scanLoanRequest reads a row of loanRequestColumns, after any columns selected before them into
+// leading
+func scanLoanRequest(row interface{ Scan(...any) error }, leading ...any) (*LoanRequest, error) {
+	loanRequest := &LoanRequest{}
+	err := row.Scan(append(
+		leading,
+		&loanRequest.ID,
+		&loanRequest.CreatedAt,
+		&loanRequest.UserID,
+		&loanRequest.AccountID,
+		&loanRequest.ProductID,
+		&loanRequest.Amount,
+		&loanRequest.DailyInterestRate,
+		&loanRequest.Status,
+		&loanRequest.DeclineReason,
+	)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return loanRequest, nil
+}
+
+func (r *Repository) Insert(ctx context.Context, loanRequest *LoanRequest) error {
 	query := `
 		INSERT INTO loan_requests
-			(user_id, amount, daily_interest_rate, status)
-		VALUES ($1, $2, $3, $4)
+			(user_id, account_id, product_id, amount, daily_interest_rate, status)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6)
 		RETURNING id, created_at
 	`
 	args := []any{
 		loanRequest.UserID,
+		loanRequest.AccountID,
+		loanRequest.ProductID,
 		loanRequest.Amount,
 		loanRequest.DailyInterestRate,
 		loanRequest.Status,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	err := r.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -41,94 +82,210 @@ func (r *Repository) Insert(loanRequest *LoanRequest) error {
 	return nil
 }
 
-func (r *Repository) Get(loanRequestID, userID int64) (*LoanRequest, error) {
-	query := `
-		SELECT id, created_at, user_id, amount, daily_interest_rate, status
+// Get returns the loan request with its amount in the currency of the account it would be paid out
+// to
+func (r *Repository) Get(ctx context.Context, loanRequestID, userID int64) (*LoanRequest, error) {
+	query := fmt.Sprintf(`
+		SELECT (SELECT currency FROM accounts WHERE accounts.id = loan_requests.account_id), %s
 		FROM loan_requests
 		WHERE id = $1
 		AND user_id = $2
-	`
-	loanRequest := &LoanRequest{}
+	`, loanRequestColumns)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	err := r.DB.QueryRowContext(ctx, query, loanRequestID, userID).Scan(
-		&loanRequest.ID,
-		&loanRequest.CreatedAt,
-		&loanRequest.UserID,
-		&loanRequest.Amount,
-		&loanRequest.DailyInterestRate,
-		&loanRequest.Status,
+	var currency money.Currency
+	loanRequest, err := scanLoanRequest(
+		r.DB.QueryRowContext(ctx, query, loanRequestID, userID), &currency,
 	)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, user.ErrNoRecord
-		default:
+		return nil, err
+	}
+
+	loanRequest.Amount = loanRequest.Amount.WithCurrency(currency)
+	return loanRequest, nil
+}
+
+// GetAll returns a page of the loan requests of the user with the status, userID 0 returns those of
+// every user and an empty status those in any status
+func (r *Repository) GetAll(
+	ctx context.Context, userID int64, status string, f filter.Filters,
+) ([]*LoanRequest, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM loan_requests
+		WHERE ($1 = 0 OR user_id = $1)
+		AND ($2 = '' OR status = $2)
+		AND ($3::timestamptz IS NULL OR created_at >= $3)
+		AND ($4::timestamptz IS NULL OR created_at < $4)
+		AND ($5::decimal IS NULL OR amount >= $5)
+		AND ($6::decimal IS NULL OR amount <= $6)
+		ORDER BY %s %s, id ASC
+		LIMIT $7 OFFSET $8
+	`, loanRequestColumns, f.SortColumn(), f.SortDirection())
+	args := []any{
+		userID,
+		status,
+		f.From,
+		f.To,
+		f.MinAmount,
+		f.MaxAmount,
+		f.Limit(),
+		f.Offset(),
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	loanRequests := []*LoanRequest{}
+	for rows.Next() {
+		loanRequest, err := scanLoanRequest(rows, &totalRecords)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		loanRequests = append(loanRequests, loanRequest)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return loanRequests, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+// UpdatedFunc is work done inside the transaction that moves a loan request on, with the request as
+// it was updated, before it is committed
+type UpdatedFunc func(ctx context.Context, tx *sql.Tx, loanRequest *LoanRequest) error
+
+// UpdateTx moves the pending loan request to newStatus, with the reason it was declined if it was,
+// then runs then in the same transaction if it is given. ErrNotPending is returned if the request
+// has already left PENDING
+func (r *Repository) UpdateTx(
+	ctx context.Context, loanRequestID, userID int64, newStatus, declineReason string,
+	then UpdatedFunc,
+) (*LoanRequest, error) {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// in case of any issues
+	defer tx.Rollback()
+
+	loanRequest, err := updateStatus(ctx, tx, loanRequestID, userID, newStatus, declineReason)
+	if err != nil {
+		return nil, err
+	}
+
+	if then != nil {
+		err = then(ctx, tx, loanRequest)
+		if err != nil {
 			return nil, err
 		}
 	}
 
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return loanRequest, nil
 }
 
-func (r *Repository) UpdateTx(loanRequestID, userID int64, newStatus string) (*LoanRequest, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// AcceptTx accepts the pending loan request and pays it out, the request, the ledger entry of the
+// payout and the loan with its installments are written in one transaction, then is run last in it
+// if given. ErrNotPending is returned if the request has already left PENDING
+func (r *Repository) AcceptTx(
+	ctx context.Context, loanRequestID, userID int64, payout *ledger.Entry, l *loan.Loan,
+	installments []*loan.Installment, then UpdatedFunc,
+) (*LoanRequest, error) {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	// in case of any issues
 	defer tx.Rollback()
 
+	loanRequest, err := updateStatus(ctx, tx, loanRequestID, userID, StatusAccepted, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// the entry locks the account before the foreign key on the loans row would
+	err = ledger.InsertTx(ctx, tx, payout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loan.InsertTx(ctx, tx, l, installments)
+	if err != nil {
+		return nil, err
+	}
+
+	if then != nil {
+		err = then(ctx, tx, loanRequest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return loanRequest, nil
+}
+
+// updateStatus moves the pending loan request to newStatus inside the transaction
+func updateStatus(
+	ctx context.Context, tx *sql.Tx, loanRequestID, userID int64, newStatus, declineReason string,
+) (*LoanRequest, error) {
 	// fetch loan request, use FOR UPDATE to lock the row from others trying to update at the same
 	// time
-	query := `
-		SELECT id, created_at, user_id, amount, daily_interest_rate, status 
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM  loan_requests
 		WHERE id = $1 
 		AND user_id = $2
 		FOR UPDATE
-	`
-	loanRequest := &LoanRequest{}
-	err = tx.QueryRowContext(ctx, query, loanRequestID, userID).Scan(
-		&loanRequest.ID,
-		&loanRequest.CreatedAt,
-		&loanRequest.UserID,
-		&loanRequest.Amount,
-		&loanRequest.DailyInterestRate,
-		&loanRequest.Status,
-	)
+	`, loanRequestColumns)
+	loanRequest, err := scanLoanRequest(tx.QueryRowContext(ctx, query, loanRequestID, userID))
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, user.ErrNoRecord
-		default:
-			return nil, err
-		}
+		return nil, err
+	}
+
+	// the borrower may have withdrawn it, or someone else responded to it, since it was last read
+	if loanRequest.Status != StatusPending {
+		return nil, ErrNotPending
 	}
 
 	updateQuery := `
 		UPDATE loan_requests
-		SET status = $1
-		WHERE id = $2
-		AND user_id = $3
-		RETURNING status
+		SET status = $1, decline_reason = $2
+		WHERE id = $3
+		AND user_id = $4
+		RETURNING status, decline_reason
 	`
+	args := []any{newStatus, declineReason, loanRequest.ID, loanRequest.UserID}
 
-	err = tx.QueryRowContext(ctx, updateQuery, newStatus, loanRequest.ID, loanRequest.UserID).Scan(
+	err = tx.QueryRowContext(ctx, updateQuery, args...).Scan(
 		&loanRequest.Status,
+		&loanRequest.DeclineReason,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return nil, err
-	}
-
 	return loanRequest, nil
 }
@@ -1,52 +1,140 @@
 package loanrequests
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/loan"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/Yusufdot101/goBankBackend/internal/webhook"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/loanrequests")
+
 type Repo interface {
-	Insert(loanRequest *LoanRequest) error
-	Get(loanRequestID, userID int64) (*LoanRequest, error)
-	UpdateTx(loanRequestID, userID int64, newStatus string) (*LoanRequest, error)
+	Insert(ctx context.Context, loanRequest *LoanRequest) error
+	Get(ctx context.Context, loanRequestID, userID int64) (*LoanRequest, error)
+	GetAll(
+		ctx context.Context, userID int64, status string, f filter.Filters,
+	) ([]*LoanRequest, filter.Metadata, error)
+	UpdateTx(
+		ctx context.Context, loanRequestID, userID int64, newStatus, declineReason string,
+		then UpdatedFunc,
+	) (*LoanRequest, error)
+	AcceptTx(
+		ctx context.Context, loanRequestID, userID int64, payout *ledger.Entry, l *loan.Loan,
+		installments []*loan.Installment, then UpdatedFunc,
+	) (*LoanRequest, error)
 }
 
-type UserService interface {
-	GetUser(userID int64) (*user.User, error)
-	UpdateUser(
-		userID int64, userName, userEmail string, userPasswordHash []byte,
-		userAccountBalance float64, userActivated bool,
-	) (*user.User, error)
+type AccountService interface {
+	GetAccount(ctx context.Context, accountID int64) (*account.Account, error)
+	GetUserAccount(
+		ctx context.Context, v *validator.Validator, userID int64, number string,
+	) (*account.Account, error)
 }
 
 type LoanService interface {
-	GetLoan(u *user.User, amount, dailyInterestRate float64) error
+	NewLoan(
+		ctx context.Context, a *account.Account, productID int64, amount money.Amount,
+		dailyInterestRate float64,
+	) (*loan.Loan, []*loan.Installment, error)
+	GetProduct(ctx context.Context, v *validator.Validator, productID int64) (*loan.Product, error)
+}
+
+// Publisher queues the webhooks of accepted loans, in the transaction that pays them out
+type Publisher interface {
+	PublishTx(ctx context.Context, tx *sql.Tx, event string, data map[string]any) error
+}
+
+// Notifier tells borrowers how their loan requests were responded to, in the same transaction
+type Notifier interface {
+	NotifyTx(
+		ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+	) error
+}
+
+// Auditor records who responded to a loan request, in the transaction of the response
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
 }
 
 type Service struct {
-	Repo        Repo
-	UserService UserService
-	LoanService LoanService
+	Repo           Repo
+	AccountService AccountService
+	LoanService    LoanService
+	Webhooks       Publisher // optional
+	Notifier       Notifier  // optional
+	Auditor        Auditor   // optional
 }
 
+// auditResponse records the response to the loan request, as it was updated, under the action.
+// only a pending request can be responded to, so that's what it looked like before
+func (s *Service) auditResponse(
+	ctx context.Context, tx *sql.Tx, action string, loanRequest *LoanRequest,
+) error {
+	if s.Auditor == nil {
+		return nil
+	}
+
+	pending := *loanRequest
+	pending.Status = StatusPending
+	pending.DeclineReason = ""
+	return s.Auditor.RecordTx(
+		ctx, tx, action, audit.TargetLoanRequest, loanRequest.ID, pending, loanRequest,
+	)
+}
+
+// New requests a loan for the user, to be paid out to their account with the number accountNumber
+// in the currency of that account. the loan is paid back on the schedule of the product with the
+// id productID, or with daily interest if productID is 0
 func (s *Service) New(
-	v *validator.Validator, u *user.User, amount, dailyInterestRate float64,
+	ctx context.Context, v *validator.Validator, u *user.User, accountNumber string, productID int64,
+	amount money.Amount, dailyInterestRate float64,
 ) (*LoanRequest, error) {
+	ctx, span := tracer.Start(ctx, "loanrequests.New")
+	defer span.End()
+
+	a, err := s.AccountService.GetUserAccount(ctx, v, u.ID, accountNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if productID != 0 {
+		_, err = s.LoanService.GetProduct(ctx, v, productID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	loanRequest := LoanRequest{
 		CreatedAt:         time.Now(),
 		UserID:            u.ID,
-		Amount:            amount,
+		AccountID:         a.ID,
+		ProductID:         productID,
+		Amount:            amount.WithCurrency(a.Currency),
 		DailyInterestRate: dailyInterestRate,
-		Status:            "PENDING",
+		Status:            StatusPending,
 	}
 
+	v.CheckAddError(a.IsActive(), "account", "not active")
 	if ValidateLoanRequest(v, &loanRequest); !v.IsValid() {
 		return nil, validator.ErrFailedValidation
 	}
 
-	err := s.Repo.Insert(&loanRequest)
+	err = s.Repo.Insert(ctx, &loanRequest)
 	if err != nil {
 		return nil, err
 	}
@@ -54,46 +142,154 @@ func (s *Service) New(
 	return &loanRequest, nil
 }
 
-func (s *Service) AcceptLoanRequest(loanRequestID, userID int64) (*LoanRequest, error) {
-	loanRequest, err := s.Repo.Get(loanRequestID, userID)
+// Get returns the loan request with the id made by the user
+func (s *Service) Get(ctx context.Context, loanRequestID, userID int64) (*LoanRequest, error) {
+	ctx, span := tracer.Start(ctx, "loanrequests.Get")
+	defer span.End()
+
+	return s.Repo.Get(ctx, loanRequestID, userID)
+}
+
+// GetAll returns a page of the loan requests of the user with the status. userID 0 returns those
+// of every user and an empty status those in any status
+func (s *Service) GetAll(
+	ctx context.Context, v *validator.Validator, userID int64, status string, f filter.Filters,
+) ([]*LoanRequest, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "loanrequests.GetAll")
+	defer span.End()
+
+	if status != "" {
+		v.CheckAddError(validator.ValueInList(status, Statuses...), "status", "invalid")
+	}
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetAll(ctx, userID, status, f)
+}
+
+// Withdraw takes back the pending loan request of the user, so it is never responded to
+func (s *Service) Withdraw(ctx context.Context, loanRequestID, userID int64) (*LoanRequest, error) {
+	ctx, span := tracer.Start(ctx, "loanrequests.Withdraw")
+	defer span.End()
+
+	return s.Repo.UpdateTx(ctx, loanRequestID, userID, StatusWithdrawn, "", nil)
+}
+
+// AcceptLoanRequest accepts the pending loan request and pays the loan out to the account it was
+// requested for. the request, the payout and the loan commit together, a payout the account can't
+// take, e.g. because it was frozen since, is a validation error in v
+func (s *Service) AcceptLoanRequest(
+	ctx context.Context, v *validator.Validator, loanRequestID, userID int64,
+) (*LoanRequest, error) {
+	ctx, span := tracer.Start(ctx, "loanrequests.AcceptLoanRequest")
+	defer span.End()
+
+	loanRequest, err := s.Repo.Get(ctx, loanRequestID, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if loanRequest.Status != "PENDING" {
+	if loanRequest.Status != StatusPending {
 		return nil, user.ErrNoRecord
 	}
 
-	loanRequest, err = s.Repo.UpdateTx(loanRequestID, userID, "ACCEPTED")
+	a, err := s.AccountService.GetAccount(ctx, loanRequest.AccountID)
 	if err != nil {
 		return nil, err
 	}
+	amount := loanRequest.Amount.WithCurrency(a.Currency)
 
-	// update the user account, add the loan to the account balance
-	u, err := s.UserService.GetUser(userID)
-	if err != nil {
-		return nil, err
+	entry := ledger.Move(
+		ledger.KindLoanPayout, fmt.Sprintf("loan request %d", loanRequest.ID),
+		ledger.AccountLoans, ledger.CustomerAccount(a.ID), amount,
+	)
+	if ledger.ValidateEntry(v, entry); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
 	}
 
-	u.AccountBalance += loanRequest.Amount
-	_, err = s.UserService.UpdateUser(
-		userID, u.Name, u.Email, u.Password.Hash, u.AccountBalance, u.Activated,
+	// the loan is recorded with its schedule if it has a product
+	l, installments, err := s.LoanService.NewLoan(
+		ctx, a, loanRequest.ProductID, amount, loanRequest.DailyInterestRate,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	// record the loan on the loans table
-	err = s.LoanService.GetLoan(u, loanRequest.Amount, loanRequest.DailyInterestRate)
+	// the borrower is told, the webhooks are queued and the response is audited with the loan paid
+	// out, not after it
+	accepted := func(ctx context.Context, tx *sql.Tx, loanRequest *LoanRequest) error {
+		// the request is in the currency of the account it is paid out to
+		loanRequest.Amount = amount
+		if s.Notifier != nil {
+			err := s.Notifier.NotifyTx(
+				ctx, tx, loanRequest.UserID, notification.EventLoanAccepted,
+				notification.AmountData(amount, ""),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if s.Webhooks != nil {
+			err := s.Webhooks.PublishTx(ctx, tx, webhook.EventLoanAccepted, map[string]any{
+				"loan_request_id":     loanRequest.ID,
+				"user_id":             loanRequest.UserID,
+				"account_number":      a.Number,
+				"product_id":          loanRequest.ProductID,
+				"amount":              amount.String(),
+				"currency":            amount.Currency(),
+				"daily_interest_rate": loanRequest.DailyInterestRate,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return s.auditResponse(ctx, tx, audit.ActionLoanRequestAccepted, loanRequest)
+	}
+	loanRequest, err = s.Repo.AcceptTx(
+		ctx, loanRequestID, userID, entry, l, installments, accepted,
+	)
 	if err != nil {
+		if ledger.AddInsertError(v, err) {
+			return nil, validator.ErrFailedValidation
+		}
 		return nil, err
 	}
 
 	return loanRequest, nil
 }
 
-func (s *Service) DeclineLoanRequest(loanRequestID, userID int64) (*LoanRequest, error) {
-	loanRequest, err := s.Repo.UpdateTx(loanRequestID, userID, "DECLINED")
+// DeclineLoanRequest declines the pending loan request, keeping the reason given for the borrower
+// to see
+func (s *Service) DeclineLoanRequest(
+	ctx context.Context, v *validator.Validator, loanRequestID, userID int64, reason string,
+) (*LoanRequest, error) {
+	ctx, span := tracer.Start(ctx, "loanrequests.DeclineLoanRequest")
+	defer span.End()
+
+	if ValidateDeclineReason(v, reason); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
+	}
+
+	declined := func(ctx context.Context, tx *sql.Tx, loanRequest *LoanRequest) error {
+		if s.Notifier != nil {
+			data := notification.AmountData(loanRequest.Amount, "")
+			data["reason"] = loanRequest.DeclineReason
+			err := s.Notifier.NotifyTx(
+				ctx, tx, loanRequest.UserID, notification.EventLoanDeclined, data,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return s.auditResponse(ctx, tx, audit.ActionLoanRequestDeclined, loanRequest)
+	}
+	loanRequest, err := s.Repo.UpdateTx(
+		ctx, loanRequestID, userID, StatusDeclined, reason, declined,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -1,9 +1,18 @@
 package loanrequests
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"strings"
 	"testing"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/loan"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
@@ -15,76 +24,168 @@ type MockRepo struct {
 	GetResult *LoanRequest
 	GetErr    error
 
+	GetAllResult []*LoanRequest
+	GetAllErr    error
+
+	UpdateTxStatus string // the status the last call to UpdateTx moved a request to
 	UpdateTxResult *LoanRequest
 	UpdateTxErr    error
+
+	// AcceptTx applies the postings of the payout to Account, so the tests can check the balance
+	// moved
+	Account        *account.Account
+	AcceptTxResult *LoanRequest
+	AcceptTxErr    error
 }
 
-func (r *MockRepo) Insert(loanRequest *LoanRequest) error {
+func (r *MockRepo) Insert(ctx context.Context, loanRequest *LoanRequest) error {
 	return r.InsertErr
 }
 
-func (r *MockRepo) Get(loanRequestID, userID int64) (*LoanRequest, error) {
+func (r *MockRepo) Get(ctx context.Context, loanRequestID, userID int64) (*LoanRequest, error) {
 	if r.GetErr != nil {
 		return nil, r.GetErr
 	}
 	return r.GetResult, nil
 }
 
-func (r *MockRepo) UpdateTx(loanRequestID, userID int64, newStatus string) (*LoanRequest, error) {
+func (r *MockRepo) GetAll(
+	ctx context.Context, userID int64, status string, f filter.Filters,
+) ([]*LoanRequest, filter.Metadata, error) {
+	if r.GetAllErr != nil {
+		return nil, filter.Metadata{}, r.GetAllErr
+	}
+	return r.GetAllResult, filter.Metadata{TotalRecords: len(r.GetAllResult)}, nil
+}
+
+func (r *MockRepo) UpdateTx(
+	ctx context.Context, loanRequestID, userID int64, newStatus, declineReason string,
+	then UpdatedFunc,
+) (*LoanRequest, error) {
+	r.UpdateTxStatus = newStatus
 	if r.UpdateTxErr != nil {
 		return nil, r.UpdateTxErr
 	}
+	if then != nil {
+		err := then(ctx, nil, r.UpdateTxResult)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return r.UpdateTxResult, nil
 }
 
-type MockUserService struct {
-	GetUserResult *user.User
-	GetUserErr    error
+func (r *MockRepo) AcceptTx(
+	ctx context.Context, loanRequestID, userID int64, payout *ledger.Entry, l *loan.Loan,
+	installments []*loan.Installment, then UpdatedFunc,
+) (*LoanRequest, error) {
+	if r.AcceptTxErr != nil {
+		return nil, r.AcceptTxErr
+	}
+	if then != nil {
+		err := then(ctx, nil, r.AcceptTxResult)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	UpdateUserResult *user.User
-	UpdateUserErr    error
+	for _, p := range payout.Postings {
+		if r.Account != nil && p.Account == ledger.CustomerAccount(r.Account.ID) {
+			r.Account.Balance, _ = r.Account.Balance.Add(p.Amount)
+		}
+	}
+	return r.AcceptTxResult, nil
 }
 
-func (us *MockUserService) GetUser(userID int64) (*user.User, error) {
-	if us.GetUserErr != nil {
-		return nil, us.GetUserErr
+// MockNotifier records the events users are notified of
+type MockNotifier struct {
+	Events []string
+}
+
+func (n *MockNotifier) NotifyTx(
+	ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+) error {
+	n.Events = append(n.Events, event)
+	return nil
+}
+
+type MockAccountService struct {
+	GetAccountResult *account.Account
+	GetAccountErr    error
+
+	GetUserAccountResult *account.Account
+	GetUserAccountErr    error
+}
+
+func (as *MockAccountService) GetAccount(
+	ctx context.Context, accountID int64,
+) (*account.Account, error) {
+	if as.GetAccountErr != nil {
+		return nil, as.GetAccountErr
 	}
-	return us.GetUserResult, nil
+	return as.GetAccountResult, nil
 }
 
-func (us *MockUserService) UpdateUser(
-	userID int64, userName, userEmail string, userPasswordHash []byte,
-	userAccountBalance float64, userActivated bool,
-) (*user.User, error) {
-	if us.UpdateUserErr != nil {
-		return nil, us.UpdateUserErr
+func (as *MockAccountService) GetUserAccount(
+	ctx context.Context, v *validator.Validator, userID int64, number string,
+) (*account.Account, error) {
+	if as.GetUserAccountErr != nil {
+		return nil, as.GetUserAccountErr
 	}
-	return us.UpdateUserResult, nil
+	return as.GetUserAccountResult, nil
 }
 
 type MockLoanService struct {
-	GetLoanErr error
+	NewLoanErr error
+
+	GetProductResult *loan.Product
+	GetProductErr    error
+}
+
+func (ls *MockLoanService) NewLoan(
+	ctx context.Context, a *account.Account, productID int64, amount money.Amount,
+	dialyInterestRate float64,
+) (*loan.Loan, []*loan.Installment, error) {
+	if ls.NewLoanErr != nil {
+		return nil, nil, ls.NewLoanErr
+	}
+	return &loan.Loan{AccountID: a.ID, Amount: amount}, nil, nil
 }
 
-func (ls *MockLoanService) GetLoan(u *user.User, amount, dialyInterestRate float64) error {
-	return ls.GetLoanErr
+func (ls *MockLoanService) GetProduct(
+	ctx context.Context, v *validator.Validator, productID int64,
+) (*loan.Product, error) {
+	if ls.GetProductErr != nil {
+		return nil, ls.GetProductErr
+	}
+	return ls.GetProductResult, nil
 }
 
 func TestNew(t *testing.T) {
 	mockUser := &user.User{
-		ID:             1,
-		Name:           "yusuf",
-		Email:          "ym@gmail.com",
-		AccountBalance: 100,
+		ID:    1,
+		Name:  "yusuf",
+		Email: "ym@gmail.com",
+	}
+	mockAccount := &account.Account{
+		ID:       1,
+		UserID:   1,
+		Number:   "1000000009",
+		Currency: "USD",
+		Status:   account.StatusActive,
+		Balance:  money.MustParse("100"),
 	}
 
 	tests := []struct {
-		name      string
-		setupRepo func(*MockRepo)
-		input     struct {
-			v                         *validator.Validator
-			u                         *user.User
-			amount, dialyInterestRate float64
+		name             string
+		setupRepo        func(*MockRepo)
+		setupLoanService func(*MockLoanService)
+		productID        int64
+		input            struct {
+			v                 *validator.Validator
+			u                 *user.User
+			amount            money.Amount
+			dialyInterestRate float64
 		}
 		expectedErr error
 	}{
@@ -94,9 +195,9 @@ func TestNew(t *testing.T) {
 			input: struct {
 				v                 *validator.Validator
 				u                 *user.User
-				amount            float64
+				amount            money.Amount
 				dialyInterestRate float64
-			}{v: validator.New(), u: mockUser, amount: 100, dialyInterestRate: 5},
+			}{v: validator.New(), u: mockUser, amount: money.MustParse("100"), dialyInterestRate: 5},
 		},
 		{
 			name:      "amount = 0",
@@ -104,9 +205,9 @@ func TestNew(t *testing.T) {
 			input: struct {
 				v                 *validator.Validator
 				u                 *user.User
-				amount            float64
+				amount            money.Amount
 				dialyInterestRate float64
-			}{v: validator.New(), u: mockUser, amount: 0, dialyInterestRate: 5},
+			}{v: validator.New(), u: mockUser, amount: money.MustParse("0"), dialyInterestRate: 5},
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
@@ -115,9 +216,9 @@ func TestNew(t *testing.T) {
 			input: struct {
 				v                 *validator.Validator
 				u                 *user.User
-				amount            float64
+				amount            money.Amount
 				dialyInterestRate float64
-			}{v: validator.New(), u: mockUser, amount: -100, dialyInterestRate: 5},
+			}{v: validator.New(), u: mockUser, amount: money.MustParse("-100"), dialyInterestRate: 5},
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
@@ -126,9 +227,9 @@ func TestNew(t *testing.T) {
 			input: struct {
 				v                 *validator.Validator
 				u                 *user.User
-				amount            float64
+				amount            money.Amount
 				dialyInterestRate float64
-			}{v: validator.New(), u: mockUser, amount: 100, dialyInterestRate: -5},
+			}{v: validator.New(), u: mockUser, amount: money.MustParse("100"), dialyInterestRate: -5},
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
@@ -139,21 +240,59 @@ func TestNew(t *testing.T) {
 			input: struct {
 				v                 *validator.Validator
 				u                 *user.User
-				amount            float64
+				amount            money.Amount
 				dialyInterestRate float64
-			}{v: validator.New(), u: mockUser, amount: 100, dialyInterestRate: 5},
+			}{v: validator.New(), u: mockUser, amount: money.MustParse("100"), dialyInterestRate: 5},
 			expectedErr: errors.New("db error"),
 		},
+		{
+			name:      "with a product",
+			setupRepo: func(r *MockRepo) {},
+			setupLoanService: func(ls *MockLoanService) {
+				ls.GetProductResult = &loan.Product{ID: 1}
+			},
+			productID: 1,
+			input: struct {
+				v                 *validator.Validator
+				u                 *user.User
+				amount            money.Amount
+				dialyInterestRate float64
+			}{v: validator.New(), u: mockUser, amount: money.MustParse("100"), dialyInterestRate: 5},
+		},
+		{
+			name:      "unknown product",
+			setupRepo: func(r *MockRepo) {},
+			setupLoanService: func(ls *MockLoanService) {
+				ls.GetProductErr = validator.ErrFailedValidation
+			},
+			productID: 2,
+			input: struct {
+				v                 *validator.Validator
+				u                 *user.User
+				amount            money.Amount
+				dialyInterestRate float64
+			}{v: validator.New(), u: mockUser, amount: money.MustParse("100"), dialyInterestRate: 5},
+			expectedErr: validator.ErrFailedValidation,
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			repo := &MockRepo{}
 			tc.setupRepo(repo)
-			svc := Service{Repo: repo}
+			loanSvc := &MockLoanService{}
+			if tc.setupLoanService != nil {
+				tc.setupLoanService(loanSvc)
+			}
+			svc := Service{
+				Repo:           repo,
+				AccountService: &MockAccountService{GetUserAccountResult: mockAccount},
+				LoanService:    loanSvc,
+			}
 
 			loanRequest, gotErr := svc.New(
-				tc.input.v, tc.input.u, tc.input.amount, tc.input.dialyInterestRate,
+				context.Background(), tc.input.v, tc.input.u, mockAccount.Number, tc.productID, tc.input.amount,
+				tc.input.dialyInterestRate,
 			)
 			if tc.expectedErr != nil {
 				if gotErr.Error() != tc.expectedErr.Error() {
@@ -168,8 +307,16 @@ func TestNew(t *testing.T) {
 				t.Errorf("expected user id %d, got %d", mockUser.ID, loanRequest.UserID)
 			}
 
-			if loanRequest.Amount != tc.input.amount {
-				t.Errorf("expected amount %f, got %f", tc.input.amount, loanRequest.Amount)
+			if loanRequest.AccountID != mockAccount.ID {
+				t.Errorf("expected account id %d, got %d", mockAccount.ID, loanRequest.AccountID)
+			}
+
+			if loanRequest.ProductID != tc.productID {
+				t.Errorf("expected product id %d, got %d", tc.productID, loanRequest.ProductID)
+			}
+
+			if loanRequest.Amount.Cmp(tc.input.amount) != 0 {
+				t.Errorf("expected amount %s, got %s", tc.input.amount, loanRequest.Amount)
 			}
 
 			if loanRequest.DailyInterestRate != tc.input.dialyInterestRate {
@@ -191,20 +338,28 @@ func TestAcceptLoanRequest(t *testing.T) {
 	mockLoanRequest := &LoanRequest{
 		ID:                1,
 		UserID:            1,
-		Amount:            100,
+		AccountID:         1,
+		Amount:            money.MustParse("100"),
 		DailyInterestRate: 5,
 	}
 	mockUser := &user.User{
-		ID:             1,
-		Name:           "yusuf",
-		Email:          "ym@gmail",
-		AccountBalance: 0,
+		ID:    1,
+		Name:  "yusuf",
+		Email: "ym@gmail",
+	}
+	mockAccount := &account.Account{
+		ID:       1,
+		UserID:   1,
+		Number:   "1000000009",
+		Currency: "USD",
+		Status:   account.StatusActive,
+		Balance:  money.MustParse("0"),
 	}
 
 	tests := []struct {
 		name             string
 		setupRepo        func(*MockRepo)
-		setupUserService func(*MockUserService)
+		setupAccountSvc  func(*MockAccountService)
 		setupLoanService func(*MockLoanService)
 		input            struct {
 			loanRequestID, userID int64
@@ -216,14 +371,14 @@ func TestAcceptLoanRequest(t *testing.T) {
 			name: "valid",
 			setupRepo: func(r *MockRepo) {
 				r.GetResult = mockLoanRequest
-				r.UpdateTxResult = &LoanRequest{
+				r.AcceptTxResult = &LoanRequest{
 					ID: mockLoanRequest.ID, UserID: mockLoanRequest.UserID,
 					Amount: mockLoanRequest.Amount, Status: "ACCEPTED",
 					DailyInterestRate: mockLoanRequest.DailyInterestRate,
 				}
 			},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountResult = mockAccount
 			},
 			setupLoanService: func(ls *MockLoanService) {},
 			input: struct {
@@ -236,14 +391,14 @@ func TestAcceptLoanRequest(t *testing.T) {
 			name: "loan request already responded to",
 			setupRepo: func(r *MockRepo) {
 				r.GetResult = mockLoanRequest
-				r.UpdateTxResult = &LoanRequest{
+				r.AcceptTxResult = &LoanRequest{
 					ID: mockLoanRequest.ID, UserID: mockLoanRequest.UserID,
 					Amount: mockLoanRequest.Amount, Status: "ACCEPTED",
 					DailyInterestRate: mockLoanRequest.DailyInterestRate,
 				}
 			},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountResult = mockAccount
 			},
 			setupLoanService: func(ls *MockLoanService) {},
 			input: struct {
@@ -258,7 +413,7 @@ func TestAcceptLoanRequest(t *testing.T) {
 			setupRepo: func(r *MockRepo) {
 				r.GetErr = errors.New("db error")
 			},
-			setupUserService: func(us *MockUserService) {},
+			setupAccountSvc:  func(as *MockAccountService) {},
 			setupLoanService: func(ls *MockLoanService) {},
 			input: struct {
 				loanRequestID int64
@@ -267,17 +422,17 @@ func TestAcceptLoanRequest(t *testing.T) {
 			expectedErr: errors.New("db error"),
 		},
 		{
-			name: "Get user failure",
+			name: "Get account failure",
 			setupRepo: func(r *MockRepo) {
 				r.GetResult = mockLoanRequest
-				r.UpdateTxResult = &LoanRequest{
+				r.AcceptTxResult = &LoanRequest{
 					ID: mockLoanRequest.ID, UserID: mockLoanRequest.UserID,
 					Amount: mockLoanRequest.Amount, Status: "ACCEPTED",
 					DailyInterestRate: mockLoanRequest.DailyInterestRate,
 				}
 			},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserErr = user.ErrNoRecord
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountErr = user.ErrNoRecord
 			},
 			setupLoanService: func(ls *MockLoanService) {},
 			input: struct {
@@ -288,34 +443,31 @@ func TestAcceptLoanRequest(t *testing.T) {
 			loanRequestOriginalStatus: "PENDING",
 		},
 		{
-			name: "update user failure",
+			name: "account frozen before the payout",
 			setupRepo: func(r *MockRepo) {
 				r.GetResult = mockLoanRequest
-				r.UpdateTxResult = &LoanRequest{
-					ID: mockLoanRequest.ID, UserID: mockLoanRequest.UserID,
-					Amount: mockLoanRequest.Amount, Status: "ACCEPTED",
-					DailyInterestRate: mockLoanRequest.DailyInterestRate,
-				}
+				r.AcceptTxErr = ledger.ErrAccountInactive
 			},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
-				us.UpdateUserErr = errors.New("db error")
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountResult = mockAccount
 			},
 			setupLoanService: func(ls *MockLoanService) {},
 			input: struct {
 				loanRequestID int64
 				userID        int64
 			}{loanRequestID: mockLoanRequest.ID, userID: mockUser.ID},
-			expectedErr:               errors.New("db error"),
 			loanRequestOriginalStatus: "PENDING",
+			expectedErr:               validator.ErrFailedValidation,
 		},
 		{
-			name: "update loan failure",
+			name: "accept failure",
 			setupRepo: func(r *MockRepo) {
 				r.GetResult = mockLoanRequest
-				r.UpdateTxErr = errors.New("db error")
+				r.AcceptTxErr = errors.New("db error")
+			},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountResult = mockAccount
 			},
-			setupUserService: func(us *MockUserService) {},
 			setupLoanService: func(ls *MockLoanService) {},
 			input: struct {
 				loanRequestID int64
@@ -325,20 +477,15 @@ func TestAcceptLoanRequest(t *testing.T) {
 			expectedErr:               errors.New("db error"),
 		},
 		{
-			name: "GetLoan failure",
+			name: "NewLoan failure",
 			setupRepo: func(r *MockRepo) {
 				r.GetResult = mockLoanRequest
-				r.UpdateTxResult = &LoanRequest{
-					ID: mockLoanRequest.ID, UserID: mockLoanRequest.UserID,
-					Amount: mockLoanRequest.Amount, Status: "ACCEPTED",
-					DailyInterestRate: mockLoanRequest.DailyInterestRate,
-				}
 			},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountResult = mockAccount
 			},
 			setupLoanService: func(ls *MockLoanService) {
-				ls.GetLoanErr = errors.New("db error")
+				ls.NewLoanErr = errors.New("db error")
 			},
 			input: struct {
 				loanRequestID int64
@@ -352,25 +499,34 @@ func TestAcceptLoanRequest(t *testing.T) {
 	for _, tc := range tests {
 		mockLoanRequest.Status = tc.loanRequestOriginalStatus
 		t.Run(tc.name, func(t *testing.T) {
-			repo := &MockRepo{}
-			userSvc := &MockUserService{}
+			repo := &MockRepo{Account: mockAccount}
+			accountSvc := &MockAccountService{}
 			loanSvc := &MockLoanService{}
 			tc.setupRepo(repo)
-			tc.setupUserService(userSvc)
+			tc.setupAccountSvc(accountSvc)
 			tc.setupLoanService(loanSvc)
 
+			notifier := &MockNotifier{}
 			svc := Service{
-				Repo:        repo,
-				UserService: userSvc,
-				LoanService: loanSvc,
+				Repo:           repo,
+				AccountService: accountSvc,
+				LoanService:    loanSvc,
+				Notifier:       notifier,
 			}
 
-			loanRequest, gotErr := svc.AcceptLoanRequest(tc.input.loanRequestID, tc.input.userID)
+			v := validator.New()
+			loanRequest, gotErr := svc.AcceptLoanRequest(
+				context.Background(), v, tc.input.loanRequestID, tc.input.userID,
+			)
 
 			if tc.expectedErr != nil {
 				if gotErr.Error() != tc.expectedErr.Error() {
 					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
 				}
+				// a failed validation has to say what failed
+				if errors.Is(gotErr, validator.ErrFailedValidation) && v.IsValid() {
+					t.Errorf("expected validation errors, got none")
+				}
 				return
 			} else if gotErr != nil {
 				t.Fatalf("unexpected error :%v", gotErr)
@@ -380,8 +536,8 @@ func TestAcceptLoanRequest(t *testing.T) {
 				t.Errorf("expected user id %d, got %d", mockUser.ID, loanRequest.UserID)
 			}
 
-			if loanRequest.Amount != mockLoanRequest.Amount {
-				t.Errorf("expected amount %f, got %f", mockLoanRequest.Amount, loanRequest.Amount)
+			if loanRequest.Amount.Cmp(mockLoanRequest.Amount) != 0 {
+				t.Errorf("expected amount %s, got %s", mockLoanRequest.Amount, loanRequest.Amount)
 			}
 
 			if loanRequest.DailyInterestRate != mockLoanRequest.DailyInterestRate {
@@ -394,12 +550,14 @@ func TestAcceptLoanRequest(t *testing.T) {
 			if loanRequest.Status != "ACCEPTED" {
 				t.Errorf("expected status %s, got %s", "ACCEPTED", loanRequest.Status)
 			}
+			if len(notifier.Events) != 1 || notifier.Events[0] != notification.EventLoanAccepted {
+				t.Errorf("expected the borrower notified, got %v", notifier.Events)
+			}
 
-			// check if the money is getting added to the users account
-			if mockUser.AccountBalance != loanRequest.Amount {
+			// check if the money is getting added to the account
+			if mockAccount.Balance.Cmp(loanRequest.Amount) != 0 {
 				t.Errorf(
-					"expected user account balance %f, got %f",
-					loanRequest.Amount, mockUser.AccountBalance,
+					"expected account balance %s, got %s", loanRequest.Amount, mockAccount.Balance,
 				)
 			}
 		})
@@ -410,7 +568,7 @@ func TestDeclineLoanRequest(t *testing.T) {
 	mockLoanRequest := &LoanRequest{
 		ID:                1,
 		UserID:            1,
-		Amount:            100,
+		Amount:            money.MustParse("100"),
 		DailyInterestRate: 5,
 		Status:            "PENDING",
 	}
@@ -419,6 +577,7 @@ func TestDeclineLoanRequest(t *testing.T) {
 		name        string
 		setupRepo   func(*MockRepo)
 		input       struct{ loanRequestID, userID int64 }
+		reason      string
 		expectedErr error
 	}{
 		{
@@ -433,6 +592,28 @@ func TestDeclineLoanRequest(t *testing.T) {
 				loanRequestID int64
 				userID        int64
 			}{loanRequestID: 1, userID: 1},
+			reason: "income too low",
+		},
+		{
+			name:      "reason too long",
+			setupRepo: func(r *MockRepo) {},
+			input: struct {
+				loanRequestID int64
+				userID        int64
+			}{loanRequestID: 1, userID: 1},
+			reason:      strings.Repeat("a", MaxDeclineReasonLength+1),
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "already responded to",
+			setupRepo: func(r *MockRepo) {
+				r.UpdateTxErr = ErrNotPending
+			},
+			input: struct {
+				loanRequestID int64
+				userID        int64
+			}{loanRequestID: 1, userID: 1},
+			expectedErr: ErrNotPending,
 		},
 		{
 			name: "update failure",
@@ -452,10 +633,14 @@ func TestDeclineLoanRequest(t *testing.T) {
 			repo := &MockRepo{}
 			tc.setupRepo(repo)
 
+			notifier := &MockNotifier{}
 			svc := Service{
-				Repo: repo,
+				Repo:     repo,
+				Notifier: notifier,
 			}
-			loanRequest, gotErr := svc.DeclineLoanRequest(tc.input.loanRequestID, tc.input.userID)
+			loanRequest, gotErr := svc.DeclineLoanRequest(
+				context.Background(), validator.New(), tc.input.loanRequestID, tc.input.userID, tc.reason,
+			)
 			if tc.expectedErr != nil {
 				if gotErr.Error() != tc.expectedErr.Error() {
 					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
@@ -476,6 +661,138 @@ func TestDeclineLoanRequest(t *testing.T) {
 			if loanRequest.Status != "DECLINED" {
 				t.Errorf("expected status %s, got %s", "ACCEPTED", loanRequest.Status)
 			}
+			if len(notifier.Events) != 1 || notifier.Events[0] != notification.EventLoanDeclined {
+				t.Errorf("expected the borrower notified, got %v", notifier.Events)
+			}
+		})
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		status      string
+		filters     filter.Filters
+		wantCount   int
+		expectedErr error
+	}{
+		{
+			name: "valid",
+			setupRepo: func(r *MockRepo) {
+				r.GetAllResult = []*LoanRequest{{ID: 1}, {ID: 2}}
+			},
+			status:    StatusPending,
+			filters:   filter.Filters{Page: 1, PageSize: 20, Sort: "-created_at"},
+			wantCount: 2,
+		},
+		{
+			name: "any status",
+			setupRepo: func(r *MockRepo) {
+				r.GetAllResult = []*LoanRequest{{ID: 1}}
+			},
+			filters:   filter.Filters{Page: 1, PageSize: 20, Sort: "-created_at"},
+			wantCount: 1,
+		},
+		{
+			name:        "unknown status",
+			setupRepo:   func(r *MockRepo) {},
+			status:      "LOST",
+			filters:     filter.Filters{Page: 1, PageSize: 20, Sort: "-created_at"},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:        "invalid page",
+			setupRepo:   func(r *MockRepo) {},
+			filters:     filter.Filters{Page: 0, PageSize: 20, Sort: "-created_at"},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "repo failure",
+			setupRepo: func(r *MockRepo) {
+				r.GetAllErr = errors.New("db error")
+			},
+			filters:     filter.Filters{Page: 1, PageSize: 20, Sort: "-created_at"},
+			expectedErr: errors.New("db error"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			tc.filters.SortSafelist = SortSafelist
+
+			svc := Service{Repo: repo}
+			loanRequests, _, gotErr := svc.GetAll(
+				context.Background(), validator.New(), 0, tc.status, tc.filters,
+			)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error :%v", gotErr)
+			}
+
+			if len(loanRequests) != tc.wantCount {
+				t.Errorf("expected %d loan requests, got %d", tc.wantCount, len(loanRequests))
+			}
+		})
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		expectedErr error
+	}{
+		{
+			name: "valid",
+			setupRepo: func(r *MockRepo) {
+				r.UpdateTxResult = &LoanRequest{ID: 1, UserID: 1, Status: StatusWithdrawn}
+			},
+		},
+		{
+			name: "not pending",
+			setupRepo: func(r *MockRepo) {
+				r.UpdateTxErr = ErrNotPending
+			},
+			expectedErr: ErrNotPending,
+		},
+		{
+			name: "not the user's",
+			setupRepo: func(r *MockRepo) {
+				r.UpdateTxErr = user.ErrNoRecord
+			},
+			expectedErr: user.ErrNoRecord,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+
+			svc := Service{Repo: repo}
+			loanRequest, gotErr := svc.Withdraw(context.Background(), 1, 1)
+			if repo.UpdateTxStatus != StatusWithdrawn {
+				t.Errorf("expected status %s, got %s", StatusWithdrawn, repo.UpdateTxStatus)
+			}
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error :%v", gotErr)
+			}
+
+			if loanRequest.Status != StatusWithdrawn {
+				t.Errorf("expected status %s, got %s", StatusWithdrawn, loanRequest.Status)
+			}
 		})
 	}
 }
@@ -0,0 +1,74 @@
+package lockout
+
+import (
+	"time"
+)
+
+// what failed logins are counted against. an account is counted by the email that was tried, so
+// emails without an account get locked the same way and lockouts don't give away who has one
+const (
+	KindAccount = "account"
+	KindIP      = "ip"
+)
+
+// SortSafelist is what lockouts can be listed by
+var SortSafelist = []string{"last_failure_at", "-last_failure_at", "failures", "-failures"}
+
+// Policy decides how failed logins slow down and lock out further attempts. after every failure
+// the next attempt has to wait BaseDelay, doubling with each failure up to MaxDelay. once there
+// have been MaxFailures, or IPMaxFailures for an IP, nothing is let through for Duration. failures
+// older than Duration are forgotten
+type Policy struct {
+	MaxFailures   int
+	IPMaxFailures int
+	Duration      time.Duration
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+}
+
+// Entry is the failed logins counted against an account or an IP
+type Entry struct {
+	Kind          string     `json:"kind"`
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// maxFailures returns how many failures lock the kind of entry out
+func (p Policy) maxFailures(kind string) int {
+	if kind == KindIP {
+		return p.IPMaxFailures
+	}
+	return p.MaxFailures
+}
+
+// delay returns how long to wait after the number of failures
+func (p Policy) delay(failures int) time.Duration {
+	if failures <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// RetryAfter returns how long until the next login attempt is let through, 0 if it is now
+func RetryAfter(entry *Entry, policy Policy, now time.Time) time.Duration {
+	if entry == nil {
+		return 0
+	}
+	if entry.LockedUntil != nil && entry.LockedUntil.After(now) {
+		return entry.LockedUntil.Sub(now)
+	}
+	// failures this old are forgotten at the next one, they don't hold anything up either
+	if now.Sub(entry.LastFailureAt) >= policy.Duration {
+		return 0
+	}
+
+	next := entry.LastFailureAt.Add(policy.delay(entry.Failures))
+	return max(next.Sub(now), 0)
+}
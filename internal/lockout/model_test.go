@@ -0,0 +1,69 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+	policy := Policy{
+		MaxFailures:   5,
+		IPMaxFailures: 20,
+		Duration:      15 * time.Minute,
+		BaseDelay:     time.Second,
+		MaxDelay:      30 * time.Second,
+	}
+	lockedUntil := now.Add(10 * time.Minute)
+
+	tests := []struct {
+		name  string
+		entry *Entry
+		want  time.Duration
+	}{
+		{
+			name:  "no failures",
+			entry: nil,
+			want:  0,
+		},
+		{
+			name:  "first failure",
+			entry: &Entry{Failures: 1, LastFailureAt: now},
+			want:  time.Second,
+		},
+		{
+			name:  "back off doubles",
+			entry: &Entry{Failures: 4, LastFailureAt: now},
+			want:  8 * time.Second,
+		},
+		{
+			name:  "back off is capped",
+			entry: &Entry{Failures: 10, LastFailureAt: now},
+			want:  30 * time.Second,
+		},
+		{
+			name:  "waited long enough",
+			entry: &Entry{Failures: 2, LastFailureAt: now.Add(-3 * time.Second)},
+			want:  0,
+		},
+		{
+			name:  "locked",
+			entry: &Entry{Failures: 5, LastFailureAt: now, LockedUntil: &lockedUntil},
+			want:  10 * time.Minute,
+		},
+		{
+			name:  "failures forgotten",
+			entry: &Entry{Failures: 4, LastFailureAt: now.Add(-time.Hour)},
+			want:  0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := RetryAfter(tc.entry, policy, now)
+			if got != tc.want {
+				t.Errorf("expected retry after %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Get returns the entry for the key, nil if nothing failed for it
+func (r *Repository) Get(ctx context.Context, kind, key string) (*Entry, error) {
+	query := `
+		SELECT kind, key, failures, last_failure_at, locked_until
+		FROM login_failures
+		WHERE kind = $1
+		AND key = $2
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var entry Entry
+	err := r.DB.QueryRowContext(ctx, query, kind, key).Scan(
+		&entry.Kind,
+		&entry.Key,
+		&entry.Failures,
+		&entry.LastFailureAt,
+		&entry.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// RecordFailure counts a failed login against the key and returns the entry. failures from before
+// forget are dropped first, so the count starts again. the key is locked until lockUntil if the
+// count reaches maxFailures, and locked is run in the same transaction if it is given
+func (r *Repository) RecordFailure(
+	ctx context.Context, kind, key string, forget time.Time, maxFailures int, lockUntil time.Time,
+	locked database.TxFunc,
+) (*Entry, error) {
+	query := `
+		INSERT INTO login_failures (kind, key, failures, last_failure_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (kind, key) DO UPDATE
+		SET failures = CASE
+				WHEN login_failures.last_failure_at < $3 THEN 1
+				ELSE login_failures.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING kind, key, failures, last_failure_at, locked_until
+	`
+	lockQuery := `
+		UPDATE login_failures
+		SET locked_until = $3
+		WHERE kind = $1
+		AND key = $2
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var entry Entry
+	err = tx.QueryRowContext(ctx, query, kind, key, forget).Scan(
+		&entry.Kind,
+		&entry.Key,
+		&entry.Failures,
+		&entry.LastFailureAt,
+		&entry.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Failures >= maxFailures {
+		_, err = tx.ExecContext(ctx, lockQuery, kind, key, lockUntil)
+		if err != nil {
+			return nil, err
+		}
+		entry.LockedUntil = &lockUntil
+	}
+
+	// only the failure that locked the key runs it, later ones extend a lockout already told about
+	if entry.Failures == maxFailures && locked != nil {
+		err = locked(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &entry, tx.Commit()
+}
+
+// Delete clears the failures counted against the key, which lifts any lockout, and runs cleared in
+// the same transaction if it is given and there were any. false is returned if there were none
+func (r *Repository) Delete(
+	ctx context.Context, kind, key string, cleared database.TxFunc,
+) (bool, error) {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx, "DELETE FROM login_failures WHERE kind = $1 AND key = $2", kind, key,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		return false, err
+	}
+
+	if cleared != nil {
+		err = cleared(ctx, tx)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetAll returns a page of the entries with failures since the time given, or still locked
+func (r *Repository) GetAll(
+	ctx context.Context, since time.Time, f filter.Filters,
+) ([]*Entry, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), kind, key, failures, last_failure_at, locked_until
+		FROM login_failures
+		WHERE last_failure_at >= $1
+		OR locked_until > NOW()
+		ORDER BY %s %s, kind ASC, key ASC
+		LIMIT $2 OFFSET $3
+	`, f.SortColumn(), f.SortDirection())
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, since, f.Limit(), f.Offset())
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*Entry{}
+	for rows.Next() {
+		var entry Entry
+		err = rows.Scan(
+			&totalRecords,
+			&entry.Kind,
+			&entry.Key,
+			&entry.Failures,
+			&entry.LastFailureAt,
+			&entry.LockedUntil,
+		)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return entries, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
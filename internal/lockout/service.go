@@ -0,0 +1,155 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/lockout")
+
+var ErrLocked = errors.New("too many failed login attempts")
+
+type Repo interface {
+	Get(ctx context.Context, kind, key string) (*Entry, error)
+	RecordFailure(
+		ctx context.Context, kind, key string, forget time.Time, maxFailures int, lockUntil time.Time,
+		locked database.TxFunc,
+	) (*Entry, error)
+	Delete(ctx context.Context, kind, key string, cleared database.TxFunc) (bool, error)
+	GetAll(ctx context.Context, since time.Time, f filter.Filters) ([]*Entry, filter.Metadata, error)
+}
+
+// Auditor records who lifted a lockout, in the transaction that lifts it
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
+}
+
+type Service struct {
+	Repo    Repo
+	Policy  Policy
+	Auditor Auditor // optional
+}
+
+// accountKey is what failures are counted against for an email, so that the way it is typed
+// doesn't matter
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check returns ErrLocked, along with how long to wait, if a login with the email from the IP has
+// to wait for earlier failures from either of them
+func (s *Service) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	ctx, span := tracer.Start(ctx, "lockout.Check")
+	defer span.End()
+
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, key := range [][2]string{{KindAccount, accountKey(email)}, {KindIP, ip}} {
+		entry, err := s.Repo.Get(ctx, key[0], key[1])
+		if err != nil {
+			return 0, err
+		}
+		retryAfter = max(retryAfter, RetryAfter(entry, s.Policy, now))
+	}
+
+	if retryAfter > 0 {
+		return retryAfter, ErrLocked
+	}
+	return 0, nil
+}
+
+// Fail counts a failed login with the email from the IP. locked is true if this failure locked the
+// account, which is when the user should be told about it. onLock, if given, is run in the
+// transaction that locks the account, so the user is told about every lockout and only those
+func (s *Service) Fail(
+	ctx context.Context, email, ip string, onLock database.TxFunc,
+) (locked bool, err error) {
+	ctx, span := tracer.Start(ctx, "lockout.Fail")
+	defer span.End()
+
+	now := time.Now()
+	forget := now.Add(-s.Policy.Duration)
+	lockUntil := now.Add(s.Policy.Duration)
+
+	entry, err := s.Repo.RecordFailure(
+		ctx, KindAccount, accountKey(email), forget, s.Policy.MaxFailures, lockUntil, onLock,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.Repo.RecordFailure(
+		ctx, KindIP, ip, forget, s.Policy.IPMaxFailures, lockUntil, nil,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return entry.Failures == s.Policy.MaxFailures, nil
+}
+
+// Reset clears the failures of the account with the email, after a successful login or when the
+// user follows the unlock link they were emailed. the failures of IPs are kept, one right guess
+// doesn't make up for guessing at other accounts
+func (s *Service) Reset(ctx context.Context, email string) error {
+	ctx, span := tracer.Start(ctx, "lockout.Reset")
+	defer span.End()
+
+	_, err := s.Repo.Delete(ctx, KindAccount, accountKey(email), nil)
+	return err
+}
+
+// Clear lifts the lockout of an account or an IP, for admins. false is returned if there was
+// nothing to clear
+func (s *Service) Clear(
+	ctx context.Context, v *validator.Validator, kind, key string,
+) (bool, error) {
+	ctx, span := tracer.Start(ctx, "lockout.Clear")
+	defer span.End()
+
+	v.CheckAddError(validator.ValueInList(kind, KindAccount, KindIP), "kind", "invalid")
+	v.CheckAddError(key != "", "key", "must be given")
+	if !v.IsValid() {
+		return false, validator.ErrFailedValidation
+	}
+
+	if kind == KindAccount {
+		key = accountKey(key)
+	}
+
+	var cleared database.TxFunc
+	if s.Auditor != nil {
+		cleared = func(ctx context.Context, tx *sql.Tx) error {
+			return s.Auditor.RecordTx(
+				ctx, tx, audit.ActionLockoutCleared, audit.TargetLockout, kind+":"+key,
+				map[string]any{"kind": kind, "key": key}, nil,
+			)
+		}
+	}
+	return s.Repo.Delete(ctx, kind, key, cleared)
+}
+
+// GetAll returns a page of the accounts and IPs with recent failures or a lockout, for admins
+func (s *Service) GetAll(
+	ctx context.Context, v *validator.Validator, f filter.Filters,
+) ([]*Entry, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "lockout.GetAll")
+	defer span.End()
+
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetAll(ctx, time.Now().Add(-s.Policy.Duration), f)
+}
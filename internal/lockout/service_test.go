@@ -0,0 +1,116 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+)
+
+// ---MOCKS---
+
+// MockRepo keeps the entries in memory, counting failures the way the database does
+type MockRepo struct {
+	entries map[[2]string]*Entry
+}
+
+func (r *MockRepo) Get(ctx context.Context, kind, key string) (*Entry, error) {
+	return r.entries[[2]string{kind, key}], nil
+}
+
+func (r *MockRepo) RecordFailure(
+	ctx context.Context, kind, key string, forget time.Time, maxFailures int, lockUntil time.Time,
+	locked database.TxFunc,
+) (*Entry, error) {
+	entry, ok := r.entries[[2]string{kind, key}]
+	if !ok || entry.LastFailureAt.Before(forget) {
+		entry = &Entry{Kind: kind, Key: key}
+		r.entries[[2]string{kind, key}] = entry
+	}
+	entry.Failures++
+	entry.LastFailureAt = time.Now()
+	if entry.Failures >= maxFailures {
+		entry.LockedUntil = &lockUntil
+	}
+	if entry.Failures == maxFailures && locked != nil {
+		err := locked(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+func (r *MockRepo) Delete(
+	ctx context.Context, kind, key string, cleared database.TxFunc,
+) (bool, error) {
+	_, ok := r.entries[[2]string{kind, key}]
+	delete(r.entries, [2]string{kind, key})
+	if ok && cleared != nil {
+		err := cleared(ctx, nil)
+		if err != nil {
+			return false, err
+		}
+	}
+	return ok, nil
+}
+
+func (r *MockRepo) GetAll(
+	ctx context.Context, since time.Time, f filter.Filters,
+) ([]*Entry, filter.Metadata, error) {
+	return nil, filter.Metadata{}, nil
+}
+
+func TestFailAndCheck(t *testing.T) {
+	repo := &MockRepo{entries: map[[2]string]*Entry{}}
+	svc := &Service{
+		Repo: repo,
+		Policy: Policy{
+			MaxFailures:   3,
+			IPMaxFailures: 10,
+			Duration:      15 * time.Minute,
+		},
+	}
+
+	told := 0
+	onLock := func(ctx context.Context, tx *sql.Tx) error {
+		told++
+		return nil
+	}
+	for i := 1; i <= 4; i++ {
+		locked, err := svc.Fail(context.Background(), "Y@gmail.com", "10.0.0.1", onLock)
+		if err != nil {
+			t.Fatalf("Fail: unexpected error %v", err)
+		}
+		if locked != (i == 3) {
+			t.Errorf("failure %d: expected locked=%v, got %v", i, i == 3, locked)
+		}
+	}
+	if told != 1 {
+		t.Errorf("expected the user told of the lockout once, got %d", told)
+	}
+
+	// the email is matched however it is typed
+	retryAfter, err := svc.Check(context.Background(), " y@GMAIL.com", "10.0.0.2")
+	if !errors.Is(err, ErrLocked) || retryAfter <= 0 {
+		t.Fatalf("expected error %v with a wait, got %v, %v", ErrLocked, err, retryAfter)
+	}
+
+	// the IP isn't locked yet, other accounts can still be tried from it
+	_, err = svc.Check(context.Background(), "m@gmail.com", "10.0.0.1")
+	if err != nil {
+		t.Errorf("expected other accounts let through, got %v", err)
+	}
+
+	if err = svc.Reset(context.Background(), "y@gmail.com"); err != nil {
+		t.Fatalf("Reset: unexpected error %v", err)
+	}
+	_, err = svc.Check(context.Background(), "y@gmail.com", "10.0.0.2")
+	if err != nil {
+		t.Errorf("expected the account unlocked, got %v", err)
+	}
+}
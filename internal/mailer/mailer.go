@@ -2,6 +2,7 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"os"
 	"strconv"
@@ -60,29 +61,38 @@ func New(host string, port int, username, password, sender string) *Mailer {
 	}
 }
 
-func (mailer *Mailer) Send(recipient, templateFile string, data map[string]any) error {
+// Render fills in the subject and the plain text and HTML bodies of the template with data
+func Render(
+	templateFile string, data map[string]any,
+) (subject, plainBody, htmlBody string, err error) {
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
-		return err
+		return "", "", "", err
 	}
 
-	// render subject template
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
-	if err != nil {
-		return err
+	rendered := make([]string, 3)
+	for i, name := range []string{"subject", "plainBody", "htmlBody"} {
+		buf := new(bytes.Buffer)
+		err = tmpl.ExecuteTemplate(buf, name, data)
+		if err != nil {
+			return "", "", "", err
+		}
+		rendered[i] = buf.String()
 	}
 
-	// render plain text template
-	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
-	if err != nil {
+	return rendered[0], rendered[1], rendered[2], nil
+}
+
+// Send emails the template to the recipient. sending can't be stopped once it started, ctx being
+// done only stops it from starting
+func (mailer *Mailer) Send(
+	ctx context.Context, recipient, templateFile string, data map[string]any,
+) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	// render HTML body template
-	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	subject, plainBody, htmlBody, err := Render(templateFile, data)
 	if err != nil {
 		return err
 	}
@@ -91,9 +101,9 @@ func (mailer *Mailer) Send(recipient, templateFile string, data map[string]any)
 	msg := mail.NewMessage()
 	msg.SetHeader("To", recipient)
 	msg.SetHeader("From", mailer.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
+	msg.SetHeader("Subject", subject)
+	msg.SetBody("text/plain", plainBody)
+	msg.AddAlternative("text/html", htmlBody)
 
 	// send the email
 	return mailer.dialer.DialAndSend(msg)
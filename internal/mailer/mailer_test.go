@@ -2,8 +2,8 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"fmt"
 	"testing"
 
 	"github.com/go-mail/mail/v2"
@@ -31,6 +31,7 @@ func TestMailerSend(t *testing.T) {
 		templateFile    string
 		recipient       string
 		data            map[string]any
+		wantSubject     string
 		wantErr         bool
 	}{
 		{
@@ -41,8 +42,60 @@ func TestMailerSend(t *testing.T) {
 			templateFile: "user_welcome.html",
 			recipient:    "yusuf",
 			data:         map[string]any{"userName": "yusuf", "userID": 1, "token": "mock-token"},
+			wantSubject:  "Hi yusuf, ",
 			wantErr:      false,
 		},
+		{
+			name: "password reset",
+			setupFakeDialer: func(f *fakeDialer) {
+				f.sent = []*mail.Message{}
+			},
+			templateFile: "password_reset.html",
+			recipient:    "yusuf",
+			data:         map[string]any{"userName": "yusuf", "token": "mock-token"},
+			wantSubject:  "Reset your password",
+			wantErr:      false,
+		},
+		{
+			name: "account locked",
+			setupFakeDialer: func(f *fakeDialer) {
+				f.sent = []*mail.Message{}
+			},
+			templateFile: "account_locked.html",
+			recipient:    "yusuf",
+			data: map[string]any{
+				"userName": "yusuf", "token": "mock-token", "lockedUntil": "12:00 UTC",
+			},
+			wantSubject: "Your account has been locked",
+			wantErr:     false,
+		},
+		{
+			name: "loan declined",
+			setupFakeDialer: func(f *fakeDialer) {
+				f.sent = []*mail.Message{}
+			},
+			templateFile: "loan_declined.html",
+			recipient:    "yusuf",
+			data: map[string]any{
+				"userName": "yusuf", "amount": "100.00", "currency": "USD", "reason": "income too low",
+			},
+			wantSubject: "Your loan request was declined",
+			wantErr:     false,
+		},
+		{
+			name: "transfer received",
+			setupFakeDialer: func(f *fakeDialer) {
+				f.sent = []*mail.Message{}
+			},
+			templateFile: "transfer_received.html",
+			recipient:    "yusuf",
+			data: map[string]any{
+				"userName": "yusuf", "amount": "100.00", "currency": "USD",
+				"accountNumber": "1000000009",
+			},
+			wantSubject: "You received 100.00 USD",
+			wantErr:     false,
+		},
 		{
 			name: "missing templateFile",
 			setupFakeDialer: func(f *fakeDialer) {
@@ -60,7 +113,7 @@ func TestMailerSend(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.setupFakeDialer(fake)
-			gotErr := m.Send(tc.recipient, tc.templateFile, tc.data)
+			gotErr := m.Send(context.Background(), tc.recipient, tc.templateFile, tc.data)
 			if (gotErr != nil) != tc.wantErr {
 				t.Fatalf("expecetd error=%v, got %v", tc.wantErr, gotErr)
 			}
@@ -79,10 +132,9 @@ func TestMailerSend(t *testing.T) {
 				t.Fatalf("wrong recipient: %v", msg.GetHeader("To")[0])
 			}
 
-			expectedSubject := fmt.Sprintf("Hi %s, ", tc.recipient)
-			if msg.GetHeader("Subject")[0] != expectedSubject {
+			if msg.GetHeader("Subject")[0] != tc.wantSubject {
 				t.Fatalf(
-					"expected subject '%s', got '%s'", expectedSubject, msg.GetHeader("Subject")[0],
+					"expected subject '%s', got '%s'", tc.wantSubject, msg.GetHeader("Subject")[0],
 				)
 			}
 			buf := new(bytes.Buffer)
@@ -97,3 +149,29 @@ func TestMailerSend(t *testing.T) {
 		})
 	}
 }
+
+// every notification has to render with the data it is sent with
+func TestNotificationTemplates(t *testing.T) {
+	data := map[string]any{
+		"userName": "yusuf", "amount": "100.00", "currency": "USD", "accountNumber": "1000000009",
+		"toAccountNumber": "1000000017", "remainingAmount": "50.00", "paidOff": false, "reason": "",
+	}
+
+	fake := &fakeDialer{}
+	m := &Mailer{dialer: fake, sender: "me@example.com"}
+	for _, file := range []string{
+		"loan_accepted.html", "loan_declined.html", "transfer_received.html", "transfer_sent.html",
+		"deposit.html", "withdrawal.html", "loan_payment.html", "loan_forgiven.html",
+	} {
+		t.Run(file, func(t *testing.T) {
+			err := m.Send(context.Background(), "yusuf", file, data)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+
+	if len(fake.sent) != 8 {
+		t.Errorf("expected 8 emails sent, got %d", len(fake.sent))
+	}
+}
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the name of every metric
+const namespace = "gobank"
+
+// the kinds of money movement counted in gobank_money_moved_total
+const (
+	KindDeposit     = "deposit"
+	KindWithdrawal  = "withdrawal"
+	KindTransfer    = "transfer"
+	KindLoanPayout  = "loan_payout"
+	KindLoanPayment = "loan_payment"
+)
+
+// Metrics are what the service exposes to Prometheus. a nil *Metrics records nothing, so code
+// handed none, tests mostly, doesn't have to check for it
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	rateLimited prometheus.Counter
+
+	emails   *prometheus.CounterVec
+	webhooks *prometheus.CounterVec
+
+	transfers     prometheus.Counter
+	loanDecisions *prometheus.CounterVec
+	moneyMoved    *prometheus.CounterVec
+}
+
+// New returns the metrics of a service using db, registered along with the stats of its pool and
+// of the Go runtime and the process
+func New(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "How long HTTP requests took to handle, by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limit_rejections_total",
+			Help:      "Requests turned away by the rate limiter.",
+		}),
+
+		emails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "emails_total",
+			Help:      "Attempts at sending queued emails, by result (sent or failed).",
+		}, []string{"result"}),
+		webhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_deliveries_total",
+			Help:      "Attempts at webhook deliveries, by result (delivered or failed).",
+		}, []string{"result"}),
+
+		transfers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transfers_total",
+			Help:      "Transfers between accounts.",
+		}),
+		loanDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "loan_decisions_total",
+			Help:      "Loan requests responded to, by status (ACCEPTED or DECLINED).",
+		}, []string{"status"}),
+		moneyMoved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "money_moved_total",
+			Help:      "Money moved, in major units, by kind of movement and currency.",
+		}, []string{"kind", "currency"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.duration, m.rateLimited, m.emails, m.webhooks, m.transfers,
+		m.loanDecisions, m.moneyMoved,
+		collectors.NewDBStatsCollector(db, "postgres"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest counts a request to the route, the pattern it matched rather than its path so
+// IDs in paths don't make a series each
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.duration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// RateLimited counts a request the rate limiter turned away
+func (m *Metrics) RateLimited() {
+	if m == nil {
+		return
+	}
+
+	m.rateLimited.Inc()
+}
+
+// EmailsSent counts a batch of queued emails a worker tried to send
+func (m *Metrics) EmailsSent(sent, failed int) {
+	if m == nil {
+		return
+	}
+
+	m.emails.WithLabelValues("sent").Add(float64(sent))
+	m.emails.WithLabelValues("failed").Add(float64(failed))
+}
+
+// WebhooksDelivered counts a batch of deliveries a worker tried to send
+func (m *Metrics) WebhooksDelivered(delivered, failed int) {
+	if m == nil {
+		return
+	}
+
+	m.webhooks.WithLabelValues("delivered").Add(float64(delivered))
+	m.webhooks.WithLabelValues("failed").Add(float64(failed))
+}
+
+// Transfer counts a transfer between accounts and the money it moved
+func (m *Metrics) Transfer(amount money.Amount) {
+	if m == nil {
+		return
+	}
+
+	m.transfers.Inc()
+	m.MoneyMoved(KindTransfer, amount)
+}
+
+// LoanDecision counts a loan request being responded to. an accepted loan is paid out, so it's
+// counted as money moved too
+func (m *Metrics) LoanDecision(status string, amount money.Amount) {
+	if m == nil {
+		return
+	}
+
+	m.loanDecisions.WithLabelValues(status).Inc()
+	if status == "ACCEPTED" {
+		m.MoneyMoved(KindLoanPayout, amount)
+	}
+}
+
+// MoneyMoved adds the amount to the money moved by kind, one of the Kind constants
+func (m *Metrics) MoneyMoved(kind string, amount money.Amount) {
+	if m == nil {
+		return
+	}
+
+	// the counter is a float, exact amounts are in the ledger
+	major := float64(amount.Units()) / 100
+	m.moneyMoved.WithLabelValues(kind, string(amount.Currency())).Add(major)
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	_ "github.com/lib/pq"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	body, err := io.ReadAll(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	amount := money.New(100, money.DefaultCurrency)
+
+	// none of these should panic
+	m.ObserveRequest(http.MethodGet, "/v1/healthcheck", http.StatusOK, time.Second)
+	m.RateLimited()
+	m.EmailsSent(1, 1)
+	m.WebhooksDelivered(1, 1)
+	m.Transfer(amount)
+	m.LoanDecision("ACCEPTED", amount)
+	m.MoneyMoved(KindDeposit, amount)
+}
+
+func TestMetrics(t *testing.T) {
+	// opening doesn't connect, the pool is only there to report its stats
+	db, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m := New(db)
+	m.ObserveRequest(http.MethodGet, "/v1/webhooks/:id", http.StatusNotFound, time.Millisecond)
+	m.RateLimited()
+	m.RateLimited()
+	m.EmailsSent(3, 1)
+	m.WebhooksDelivered(0, 2)
+	m.Transfer(money.New(1050, money.DefaultCurrency))
+	m.LoanDecision("ACCEPTED", money.New(20000, money.DefaultCurrency))
+	m.LoanDecision("DECLINED", money.New(50000, money.DefaultCurrency))
+	m.MoneyMoved(KindDeposit, money.New(25, money.DefaultCurrency))
+
+	currency := string(money.DefaultCurrency)
+	body := scrape(t, m)
+	for _, want := range []string{
+		`gobank_http_requests_total{method="GET",route="/v1/webhooks/:id",status="404"} 1`,
+		`gobank_http_request_duration_seconds_count{method="GET",route="/v1/webhooks/:id"} 1`,
+		`gobank_rate_limit_rejections_total 2`,
+		`gobank_emails_total{result="sent"} 3`,
+		`gobank_emails_total{result="failed"} 1`,
+		`gobank_webhook_deliveries_total{result="failed"} 2`,
+		`gobank_transfers_total 1`,
+		`gobank_loan_decisions_total{status="ACCEPTED"} 1`,
+		`gobank_loan_decisions_total{status="DECLINED"} 1`,
+		`gobank_money_moved_total{currency="` + currency + `",kind="transfer"} 10.5`,
+		`gobank_money_moved_total{currency="` + currency + `",kind="loan_payout"} 200`,
+		`gobank_money_moved_total{currency="` + currency + `",kind="deposit"} 0.25`,
+		`go_sql_max_open_connections{db_name="postgres"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in the metrics", want)
+		}
+	}
+
+}
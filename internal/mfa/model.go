@@ -0,0 +1,27 @@
+package mfa
+
+import (
+	"strings"
+	"time"
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets when they enrol, each can be used once
+// in place of a code from their authenticator app
+const RecoveryCodeCount = 10
+
+// Enrolment is the TOTP secret of a user. it is only used for signing in once it is confirmed,
+// which is when the user has shown their app generates the right codes
+type Enrolment struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       []byte // encrypted
+	ConfirmedAt  *time.Time
+	LastUsedStep int64 // the time step of the last code accepted, so a code can't be used twice
+}
+
+// normalizeCode removes the spaces and dashes people type codes with and upper cases it
+func normalizeCode(code string) string {
+	code = strings.ReplaceAll(code, " ", "")
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ToUpper(code)
+}
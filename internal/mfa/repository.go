@@ -0,0 +1,170 @@
+package mfa
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert starts the enrolment of the user, replacing one that was never confirmed.
+// ErrAlreadyEnrolled is returned if the user has a confirmed enrolment
+func (r *Repository) Insert(ctx context.Context, enrolment *Enrolment) error {
+	query := `
+		INSERT INTO mfa_enrolments (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+		WHERE mfa_enrolments.confirmed_at IS NULL
+		RETURNING created_at
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	err := r.DB.QueryRowContext(ctx, query, enrolment.UserID, enrolment.Secret).Scan(
+		&enrolment.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrAlreadyEnrolled
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) Get(ctx context.Context, userID int64) (*Enrolment, error) {
+	query := `
+		SELECT user_id, created_at, secret, confirmed_at, last_used_step
+		FROM mfa_enrolments
+		WHERE user_id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var enrolment Enrolment
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(
+		&enrolment.UserID,
+		&enrolment.CreatedAt,
+		&enrolment.Secret,
+		&enrolment.ConfirmedAt,
+		&enrolment.LastUsedStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return &enrolment, nil
+}
+
+// ConfirmTx confirms the enrolment of the user and replaces their recovery codes with the hashes
+// given, in one transaction
+func (r *Repository) ConfirmTx(
+	ctx context.Context, userID, step int64, recoveryCodeHashes [][]byte,
+) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE mfa_enrolments
+		SET confirmed_at = NOW(), last_used_step = $2
+		WHERE user_id = $1
+		AND confirmed_at IS NULL
+	`
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrAlreadyEnrolled
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(
+			ctx, "INSERT INTO mfa_recovery_codes (user_id, hash) VALUES ($1, $2)", userID, hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that a code from the time step was used to sign in. false is returned if a code
+// from that step or a later one was already used, in which case the code has to be refused
+func (r *Repository) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	query := `
+		UPDATE mfa_enrolments
+		SET last_used_step = $2
+		WHERE user_id = $1
+		AND last_used_step < $2
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	result, err := r.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+// UseRecoveryCode marks the recovery code of the user with the hash as used. false is returned if
+// the user has no such code or it was used already
+func (r *Repository) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) (bool, error) {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1
+		AND hash = $2
+		AND used_at IS NULL
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	result, err := r.DB.ExecContext(ctx, query, userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
@@ -0,0 +1,100 @@
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+var (
+	ErrNoKey         = errors.New("mfa encryption key must be 32 bytes")
+	ErrBadCiphertext = errors.New("mfa secret could not be decrypted")
+)
+
+// recovery codes are written down by people, so their alphabet leaves out 0, O, 1 and I. it has
+// 32 letters, so a random byte picks one without bias
+const (
+	recoveryAlphabet  = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	recoveryCodeChars = 10
+)
+
+// encrypt seals the plaintext with AES-256-GCM, the random nonce is put in front of the result
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// decrypt opens what encrypt sealed
+func decrypt(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrBadCiphertext
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrBadCiphertext
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrNoKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// generateRecoveryCodes returns n random recovery codes, formatted as two groups of five
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for range n {
+		randomBytes := make([]byte, recoveryCodeChars)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := make([]byte, 0, recoveryCodeChars+1)
+		for i, b := range randomBytes {
+			if i == recoveryCodeChars/2 {
+				code = append(code, '-')
+			}
+			code = append(code, recoveryAlphabet[int(b)%len(recoveryAlphabet)])
+		}
+		codes = append(codes, string(code))
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode returns the sha256 hash recovery codes are stored by, codes are random enough
+// that a fast hash is fine, same as tokens
+func hashRecoveryCode(code string) []byte {
+	hash := sha256.Sum256([]byte(normalizeCode(code)))
+	return hash[:]
+}
@@ -0,0 +1,180 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/mfa")
+
+var ErrAlreadyEnrolled = errors.New("two-factor authentication is already enabled")
+
+type Repo interface {
+	Insert(ctx context.Context, enrolment *Enrolment) error
+	Get(ctx context.Context, userID int64) (*Enrolment, error)
+	ConfirmTx(ctx context.Context, userID, step int64, recoveryCodeHashes [][]byte) error
+	UseStep(ctx context.Context, userID, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int64, hash []byte) (bool, error)
+}
+
+type Service struct {
+	Repo   Repo
+	Key    []byte // the AES-256 key secrets are encrypted with
+	Issuer string // the name authenticator apps show the codes under
+}
+
+// Enrol starts TOTP enrolment for the user. the secret and the URI to show as a QR code are
+// returned, the enrolment isn't used until it is confirmed with a code from the app
+func (s *Service) Enrol(ctx context.Context, u *user.User) (secret, uri string, err error) {
+	ctx, span := tracer.Start(ctx, "mfa.Enrol")
+	defer span.End()
+
+	secret, err = GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	encrypted, err := encrypt(s.Key, []byte(secret))
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.Repo.Insert(ctx, &Enrolment{UserID: u.ID, Secret: encrypted})
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, ProvisioningURI(s.Issuer, u.Email, secret), nil
+}
+
+// Confirm finishes the enrolment of the user with a code from their app, which shows it was set
+// up right. the recovery codes are returned in plaintext, this is the only time they are shown
+func (s *Service) Confirm(
+	ctx context.Context, v *validator.Validator, userID int64, code string,
+) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "mfa.Confirm")
+	defer span.End()
+
+	enrolment, err := s.Repo.Get(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			v.AddError("code", "two-factor authentication enrolment has not been started")
+			return nil, validator.ErrFailedValidation
+
+		default:
+			return nil, err
+		}
+	}
+	if enrolment.ConfirmedAt != nil {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	matchedStep, ok, err := s.validateTOTP(ctx, enrolment, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		v.AddError("code", "invalid code")
+		return nil, validator.ErrFailedValidation
+	}
+
+	recoveryCodes, err := generateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([][]byte, 0, len(recoveryCodes))
+	for _, recoveryCode := range recoveryCodes {
+		hashes = append(hashes, hashRecoveryCode(recoveryCode))
+	}
+
+	err = s.Repo.ConfirmTx(ctx, userID, matchedStep, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// IsEnrolled reports whether the user has to give a code to sign in
+func (s *Service) IsEnrolled(ctx context.Context, userID int64) (bool, error) {
+	ctx, span := tracer.Start(ctx, "mfa.IsEnrolled")
+	defer span.End()
+
+	enrolment, err := s.Repo.Get(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return enrolment.ConfirmedAt != nil, nil
+}
+
+// Verify checks the code the user gave to sign in, either one from their app or one of their
+// recovery codes. each code is only accepted once
+func (s *Service) Verify(
+	ctx context.Context, v *validator.Validator, userID int64, code string,
+) error {
+	ctx, span := tracer.Start(ctx, "mfa.Verify")
+	defer span.End()
+
+	v.CheckAddError(code != "", "code", "must be given")
+	if !v.IsValid() {
+		return validator.ErrFailedValidation
+	}
+
+	enrolment, err := s.Repo.Get(ctx, userID)
+	if err != nil && !errors.Is(err, user.ErrNoRecord) {
+		return err
+	}
+	if enrolment == nil || enrolment.ConfirmedAt == nil {
+		v.AddError("code", "two-factor authentication is not enabled")
+		return validator.ErrFailedValidation
+	}
+
+	code = normalizeCode(code)
+	ok := false
+	if len(code) == digits {
+		var matchedStep int64
+		matchedStep, ok, err = s.validateTOTP(ctx, enrolment, code)
+		if err != nil {
+			return err
+		}
+		if ok {
+			ok, err = s.Repo.UseStep(ctx, userID, matchedStep)
+		}
+	} else {
+		ok, err = s.Repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	}
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		v.AddError("code", "invalid code")
+		return validator.ErrFailedValidation
+	}
+
+	return nil
+}
+
+// validateTOTP decrypts the secret of the enrolment and checks the code against it
+func (s *Service) validateTOTP(
+	ctx context.Context, enrolment *Enrolment, code string,
+) (int64, bool, error) {
+	secret, err := decrypt(s.Key, enrolment.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return Validate(string(secret), normalizeCode(code), time.Now())
+}
@@ -0,0 +1,162 @@
+package mfa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// ---MOCKS---
+
+type MockRepo struct {
+	GetResult *Enrolment
+	GetErr    error
+
+	UseStepResult bool
+
+	UseRecoveryCodeResult bool
+	UsedRecoveryCodeHash  []byte
+
+	ConfirmedStep   int64
+	ConfirmedHashes [][]byte
+}
+
+func (r *MockRepo) Insert(ctx context.Context, enrolment *Enrolment) error {
+	r.GetResult = enrolment
+	return nil
+}
+
+func (r *MockRepo) Get(ctx context.Context, userID int64) (*Enrolment, error) {
+	return r.GetResult, r.GetErr
+}
+
+func (r *MockRepo) ConfirmTx(
+	ctx context.Context, userID, step int64, recoveryCodeHashes [][]byte,
+) error {
+	r.ConfirmedStep = step
+	r.ConfirmedHashes = recoveryCodeHashes
+	return nil
+}
+
+func (r *MockRepo) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	return r.UseStepResult, nil
+}
+
+func (r *MockRepo) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) (bool, error) {
+	r.UsedRecoveryCodeHash = hash
+	return r.UseRecoveryCodeResult, nil
+}
+
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+func TestEnrolAndConfirm(t *testing.T) {
+	repo := &MockRepo{}
+	svc := &Service{Repo: repo, Key: testKey, Issuer: "goBank"}
+
+	secret, _, err := svc.Enrol(context.Background(), &user.User{ID: 1, Email: "y@gmail.com"})
+	if err != nil {
+		t.Fatalf("Enrol: unexpected error %v", err)
+	}
+	if bytes.Contains(repo.GetResult.Secret, []byte(secret)) {
+		t.Fatal("expected the secret to be stored encrypted")
+	}
+
+	v := validator.New()
+	_, err = svc.Confirm(context.Background(), v, 1, "000000")
+	if !errors.Is(err, validator.ErrFailedValidation) {
+		t.Fatalf("expected error %v for a wrong code, got %v", validator.ErrFailedValidation, err)
+	}
+
+	code, _ := Code(secret, time.Now())
+	recoveryCodes, err := svc.Confirm(context.Background(), validator.New(), 1, code)
+	if err != nil {
+		t.Fatalf("Confirm: unexpected error %v", err)
+	}
+	if len(recoveryCodes) != RecoveryCodeCount || len(repo.ConfirmedHashes) != RecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", RecoveryCodeCount, len(recoveryCodes))
+	}
+	if !bytes.Equal(repo.ConfirmedHashes[0], hashRecoveryCode(recoveryCodes[0])) {
+		t.Error("expected the recovery codes to be stored hashed")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, _ := GenerateSecret()
+	encrypted, _ := encrypt(testKey, []byte(secret))
+	now := time.Now()
+	code, _ := Code(secret, now)
+
+	tests := []struct {
+		name        string
+		code        string
+		setupRepo   func(*MockRepo)
+		expectedErr error
+	}{
+		{
+			name: "valid code",
+			code: code,
+			setupRepo: func(r *MockRepo) {
+				r.UseStepResult = true
+			},
+		},
+		{
+			name:        "code used already",
+			code:        code,
+			setupRepo:   func(r *MockRepo) {},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "recovery code",
+			code: "abcde-fghjk",
+			setupRepo: func(r *MockRepo) {
+				r.UseRecoveryCodeResult = true
+			},
+		},
+		{
+			name:        "unknown recovery code",
+			code:        "ABCDE-FGHJK",
+			setupRepo:   func(r *MockRepo) {},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "not enrolled",
+			code: code,
+			setupRepo: func(r *MockRepo) {
+				r.GetResult = nil
+				r.GetErr = user.ErrNoRecord
+			},
+			expectedErr: validator.ErrFailedValidation,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{
+				GetResult: &Enrolment{UserID: 1, Secret: encrypted, ConfirmedAt: &now},
+			}
+			tc.setupRepo(repo)
+			svc := &Service{Repo: repo, Key: testKey}
+
+			err := svc.Verify(context.Background(), validator.New(), 1, tc.code)
+			if tc.expectedErr != nil {
+				if err == nil || err.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected err %v, got %v", tc.expectedErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			// recovery codes are matched however they were typed
+			if repo.UsedRecoveryCodeHash != nil &&
+				!bytes.Equal(repo.UsedRecoveryCodeHash, hashRecoveryCode("ABCDEFGHJK")) {
+				t.Error("expected the recovery code to be normalized before it is looked up")
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// the TOTP parameters, the defaults of RFC 6238 and the only ones every authenticator app supports
+const (
+	period = 30
+	digits = 6
+	// how many steps either side of now a code is accepted for, to allow for clock drift
+	skew = 1
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random 160 bit secret, base32 encoded as authenticator apps expect
+func GenerateSecret() (string, error) {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32NoPadding.EncodeToString(randomBytes), nil
+}
+
+// step returns the time step t falls in
+func step(t time.Time) int64 {
+	return t.Unix() / period
+}
+
+// codeAt returns the code for the time step, as worked out in RFC 4226
+func codeAt(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, the low 4 bits of the last byte pick where the 31 bit code is read from
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, code%1_000_000), nil
+}
+
+// Code returns the code for the secret at t
+func Code(secret string, t time.Time) (string, error) {
+	return codeAt(secret, step(t))
+}
+
+// Validate checks the code against the secret at t, allowing for a step of clock drift either way.
+// the step the code matched is returned, so the caller can refuse it being used again
+func Validate(secret, code string, t time.Time) (matchedStep int64, ok bool, err error) {
+	now := step(t)
+	for s := now - skew; s <= now+skew; s++ {
+		want, err := codeAt(secret, s)
+		if err != nil {
+			return 0, false, err
+		}
+		if hmac.Equal([]byte(want), []byte(code)) {
+			return s, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// ProvisioningURI returns the otpauth:// URI authenticator apps read from a QR code
+func ProvisioningURI(issuer, accountName, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(digits))
+	values.Set("period", fmt.Sprint(period))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + accountName,
+		RawQuery: values.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,106 @@
+package mfa
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 test vectors of RFC 6238, cut to 6 digits, the secret is "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tc := range tests {
+		got, err := Code(rfcSecret, time.Unix(tc.unix, 0))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got != tc.want {
+			t.Errorf("at %d: expected code %s, got %s", tc.unix, tc.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := Code(rfcSecret, now)
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		code   string
+		wantOK bool
+	}{
+		{name: "same step", at: now, code: code, wantOK: true},
+		{name: "one step of drift", at: now.Add(period * time.Second), code: code, wantOK: true},
+		{name: "too old", at: now.Add(2 * period * time.Second), code: code, wantOK: false},
+		{name: "wrong code", at: now, code: "000000", wantOK: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			matchedStep, ok, err := Validate(rfcSecret, tc.code, tc.at)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if ok != tc.wantOK {
+				t.Fatalf("expected ok=%v, got %v", tc.wantOK, ok)
+			}
+			if ok && matchedStep != step(now) {
+				t.Errorf("expected step %d matched, got %d", step(now), matchedStep)
+			}
+		})
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("goBank", "y@gmail.com", rfcSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/goBank:y@gmail.com?") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+rfcSecret) {
+		t.Errorf("expected the secret in the uri, got %s", uri)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+
+	ciphertext, err := encrypt(key, []byte(rfcSecret))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if bytes.Contains(ciphertext, []byte(rfcSecret)) {
+		t.Fatal("expected the secret to be encrypted")
+	}
+
+	plaintext, err := decrypt(key, ciphertext)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if string(plaintext) != rfcSecret {
+		t.Errorf("expected %s back, got %s", rfcSecret, plaintext)
+	}
+
+	_, err = decrypt(bytes.Repeat([]byte{8}, 32), ciphertext)
+	if err != ErrBadCiphertext {
+		t.Errorf("expected error %v with the wrong key, got %v", ErrBadCiphertext, err)
+	}
+
+	_, err = encrypt([]byte("short"), []byte(rfcSecret))
+	if err != ErrNoKey {
+		t.Errorf("expected error %v with a short key, got %v", ErrNoKey, err)
+	}
+}
@@ -0,0 +1,344 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount    = errors.New("must be a number with at most 2 decimal places")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrOverflow         = errors.New("amount out of range")
+)
+
+// Currency is the ISO 4217 code of the currency an amount is in
+type Currency string
+
+// DefaultCurrency is used for amounts read from the database or from requests, neither of which
+// carries a currency of its own
+const DefaultCurrency Currency = "USD"
+
+// minorPerMajor is the number of minor units in a major unit, the amount columns on the database
+// are DECIMAL(12, 2) so we keep the same precision
+const minorPerMajor = 100
+
+// RoundingMode decides what happens to the fraction of a minor unit left over by a calculation
+type RoundingMode int8
+
+const (
+	// RoundHalfEven rounds to the nearest minor unit, ties go to the even one (banker's rounding)
+	RoundHalfEven RoundingMode = iota
+	// RoundHalfUp rounds to the nearest minor unit, ties go away from zero
+	RoundHalfUp
+	// RoundDown drops the fraction, rounding towards zero
+	RoundDown
+	// RoundUp rounds away from zero whenever there is a fraction
+	RoundUp
+)
+
+// Amount is an exact amount of money held as a whole number of minor units (cents). the zero value
+// is 0 with no currency, and takes on the currency of whatever it is added to. arithmetic keeps
+// amounts within ±math.MaxInt64 units, so negating one is always exact
+type Amount struct {
+	units    int64
+	currency Currency
+}
+
+// New returns an amount of the given minor units
+func New(units int64, currency Currency) Amount {
+	return Amount{units: units, currency: currency}
+}
+
+// Parse reads a decimal string such as "12.5" or "-3.25". it never rounds, more than 2 decimal
+// places is an error
+func Parse(s string, currency Currency) (Amount, error) {
+	s = strings.TrimSpace(s)
+
+	negative := false
+	if rest, found := strings.CutPrefix(s, "-"); found {
+		negative = true
+		s = rest
+	}
+
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	if (whole == "" && fraction == "") || (hasPoint && fraction == "") {
+		return Amount{}, ErrInvalidAmount
+	}
+	if !isDigits(whole) || !isDigits(fraction) {
+		return Amount{}, ErrInvalidAmount
+	}
+
+	// trailing zeros don't add precision, so "1.500" is as good as "1.50"
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > 2 {
+		return Amount{}, ErrInvalidAmount
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	if whole == "" {
+		whole = "0"
+	}
+	minor, _ := strconv.ParseInt(fraction, 10, 64)
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || major > (math.MaxInt64-minor)/minorPerMajor {
+		return Amount{}, ErrOverflow
+	}
+
+	units := major*minorPerMajor + minor
+	if negative {
+		units = -units
+	}
+
+	return Amount{units: units, currency: currency}, nil
+}
+
+// MustParse is like Parse but panics on error, it is for amounts known at compile time
+func MustParse(s string) Amount {
+	a, err := Parse(s, DefaultCurrency)
+	if err != nil {
+		panic(fmt.Sprintf("money: cannot parse %q: %v", s, err))
+	}
+
+	return a
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Units returns the amount in minor units
+func (a Amount) Units() int64 {
+	return a.units
+}
+
+func (a Amount) Currency() Currency {
+	return a.currency
+}
+
+// WithCurrency returns the same amount in the given currency, it does not convert anything
+func (a Amount) WithCurrency(currency Currency) Amount {
+	a.currency = currency
+	return a
+}
+
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+func (a Amount) IsPositive() bool {
+	return a.units > 0
+}
+
+func (a Amount) IsNegative() bool {
+	return a.units < 0
+}
+
+// String formats the amount as a plain decimal, e.g. "-12.05"
+func (a Amount) String() string {
+	sign := ""
+	abs := uint64(a.units)
+	if a.units < 0 {
+		sign = "-"
+		abs = uint64(-(a.units + 1)) + 1
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, abs/minorPerMajor, abs%minorPerMajor)
+}
+
+// sameCurrency returns the currency both amounts share, an empty currency matches any other
+func sameCurrency(a, b Amount) (Currency, error) {
+	switch {
+	case a.currency == b.currency || b.currency == "":
+		return a.currency, nil
+	case a.currency == "":
+		return b.currency, nil
+	default:
+		return "", ErrCurrencyMismatch
+	}
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+	currency, err := sameCurrency(a, b)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	sum := a.units + b.units
+	// signed overflow wraps around, which flips the sign away from that of both operands
+	if (a.units > 0 && b.units > 0 && sum < 0) || (a.units < 0 && b.units < 0 && sum >= 0) {
+		return Amount{}, ErrOverflow
+	}
+	if sum == math.MinInt64 {
+		return Amount{}, ErrOverflow
+	}
+
+	return Amount{units: sum, currency: currency}, nil
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+	return a.Add(b.Neg())
+}
+
+func (a Amount) Neg() Amount {
+	return Amount{units: -a.units, currency: a.currency}
+}
+
+// Cmp returns -1, 0 or +1 depending on whether a is less than, equal to or greater than b. amounts
+// in different currencies can't be compared, so doing so is a programming error and panics
+func (a Amount) Cmp(b Amount) int {
+	if _, err := sameCurrency(a, b); err != nil {
+		panic(fmt.Sprintf("money: comparing %s with %s", a.currency, b.currency))
+	}
+
+	switch {
+	case a.units < b.units:
+		return -1
+	case a.units > b.units:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (a Amount) LessThan(b Amount) bool {
+	return a.Cmp(b) < 0
+}
+
+func Min(a, b Amount) Amount {
+	if b.LessThan(a) {
+		return b
+	}
+
+	return a
+}
+
+func Max(a, b Amount) Amount {
+	if a.LessThan(b) {
+		return b
+	}
+
+	return a
+}
+
+// Mul multiplies the amount by an exact factor, rounding the result to a whole minor unit with the
+// given mode
+func (a Amount) Mul(factor *big.Rat, mode RoundingMode) (Amount, error) {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(a.units), factor)
+
+	units, err := roundRat(product, mode)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	return Amount{units: units, currency: a.currency}, nil
+}
+
+// Rate returns percent as an exact fraction, e.g. Rate(5) is 5/100. it goes through the shortest
+// decimal form of percent, so 0.1 is exactly 1/1000 and not the nearest float64
+func Rate(percent float64) *big.Rat {
+	rate, _ := new(big.Rat).SetString(strconv.FormatFloat(percent, 'f', -1, 64))
+	return rate.Quo(rate, big.NewRat(100, 1))
+}
+
+func roundRat(x *big.Rat, mode RoundingMode) (int64, error) {
+	quotient, remainder := new(big.Int).QuoRem(x.Num(), x.Denom(), new(big.Int))
+
+	if remainder.Sign() != 0 {
+		// compare twice the remainder with the denominator to tell where the fraction falls
+		// relative to a half
+		half := new(big.Int).Abs(remainder)
+		half.Lsh(half, 1)
+		cmp := half.Cmp(x.Denom())
+
+		step := big.NewInt(int64(x.Sign()))
+		roundAway := false
+		switch mode {
+		case RoundUp:
+			roundAway = true
+		case RoundHalfUp:
+			roundAway = cmp >= 0
+		case RoundHalfEven:
+			roundAway = cmp > 0 || (cmp == 0 && quotient.Bit(0) == 1)
+		}
+
+		if roundAway {
+			quotient.Add(quotient, step)
+		}
+	}
+
+	if !quotient.IsInt64() || quotient.Int64() == math.MinInt64 {
+		return 0, ErrOverflow
+	}
+
+	return quotient.Int64(), nil
+}
+
+// MarshalJSON writes the amount as a JSON number, formatted exactly rather than through a float
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts the amount as a JSON number or a string holding one
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	parsed, err := Parse(s, DefaultCurrency)
+	if err != nil {
+		return fmt.Errorf("amount %w", err)
+	}
+
+	*a = parsed
+	return nil
+}
+
+// Value lets amounts be used as query arguments, they are sent as decimal strings so the database
+// never sees a float
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan lets amounts be read from DECIMAL columns
+func (a *Amount) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*a = Amount{currency: DefaultCurrency}
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', 2, 64)
+	default:
+		return fmt.Errorf("money: cannot scan %T into Amount", src)
+	}
+
+	parsed, err := Parse(s, DefaultCurrency)
+	if err != nil {
+		return fmt.Errorf("money: cannot scan %q: %w", s, err)
+	}
+
+	*a = parsed
+	return nil
+}
@@ -0,0 +1,247 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantUnits   int64
+		expectedErr error
+	}{
+		{name: "whole", input: "12", wantUnits: 1200},
+		{name: "one decimal", input: "12.5", wantUnits: 1250},
+		{name: "two decimals", input: "0.05", wantUnits: 5},
+		{name: "negative", input: "-3.25", wantUnits: -325},
+		{name: "leading point", input: ".5", wantUnits: 50},
+		{name: "trailing zeros", input: "1.500", wantUnits: 150},
+		{name: "too precise", input: "1.005", expectedErr: ErrInvalidAmount},
+		{name: "exponent", input: "1e3", expectedErr: ErrInvalidAmount},
+		{name: "empty", input: "", expectedErr: ErrInvalidAmount},
+		{name: "dangling point", input: "1.", expectedErr: ErrInvalidAmount},
+		{name: "too large", input: "100000000000000000000", expectedErr: ErrOverflow},
+		{name: "just too large", input: "92233720368547758.08", expectedErr: ErrOverflow},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, gotErr := Parse(tc.input, DefaultCurrency)
+			if !errors.Is(gotErr, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+			}
+			if gotErr != nil {
+				return
+			}
+
+			if got.Units() != tc.wantUnits {
+				t.Errorf("expected units=%d, got units=%d", tc.wantUnits, got.Units())
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{amount: New(1205, DefaultCurrency), want: "12.05"},
+		{amount: New(-5, DefaultCurrency), want: "-0.05"},
+		{amount: New(0, DefaultCurrency), want: "0.00"},
+		{amount: New(math.MinInt64, DefaultCurrency), want: "-92233720368547758.08"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.want, func(t *testing.T) {
+			if got := tc.amount.String(); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        Amount
+		wantUnits   int64
+		expectedErr error
+	}{
+		{
+			name:      "same currency",
+			a:         New(100, "USD"),
+			b:         New(250, "USD"),
+			wantUnits: 350,
+		},
+		{
+			name:      "zero value adopts currency",
+			a:         Amount{},
+			b:         New(250, "USD"),
+			wantUnits: 250,
+		},
+		{
+			name:        "different currency",
+			a:           New(100, "USD"),
+			b:           New(100, "EUR"),
+			expectedErr: ErrCurrencyMismatch,
+		},
+		{
+			name:        "overflow",
+			a:           New(math.MaxInt64, "USD"),
+			b:           New(1, "USD"),
+			expectedErr: ErrOverflow,
+		},
+		{
+			name:        "negative overflow",
+			a:           New(-math.MaxInt64, "USD"),
+			b:           New(-1, "USD"),
+			expectedErr: ErrOverflow,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, gotErr := tc.a.Add(tc.b)
+			if !errors.Is(gotErr, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+			}
+			if gotErr != nil {
+				return
+			}
+
+			if got.Units() != tc.wantUnits {
+				t.Errorf("expected units=%d, got units=%d", tc.wantUnits, got.Units())
+			}
+			if got.Currency() != "USD" {
+				t.Errorf("expected currency USD, got %s", got.Currency())
+			}
+		})
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		name      string
+		units     int64
+		factor    *big.Rat
+		mode      RoundingMode
+		wantUnits int64
+	}{
+		{
+			name: "exact", units: 1000, factor: Rate(5), mode: RoundHalfEven,
+			wantUnits: 50,
+		},
+		{
+			name: "half even down", units: 25, factor: big.NewRat(1, 10), mode: RoundHalfEven,
+			wantUnits: 2,
+		},
+		{
+			name: "half even up", units: 35, factor: big.NewRat(1, 10), mode: RoundHalfEven,
+			wantUnits: 4,
+		},
+		{
+			name: "half up", units: 25, factor: big.NewRat(1, 10), mode: RoundHalfUp,
+			wantUnits: 3,
+		},
+		{
+			name: "down", units: 29, factor: big.NewRat(1, 10), mode: RoundDown,
+			wantUnits: 2,
+		},
+		{
+			name: "up", units: 21, factor: big.NewRat(1, 10), mode: RoundUp,
+			wantUnits: 3,
+		},
+		{
+			name: "negative half up", units: -25, factor: big.NewRat(1, 10), mode: RoundHalfUp,
+			wantUnits: -3,
+		},
+		{
+			name: "negative down", units: -29, factor: big.NewRat(1, 10), mode: RoundDown,
+			wantUnits: -2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := New(tc.units, DefaultCurrency).Mul(tc.factor, tc.mode)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got.Units() != tc.wantUnits {
+				t.Errorf("expected units=%d, got units=%d", tc.wantUnits, got.Units())
+			}
+		})
+	}
+}
+
+func TestRate(t *testing.T) {
+	if got, want := Rate(0.1), big.NewRat(1, 1000); got.Cmp(want) != 0 {
+		t.Fatalf("expected rate %s, got %s", want, got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var input struct {
+		Amount Amount `json:"amount"`
+	}
+
+	err := json.Unmarshal([]byte(`{"amount": 10.1}`), &input)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if input.Amount.Units() != 1010 {
+		t.Fatalf("expected units=1010, got units=%d", input.Amount.Units())
+	}
+
+	err = json.Unmarshal([]byte(`{"amount": "10.25"}`), &input)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if input.Amount.Units() != 1025 {
+		t.Fatalf("expected units=1025, got units=%d", input.Amount.Units())
+	}
+
+	err = json.Unmarshal([]byte(`{"amount": 10.251}`), &input)
+	if err == nil {
+		t.Fatal("expected error for too precise amount")
+	}
+
+	out, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if string(out) != `{"amount":10.25}` {
+		t.Fatalf("expected %s, got %s", `{"amount":10.25}`, out)
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       any
+		wantUnits int64
+	}{
+		{name: "bytes", src: []byte("12.34"), wantUnits: 1234},
+		{name: "string", src: "0", wantUnits: 0},
+		{name: "int", src: int64(7), wantUnits: 700},
+		{name: "null", src: nil, wantUnits: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var a Amount
+			if err := a.Scan(tc.src); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if a.Units() != tc.wantUnits {
+				t.Errorf("expected units=%d, got units=%d", tc.wantUnits, a.Units())
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package notification
+
+import (
+	"regexp"
+
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// the events users are notified about. every event is sent unless the user turned it off, on the
+// channels it is routed to
+const (
+	EventLoanAccepted     = "LOAN_ACCEPTED"
+	EventLoanDeclined     = "LOAN_DECLINED"
+	EventTransferReceived = "TRANSFER_RECEIVED"
+	EventTransferSent     = "TRANSFER_SENT"
+	EventDeposit          = "DEPOSIT"
+	EventWithdrawal       = "WITHDRAWAL"
+	EventLoanPayment      = "LOAN_PAYMENT"
+	EventLoanForgiven     = "LOAN_FORGIVEN"
+)
+
+var Events = []string{
+	EventLoanAccepted, EventLoanDeclined, EventTransferReceived, EventTransferSent, EventDeposit,
+	EventWithdrawal, EventLoanPayment, EventLoanForgiven,
+}
+
+// Templates are the templates of each event, every one of them has a subject and a plain and an
+// HTML body. the subject is what is texted and the plain body is what is shown in the app
+var Templates = map[string]string{
+	EventLoanAccepted:     "loan_accepted.html",
+	EventLoanDeclined:     "loan_declined.html",
+	EventTransferReceived: "transfer_received.html",
+	EventTransferSent:     "transfer_sent.html",
+	EventDeposit:          "deposit.html",
+	EventWithdrawal:       "withdrawal.html",
+	EventLoanPayment:      "loan_payment.html",
+	EventLoanForgiven:     "loan_forgiven.html",
+}
+
+// Preferences are whether the user wants to be notified of each event, by event
+type Preferences map[string]bool
+
+func ValidatePreferences(v *validator.Validator, preferences Preferences) {
+	v.CheckAddError(len(preferences) > 0, "preferences", "must be given")
+	for event := range preferences {
+		v.CheckAddError(validator.ValueInList(event, Events...), event, "unknown event")
+	}
+}
+
+// PhoneRX matches phone numbers in E.164 format, which is what SMS gateways take, e.g.
+// +254712345678
+var PhoneRX = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// ValidatePhone checks the number texts are sent to, an empty one means the user isn't texted
+func ValidatePhone(v *validator.Validator, phone string) {
+	if phone != "" {
+		v.CheckAddError(
+			validator.Matches(phone, PhoneRX), "phone",
+			"must be in international format, e.g. +254712345678",
+		)
+	}
+}
@@ -0,0 +1,142 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/lib/pq"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Get returns the preferences the user has set, events they never set are left out
+func (r *Repository) Get(ctx context.Context, userID int64) (Preferences, error) {
+	query := `
+		SELECT event, enabled
+		FROM notification_preferences
+		WHERE user_id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	preferences := Preferences{}
+	for rows.Next() {
+		var event string
+		var enabled bool
+		if err = rows.Scan(&event, &enabled); err != nil {
+			return nil, err
+		}
+		preferences[event] = enabled
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return preferences, nil
+}
+
+// Set saves the preferences of the user, leaving the events not in preferences as they were
+func (r *Repository) Set(ctx context.Context, userID int64, preferences Preferences) error {
+	query := `
+		INSERT INTO notification_preferences (user_id, event, enabled)
+		SELECT $1, event, enabled
+		FROM unnest($2::text[], $3::boolean[]) AS p(event, enabled)
+		ON CONFLICT (user_id, event) DO UPDATE
+		SET enabled = EXCLUDED.enabled
+	`
+	events := make([]string, 0, len(preferences))
+	enabled := make([]bool, 0, len(preferences))
+	for event, on := range preferences {
+		events = append(events, event)
+		enabled = append(enabled, on)
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, userID, pq.Array(events), pq.Array(enabled))
+	return err
+}
+
+// Enabled returns whether the user wants to be notified of the event, they do unless they said
+// otherwise
+func (r *Repository) Enabled(ctx context.Context, userID int64, event string) (bool, error) {
+	query := `
+		SELECT COALESCE((
+			SELECT enabled
+			FROM notification_preferences
+			WHERE user_id = $1
+			AND event = $2
+		), TRUE)
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var enabled bool
+	err := r.DB.QueryRowContext(ctx, query, userID, event).Scan(&enabled)
+	if err != nil {
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+// Phone returns the number the user is texted on, or an empty string if they gave none
+func (r *Repository) Phone(ctx context.Context, userID int64) (string, error) {
+	query := `
+		SELECT COALESCE((
+			SELECT phone
+			FROM notification_phones
+			WHERE user_id = $1
+		), '')
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var phone string
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(&phone)
+	if err != nil {
+		return "", err
+	}
+
+	return phone, nil
+}
+
+// SetPhone saves the number the user is texted on, an empty one removes it
+func (r *Repository) SetPhone(ctx context.Context, userID int64, phone string) error {
+	query := `
+		INSERT INTO notification_phones (user_id, phone)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET phone = EXCLUDED.phone
+	`
+	args := []any{userID, phone}
+	if phone == "" {
+		query = `
+			DELETE FROM notification_phones
+			WHERE user_id = $1
+		`
+		args = args[:1]
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, args...)
+	return err
+}
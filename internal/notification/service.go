@@ -0,0 +1,163 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notify"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/notification")
+
+type Repo interface {
+	Get(ctx context.Context, userID int64) (Preferences, error)
+	Set(ctx context.Context, userID int64, preferences Preferences) error
+	Enabled(ctx context.Context, userID int64, event string) (bool, error)
+	Phone(ctx context.Context, userID int64) (string, error)
+	SetPhone(ctx context.Context, userID int64, phone string) error
+}
+
+// Notifier sends a message inside a transaction on the channels its event is routed to
+type Notifier interface {
+	SendTx(ctx context.Context, tx *sql.Tx, msg *notify.Message) error
+}
+
+type UserService interface {
+	GetUser(ctx context.Context, userID int64) (*user.User, error)
+}
+
+type Service struct {
+	Repo        Repo
+	Notifier    Notifier
+	UserService UserService
+}
+
+// Preferences returns whether the user is notified of each event, including those they never set
+func (s *Service) Preferences(ctx context.Context, userID int64) (Preferences, error) {
+	ctx, span := tracer.Start(ctx, "notification.Preferences")
+	defer span.End()
+
+	set, err := s.Repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := Preferences{}
+	for _, event := range Events {
+		enabled, ok := set[event]
+		preferences[event] = enabled || !ok
+	}
+
+	return preferences, nil
+}
+
+// UpdatePreferences turns the events in preferences on or off for the user and, if phone is given,
+// sets the number they are texted on. the preferences can be left out when the phone is given. all
+// of the preferences of the user are returned, with their phone
+func (s *Service) UpdatePreferences(
+	ctx context.Context, v *validator.Validator, userID int64, preferences Preferences, phone *string,
+) (Preferences, string, error) {
+	ctx, span := tracer.Start(ctx, "notification.UpdatePreferences")
+	defer span.End()
+
+	if phone == nil || len(preferences) > 0 {
+		ValidatePreferences(v, preferences)
+	}
+	if phone != nil {
+		ValidatePhone(v, *phone)
+	}
+	if !v.IsValid() {
+		return nil, "", validator.ErrFailedValidation
+	}
+
+	if len(preferences) > 0 {
+		err := s.Repo.Set(ctx, userID, preferences)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if phone != nil {
+		err := s.Repo.SetPhone(ctx, userID, *phone)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	all, err := s.Preferences(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	current, err := s.Repo.Phone(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return all, current, nil
+}
+
+// Phone returns the number the user is texted on, or an empty string if they gave none
+func (s *Service) Phone(ctx context.Context, userID int64) (string, error) {
+	ctx, span := tracer.Start(ctx, "notification.Phone")
+	defer span.End()
+
+	return s.Repo.Phone(ctx, userID)
+}
+
+// SendTx notifies the user of the event inside tx, with their name added to data, unless they
+// turned the event off. the message is returned for the channels that are sent on after tx is
+// committed, nil if the event is off
+func (s *Service) SendTx(
+	ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+) (*notify.Message, error) {
+	ctx, span := tracer.Start(ctx, "notification.SendTx")
+	defer span.End()
+
+	enabled, err := s.Repo.Enabled(ctx, userID, event)
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	u, err := s.UserService.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	phone, err := s.Repo.Phone(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["userName"] = u.Name
+
+	msg := &notify.Message{
+		UserID:   u.ID,
+		Email:    u.Email,
+		Phone:    phone,
+		Event:    event,
+		Template: Templates[event],
+		Data:     data,
+	}
+	err = s.Notifier.SendTx(ctx, tx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// AmountData is the data of every notification about money moving into or out of an account
+func AmountData(amount money.Amount, accountNumber string) map[string]any {
+	return map[string]any{
+		"amount":        amount.String(),
+		"currency":      amount.Currency(),
+		"accountNumber": accountNumber,
+	}
+}
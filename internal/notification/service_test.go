@@ -0,0 +1,262 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/notify"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// ---MOCKS---
+type MockRepo struct {
+	GetResult Preferences
+	GetErr    error
+
+	SetErr error
+
+	EnabledResult bool
+	EnabledErr    error
+
+	PhoneResult string
+	PhoneErr    error
+
+	SetPhoneErr error
+}
+
+func (r *MockRepo) Get(ctx context.Context, userID int64) (Preferences, error) {
+	return r.GetResult, r.GetErr
+}
+
+func (r *MockRepo) Set(ctx context.Context, userID int64, preferences Preferences) error {
+	return r.SetErr
+}
+
+func (r *MockRepo) Enabled(ctx context.Context, userID int64, event string) (bool, error) {
+	return r.EnabledResult, r.EnabledErr
+}
+
+func (r *MockRepo) Phone(ctx context.Context, userID int64) (string, error) {
+	return r.PhoneResult, r.PhoneErr
+}
+
+func (r *MockRepo) SetPhone(ctx context.Context, userID int64, phone string) error {
+	return r.SetPhoneErr
+}
+
+type MockNotifier struct {
+	Msg     *notify.Message
+	SendErr error
+}
+
+func (n *MockNotifier) SendTx(ctx context.Context, tx *sql.Tx, msg *notify.Message) error {
+	n.Msg = msg
+	return n.SendErr
+}
+
+type MockUserService struct {
+	GetUserResult *user.User
+	GetUserErr    error
+}
+
+func (us *MockUserService) GetUser(ctx context.Context, userID int64) (*user.User, error) {
+	return us.GetUserResult, us.GetUserErr
+}
+
+func TestPreferences(t *testing.T) {
+	repo := &MockRepo{GetResult: Preferences{EventDeposit: false, EventWithdrawal: true}}
+	svc := Service{Repo: repo}
+
+	preferences, err := svc.Preferences(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(preferences) != len(Events) {
+		t.Fatalf("expected %d preferences, got %d", len(Events), len(preferences))
+	}
+	// events never set are on
+	for _, event := range Events {
+		want := event != EventDeposit
+		if preferences[event] != want {
+			t.Errorf("expected %s to be %v, got %v", event, want, preferences[event])
+		}
+	}
+}
+
+func TestUpdatePreferences(t *testing.T) {
+	phone, localPhone := "+254712345678", "0712345678"
+
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		preferences Preferences
+		phone       *string
+		expectedErr error
+	}{
+		{
+			name:        "valid",
+			setupRepo:   func(r *MockRepo) {},
+			preferences: Preferences{EventDeposit: false},
+		},
+		{
+			name:      "phone only",
+			setupRepo: func(r *MockRepo) {},
+			phone:     &phone,
+		},
+		{
+			name:      "phone removed",
+			setupRepo: func(r *MockRepo) {},
+			phone:     new(string),
+		},
+		{
+			name:        "phone not international",
+			setupRepo:   func(r *MockRepo) {},
+			preferences: Preferences{EventDeposit: false},
+			phone:       &localPhone,
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "set phone failure",
+			setupRepo: func(r *MockRepo) {
+				r.SetPhoneErr = errors.New("db error")
+			},
+			phone:       &phone,
+			expectedErr: errors.New("db error"),
+		},
+		{
+			name:        "unknown event",
+			setupRepo:   func(r *MockRepo) {},
+			preferences: Preferences{"BIRTHDAY": false},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:        "nothing given",
+			setupRepo:   func(r *MockRepo) {},
+			preferences: Preferences{},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "set failure",
+			setupRepo: func(r *MockRepo) {
+				r.SetErr = errors.New("db error")
+			},
+			preferences: Preferences{EventDeposit: false},
+			expectedErr: errors.New("db error"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			svc := Service{Repo: repo}
+
+			_, _, gotErr := svc.UpdatePreferences(
+				context.Background(), validator.New(), 1, tc.preferences, tc.phone,
+			)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error :%v", gotErr)
+			}
+		})
+	}
+}
+
+func TestSendTx(t *testing.T) {
+	mockUser := &user.User{ID: 1, Name: "yusuf", Email: "y@gmail.com"}
+
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		userErr     error
+		wantSent    bool
+		expectedErr error
+	}{
+		{
+			name: "enabled",
+			setupRepo: func(r *MockRepo) {
+				r.EnabledResult = true
+				r.PhoneResult = "+254712345678"
+			},
+			wantSent: true,
+		},
+		{
+			name:      "turned off",
+			setupRepo: func(r *MockRepo) {},
+		},
+		{
+			name: "preference lookup failure",
+			setupRepo: func(r *MockRepo) {
+				r.EnabledErr = errors.New("db error")
+			},
+			expectedErr: errors.New("db error"),
+		},
+		{
+			name: "user not found",
+			setupRepo: func(r *MockRepo) {
+				r.EnabledResult = true
+			},
+			userErr:     user.ErrNoRecord,
+			expectedErr: user.ErrNoRecord,
+		},
+		{
+			name: "phone lookup failure",
+			setupRepo: func(r *MockRepo) {
+				r.EnabledResult = true
+				r.PhoneErr = errors.New("db error")
+			},
+			expectedErr: errors.New("db error"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			n := &MockNotifier{}
+			svc := Service{
+				Repo:        repo,
+				Notifier:    n,
+				UserService: &MockUserService{GetUserResult: mockUser, GetUserErr: tc.userErr},
+			}
+
+			data := map[string]any{"amount": "10.00"}
+			msg, gotErr := svc.SendTx(context.Background(), nil, mockUser.ID, EventDeposit, data)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error :%v", gotErr)
+			}
+
+			if (n.Msg != nil) != tc.wantSent {
+				t.Fatalf("expected sent=%v, got %+v", tc.wantSent, n.Msg)
+			}
+			if msg != n.Msg {
+				t.Fatalf("expected the message sent returned, got %+v", msg)
+			}
+			if !tc.wantSent {
+				return
+			}
+			if msg.Email != mockUser.Email || msg.Template != Templates[EventDeposit] {
+				t.Errorf("expected %s sent to %s, got %s to %s", Templates[EventDeposit],
+					mockUser.Email, msg.Template, msg.Email)
+			}
+			if msg.UserID != mockUser.ID || msg.Event != EventDeposit || msg.Phone != "+254712345678" {
+				t.Errorf("expected the deposit sent to user 1 with their phone, got %+v", msg)
+			}
+			if msg.Data["userName"] != mockUser.Name {
+				t.Errorf("expected userName %s, got %v", mockUser.Name, msg.Data["userName"])
+			}
+		})
+	}
+}
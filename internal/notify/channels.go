@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+)
+
+// Channel is a way of reaching the user with a notification
+type Channel interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// TxChannel is a channel that writes to the database, so it can send inside the transaction of what
+// the notification is about and there is never one about something that was rolled back
+type TxChannel interface {
+	Channel
+	SendTx(ctx context.Context, tx *sql.Tx, msg *Message) error
+}
+
+type Mailer interface {
+	Send(ctx context.Context, recipient, templateFile string, data map[string]any) error
+}
+
+// Email sends the email template of the event to the user
+type Email struct {
+	Mailer Mailer
+}
+
+func (e *Email) Send(ctx context.Context, msg *Message) error {
+	return e.Mailer.Send(ctx, msg.Email, msg.Template, msg.Data)
+}
+
+// SendTx queues the email in the outbox inside tx, whatever Mailer is
+func (e *Email) SendTx(ctx context.Context, tx *sql.Tx, msg *Message) error {
+	return outbox.InsertTx(ctx, tx, &outbox.Message{
+		Recipient: msg.Email,
+		Template:  msg.Template,
+		Data:      msg.Data,
+	})
+}
+
+// SMS sends the subject of the notification as a text through an HTTP gateway. the gateway is sent
+// a POST of {"from": Sender, "to": phone, "body": subject} with the token as a bearer token, which
+// is what most gateways take or can be put behind. users who gave no phone number are skipped
+type SMS struct {
+	URL    string
+	Token  string
+	Sender string
+	Client *http.Client
+}
+
+// NewSMS returns an SMS channel that gives up on the gateway after 5 seconds, like the mailer does
+// on the SMTP server
+func NewSMS(url, token, sender string) *SMS {
+	return &SMS{
+		URL:    url,
+		Token:  token,
+		Sender: sender,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (s *SMS) Send(ctx context.Context, msg *Message) error {
+	if msg.Phone == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"from": s.Sender,
+		"to":   msg.Phone,
+		"body": msg.Subject,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("sms gateway responded %s", res.Status)
+	}
+
+	return nil
+}
+
+type InAppRepo interface {
+	Insert(ctx context.Context, notification *Notification) error
+}
+
+// InApp keeps the notification for the user to read in the app
+type InApp struct {
+	Repo InAppRepo
+}
+
+func (i *InApp) Send(ctx context.Context, msg *Message) error {
+	return i.Repo.Insert(ctx, inAppNotification(msg))
+}
+
+func (i *InApp) SendTx(ctx context.Context, tx *sql.Tx, msg *Message) error {
+	return InsertTx(ctx, tx, inAppNotification(msg))
+}
+
+func inAppNotification(msg *Message) *Notification {
+	return &Notification{
+		UserID:  msg.UserID,
+		Event:   msg.Event,
+		Subject: msg.Subject,
+		Body:    msg.Body,
+	}
+}
+
+// Log writes every notification as a line of JSON, to a file or stdout, so they can be seen while
+// developing without a mail server or SMS gateway
+type Log struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewLog(out io.Writer) *Log {
+	return &Log{out: out}
+}
+
+func (l *Log) Send(ctx context.Context, msg *Message) error {
+	line, err := json.Marshal(map[string]any{
+		"time":    time.Now().UTC().Format(time.RFC3339),
+		"user_id": msg.UserID,
+		"email":   msg.Email,
+		"phone":   msg.Phone,
+		"event":   msg.Event,
+		"subject": msg.Subject,
+		"body":    msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.out.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSMSSend(t *testing.T) {
+	var got map[string]string
+	var auth string
+	status := http.StatusOK
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer gateway.Close()
+
+	sms := NewSMS(gateway.URL, "secret", "goBank")
+	msg := &Message{Phone: "+254712345678", Subject: "10.00 USD was deposited"}
+
+	err := sms.Send(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got["to"] != msg.Phone || got["body"] != msg.Subject || got["from"] != "goBank" {
+		t.Errorf("expected the subject texted to %s, got %v", msg.Phone, got)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("expected the token as a bearer token, got %q", auth)
+	}
+
+	status = http.StatusBadGateway
+	if err = sms.Send(context.Background(), msg); err == nil {
+		t.Errorf("expected an error when the gateway fails")
+	}
+
+	// users without a phone aren't texted
+	got = nil
+	if err = sms.Send(context.Background(), &Message{Subject: "hi"}); err != nil || got != nil {
+		t.Errorf("expected nothing sent without a phone, got %v, %v", got, err)
+	}
+}
+
+func TestLogSend(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := NewLog(out).Send(
+		context.Background(), &Message{UserID: 1, Event: "DEPOSIT", Subject: "hi", Body: "hello"},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var line map[string]any
+	err = json.Unmarshal(out.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("expected a line of JSON, got %q", out.String())
+	}
+	if line["event"] != "DEPOSIT" || line["subject"] != "hi" || line["body"] != "hello" {
+		t.Errorf("expected the notification written out, got %v", line)
+	}
+}
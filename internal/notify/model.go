@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// the channels a notification can go out on. email and SMS reach the user wherever they are, in-app
+// notifications wait for them in the app and log writes them out for local development
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelInApp = "in_app"
+	ChannelLog   = "log"
+)
+
+var Channels = []string{ChannelEmail, ChannelSMS, ChannelInApp, ChannelLog}
+
+// SortSafelist is what in-app notifications can be listed by
+var SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+// Message is a notification about an event on its way to the user, on every channel the event is
+// routed to. Subject and Body are rendered from the email template of the event for the channels
+// that don't send the template themselves
+type Message struct {
+	UserID   int64
+	Email    string
+	Phone    string // empty if the user gave none, SMS is then skipped
+	Event    string
+	Template string
+	Data     map[string]any
+	Subject  string
+	Body     string
+}
+
+// Notification is an in-app notification, unread until ReadAt is set
+type Notification struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    int64      `json:"-"`
+	Event     string     `json:"event"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// Routes are the channels each event goes out on, by event
+type Routes map[string][]string
+
+// ParseChannels reads a comma separated list of channels, e.g. "email,in_app"
+func ParseChannels(s string) ([]string, error) {
+	channels := []string{}
+	for _, channel := range strings.Split(s, ",") {
+		channel = strings.TrimSpace(channel)
+		if channel == "" {
+			continue
+		}
+		if !validator.ValueInList(channel, Channels...) {
+			return nil, fmt.Errorf("unknown channel %q", channel)
+		}
+		channels = append(channels, channel)
+	}
+
+	return channels, nil
+}
+
+// ParseRoutes reads the channels of each event from a semicolon separated list of routes, e.g.
+// "DEPOSIT=in_app;TRANSFER_RECEIVED=email,sms,in_app". an event with nothing after the = is sent
+// nowhere
+func ParseRoutes(s string) (Routes, error) {
+	routes := Routes{}
+	for _, route := range strings.Split(s, ";") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+
+		event, channels, ok := strings.Cut(route, "=")
+		event = strings.TrimSpace(event)
+		if !ok || event == "" {
+			return nil, fmt.Errorf("invalid route %q, must be EVENT=channel,channel", route)
+		}
+
+		var err error
+		routes[event], err = ParseChannels(channels)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", route, err)
+		}
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Routes
+		wantErr bool
+	}{
+		{name: "none", s: "", want: Routes{}},
+		{
+			name: "several",
+			s:    "DEPOSIT=in_app; TRANSFER_RECEIVED=email, sms,in_app",
+			want: Routes{
+				"DEPOSIT":           {ChannelInApp},
+				"TRANSFER_RECEIVED": {ChannelEmail, ChannelSMS, ChannelInApp},
+			},
+		},
+		{name: "sent nowhere", s: "WITHDRAWAL=", want: Routes{"WITHDRAWAL": {}}},
+		{name: "no channels", s: "DEPOSIT", wantErr: true},
+		{name: "no event", s: "=email", wantErr: true},
+		{name: "unknown channel", s: "DEPOSIT=pigeon", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseRoutes(tc.s)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error=%v, got %v", tc.wantErr, err)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
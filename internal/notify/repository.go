@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// the columns of a notification, in the order scanNotification reads them
+const notificationColumns = `id, created_at, user_id, event, subject, body, read_at`
+
+// scanNotification reads a row of notificationColumns, after any columns selected before them into
+// leading
+func scanNotification(
+	row interface{ Scan(...any) error }, leading ...any,
+) (*Notification, error) {
+	var notification Notification
+	err := row.Scan(append(
+		leading,
+		&notification.ID,
+		&notification.CreatedAt,
+		&notification.UserID,
+		&notification.Event,
+		&notification.Subject,
+		&notification.Body,
+		&notification.ReadAt,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notification, nil
+}
+
+// querier is what inserting a notification needs, so it can be done on its own or inside a
+// transaction
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insert(ctx context.Context, q querier, notification *Notification) error {
+	query := `
+		INSERT INTO notifications (user_id, event, subject, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	args := []any{notification.UserID, notification.Event, notification.Subject, notification.Body}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(
+		&notification.ID,
+		&notification.CreatedAt,
+	)
+}
+
+func (r *Repository) Insert(ctx context.Context, notification *Notification) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	return insert(ctx, r.DB, notification)
+}
+
+// InsertTx is Insert inside a transaction owned by the caller, so the user is never told of what
+// was rolled back
+func InsertTx(ctx context.Context, tx *sql.Tx, notification *Notification) error {
+	return insert(ctx, tx, notification)
+}
+
+// GetAll returns a page of the in-app notifications of the user, only the read or unread ones if
+// read is given
+func (r *Repository) GetAll(
+	ctx context.Context, userID int64, read *bool, f filter.Filters,
+) ([]*Notification, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM notifications
+		WHERE user_id = $1
+		AND ($2::boolean IS NULL OR (read_at IS NOT NULL) = $2)
+		AND ($3::timestamptz IS NULL OR created_at >= $3)
+		AND ($4::timestamptz IS NULL OR created_at < $4)
+		ORDER BY %s %s, id ASC
+		LIMIT $5 OFFSET $6
+	`, notificationColumns, f.SortColumn(), f.SortDirection())
+	args := []any{userID, read, f.From, f.To, f.Limit(), f.Offset()}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	notifications := []*Notification{}
+	for rows.Next() {
+		notification, err := scanNotification(rows, &totalRecords)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		notifications = append(notifications, notification)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return notifications, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+// Unread returns how many of the notifications of the user are unread
+func (r *Repository) Unread(ctx context.Context, userID int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM notifications
+		WHERE user_id = $1
+		AND read_at IS NULL
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var unread int
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(&unread)
+	return unread, err
+}
+
+// SetRead marks the notification of the user as read, or as unread again. a notification already
+// read keeps the time it was first read
+func (r *Repository) SetRead(
+	ctx context.Context, notificationID, userID int64, read bool,
+) (*Notification, error) {
+	query := fmt.Sprintf(`
+		UPDATE notifications
+		SET read_at = CASE WHEN $3 THEN COALESCE(read_at, NOW()) END
+		WHERE id = $1
+		AND user_id = $2
+		RETURNING %s
+	`, notificationColumns)
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	notification, err := scanNotification(
+		r.DB.QueryRowContext(ctx, query, notificationID, userID, read),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+
+		default:
+			return nil, err
+		}
+	}
+
+	return notification, nil
+}
+
+// MarkAllRead marks every unread notification of the user as read and returns how many there were
+func (r *Repository) MarkAllRead(ctx context.Context, userID int64) (int64, error) {
+	query := `
+		UPDATE notifications
+		SET read_at = NOW()
+		WHERE user_id = $1
+		AND read_at IS NULL
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	result, err := r.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,199 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/notify")
+
+type Repo interface {
+	GetAll(
+		ctx context.Context, userID int64, read *bool, f filter.Filters,
+	) ([]*Notification, filter.Metadata, error)
+	Unread(ctx context.Context, userID int64) (int, error)
+	SetRead(ctx context.Context, notificationID, userID int64, read bool) (*Notification, error)
+	MarkAllRead(ctx context.Context, userID int64) (int64, error)
+}
+
+// Render fills in the template of a message, it's mailer.Render outside of tests
+type Render func(
+	templateFile string, data map[string]any,
+) (subject, plainBody, htmlBody string, err error)
+
+type Service struct {
+	Repo     Repo
+	Channels map[string]Channel // by name, only the channels that are set up
+	Routes   Routes
+	Default  []string // the channels of events that aren't in Routes
+	Render   Render
+}
+
+// Send sends the message on every channel its event is routed to. a channel failing doesn't stop
+// the others, the errors of all of them are returned together
+func (s *Service) Send(ctx context.Context, msg *Message) error {
+	ctx, span := tracer.Start(ctx, "notify.Send")
+	defer span.End()
+
+	channels := s.route(msg)
+	if len(channels) == 0 {
+		return nil
+	}
+
+	err := s.render(msg)
+	if err != nil {
+		return err
+	}
+
+	return s.send(ctx, msg, channels, func(Channel) bool { return true })
+}
+
+// SendTx sends the message inside tx on the channels its event is routed to that are TxChannels,
+// the first of them to fail fails it. the rest are left for SendAfterCommit
+func (s *Service) SendTx(ctx context.Context, tx *sql.Tx, msg *Message) error {
+	ctx, span := tracer.Start(ctx, "notify.SendTx")
+	defer span.End()
+
+	channels := s.route(msg)
+	if len(channels) == 0 {
+		return nil
+	}
+
+	err := s.render(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range channels {
+		channel, ok := s.Channels[name].(TxChannel)
+		if !ok {
+			continue
+		}
+
+		err = channel.SendTx(ctx, tx, msg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// SendAfterCommit sends the message SendTx was given on the channels it left out, once the
+// transaction is committed. like Send, the errors of all of them are returned together
+func (s *Service) SendAfterCommit(ctx context.Context, msg *Message) error {
+	ctx, span := tracer.Start(ctx, "notify.SendAfterCommit")
+	defer span.End()
+
+	return s.send(ctx, msg, s.route(msg), func(channel Channel) bool {
+		_, ok := channel.(TxChannel)
+		return !ok
+	})
+}
+
+// route returns the names of the channels the event of the message is routed to
+func (s *Service) route(msg *Message) []string {
+	channels, ok := s.Routes[msg.Event]
+	if !ok {
+		return s.Default
+	}
+	return channels
+}
+
+// render fills in the subject and body of the message from its template
+func (s *Service) render(msg *Message) error {
+	subject, plainBody, _, err := s.Render(msg.Template, msg.Data)
+	if err != nil {
+		return err
+	}
+	msg.Subject, msg.Body = subject, strings.TrimSpace(plainBody)
+
+	return nil
+}
+
+// send sends the rendered message on those of the channels that include returns true for
+func (s *Service) send(
+	ctx context.Context, msg *Message, channels []string, include func(Channel) bool,
+) error {
+	var errs []error
+	for _, name := range channels {
+		channel, ok := s.Channels[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: channel not set up", name))
+			continue
+		}
+		if !include(channel) {
+			continue
+		}
+
+		err := channel.Send(ctx, msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// GetAll returns a page of the in-app notifications of the user, with how many of them are unread.
+// read is "true" or "false" to only get the read or unread ones, or empty for all of them
+func (s *Service) GetAll(
+	ctx context.Context, v *validator.Validator, userID int64, read string, f filter.Filters,
+) ([]*Notification, int, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "notify.GetAll")
+	defer span.End()
+
+	if read != "" {
+		v.CheckAddError(validator.ValueInList(read, "true", "false"), "read", "must be true or false")
+	}
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, 0, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	var readOnly *bool
+	if read != "" {
+		isRead := read == "true"
+		readOnly = &isRead
+	}
+
+	notifications, metadata, err := s.Repo.GetAll(ctx, userID, readOnly, f)
+	if err != nil {
+		return nil, 0, filter.Metadata{}, err
+	}
+
+	unread, err := s.Repo.Unread(ctx, userID)
+	if err != nil {
+		return nil, 0, filter.Metadata{}, err
+	}
+
+	return notifications, unread, metadata, nil
+}
+
+// SetRead marks the notification of the user as read or unread
+func (s *Service) SetRead(
+	ctx context.Context, v *validator.Validator, notificationID, userID int64, read *bool,
+) (*Notification, error) {
+	ctx, span := tracer.Start(ctx, "notify.SetRead")
+	defer span.End()
+
+	if v.CheckAddError(read != nil, "read", "must be given"); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
+	}
+
+	return s.Repo.SetRead(ctx, notificationID, userID, *read)
+}
+
+// MarkAllRead marks every notification of the user as read and returns how many were unread
+func (s *Service) MarkAllRead(ctx context.Context, userID int64) (int64, error) {
+	ctx, span := tracer.Start(ctx, "notify.MarkAllRead")
+	defer span.End()
+
+	return s.Repo.MarkAllRead(ctx, userID)
+}
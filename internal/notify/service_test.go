@@ -0,0 +1,238 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// ---MOCKS---
+type MockRepo struct {
+	GetAllRead *bool
+	UnreadErr  error
+}
+
+func (r *MockRepo) GetAll(
+	ctx context.Context, userID int64, read *bool, f filter.Filters,
+) ([]*Notification, filter.Metadata, error) {
+	r.GetAllRead = read
+	return []*Notification{}, filter.Metadata{}, nil
+}
+
+func (r *MockRepo) Unread(ctx context.Context, userID int64) (int, error) {
+	return 3, r.UnreadErr
+}
+
+func (r *MockRepo) SetRead(
+	ctx context.Context, notificationID, userID int64, read bool,
+) (*Notification, error) {
+	return &Notification{}, nil
+}
+
+func (r *MockRepo) MarkAllRead(ctx context.Context, userID int64) (int64, error) {
+	return 0, nil
+}
+
+type MockChannel struct {
+	Sent    []*Message
+	SendErr error
+}
+
+func (c *MockChannel) Send(ctx context.Context, msg *Message) error {
+	c.Sent = append(c.Sent, msg)
+	return c.SendErr
+}
+
+// MockTxChannel is a channel that can send inside a transaction, like the email and in-app ones
+type MockTxChannel struct {
+	MockChannel
+	SentTx    []*Message
+	SendTxErr error
+}
+
+func (c *MockTxChannel) SendTx(ctx context.Context, tx *sql.Tx, msg *Message) error {
+	c.SentTx = append(c.SentTx, msg)
+	return c.SendTxErr
+}
+
+func mockRender(
+	templateFile string, data map[string]any,
+) (subject, plainBody, htmlBody string, err error) {
+	if templateFile == "" {
+		return "", "", "", errors.New("no template")
+	}
+	return "subject of " + templateFile, "\nbody\n", "<p>body</p>", nil
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name        string
+		event       string
+		template    string
+		emailErr    error
+		wantEmailed bool
+		wantInApp   bool
+		expectedErr string
+	}{
+		{
+			name:        "default channels",
+			event:       "DEPOSIT",
+			template:    "deposit.html",
+			wantEmailed: true,
+			wantInApp:   true,
+		},
+		{
+			name:      "routed",
+			event:     "WITHDRAWAL",
+			template:  "withdrawal.html",
+			wantInApp: true,
+		},
+		{
+			name:     "routed nowhere",
+			event:    "LOAN_PAYMENT",
+			template: "loan_payment.html",
+		},
+		{
+			name:        "channel not set up",
+			event:       "TRANSFER_SENT",
+			template:    "transfer_sent.html",
+			wantEmailed: true,
+			expectedErr: "sms: channel not set up",
+		},
+		{
+			name:        "a failing channel doesn't stop the others",
+			event:       "DEPOSIT",
+			template:    "deposit.html",
+			emailErr:    errors.New("smtp down"),
+			wantEmailed: true,
+			wantInApp:   true,
+			expectedErr: "email: smtp down",
+		},
+		{
+			name:        "render failure",
+			event:       "DEPOSIT",
+			expectedErr: "no template",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			email := &MockChannel{SendErr: tc.emailErr}
+			inApp := &MockChannel{}
+			svc := Service{
+				Channels: map[string]Channel{ChannelEmail: email, ChannelInApp: inApp},
+				Routes: Routes{
+					"WITHDRAWAL":    {ChannelInApp},
+					"LOAN_PAYMENT":  {},
+					"TRANSFER_SENT": {ChannelEmail, ChannelSMS},
+				},
+				Default: []string{ChannelEmail, ChannelInApp},
+				Render:  mockRender,
+			}
+
+			msg := &Message{UserID: 1, Event: tc.event, Template: tc.template}
+			gotErr := svc.Send(context.Background(), msg)
+			if tc.expectedErr != "" {
+				if gotErr == nil || !strings.Contains(gotErr.Error(), tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error :%v", gotErr)
+			}
+
+			if (len(email.Sent) == 1) != tc.wantEmailed {
+				t.Errorf("expected emailed=%v, got %d sent", tc.wantEmailed, len(email.Sent))
+			}
+			if (len(inApp.Sent) == 1) != tc.wantInApp {
+				t.Errorf("expected in app=%v, got %d sent", tc.wantInApp, len(inApp.Sent))
+			}
+			if tc.wantInApp && (msg.Subject != "subject of "+tc.template || msg.Body != "body") {
+				t.Errorf("expected the template rendered, got %q, %q", msg.Subject, msg.Body)
+			}
+		})
+	}
+}
+
+func TestSendTxAndAfterCommit(t *testing.T) {
+	inApp := &MockTxChannel{}
+	sms := &MockChannel{}
+	svc := Service{
+		Channels: map[string]Channel{ChannelInApp: inApp, ChannelSMS: sms},
+		Default:  []string{ChannelInApp, ChannelSMS},
+		Render:   mockRender,
+	}
+
+	msg := &Message{UserID: 1, Event: "DEPOSIT", Template: "deposit.html"}
+	err := svc.SendTx(context.Background(), nil, msg)
+	if err != nil {
+		t.Fatalf("unexpected error :%v", err)
+	}
+	if len(inApp.SentTx) != 1 || len(sms.Sent) != 0 {
+		t.Fatalf("expected only the in-app sent in the transaction, got %d, %d",
+			len(inApp.SentTx), len(sms.Sent))
+	}
+
+	err = svc.SendAfterCommit(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("unexpected error :%v", err)
+	}
+	if len(inApp.Sent) != 0 || len(sms.Sent) != 1 {
+		t.Errorf("expected only the sms sent after the commit, got %d, %d",
+			len(inApp.Sent), len(sms.Sent))
+	}
+	if sms.Sent[0].Subject != "subject of deposit.html" {
+		t.Errorf("expected the message rendered by SendTx, got %q", sms.Sent[0].Subject)
+	}
+
+	// the transaction is rolled back if a channel in it fails
+	inApp.SendTxErr = errors.New("db down")
+	err = svc.SendTx(context.Background(), nil, msg)
+	if err == nil || !strings.Contains(err.Error(), "in_app: db down") {
+		t.Errorf("expected the in-app error, got %v", err)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	f := filter.Filters{Page: 1, PageSize: 10, Sort: "-created_at", SortSafelist: SortSafelist}
+
+	tests := []struct {
+		name        string
+		read        string
+		wantRead    *bool
+		expectedErr error
+	}{
+		{name: "all", read: ""},
+		{name: "unread", read: "false", wantRead: new(bool)},
+		{name: "invalid", read: "maybe", expectedErr: validator.ErrFailedValidation},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			svc := Service{Repo: repo}
+
+			_, unread, _, gotErr := svc.GetAll(context.Background(), validator.New(), 1, tc.read, f)
+			if tc.expectedErr != nil {
+				if !errors.Is(gotErr, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error :%v", gotErr)
+			}
+
+			if unread != 3 {
+				t.Errorf("expected 3 unread, got %d", unread)
+			}
+			if (repo.GetAllRead == nil) != (tc.wantRead == nil) ||
+				(tc.wantRead != nil && *repo.GetAllRead != *tc.wantRead) {
+				t.Errorf("expected read %v, got %v", tc.wantRead, repo.GetAllRead)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package outbox
+
+import (
+	"errors"
+	"time"
+)
+
+// the states a message moves through. a message is PENDING until it is sent or has failed
+// MaxAttempts times, at which point it is DEAD and left for an admin to look at and retry
+const (
+	StatusPending = "PENDING"
+	StatusSent    = "SENT"
+	StatusDead    = "DEAD"
+)
+
+var Statuses = []string{StatusPending, StatusSent, StatusDead}
+
+// SortSafelist is what messages can be listed by
+var SortSafelist = []string{
+	"id", "created_at", "next_attempt_at", "-id", "-created_at", "-next_attempt_at",
+}
+
+var (
+	// ErrNoRecord stands in for user.ErrNoRecord, which can't be used here because the user package
+	// queues its emails through this one
+	ErrNoRecord = errors.New("no record")
+	ErrNotDead  = errors.New("only dead messages can be retried")
+)
+
+// Message is an email waiting to be sent, or that was. Data is what the template is rendered with,
+// it can hold tokens so it is never shown and is cleared once the message is sent
+type Message struct {
+	ID            int64          `json:"id"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Recipient     string         `json:"recipient"`
+	Template      string         `json:"template"`
+	Data          map[string]any `json:"-"`
+	Status        string         `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	LastError     string         `json:"last_error,omitempty"`
+	SentAt        *time.Time     `json:"sent_at,omitempty"`
+}
+
+// Policy decides how failed messages are retried. after a failure the next attempt waits
+// BaseDelay, doubling with each failure up to MaxDelay. a message that failed MaxAttempts times is
+// dead. Lease is how long a message is held by the worker sending it, if the worker dies the
+// message is picked up again after it
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Lease       time.Duration
+}
+
+// Backoff returns how long to wait before trying a message again after the number of attempts
+func (p Policy) Backoff(attempts int) time.Duration {
+	if attempts <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
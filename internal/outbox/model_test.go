@@ -0,0 +1,30 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{MaxAttempts: 8, BaseDelay: 30 * time.Second, MaxDelay: time.Hour}
+
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{name: "not tried yet", attempts: 0, want: 0},
+		{name: "first failure", attempts: 1, want: 30 * time.Second},
+		{name: "back off doubles", attempts: 3, want: 2 * time.Minute},
+		{name: "back off is capped", attempts: 20, want: time.Hour},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := policy.Backoff(tc.attempts)
+			if got != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// the columns of a message, in the order scanMessage reads them
+const messageColumns = `id, created_at, recipient, template, data, status, attempts,
+	next_attempt_at, last_error, sent_at`
+
+// scanMessage reads a row of messageColumns, after any columns selected before them into leading
+func scanMessage(row interface{ Scan(...any) error }, leading ...any) (*Message, error) {
+	var msg Message
+	var data []byte
+	err := row.Scan(append(
+		leading,
+		&msg.ID,
+		&msg.CreatedAt,
+		&msg.Recipient,
+		&msg.Template,
+		&data,
+		&msg.Status,
+		&msg.Attempts,
+		&msg.NextAttemptAt,
+		&msg.LastError,
+		&msg.SentAt,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers are kept as they were written, as floats a user id of a million would render as 1e+06
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// querier is what inserting a message needs, so it can be done on its own or inside a transaction
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insert(ctx context.Context, q querier, msg *Message) error {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO outbox_messages (recipient, template, data)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, status, next_attempt_at
+	`
+
+	return q.QueryRowContext(ctx, query, msg.Recipient, msg.Template, data).Scan(
+		&msg.ID,
+		&msg.CreatedAt,
+		&msg.Status,
+		&msg.NextAttemptAt,
+	)
+}
+
+func (r *Repository) Insert(ctx context.Context, msg *Message) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	return insert(ctx, r.DB, msg)
+}
+
+// InsertTx is Insert inside a transaction owned by the caller, so that the message is only ever
+// queued if what it is about is committed, e.g. the user a welcome email is for
+func InsertTx(ctx context.Context, tx *sql.Tx, msg *Message) error {
+	return insert(ctx, tx, msg)
+}
+
+// Claim takes up to limit pending messages due by now for the caller to send, counting the attempt
+// and holding them until lease has passed. messages claimed by someone else are skipped, so any
+// number of workers can claim at once
+func (r *Repository) Claim(
+	ctx context.Context, limit int, now time.Time, lease time.Duration,
+) ([]*Message, error) {
+	query := fmt.Sprintf(`
+		UPDATE outbox_messages
+		SET attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id
+			FROM outbox_messages
+			WHERE status = 'PENDING'
+			AND next_attempt_at <= $1
+			ORDER BY next_attempt_at, id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING %s
+	`, messageColumns)
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// MarkSent records that the message was sent, clearing its data
+func (r *Repository) MarkSent(ctx context.Context, messageID int64, now time.Time) error {
+	query := `
+		UPDATE outbox_messages
+		SET status = 'SENT', sent_at = $2, data = '{}', last_error = ''
+		WHERE id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, messageID, now)
+	return err
+}
+
+// MarkFailed records why sending the message failed, and when to try it next. a dead message is
+// not tried again
+func (r *Repository) MarkFailed(
+	ctx context.Context, messageID int64, lastError string, dead bool, nextAttemptAt time.Time,
+) error {
+	query := `
+		UPDATE outbox_messages
+		SET status = CASE WHEN $3 THEN 'DEAD' ELSE 'PENDING' END, last_error = $2,
+			next_attempt_at = $4
+		WHERE id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, messageID, lastError, dead, nextAttemptAt)
+	return err
+}
+
+// GetAll returns a page of the messages with the status, or of all of them if status is empty
+func (r *Repository) GetAll(
+	ctx context.Context, status string, f filter.Filters,
+) ([]*Message, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM outbox_messages
+		WHERE (status = $1 OR $1 = '')
+		AND ($2::timestamptz IS NULL OR created_at >= $2)
+		AND ($3::timestamptz IS NULL OR created_at < $3)
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5
+	`, messageColumns, f.SortColumn(), f.SortDirection())
+	args := []any{status, f.From, f.To, f.Limit(), f.Offset()}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	messages := []*Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows, &totalRecords)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return messages, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+// Retry puts a dead message back in the queue to be sent now, with all its attempts back, then
+// runs then in the same transaction if it is given. ErrNotDead is returned for a message that
+// isn't dead
+func (r *Repository) Retry(
+	ctx context.Context, messageID int64, then database.TxFunc,
+) (*Message, error) {
+	query := fmt.Sprintf(`
+		UPDATE outbox_messages
+		SET status = 'PENDING', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1
+		AND status = 'DEAD'
+		RETURNING %s
+	`, messageColumns)
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	msg, err := scanMessage(tx.QueryRowContext(ctx, query, messageID))
+	if err == nil {
+		if then != nil {
+			err = then(ctx, tx)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// tell a message that doesn't exist apart from one that isn't dead
+	var exists bool
+	err = r.DB.QueryRowContext(
+		ctx, `SELECT EXISTS(SELECT 1 FROM outbox_messages WHERE id = $1)`, messageID,
+	).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoRecord
+	}
+	return nil, ErrNotDead
+}
@@ -0,0 +1,132 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/outbox")
+
+type Repo interface {
+	Insert(ctx context.Context, msg *Message) error
+	Claim(ctx context.Context, limit int, now time.Time, lease time.Duration) ([]*Message, error)
+	MarkSent(ctx context.Context, messageID int64, now time.Time) error
+	MarkFailed(
+		ctx context.Context, messageID int64, lastError string, dead bool, nextAttemptAt time.Time,
+	) error
+	GetAll(ctx context.Context, status string, f filter.Filters) ([]*Message, filter.Metadata, error)
+	Retry(ctx context.Context, messageID int64, then database.TxFunc) (*Message, error)
+}
+
+type Mailer interface {
+	Send(ctx context.Context, recipient, templateFile string, data map[string]any) error
+}
+
+// Auditor records who put a dead email back in the queue, in the transaction that does it
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
+}
+
+type Service struct {
+	Repo    Repo
+	Mailer  Mailer // only needed to deliver
+	Policy  Policy
+	Auditor Auditor // optional
+}
+
+// Send queues the email to be sent by the workers. it has the same signature as the mailer so the
+// service can be used anywhere an email would be sent straight away
+func (s *Service) Send(
+	ctx context.Context, recipient, templateFile string, data map[string]any,
+) error {
+	ctx, span := tracer.Start(ctx, "outbox.Send")
+	defer span.End()
+
+	return s.Repo.Insert(ctx, &Message{
+		Recipient: recipient,
+		Template:  templateFile,
+		Data:      data,
+	})
+}
+
+// Deliver claims up to limit messages due by now and sends them. a message that fails is tried
+// again after the back-off of the policy, or is dead once it has been tried MaxAttempts times. it
+// returns how many were sent and how many failed
+func (s *Service) Deliver(
+	ctx context.Context, limit int, now time.Time,
+) (sent, failed int, err error) {
+	ctx, span := tracer.Start(ctx, "outbox.Deliver")
+	defer span.End()
+
+	messages, err := s.Repo.Claim(ctx, limit, now, s.Policy.Lease)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, msg := range messages {
+		sendErr := s.Mailer.Send(ctx, msg.Recipient, msg.Template, msg.Data)
+		if sendErr == nil {
+			sent++
+			err = s.Repo.MarkSent(ctx, msg.ID, time.Now())
+			if err != nil {
+				return sent, failed, err
+			}
+			continue
+		}
+
+		failed++
+		dead := msg.Attempts >= s.Policy.MaxAttempts
+		nextAttemptAt := now.Add(s.Policy.Backoff(msg.Attempts))
+		err = s.Repo.MarkFailed(ctx, msg.ID, sendErr.Error(), dead, nextAttemptAt)
+		if err != nil {
+			return sent, failed, err
+		}
+	}
+
+	return sent, failed, nil
+}
+
+// GetAll returns a page of the messages with the status, or of all of them if status is empty
+func (s *Service) GetAll(
+	ctx context.Context, v *validator.Validator, status string, f filter.Filters,
+) ([]*Message, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "outbox.GetAll")
+	defer span.End()
+
+	if status != "" {
+		v.CheckAddError(validator.ValueInList(status, Statuses...), "status", "invalid")
+	}
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetAll(ctx, status, f)
+}
+
+// Retry puts the dead message back in the queue
+func (s *Service) Retry(ctx context.Context, messageID int64) (*Message, error) {
+	ctx, span := tracer.Start(ctx, "outbox.Retry")
+	defer span.End()
+
+	var retried database.TxFunc
+	if s.Auditor != nil {
+		// only a dead message can be retried, and it is pending again once it is
+		retried = func(ctx context.Context, tx *sql.Tx) error {
+			return s.Auditor.RecordTx(
+				ctx, tx, audit.ActionOutboxRetried, audit.TargetOutboxMessage, messageID,
+				map[string]any{"status": StatusDead}, map[string]any{"status": StatusPending},
+			)
+		}
+	}
+
+	return s.Repo.Retry(ctx, messageID, retried)
+}
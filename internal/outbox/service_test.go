@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// ---MOCKS---
+type MockRepo struct {
+	Inserted  []*Message
+	InsertErr error
+
+	ClaimResult []*Message
+	ClaimErr    error
+
+	Sent   []int64
+	Failed map[int64]failure
+}
+
+// failure is what MarkFailed was called with for a message
+type failure struct {
+	lastError     string
+	dead          bool
+	nextAttemptAt time.Time
+}
+
+func (r *MockRepo) Insert(ctx context.Context, msg *Message) error {
+	if r.InsertErr != nil {
+		return r.InsertErr
+	}
+	r.Inserted = append(r.Inserted, msg)
+	return nil
+}
+
+func (r *MockRepo) Claim(
+	ctx context.Context, limit int, now time.Time, lease time.Duration,
+) ([]*Message, error) {
+	return r.ClaimResult, r.ClaimErr
+}
+
+func (r *MockRepo) MarkSent(ctx context.Context, messageID int64, now time.Time) error {
+	r.Sent = append(r.Sent, messageID)
+	return nil
+}
+
+func (r *MockRepo) MarkFailed(
+	ctx context.Context, messageID int64, lastError string, dead bool, nextAttemptAt time.Time,
+) error {
+	if r.Failed == nil {
+		r.Failed = map[int64]failure{}
+	}
+	r.Failed[messageID] = failure{lastError, dead, nextAttemptAt}
+	return nil
+}
+
+func (r *MockRepo) GetAll(
+	ctx context.Context, status string, f filter.Filters,
+) ([]*Message, filter.Metadata, error) {
+	return []*Message{}, filter.Metadata{}, nil
+}
+
+func (r *MockRepo) Retry(
+	ctx context.Context, messageID int64, then database.TxFunc,
+) (*Message, error) {
+	return nil, nil
+}
+
+// MockMailer fails to send to the recipients in FailFor
+type MockMailer struct {
+	FailFor map[string]bool
+}
+
+func (m *MockMailer) Send(
+	ctx context.Context, recipient, templateFile string, data map[string]any,
+) error {
+	if m.FailFor[recipient] {
+		return errors.New("smtp down")
+	}
+	return nil
+}
+
+func TestSend(t *testing.T) {
+	repo := &MockRepo{}
+	svc := Service{Repo: repo}
+
+	err := svc.Send(
+		context.Background(), "y@gmail.com", "deposit.html", map[string]any{"amount": "10.00"},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(repo.Inserted) != 1 || repo.Inserted[0].Template != "deposit.html" {
+		t.Fatalf("expected the email queued, got %+v", repo.Inserted)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	now := time.Now()
+	policy := Policy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+	repo := &MockRepo{
+		ClaimResult: []*Message{
+			{ID: 1, Recipient: "ok@gmail.com", Attempts: 1},
+			{ID: 2, Recipient: "bad@gmail.com", Attempts: 2},
+			{ID: 3, Recipient: "bad@gmail.com", Attempts: 3},
+		},
+	}
+	svc := Service{
+		Repo:   repo,
+		Mailer: &MockMailer{FailFor: map[string]bool{"bad@gmail.com": true}},
+		Policy: policy,
+	}
+
+	sent, failed, err := svc.Deliver(context.Background(), 10, now)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if sent != 1 || failed != 2 {
+		t.Fatalf("expected 1 sent and 2 failed, got %d and %d", sent, failed)
+	}
+	if len(repo.Sent) != 1 || repo.Sent[0] != 1 {
+		t.Errorf("expected message 1 marked sent, got %v", repo.Sent)
+	}
+
+	// a failure is tried again after the back-off, until it has used up its attempts
+	retried := repo.Failed[2]
+	if retried.dead || !retried.nextAttemptAt.Equal(now.Add(2*time.Minute)) {
+		t.Errorf("expected message 2 retried in 2 minutes, got %+v", retried)
+	}
+	if retried.lastError != "smtp down" {
+		t.Errorf("expected the error kept, got %q", retried.lastError)
+	}
+	if !repo.Failed[3].dead {
+		t.Errorf("expected message 3 dead, got %+v", repo.Failed[3])
+	}
+}
+
+func TestDeliverClaimFailure(t *testing.T) {
+	svc := Service{Repo: &MockRepo{ClaimErr: errors.New("db error")}}
+
+	_, _, err := svc.Deliver(context.Background(), 10, time.Now())
+	if err == nil || err.Error() != "db error" {
+		t.Fatalf("expected error db error, got %v", err)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		expectedErr error
+	}{
+		{name: "dead", status: StatusDead},
+		{name: "any status", status: ""},
+		{name: "unknown status", status: "LOST", expectedErr: validator.ErrFailedValidation},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := Service{Repo: &MockRepo{}}
+			f := filter.Filters{
+				Page: 1, PageSize: 20, Sort: "-created_at", SortSafelist: SortSafelist,
+			}
+
+			_, _, err := svc.GetAll(context.Background(), validator.New(), tc.status, f)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
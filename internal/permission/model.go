@@ -1,13 +1,69 @@
 package permission
 
 import (
+	"regexp"
 	"slices"
+	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
 type Permission string
 
+// Definition is a permission in the catalog, along with what it lets a user do
+type Definition struct {
+	Code        Permission `json:"code"`
+	Description string     `json:"description"`
+}
+
+// Role bundles permissions together, a user with the role has all of its permissions and all the
+// permissions of the roles it inherits, and of the roles those inherit
+type Role struct {
+	ID          int64        `json:"id"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions"`
+	Inherits    []string     `json:"inherits"`
+}
+
+// the changes to the roles of a user recorded in the grant log
+const (
+	ActionAssign = "ASSIGN"
+	ActionRevoke = "REVOKE"
+	ActionExpire = "EXPIRE"
+)
+
+// Grant is a role given to a user. it lasts until ExpiresAt, if set, and MaxAmount caps the amount
+// of any money the role, or the roles it inherits, lets the user move. the cap is in Currency,
+// money in any other currency can't be moved with the grant at all
+type Grant struct {
+	UserID    int64          `json:"user_id"`
+	Role      string         `json:"role"`
+	GrantedAt time.Time      `json:"granted_at"`
+	GrantedBy *int64         `json:"granted_by"`
+	Reason    string         `json:"reason"`
+	ExpiresAt *time.Time     `json:"expires_at,omitempty"`
+	MaxAmount *money.Amount  `json:"max_amount,omitempty"`
+	Currency  money.Currency `json:"currency,omitempty"` // only set along with MaxAmount
+}
+
+// Scope is what a user may do with the permissions they were checked for. MaxAmounts is the most
+// they can move at once in each currency their grants are limited in, they can't move any other
+// currency. it is nil when at least one of the grants they come through has no limit
+type Scope struct {
+	MaxAmounts map[money.Currency]money.Amount
+}
+
+var (
+	// codes are upper case words separated by underscores, like APPROVE_LOANS
+	CodeRX = regexp.MustCompile(`^[A-Z]+(_[A-Z]+)*$`)
+	// role names are lower case words separated by underscores, like loan_officer
+	RoleNameRX = regexp.MustCompile(`^[a-z]+(_[a-z]+)*$`)
+)
+
 func Includes(permissions []Permission, code ...string) bool {
 	for _, value := range code {
 		if slices.Contains(permissions, Permission(value)) {
@@ -18,13 +74,46 @@ func Includes(permissions []Permission, code ...string) bool {
 	return false
 }
 
+// ValidateCode only checks the code is well formed, which codes exist is up to the catalog
 func ValidateCode(v *validator.Validator, code string) {
 	v.CheckAddError(code != "", "code", "must be provided")
-	safePermissions := []string{
-		"APPROVE_LOANS",
-		"DELETE_LOANS",
-		"ADMIN",
-		"SUPERUSER",
+	v.CheckAddError(len(code) <= 50, "code", "must not be more than 50 characters long")
+	v.CheckAddError(validator.Matches(code, CodeRX), "code", "invalid")
+}
+
+func ValidateRoleName(v *validator.Validator, name string) {
+	v.CheckAddError(name != "", "role", "must be provided")
+	v.CheckAddError(len(name) <= 50, "role", "must not be more than 50 characters long")
+	v.CheckAddError(validator.Matches(name, RoleNameRX), "role", "invalid")
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	ValidateRoleName(v, role.Name)
+	v.CheckAddError(len(role.Description) <= 500, "description", "must not be more than 500 bytes")
+	v.CheckAddError(
+		len(role.Permissions) > 0 || len(role.Inherits) > 0, "permissions",
+		"a role must have a permission or inherit another role",
+	)
+	for _, code := range role.Permissions {
+		v.CheckAddError(validator.Matches(string(code), CodeRX), "permissions", "invalid code")
+	}
+	for _, name := range role.Inherits {
+		v.CheckAddError(validator.Matches(name, RoleNameRX), "inherits", "invalid role")
+	}
+}
+
+func ValidateGrant(v *validator.Validator, grant *Grant, now time.Time) {
+	ValidateRoleName(v, grant.Role)
+	v.CheckAddError(grant.Reason != "", "reason", "must be given")
+	v.CheckAddError(len(grant.Reason) <= 500, "reason", "must not be more than 500 bytes")
+	if grant.ExpiresAt != nil {
+		v.CheckAddError(grant.ExpiresAt.After(now), "expires_at", "must be in the future")
+	}
+	if grant.MaxAmount != nil {
+		v.CheckAddError(grant.MaxAmount.IsPositive(), "max_amount", "must be more than 0")
+		v.CheckAddError(
+			slices.Contains(account.SupportedCurrencies, grant.Currency), "currency",
+			"not supported",
+		)
 	}
-	v.CheckAddError(validator.ValueInList(code, safePermissions...), "code", "invalid")
 }
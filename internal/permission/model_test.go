@@ -2,7 +2,9 @@ package permission
 
 import (
 	"testing"
+	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
@@ -49,6 +51,11 @@ func TestValidateCode(t *testing.T) {
 			code:      "ADMIN",
 			wantValid: true,
 		},
+		{
+			name:      "code not seeded",
+			code:      "VIEW_LEDGER",
+			wantValid: true,
+		},
 		{
 			name:      "unsafe code",
 			code:      "super-admin",
@@ -79,3 +86,67 @@ func TestValidateCode(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateGrant(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	zero := money.MustParse("0")
+	limit := money.MustParse("500")
+
+	tests := []struct {
+		name      string
+		grant     *Grant
+		wantValid bool
+		wantKey   string
+	}{
+		{
+			name:      "valid",
+			grant:     &Grant{Role: "teller", Reason: "covering the desk", ExpiresAt: &future},
+			wantValid: true,
+		},
+		{
+			name:    "no reason",
+			grant:   &Grant{Role: "teller"},
+			wantKey: "reason",
+		},
+		{
+			name:    "already expired",
+			grant:   &Grant{Role: "teller", Reason: "covering the desk", ExpiresAt: &past},
+			wantKey: "expires_at",
+		},
+		{
+			name: "limit in euros",
+			grant: &Grant{
+				Role: "teller", Reason: "covering the desk", MaxAmount: &limit, Currency: "EUR",
+			},
+			wantValid: true,
+		},
+		{
+			name: "zero limit",
+			grant: &Grant{
+				Role: "teller", Reason: "covering the desk", MaxAmount: &zero, Currency: "USD",
+			},
+			wantKey: "max_amount",
+		},
+		{
+			name: "limit in an unsupported currency",
+			grant: &Grant{
+				Role: "teller", Reason: "covering the desk", MaxAmount: &limit, Currency: "JPY",
+			},
+			wantKey: "currency",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateGrant(v, tc.grant, now)
+			if v.IsValid() != tc.wantValid {
+				t.Fatalf("expected valid=%v, got errors %v", tc.wantValid, v.Errors)
+			}
+			if tc.wantKey != "" && v.Errors[tc.wantKey] == "" {
+				t.Errorf("expected an error for %s, got %v", tc.wantKey, v.Errors)
+			}
+		})
+	}
+}
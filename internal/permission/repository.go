@@ -3,44 +3,124 @@ package permission
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/lib/pq"
 )
 
+var (
+	ErrDuplicateCode = errors.New("duplicate permission code")
+	ErrDuplicateRole = errors.New("duplicate role name")
+)
+
+// userRolesCTE is the roles of user $1 along with every role they inherit, however far up, and the
+// max amount of the grant each comes through, with its currency. grants past their expiry are left
+// out even before they are swept. UNION drops the rows already seen, so even a loop in the
+// hierarchy would end
+const userRolesCTE = `
+	WITH RECURSIVE user_roles (role_id, max_amount, max_amount_currency) AS (
+		SELECT role_id, max_amount, max_amount_currency
+		FROM users_roles
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		UNION
+		SELECT roles_inherits.inherits_id, user_roles.max_amount, user_roles.max_amount_currency
+		FROM roles_inherits
+		INNER JOIN user_roles ON user_roles.role_id = roles_inherits.role_id
+	)
+`
+
 type Repository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (r *Repository) Insert(code Permission) error {
+// Insert adds the permission to the catalog, then runs then in the same transaction if it is given
+func (r *Repository) Insert(
+	ctx context.Context, code Permission, description string, then database.TxFunc,
+) error {
 	query := `
-		INSERT INTO permissions (code)
-		VALUES ($1)
+		INSERT INTO permissions (code, description)
+		VALUES ($1, $2)
 		RETURNING code
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	err := r.DB.QueryRowContext(ctx, query, code).Scan(&code)
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	err = tx.QueryRowContext(ctx, query, code, description).Scan(&code)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "permissions_code_key"`:
+			return ErrDuplicateCode
+		default:
+			return err
+		}
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (r *Repository) AllForUser(userID int64) ([]Permission, error) {
+// GetAll returns the permission catalog
+func (r *Repository) GetAll(ctx context.Context) ([]*Definition, error) {
 	query := `
-		SELECT permissions.code
+		SELECT code, description
+		FROM permissions
+		ORDER BY code
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	definitions := []*Definition{}
+	for rows.Next() {
+		var definition Definition
+		err = rows.Scan(&definition.Code, &definition.Description)
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, &definition)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return definitions, nil
+}
+
+// AllForUser returns every permission the user has through their roles
+func (r *Repository) AllForUser(ctx context.Context, userID int64) ([]Permission, error) {
+	query := userRolesCTE + `
+		SELECT DISTINCT permissions.code
 		FROM permissions
-		INNER JOIN users_permissions 
-		ON users_permissions.permission_id = permissions.id
-		WHERE users_permissions.user_id = $1
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN user_roles ON user_roles.role_id = roles_permissions.role_id
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	rows, err := r.DB.QueryContext(ctx, query, userID)
@@ -66,7 +146,31 @@ func (r *Repository) AllForUser(userID int64) ([]Permission, error) {
 	return permissions, nil
 }
 
-func (r *Repository) Delete(code ...string) error {
+// HasAny reports whether the user has at least one of the codes through their roles
+func (r *Repository) HasAny(ctx context.Context, userID int64, code ...string) (bool, error) {
+	query := userRolesCTE + `
+		SELECT EXISTS (
+			SELECT 1
+			FROM permissions
+			INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+			INNER JOIN user_roles ON user_roles.role_id = roles_permissions.role_id
+			WHERE permissions.code = ANY($2)
+		)
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var has bool
+	err := r.DB.QueryRowContext(ctx, query, userID, pq.Array(code)).Scan(&has)
+	if err != nil {
+		return false, err
+	}
+
+	return has, nil
+}
+
+func (r *Repository) Delete(ctx context.Context, code ...string) error {
 	query := `
 		DELETE FROM permissions
 		WHERE id IN (
@@ -75,10 +179,10 @@ func (r *Repository) Delete(code ...string) error {
 			WHERE code = ANY($1)
 		)
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	res, err := r.DB.ExecContext(ctx, query, code)
+	res, err := r.DB.ExecContext(ctx, query, pq.Array(code))
 	if err != nil {
 		return err
 	}
@@ -95,33 +199,302 @@ func (r *Repository) Delete(code ...string) error {
 	return nil
 }
 
-func (r *Repository) Grant(userID int64, code ...string) error {
+// roleQuery selects roles along with the codes of their own permissions and the names of the
+// roles they inherit directly
+const roleQuery = `
+	SELECT roles.id, roles.created_at, roles.name, roles.description,
+		ARRAY(
+			SELECT permissions.code
+			FROM roles_permissions
+			INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
+			WHERE roles_permissions.role_id = roles.id
+			ORDER BY permissions.code
+		),
+		ARRAY(
+			SELECT inherits.name
+			FROM roles_inherits
+			INNER JOIN roles AS inherits ON inherits.id = roles_inherits.inherits_id
+			WHERE roles_inherits.role_id = roles.id
+			ORDER BY inherits.name
+		)
+	FROM roles
+`
+
+// scanRole reads a row selected by roleQuery
+func scanRole(row interface{ Scan(...any) error }) (*Role, error) {
+	var role Role
+	var codes []string
+	err := row.Scan(
+		&role.ID,
+		&role.CreatedAt,
+		&role.Name,
+		&role.Description,
+		pq.Array(&codes),
+		pq.Array(&role.Inherits),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	role.Permissions = make([]Permission, len(codes))
+	for i, code := range codes {
+		role.Permissions[i] = Permission(code)
+	}
+	if role.Inherits == nil {
+		role.Inherits = []string{}
+	}
+
+	return &role, nil
+}
+
+func (r *Repository) GetRole(ctx context.Context, name string) (*Role, error) {
+	query := roleQuery + `
+		WHERE roles.name = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	role, err := scanRole(r.DB.QueryRowContext(ctx, query, name))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return role, nil
+}
+
+func (r *Repository) GetRoles(ctx context.Context) ([]*Role, error) {
+	query := roleQuery + `
+		ORDER BY roles.name
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// InsertRole adds the role along with its permissions and the roles it inherits, which must all
+// exist already, then runs then in the same transaction if it is given
+func (r *Repository) InsertRole(ctx context.Context, role *Role, then database.TxFunc) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO users_permissions
+		INSERT INTO roles (name, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at
+	`
+	err = tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(
+		&role.ID,
+		&role.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRole
+		default:
+			return err
+		}
+	}
+
+	codes := make([]string, len(role.Permissions))
+	for i, code := range role.Permissions {
+		codes[i] = string(code)
+	}
+	query = `
+		INSERT INTO roles_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
-		ON CONFLICT DO NOTHING
 	`
+	_, err = tx.ExecContext(ctx, query, role.ID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	query = `
+		INSERT INTO roles_inherits
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+	`
+	_, err = tx.ExecContext(ctx, query, role.ID, pq.Array(role.Inherits))
+	if err != nil {
+		return err
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Scope returns the scope the user has at least one of the codes in, nil if they have none of them
+func (r *Repository) Scope(ctx context.Context, userID int64, code ...string) (*Scope, error) {
+	// a row for each currency the grants are limited in, the grants without a limit have none
+	query := userRolesCTE + `
+		SELECT BOOL_OR(user_roles.max_amount IS NULL), COALESCE(user_roles.max_amount_currency, ''),
+			MAX(user_roles.max_amount)
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN user_roles ON user_roles.role_id = roles_permissions.role_id
+		WHERE permissions.code = ANY($2)
+		GROUP BY user_roles.max_amount_currency
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	_, err := r.DB.ExecContext(ctx, query, userID, pq.Array(code))
-	return err
+	rows, err := r.DB.QueryContext(ctx, query, userID, pq.Array(code))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scope *Scope
+	var unlimited bool
+	for rows.Next() {
+		var noLimit bool
+		var currency money.Currency
+		var maxAmount *money.Amount
+		err = rows.Scan(&noLimit, &currency, &maxAmount)
+		if err != nil {
+			return nil, err
+		}
+
+		if scope == nil {
+			scope = &Scope{MaxAmounts: map[money.Currency]money.Amount{}}
+		}
+		if noLimit {
+			unlimited = true
+			continue
+		}
+		scope.MaxAmounts[currency] = maxAmount.WithCurrency(currency)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if unlimited {
+		return &Scope{}, nil
+	}
+	return scope, nil
+}
+
+// AssignRole grants the role to the user and logs it, then runs then in the same transaction if it
+// is given. granting a role the user already has replaces the reason, expiry and limit it had
+func (r *Repository) AssignRole(
+	ctx context.Context, grant *Grant, roleID int64, then database.TxFunc,
+) error {
+	query := `
+		WITH granted AS (
+			INSERT INTO users_roles (
+				user_id, role_id, granted_by, reason, expires_at, max_amount, max_amount_currency
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+			ON CONFLICT (user_id, role_id) DO UPDATE
+			SET granted_at = NOW(), granted_by = EXCLUDED.granted_by, reason = EXCLUDED.reason,
+				expires_at = EXCLUDED.expires_at, max_amount = EXCLUDED.max_amount,
+				max_amount_currency = EXCLUDED.max_amount_currency
+			RETURNING *
+		)
+		INSERT INTO role_grant_events (
+			action, user_id, role, performed_by, reason, expires_at, max_amount, max_amount_currency
+		)
+		SELECT $8, granted.user_id, roles.name, granted.granted_by, granted.reason,
+			granted.expires_at, granted.max_amount, granted.max_amount_currency
+		FROM granted
+		INNER JOIN roles ON roles.id = granted.role_id
+		RETURNING created_at
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := []any{
+		grant.UserID, roleID, grant.GrantedBy, grant.Reason, grant.ExpiresAt, grant.MaxAmount,
+		grant.Currency, ActionAssign,
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&grant.GrantedAt)
+	if err != nil {
+		return err
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (r *Repository) Revoke(userID int64, code ...string) error {
+// RevokeRole takes the role from the user and logs it, then runs then in the same transaction if
+// it is given
+func (r *Repository) RevokeRole(
+	ctx context.Context, userID, roleID, revokedBy int64, then database.TxFunc,
+) error {
 	query := `
-		DELETE FROM users_permissions
-		WHERE user_id = $1
-		AND permission_id IN (
-            SELECT id FROM permissions WHERE code = ANY($2)
-        )
+		WITH revoked AS (
+			DELETE FROM users_roles
+			WHERE user_id = $1 AND role_id = $2
+			RETURNING *
+		)
+		INSERT INTO role_grant_events (action, user_id, role, performed_by)
+		SELECT $4, revoked.user_id, roles.name, $3
+		FROM revoked
+		INNER JOIN roles ON roles.id = revoked.role_id
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	res, err := r.DB.ExecContext(ctx, query, userID, code)
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, query, userID, roleID, revokedBy, ActionRevoke)
 	if err != nil {
 		return err
 	}
@@ -135,5 +508,92 @@ func (r *Repository) Revoke(userID int64, code ...string) error {
 		return user.ErrNoRecord
 	}
 
-	return nil
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteExpiredGrants removes the grants that expired by now, logging each, and returns how many
+// there were
+func (r *Repository) DeleteExpiredGrants(ctx context.Context, now time.Time) (int64, error) {
+	query := `
+		WITH expired AS (
+			DELETE FROM users_roles
+			WHERE expires_at <= $1
+			RETURNING *
+		)
+		INSERT INTO role_grant_events (
+			action, user_id, role, reason, expires_at, max_amount, max_amount_currency
+		)
+		SELECT $2, expired.user_id, roles.name, expired.reason, expired.expires_at,
+			expired.max_amount, expired.max_amount_currency
+		FROM expired
+		INNER JOIN roles ON roles.id = expired.role_id
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, query, now, ActionExpire)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GrantsForUser returns the roles the user has been granted directly and hasn't expired
+func (r *Repository) GrantsForUser(ctx context.Context, userID int64) ([]*Grant, error) {
+	query := `
+		SELECT users_roles.user_id, roles.name, users_roles.granted_at, users_roles.granted_by,
+			users_roles.reason, users_roles.expires_at, users_roles.max_amount,
+			COALESCE(users_roles.max_amount_currency, '')
+		FROM users_roles
+		INNER JOIN roles ON roles.id = users_roles.role_id
+		WHERE users_roles.user_id = $1
+		AND (users_roles.expires_at IS NULL OR users_roles.expires_at > NOW())
+		ORDER BY roles.name
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []*Grant{}
+	for rows.Next() {
+		var grant Grant
+		err = rows.Scan(
+			&grant.UserID,
+			&grant.Role,
+			&grant.GrantedAt,
+			&grant.GrantedBy,
+			&grant.Reason,
+			&grant.ExpiresAt,
+			&grant.MaxAmount,
+			&grant.Currency,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if grant.MaxAmount != nil {
+			*grant.MaxAmount = grant.MaxAmount.WithCurrency(grant.Currency)
+		}
+		grants = append(grants, &grant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
 }
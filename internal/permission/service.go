@@ -1,42 +1,106 @@
 package permission
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/permission")
+
 type Repo interface {
-	AllForUser(userID int64) ([]Permission, error)
-	Grant(userID int64, code ...string) error
-	Revoke(userID int64, code ...string) error
-	Delete(code ...string) error
-	Insert(code Permission) error
+	AllForUser(ctx context.Context, userID int64) ([]Permission, error)
+	HasAny(ctx context.Context, userID int64, code ...string) (bool, error)
+	Delete(ctx context.Context, code ...string) error
+	Insert(ctx context.Context, code Permission, description string, then database.TxFunc) error
+	GetAll(ctx context.Context) ([]*Definition, error)
+	GetRole(ctx context.Context, name string) (*Role, error)
+	GetRoles(ctx context.Context) ([]*Role, error)
+	InsertRole(ctx context.Context, role *Role, then database.TxFunc) error
+	Scope(ctx context.Context, userID int64, code ...string) (*Scope, error)
+	AssignRole(ctx context.Context, grant *Grant, roleID int64, then database.TxFunc) error
+	RevokeRole(ctx context.Context, userID, roleID, revokedBy int64, then database.TxFunc) error
+	DeleteExpiredGrants(ctx context.Context, now time.Time) (int64, error)
+	GrantsForUser(ctx context.Context, userID int64) ([]*Grant, error)
 }
 
 type UserService interface {
-	GetUser(userID int64) (*user.User, error)
+	GetUser(ctx context.Context, userID int64) (*user.User, error)
+}
+
+// Auditor records who changed the permissions, roles and grants, in the transactions that do so
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
 }
 
 type Service struct {
 	Repo        Repo
 	UserService UserService
+	Auditor     Auditor // optional
 }
 
-func (s *Service) UserHas(v *validator.Validator, u *user.User, code string) (bool, error) {
-	if ValidateCode(v, code); !v.IsValid() {
+// auditTx records the action done to the target in the transaction that does it, nil without an
+// Auditor
+func (s *Service) auditTx(action, targetType string, targetID, before, after any) database.TxFunc {
+	if s.Auditor == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, tx *sql.Tx) error {
+		return s.Auditor.RecordTx(ctx, tx, action, targetType, targetID, before, after)
+	}
+}
+
+// UserHas reports whether the user has any one of the codes, through any of their roles
+func (s *Service) UserHas(
+	ctx context.Context, v *validator.Validator, u *user.User, code ...string,
+) (bool, error) {
+	ctx, span := tracer.Start(ctx, "permission.UserHas")
+	defer span.End()
+
+	for _, c := range code {
+		ValidateCode(v, c)
+	}
+	if !v.IsValid() {
 		return false, validator.ErrFailedValidation
 	}
 
-	userPermissions, err := s.Repo.AllForUser(u.ID)
-	if err != nil {
-		return false, err
+	return s.Repo.HasAny(ctx, u.ID, code...)
+}
+
+// UserScope returns the scope the user has any one of the codes in, nil if they have none of them
+func (s *Service) UserScope(
+	ctx context.Context, v *validator.Validator, u *user.User, code ...string,
+) (*Scope, error) {
+	ctx, span := tracer.Start(ctx, "permission.UserScope")
+	defer span.End()
+
+	for _, c := range code {
+		ValidateCode(v, c)
+	}
+	if !v.IsValid() {
+		return nil, validator.ErrFailedValidation
 	}
 
-	return Includes(userPermissions, code), nil
+	return s.Repo.Scope(ctx, u.ID, code...)
 }
 
-func (s *Service) UserAllPermissions(userID int64) ([]Permission, error) {
-	allPermissions, err := s.Repo.AllForUser(userID)
+func (s *Service) UserAllPermissions(ctx context.Context, userID int64) ([]Permission, error) {
+	ctx, span := tracer.Start(ctx, "permission.UserAllPermissions")
+	defer span.End()
+
+	allPermissions, err := s.Repo.AllForUser(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -44,46 +108,192 @@ func (s *Service) UserAllPermissions(userID int64) ([]Permission, error) {
 	return allPermissions, nil
 }
 
-func (s *Service) GrantUser(v *validator.Validator, userID int64, code string) error {
+func (s *Service) DeletePermission(ctx context.Context, code string) error {
+	ctx, span := tracer.Start(ctx, "permission.DeletePermission")
+	defer span.End()
+
+	return s.Repo.Delete(ctx, code)
+}
+
+func (s *Service) AddNewPermission(
+	ctx context.Context, v *validator.Validator, code, description string,
+) error {
+	ctx, span := tracer.Start(ctx, "permission.AddNewPermission")
+	defer span.End()
+
 	if ValidateCode(v, code); !v.IsValid() {
 		return validator.ErrFailedValidation
 	}
 
-	// verify the user exists
-	u, err := s.UserService.GetUser(userID)
+	added := s.auditTx(
+		audit.ActionPermissionCreated, audit.TargetPermission, code, nil,
+		map[string]any{"code": code, "description": description},
+	)
+	err := s.Repo.Insert(ctx, Permission(code), description, added)
 	if err != nil {
 		return err
 	}
 
-	return s.Repo.Grant(u.ID, code)
+	return nil
 }
 
-func (s *Service) RevokeFromUser(v *validator.Validator, userID int64, code string) error {
-	if ValidateCode(v, code); !v.IsValid() {
+// Permissions returns the permission catalog
+func (s *Service) Permissions(ctx context.Context) ([]*Definition, error) {
+	ctx, span := tracer.Start(ctx, "permission.Permissions")
+	defer span.End()
+
+	return s.Repo.GetAll(ctx)
+}
+
+func (s *Service) Roles(ctx context.Context) ([]*Role, error) {
+	ctx, span := tracer.Start(ctx, "permission.Roles")
+	defer span.End()
+
+	return s.Repo.GetRoles(ctx)
+}
+
+// CreateRole adds a new role. its permissions have to be in the catalog and the roles it inherits
+// have to exist, since roles can't be changed once made this also keeps loops out of the hierarchy
+func (s *Service) CreateRole(ctx context.Context, v *validator.Validator, role *Role) error {
+	ctx, span := tracer.Start(ctx, "permission.CreateRole")
+	defer span.End()
+
+	role.Permissions = slices.Compact(slices.Sorted(slices.Values(role.Permissions)))
+	role.Inherits = slices.Compact(slices.Sorted(slices.Values(role.Inherits)))
+	if ValidateRole(v, role); !v.IsValid() {
 		return validator.ErrFailedValidation
 	}
-	// verify the user exists
-	u, err := s.UserService.GetUser(userID)
+
+	catalog, err := s.Repo.GetAll(ctx)
 	if err != nil {
 		return err
 	}
+	codes := make([]Permission, len(catalog))
+	for i, definition := range catalog {
+		codes[i] = definition.Code
+	}
+	for _, code := range role.Permissions {
+		v.CheckAddError(slices.Contains(codes, code), "permissions", "unknown code "+string(code))
+	}
 
-	return s.Repo.Revoke(u.ID, code)
+	for _, name := range role.Inherits {
+		_, err := s.Repo.GetRole(ctx, name)
+		if err != nil {
+			switch {
+			case errors.Is(err, user.ErrNoRecord):
+				v.AddError("inherits", "unknown role "+name)
+			default:
+				return err
+			}
+		}
+	}
+	if !v.IsValid() {
+		return validator.ErrFailedValidation
+	}
+
+	return s.Repo.InsertRole(
+		ctx, role, s.auditTx(audit.ActionRoleCreated, audit.TargetRole, role.Name, nil, role),
+	)
 }
 
-func (s *Service) DeletePermission(code string) error {
-	return s.Repo.Delete(code)
+// userAndRole looks up the user and the role named, a role that doesn't exist is a validation
+// error while a user that doesn't is user.ErrNoRecord
+func (s *Service) userAndRole(
+	ctx context.Context, v *validator.Validator, userID int64, name string,
+) (*user.User, *Role, error) {
+	if ValidateRoleName(v, name); !v.IsValid() {
+		return nil, nil, validator.ErrFailedValidation
+	}
+
+	u, err := s.UserService.GetUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	role, err := s.Repo.GetRole(ctx, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrNoRecord):
+			v.AddError("role", "does not exist")
+			return nil, nil, validator.ErrFailedValidation
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return u, role, nil
 }
 
-func (s *Service) AddNewPermission(v *validator.Validator, code string) error {
-	if ValidateCode(v, code); !v.IsValid() {
+// AssignRole grants the role to the user in the grant
+func (s *Service) AssignRole(ctx context.Context, v *validator.Validator, grant *Grant) error {
+	ctx, span := tracer.Start(ctx, "permission.AssignRole")
+	defer span.End()
+
+	// a limit given without a currency is in the default one, the currency means nothing without it
+	if grant.MaxAmount == nil {
+		grant.Currency = ""
+	} else {
+		if grant.Currency == "" {
+			grant.Currency = money.DefaultCurrency
+		}
+		maxAmount := grant.MaxAmount.WithCurrency(grant.Currency)
+		grant.MaxAmount = &maxAmount
+	}
+
+	if ValidateGrant(v, grant, time.Now()); !v.IsValid() {
 		return validator.ErrFailedValidation
 	}
 
-	err := s.Repo.Insert(Permission(code))
+	u, role, err := s.userAndRole(ctx, v, grant.UserID, grant.Role)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	grant.UserID = u.ID
+	return s.Repo.AssignRole(
+		ctx, grant, role.ID,
+		s.auditTx(audit.ActionRoleAssigned, audit.TargetUser, grant.UserID, nil, grant),
+	)
+}
+
+// RevokeRole takes the role from the user, user.ErrNoRecord is returned if they didn't have it
+func (s *Service) RevokeRole(
+	ctx context.Context, v *validator.Validator, userID int64, name string, revokedBy int64,
+) error {
+	ctx, span := tracer.Start(ctx, "permission.RevokeRole")
+	defer span.End()
+
+	u, role, err := s.userAndRole(ctx, v, userID, name)
+	if err != nil {
+		return err
+	}
+
+	revoked := s.auditTx(
+		audit.ActionRoleRevoked, audit.TargetUser, u.ID,
+		map[string]any{"user_id": u.ID, "role": name}, nil,
+	)
+	return s.Repo.RevokeRole(ctx, u.ID, role.ID, revokedBy, revoked)
+}
+
+// Grants returns the roles the user has been granted directly, along with their scope and expiry
+func (s *Service) Grants(ctx context.Context, userID int64) ([]*Grant, error) {
+	ctx, span := tracer.Start(ctx, "permission.Grants")
+	defer span.End()
+
+	// verify the user exists
+	u, err := s.UserService.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Repo.GrantsForUser(ctx, u.ID)
+}
+
+// DeleteExpiredGrants removes the grants that have expired. they already stopped counting when
+// they expired, this only records that they are gone and keeps the table small
+func (s *Service) DeleteExpiredGrants(ctx context.Context) (int64, error) {
+	ctx, span := tracer.Start(ctx, "permission.DeleteExpiredGrants")
+	defer span.End()
+
+	return s.Repo.DeleteExpiredGrants(ctx, time.Now())
 }
@@ -1,9 +1,12 @@
 package permission
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/database"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
@@ -12,41 +15,103 @@ type MockRepo struct {
 	AllForUserResult []Permission
 	AllForUserErr    error
 
-	GrantErr  error
-	RevokeErr error
-	DeleteErr error
-	InsertErr error
+	HasAnyResult bool
+	HasAnyErr    error
+
+	GetAllResult []*Definition
+	GetAllErr    error
+
+	GetRoleResult map[string]*Role
+	GetRoleErr    error
+
+	DeleteErr     error
+	InsertErr     error
+	InsertRoleErr error
+	AssignRoleErr error
+	RevokeRoleErr error
+
+	ScopeResult *Scope
+	ScopeErr    error
 }
 
-func (r *MockRepo) AllForUser(userID int64) ([]Permission, error) {
+func (r *MockRepo) AllForUser(ctx context.Context, userID int64) ([]Permission, error) {
 	if r.AllForUserErr != nil {
 		return nil, r.AllForUserErr
 	}
 	return r.AllForUserResult, nil
 }
 
-func (r *MockRepo) Grant(userID int64, code ...string) error {
-	return r.GrantErr
+func (r *MockRepo) HasAny(ctx context.Context, userID int64, code ...string) (bool, error) {
+	return r.HasAnyResult, r.HasAnyErr
 }
 
-func (r *MockRepo) Revoke(userID int64, code ...string) error {
-	return r.RevokeErr
-}
-
-func (r *MockRepo) Delete(code ...string) error {
+func (r *MockRepo) Delete(ctx context.Context, code ...string) error {
 	return r.DeleteErr
 }
 
-func (r *MockRepo) Insert(code Permission) error {
+func (r *MockRepo) Insert(
+	ctx context.Context, code Permission, description string, then database.TxFunc,
+) error {
 	return r.InsertErr
 }
 
+func (r *MockRepo) GetAll(ctx context.Context) ([]*Definition, error) {
+	return r.GetAllResult, r.GetAllErr
+}
+
+func (r *MockRepo) GetRole(ctx context.Context, name string) (*Role, error) {
+	if r.GetRoleErr != nil {
+		return nil, r.GetRoleErr
+	}
+	role, ok := r.GetRoleResult[name]
+	if !ok {
+		return nil, user.ErrNoRecord
+	}
+	return role, nil
+}
+
+func (r *MockRepo) GetRoles(ctx context.Context) ([]*Role, error) {
+	roles := []*Role{}
+	for _, role := range r.GetRoleResult {
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func (r *MockRepo) InsertRole(ctx context.Context, role *Role, then database.TxFunc) error {
+	return r.InsertRoleErr
+}
+
+func (r *MockRepo) Scope(ctx context.Context, userID int64, code ...string) (*Scope, error) {
+	return r.ScopeResult, r.ScopeErr
+}
+
+func (r *MockRepo) AssignRole(
+	ctx context.Context, grant *Grant, roleID int64, then database.TxFunc,
+) error {
+	return r.AssignRoleErr
+}
+
+func (r *MockRepo) RevokeRole(
+	ctx context.Context, userID, roleID, revokedBy int64, then database.TxFunc,
+) error {
+	return r.RevokeRoleErr
+}
+
+func (r *MockRepo) DeleteExpiredGrants(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (r *MockRepo) GrantsForUser(ctx context.Context, userID int64) ([]*Grant, error) {
+	return []*Grant{}, nil
+}
+
 type MockUserService struct {
 	GetUserResult *user.User
 	GetUserErr    error
 }
 
-func (us *MockUserService) GetUser(userID int64) (*user.User, error) {
+func (us *MockUserService) GetUser(ctx context.Context, userID int64) (*user.User, error) {
 	if us.GetUserErr != nil {
 		return nil, us.GetUserErr
 	}
@@ -54,43 +119,40 @@ func (us *MockUserService) GetUser(userID int64) (*user.User, error) {
 }
 
 func TestUserHas(t *testing.T) {
-	mockPermissions := []Permission{"ADMIN", "SUPERUSER"}
 	tests := []struct {
 		name        string
 		setupRepo   func(*MockRepo)
-		code        string
+		code        []string
 		wantOutput  bool
 		expectedErr error
 	}{
 		{
 			name: "valid",
 			setupRepo: func(r *MockRepo) {
-				r.AllForUserResult = mockPermissions
+				r.HasAnyResult = true
 			},
-			code:       "ADMIN",
+			code:       []string{"ADMIN", "SUPERUSER"},
 			wantOutput: true,
 		},
 		{
-			name: "user doesn't have permission",
-			setupRepo: func(r *MockRepo) {
-				r.AllForUserResult = mockPermissions
-			},
-			code:       "APPROVE_LOANS",
+			name:       "user doesn't have permission",
+			setupRepo:  func(r *MockRepo) {},
+			code:       []string{"APPROVE_LOANS"},
 			wantOutput: false,
 		},
 		{
-			name: "AllForUser failure",
+			name: "HasAny failure",
 			setupRepo: func(r *MockRepo) {
-				r.AllForUserErr = user.ErrNoRecord
+				r.HasAnyErr = user.ErrNoRecord
 			},
-			code:        "DELETE_LOANS",
+			code:        []string{"DELETE_LOANS"},
 			wantOutput:  false,
 			expectedErr: user.ErrNoRecord,
 		},
 		{
 			name:        "unsafe code",
 			setupRepo:   func(r *MockRepo) {},
-			code:        "code3",
+			code:        []string{"ADMIN", "code3"},
 			wantOutput:  false,
 			expectedErr: validator.ErrFailedValidation,
 		},
@@ -106,7 +168,7 @@ func TestUserHas(t *testing.T) {
 			}
 
 			v := validator.New()
-			has, gotErr := svc.UserHas(v, &user.User{}, tc.code)
+			has, gotErr := svc.UserHas(context.Background(), v, &user.User{}, tc.code...)
 			if tc.expectedErr != nil {
 				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
 					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
@@ -123,7 +185,97 @@ func TestUserHas(t *testing.T) {
 	}
 }
 
-func TestGrantUser(t *testing.T) {
+func TestCreateRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		role        *Role
+		expectedErr error
+		wantErrors  map[string]string
+	}{
+		{
+			name:      "valid",
+			setupRepo: func(r *MockRepo) {},
+			role: &Role{
+				Name: "auditor", Permissions: []Permission{"VIEW_LEDGER", "VIEW_LEDGER"},
+				Inherits: []string{"teller"},
+			},
+		},
+		{
+			name:        "no permissions",
+			setupRepo:   func(r *MockRepo) {},
+			role:        &Role{Name: "auditor"},
+			expectedErr: validator.ErrFailedValidation,
+			wantErrors: map[string]string{
+				"permissions": "a role must have a permission or inherit another role",
+			},
+		},
+		{
+			name:      "unknown code",
+			setupRepo: func(r *MockRepo) {},
+			role: &Role{
+				Name: "auditor", Permissions: []Permission{"VIEW_ACCOUNTS"},
+			},
+			expectedErr: validator.ErrFailedValidation,
+			wantErrors:  map[string]string{"permissions": "unknown code VIEW_ACCOUNTS"},
+		},
+		{
+			name:      "unknown role inherited",
+			setupRepo: func(r *MockRepo) {},
+			role: &Role{
+				Name: "auditor", Inherits: []string{"cashier"},
+			},
+			expectedErr: validator.ErrFailedValidation,
+			wantErrors:  map[string]string{"inherits": "unknown role cashier"},
+		},
+		{
+			name: "duplicate name",
+			setupRepo: func(r *MockRepo) {
+				r.InsertRoleErr = ErrDuplicateRole
+			},
+			role: &Role{
+				Name: "teller", Permissions: []Permission{"VIEW_LEDGER"},
+			},
+			expectedErr: ErrDuplicateRole,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{
+				GetAllResult:  []*Definition{{Code: "VIEW_LEDGER"}, {Code: "DEPOSIT"}},
+				GetRoleResult: map[string]*Role{"teller": {ID: 1, Name: "teller"}},
+			}
+			tc.setupRepo(repo)
+
+			svc := Service{
+				Repo: repo,
+			}
+
+			v := validator.New()
+			gotErr := svc.CreateRole(context.Background(), v, tc.role)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				for key, val := range tc.wantErrors {
+					if v.Errors[key] != val {
+						t.Errorf("expected message=%s for key=%v, got %s", val, key, v.Errors[key])
+					}
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error %v", gotErr)
+			}
+
+			if len(tc.role.Permissions) != 1 {
+				t.Errorf("expected the permissions without duplicates, got %v", tc.role.Permissions)
+			}
+		})
+	}
+}
+
+func TestAssignRole(t *testing.T) {
 	mockUser := &user.User{
 		ID:    1,
 		Name:  "yusuf",
@@ -133,7 +285,7 @@ func TestGrantUser(t *testing.T) {
 		name             string
 		setupRepo        func(*MockRepo)
 		setupUserService func(*MockUserService)
-		code             string
+		role             string
 		expectedErr      error
 	}{
 		{
@@ -142,40 +294,51 @@ func TestGrantUser(t *testing.T) {
 			setupUserService: func(us *MockUserService) {
 				us.GetUserResult = mockUser
 			},
-			code: "ADMIN",
+			role: "admin",
 		},
 		{
-			name:             "unsafe code",
+			name:             "invalid role name",
 			setupRepo:        func(r *MockRepo) {},
 			setupUserService: func(us *MockUserService) {},
-			code:             "random",
+			role:             "Super-Admin",
 			expectedErr:      validator.ErrFailedValidation,
 		},
+		{
+			name:      "unknown role",
+			setupRepo: func(r *MockRepo) {},
+			setupUserService: func(us *MockUserService) {
+				us.GetUserResult = mockUser
+			},
+			role:        "cashier",
+			expectedErr: validator.ErrFailedValidation,
+		},
 		{
 			name:      "GetUser failure",
 			setupRepo: func(r *MockRepo) {},
 			setupUserService: func(us *MockUserService) {
 				us.GetUserErr = errors.New("db GetUser error")
 			},
-			code:        "ADMIN",
+			role:        "admin",
 			expectedErr: errors.New("db GetUser error"),
 		},
 		{
 			name: "Grant failure",
 			setupRepo: func(r *MockRepo) {
-				r.GrantErr = errors.New("db Grant error")
+				r.AssignRoleErr = errors.New("db AssignRole error")
 			},
 			setupUserService: func(us *MockUserService) {
 				us.GetUserResult = mockUser
 			},
-			code:        "ADMIN",
-			expectedErr: errors.New("db Grant error"),
+			role:        "admin",
+			expectedErr: errors.New("db AssignRole error"),
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			repo := &MockRepo{}
+			repo := &MockRepo{
+				GetRoleResult: map[string]*Role{"admin": {ID: 1, Name: "admin"}},
+			}
 			userSvc := &MockUserService{}
 			tc.setupRepo(repo)
 			tc.setupUserService(userSvc)
@@ -186,7 +349,9 @@ func TestGrantUser(t *testing.T) {
 			}
 
 			v := validator.New()
-			gotErr := svc.GrantUser(v, mockUser.ID, tc.code)
+			gotErr := svc.AssignRole(context.Background(), v, &Grant{
+				UserID: mockUser.ID, Role: tc.role, Reason: "covering the teller desk",
+			})
 			if tc.expectedErr != nil {
 				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
 					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
@@ -199,7 +364,7 @@ func TestGrantUser(t *testing.T) {
 	}
 }
 
-func TestRevokeFromUser(t *testing.T) {
+func TestRevokeRole(t *testing.T) {
 	mockUser := &user.User{
 		ID:    1,
 		Name:  "yusuf",
@@ -209,7 +374,7 @@ func TestRevokeFromUser(t *testing.T) {
 		name             string
 		setupRepo        func(*MockRepo)
 		setupUserService func(*MockUserService)
-		code             string
+		role             string
 		expectedErr      error
 	}{
 		{
@@ -218,40 +383,51 @@ func TestRevokeFromUser(t *testing.T) {
 			setupUserService: func(us *MockUserService) {
 				us.GetUserResult = mockUser
 			},
-			code: "ADMIN",
+			role: "admin",
 		},
 		{
-			name:             "unsafe code",
+			name:             "invalid role name",
 			setupRepo:        func(r *MockRepo) {},
 			setupUserService: func(us *MockUserService) {},
-			code:             "random",
+			role:             "Super-Admin",
 			expectedErr:      validator.ErrFailedValidation,
 		},
+		{
+			name:      "unknown role",
+			setupRepo: func(r *MockRepo) {},
+			setupUserService: func(us *MockUserService) {
+				us.GetUserResult = mockUser
+			},
+			role:        "cashier",
+			expectedErr: validator.ErrFailedValidation,
+		},
 		{
 			name:      "GetUser failure",
 			setupRepo: func(r *MockRepo) {},
 			setupUserService: func(us *MockUserService) {
 				us.GetUserErr = errors.New("db GetUser error")
 			},
-			code:        "ADMIN",
+			role:        "admin",
 			expectedErr: errors.New("db GetUser error"),
 		},
 		{
 			name: "Revoke failure",
 			setupRepo: func(r *MockRepo) {
-				r.RevokeErr = errors.New("db Revoke error")
+				r.RevokeRoleErr = errors.New("db RevokeRole error")
 			},
 			setupUserService: func(us *MockUserService) {
 				us.GetUserResult = mockUser
 			},
-			code:        "ADMIN",
-			expectedErr: errors.New("db Revoke error"),
+			role:        "admin",
+			expectedErr: errors.New("db RevokeRole error"),
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			repo := &MockRepo{}
+			repo := &MockRepo{
+				GetRoleResult: map[string]*Role{"admin": {ID: 1, Name: "admin"}},
+			}
 			userSvc := &MockUserService{}
 			tc.setupRepo(repo)
 			tc.setupUserService(userSvc)
@@ -262,7 +438,7 @@ func TestRevokeFromUser(t *testing.T) {
 			}
 
 			v := validator.New()
-			gotErr := svc.RevokeFromUser(v, mockUser.ID, tc.code)
+			gotErr := svc.RevokeRole(context.Background(), v, mockUser.ID, tc.role, 2)
 			if tc.expectedErr != nil {
 				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
 					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
@@ -313,7 +489,7 @@ func TestAddNewPermission(t *testing.T) {
 			}
 
 			v := validator.New()
-			gotErr := svc.AddNewPermission(v, tc.code)
+			gotErr := svc.AddNewPermission(context.Background(), v, tc.code, "")
 			if tc.expectedErr != nil {
 				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
 					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
@@ -9,16 +9,48 @@ import (
 const (
 	ScopeActivation    = "activation"
 	ScopeAuthorization = "authorization"
+	ScopePasswordReset = "password-reset"
+	// ScopeMFAPending is given to users with two-factor authentication once their password is
+	// checked, it can only be swapped for an authorization token along with a code
+	ScopeMFAPending = "mfa-pending"
+	// ScopeRefresh tokens are swapped for a new access token and a new refresh token once the
+	// access token expires, each one can only be used once
+	ScopeRefresh = "refresh"
+	// ScopeUnlock tokens are emailed to users whose account was locked by failed logins
+	ScopeUnlock = "unlock"
+)
+
+// how long access and refresh tokens last, unless the service is given other values
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
 )
 
 type Token struct {
-	ID        int64
-	CreatedAt time.Time
-	Expiry    time.Time
-	UserID    int64
-	Plaintext string
-	hash      []byte
-	Scope     string
+	ID         int64
+	CreatedAt  time.Time
+	Expiry     time.Time
+	UserID     int64
+	Plaintext  string
+	hash       []byte
+	Scope      string
+	LastUsedAt *time.Time
+	IP         string
+	UserAgent  string
+	FamilyID   int64      // the tokens of one sign in share a family, rotation keeps it
+	UsedAt     *time.Time // when a refresh token was swapped for new tokens
+}
+
+// Session is a token family as the user sees it, without anything that could be used to sign in
+// with it. Current is set on the one the listing was asked for with
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Expiry     time.Time  `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
 }
 
 func ValidateToken(v *validator.Validator, tokenPlaintext string) {
@@ -5,19 +5,31 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
 )
 
-var ErrInvaildToken = errors.New("invalid token")
+var (
+	ErrInvaildToken = errors.New("invalid token")
+	ErrTokenReused  = errors.New("refresh token already used")
+)
 
 type Repository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// querier is what inserting a token needs, so it can be done on its own or inside a transaction
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
-func (r *Repository) Insert(token *Token) error {
+// insert inserts the token, a token without a family starts a new one
+func insert(ctx context.Context, q querier, token *Token) error {
 	query := `
-		INSERT INTO tokens  (user_id, hash, expiry, scope)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at
+		INSERT INTO tokens  (user_id, hash, expiry, scope, ip, user_agent, last_used_at, family_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE(NULLIF($8, 0), nextval('token_families_id_seq')))
+		RETURNING id, created_at, family_id
 	`
 
 	args := []any{
@@ -25,22 +37,182 @@ func (r *Repository) Insert(token *Token) error {
 		token.hash,
 		token.Expiry,
 		token.Scope,
+		token.IP,
+		token.UserAgent,
+		token.LastUsedAt,
+		token.FamilyID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	return q.QueryRowContext(ctx, query, args...).Scan(
+		&token.ID,
+		&token.CreatedAt,
+		&token.FamilyID,
+	)
+}
+
+func (r *Repository) Insert(ctx context.Context, token *Token) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
-	return r.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+	return insert(ctx, r.DB, token)
+}
+
+// NewTx makes a token for the user and inserts it inside a transaction owned by the caller, so
+// that it is only kept if whatever it was made for is, e.g. the user an activation token is for
+func NewTx(
+	ctx context.Context, tx *sql.Tx, userID int64, timeToLive time.Duration, scope string,
+) (*Token, error) {
+	token, err := generateToken(userID, timeToLive, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insert(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// InsertFamilyTx inserts the tokens in one transaction, all in the family of the first one
+func (r *Repository) InsertFamilyTx(ctx context.Context, tokens ...*Token) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, token := range tokens {
+		token.FamilyID = tokens[0].FamilyID
+		err = insert(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RotateTx swaps the refresh token with the plaintext for the new tokens, which join its family.
+// the refresh token is kept, marked as used, and the access tokens it was issued with are
+// deleted. a refresh token that was used before means it was stolen, either by whoever used it
+// first or whoever is using it now, so the whole family is deleted and ErrTokenReused returned
+func (r *Repository) RotateTx(ctx context.Context, tokenPlaintext string, tokens ...*Token) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, user_id, family_id, expiry, used_at
+		FROM tokens
+		WHERE hash = $1
+		AND scope = $2
+		FOR UPDATE
+	`
+
+	var refresh Token
+	err = tx.QueryRowContext(ctx, query, hashPlaintext(tokenPlaintext), ScopeRefresh).Scan(
+		&refresh.ID,
+		&refresh.UserID,
+		&refresh.FamilyID,
+		&refresh.Expiry,
+		&refresh.UsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrInvaildToken
+		default:
+			return err
+		}
+	}
+
+	if refresh.UsedAt != nil {
+		_, err = tx.ExecContext(ctx, "DELETE FROM tokens WHERE family_id = $1", refresh.FamilyID)
+		if err != nil {
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		return ErrTokenReused
+	}
+	if !refresh.Expiry.After(time.Now()) {
+		return ErrInvaildToken
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE tokens SET used_at = NOW() WHERE id = $1", refresh.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx, "DELETE FROM tokens WHERE family_id = $1 AND scope = $2", refresh.FamilyID,
+		ScopeAuthorization,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		token.UserID = refresh.UserID
+		token.FamilyID = refresh.FamilyID
+		err = insert(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (r *Repository) DeleteAllForUser(userID int64, scope string) error {
+// DeleteFamily deletes the token of the user with the plaintext and every other token in its
+// family, which signs out the session it belongs to. ErrInvaildToken is returned if there is no
+// such token
+func (r *Repository) DeleteFamily(ctx context.Context, userID int64, tokenPlaintext string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1
+		AND family_id = (SELECT family_id FROM tokens WHERE user_id = $1 AND hash = $2)
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	result, err := r.DB.ExecContext(ctx, query, userID, hashPlaintext(tokenPlaintext))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvaildToken
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteAllForUser(ctx context.Context, userID int64, scope string) error {
 	query := `
 		DELETE FROM tokens
 		WHERE user_id = $1
 		AND scope = $2
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	_, err := r.DB.ExecContext(ctx, query, userID, scope)
@@ -50,3 +222,117 @@ func (r *Repository) DeleteAllForUser(userID int64, scope string) error {
 
 	return nil
 }
+
+// DeleteSessions deletes the authorization and refresh tokens of the user together, then runs then
+// in the same transaction if it is given
+func (r *Repository) DeleteSessions(ctx context.Context, userID int64, then database.TxFunc) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1
+		AND scope IN ($2, $3)
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, userID, ScopeAuthorization, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Touch records that the authorization token was used, from where and by what. it is called on
+// every authenticated request, so the row is only written once a minute at most
+func (r *Repository) Touch(ctx context.Context, tokenPlaintext, ip, userAgent string) error {
+	query := `
+		UPDATE tokens
+		SET last_used_at = NOW(), ip = $2, user_agent = $3
+		WHERE hash = $1
+		AND scope = $4
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`
+	args := []any{
+		hashPlaintext(tokenPlaintext),
+		ip,
+		userAgent,
+		ScopeAuthorization,
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetSessions returns the sessions of the user that are still signed in, the most recently used
+// first. a session is a family of access and refresh tokens, it is shown with where it was last
+// used from. the one with currentPlaintext, if any, is marked as the current session
+func (r *Repository) GetSessions(
+	ctx context.Context, userID int64, currentPlaintext string,
+) ([]*Session, error) {
+	query := `
+		SELECT family_id, MIN(created_at), MAX(expiry), MAX(last_used_at),
+			(ARRAY_AGG(ip ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC))[1],
+			(ARRAY_AGG(user_agent ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC))[1],
+			BOOL_OR(hash = $2)
+		FROM tokens
+		WHERE user_id = $1
+		AND scope IN ($3, $4)
+		AND expiry > NOW()
+		AND used_at IS NULL
+		GROUP BY family_id
+		ORDER BY COALESCE(MAX(last_used_at), MIN(created_at)) DESC, family_id DESC
+	`
+	args := []any{
+		userID,
+		hashPlaintext(currentPlaintext),
+		ScopeAuthorization,
+		ScopeRefresh,
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err = rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.Expiry,
+			&session.LastUsedAt,
+			&session.IP,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
@@ -1,14 +1,32 @@
 package token
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/base32"
 	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/token")
+
+// Auditor records who signed a user out of every session, in the transaction that does it
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
+}
+
 type Service struct {
-	Repo *Repository
+	Repo       *Repository
+	AccessTTL  time.Duration // DefaultAccessTTL if not set
+	RefreshTTL time.Duration // DefaultRefreshTTL if not set
+	Auditor    Auditor       // optional, users signing themselves out aren't audited
 }
 
 func generateToken(userID int64, timeToLive time.Duration, scope string) (*Token, error) {
@@ -29,34 +47,156 @@ func generateToken(userID int64, timeToLive time.Duration, scope string) (*Token
 	// encode it to base 32, it might have '=' at the end so we remove it with base32.NoPadding
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	// hash the plaintext with sha256
-	hash := sha256.Sum256([]byte(token.Plaintext))
-
-	// make it slice and store it
-	token.hash = hash[:]
+	// hash the plaintext with sha256 and store it
+	token.hash = hashPlaintext(token.Plaintext)
 
 	return &token, nil
 }
 
-func (s *Service) DeleteAllForUser(userID int64, scope string) error {
-	return s.Repo.DeleteAllForUser(userID, scope)
+// hashPlaintext returns the sha256 hash tokens are stored and looked up by
+func hashPlaintext(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
 }
 
-func (s *Service) New(userID int64, timeToLive time.Duration, scope string) (*Token, error) {
+func (s *Service) DeleteAllForUser(ctx context.Context, userID int64, scope string) error {
+	ctx, span := tracer.Start(ctx, "token.DeleteAllForUser")
+	defer span.End()
+
+	return s.Repo.DeleteAllForUser(ctx, userID, scope)
+}
+
+func (s *Service) New(
+	ctx context.Context, userID int64, timeToLive time.Duration, scope string,
+) (*Token, error) {
+	ctx, span := tracer.Start(ctx, "token.New")
+	defer span.End()
+
 	token, err := generateToken(userID, timeToLive, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.Repo.Insert(token)
+	err = s.Repo.Insert(ctx, token)
 	return token, err
 }
 
-func (s *Service) AuthorizationToken(userID int64) (*Token, error) {
-	token, err := s.New(userID, 24*time.Hour, ScopeAuthorization)
+// newPair generates an access token and a refresh token for the user
+func (s *Service) newPair(
+	ctx context.Context, userID int64, ip, userAgent string,
+) (access, refresh *Token, err error) {
+	accessTTL, refreshTTL := s.AccessTTL, s.RefreshTTL
+	if accessTTL == 0 {
+		accessTTL = DefaultAccessTTL
+	}
+	if refreshTTL == 0 {
+		refreshTTL = DefaultRefreshTTL
+	}
+
+	access, err = generateToken(userID, accessTTL, ScopeAuthorization)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	refresh, err = generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.IP = ip
+		token.UserAgent = userAgent
+	}
+
+	return access, refresh, nil
+}
+
+// AuthorizationToken signs the user in with a short lived access token and a refresh token to get
+// new ones with. ip and userAgent are where the request came from and are shown in the sessions of
+// the user
+func (s *Service) AuthorizationToken(
+	ctx context.Context, userID int64, ip, userAgent string,
+) (access, refresh *Token, err error) {
+	ctx, span := tracer.Start(ctx, "token.AuthorizationToken")
+	defer span.End()
+
+	access, refresh, err = s.newPair(ctx, userID, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.Repo.InsertFamilyTx(ctx, access, refresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// Refresh swaps the refresh token for a new access token and a new refresh token.
+// ErrInvaildToken is returned if it doesn't exist or has expired and ErrTokenReused if it was
+// already used, in which case the session it belongs to is signed out
+func (s *Service) Refresh(
+	ctx context.Context, tokenPlaintext, ip, userAgent string,
+) (access, refresh *Token, err error) {
+	ctx, span := tracer.Start(ctx, "token.Refresh")
+	defer span.End()
+
+	// the user is only known once the refresh token is found, RotateTx fills it in
+	access, refresh, err = s.newPair(ctx, 0, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	// refreshing is using the session, the new access token is shown as used from here
+	now := time.Now()
+	access.LastUsedAt = &now
+
+	err = s.Repo.RotateTx(ctx, tokenPlaintext, access, refresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// Revoke signs out the session the token of the user belongs to
+func (s *Service) Revoke(ctx context.Context, userID int64, tokenPlaintext string) error {
+	ctx, span := tracer.Start(ctx, "token.Revoke")
+	defer span.End()
+
+	return s.Repo.DeleteFamily(ctx, userID, tokenPlaintext)
+}
+
+// RevokeAll signs the user out of every session
+func (s *Service) RevokeAll(ctx context.Context, userID int64) error {
+	ctx, span := tracer.Start(ctx, "token.RevokeAll")
+	defer span.End()
+
+	var revoked database.TxFunc
+	if s.Auditor != nil {
+		revoked = func(ctx context.Context, tx *sql.Tx) error {
+			return s.Auditor.RecordTx(
+				ctx, tx, audit.ActionSessionsRevoked, audit.TargetUser, userID, nil, nil,
+			)
+		}
+	}
+
+	return s.Repo.DeleteSessions(ctx, userID, revoked)
+}
+
+// Touch records that the access token was just used
+func (s *Service) Touch(ctx context.Context, tokenPlaintext, ip, userAgent string) error {
+	ctx, span := tracer.Start(ctx, "token.Touch")
+	defer span.End()
+
+	return s.Repo.Touch(ctx, tokenPlaintext, ip, userAgent)
+}
+
+// Sessions returns the sessions the user is signed in with
+func (s *Service) Sessions(
+	ctx context.Context, userID int64, currentPlaintext string,
+) ([]*Session, error) {
+	ctx, span := tracer.Start(ctx, "token.Sessions")
+	defer span.End()
 
-	return token, nil
+	return s.Repo.GetSessions(ctx, userID, currentPlaintext)
 }
@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// the exporters spans can be sent with
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout" // for local development, spans are printed as they end
+	ExporterOTLP   = "otlp"
+)
+
+var ErrUnknownExporter = errors.New("unknown exporter")
+
+type Config struct {
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector. empty, the OTEL_EXPORTER_OTLP_*
+	// environment variables are used, and failing those localhost:4318
+	Endpoint string
+	Insecure bool // send to the collector over HTTP instead of HTTPS
+	// SampleRatio is the share of traces started here that are kept, from 0 to 1. traces started
+	// by the caller are kept if the caller kept them
+	SampleRatio float64
+}
+
+// Setup makes the exporter of cfg the one spans of the whole service, called service at version,
+// are sent with, stdout being where the stdout exporter writes. it returns the function that sends
+// the spans that haven't been yet and stops it, to call before exiting. with ExporterNone spans
+// aren't recorded at all
+func Setup(
+	cfg Config, service, version string, stdout io.Writer,
+) (func(context.Context) error, error) {
+	// the trace context of requests is passed on whether the spans are recorded or not
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var processor sdktrace.SpanProcessor
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(stdout))
+		if err != nil {
+			return nil, err
+		}
+		// printed as soon as they end rather than in batches, it's someone watching them locally
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), opts...)
+		if err != nil {
+			return nil, err
+		}
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownExporter, cfg.Exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(service),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name        string
+		exporter    string
+		expectedErr error
+		wantSpan    bool
+	}{
+		{name: "none", exporter: ExporterNone},
+		{name: "not set", exporter: ""},
+		{name: "stdout", exporter: ExporterStdout, wantSpan: true},
+		{name: "unknown", exporter: "jaeger", expectedErr: ErrUnknownExporter},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			cfg := Config{Exporter: tc.exporter, SampleRatio: 1}
+			shutdown, gotErr := Setup(cfg, "gobank-test", "1.0.0", &out)
+			if !errors.Is(gotErr, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+			}
+			if gotErr != nil {
+				return
+			}
+
+			_, span := otel.Tracer("test").Start(context.Background(), "test.Span")
+			span.End()
+			if err := shutdown(context.Background()); err != nil {
+				t.Fatalf("shutdown: unexpected error %v", err)
+			}
+
+			got := out.String()
+			if tc.wantSpan != strings.Contains(got, `"Name":"test.Span"`) {
+				t.Errorf("expected the span printed %v, got %q", tc.wantSpan, got)
+			}
+			if tc.wantSpan && !strings.Contains(got, "gobank-test") {
+				t.Errorf("expected the service name in the span, got %q", got)
+			}
+		})
+	}
+}
@@ -3,21 +3,26 @@ package transaction
 import (
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
+// SortSafelist are the values the deposits and withdrawals of a user can be sorted by
+var SortSafelist = []string{"id", "created_at", "amount", "-id", "-created_at", "-amount"}
+
 type Transaction struct {
 	ID          int64
 	CreatedAt   time.Time
 	UserID      int64
+	AccountID   int64
 	Action      string
-	Amount      float64
+	Amount      money.Amount
 	PerformedBy string
 }
 
 func ValidateTransaction(v *validator.Validator, transaction *Transaction) {
-	v.CheckAddError(transaction.Amount != 0, "amount", "must be given")
-	v.CheckAddError(transaction.Amount > 0, "amount", "must be more than 0")
+	v.CheckAddError(!transaction.Amount.IsZero(), "amount", "must be given")
+	v.CheckAddError(transaction.Amount.IsPositive(), "amount", "must be more than 0")
 
 	safeActions := []string{
 		"DEPOSIT",
@@ -3,31 +3,132 @@ package transaction
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
 )
 
 type Repository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (r *Repository) Insert(transaction *Transaction) error {
+// Insert records the deposit or withdrawal together with the ledger entry that moves the money, in
+// one transaction, so there is never a transaction without its entry or the other way round. then,
+// if given, is run last in the transaction
+func (r *Repository) Insert(
+	ctx context.Context, transaction *Transaction, entry *ledger.Entry, then database.TxFunc,
+) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the entry locks the account, before the foreign key on the transactions row would
+	err = ledger.InsertTx(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO transactions (user_id, action, amount, performed_by)
-		VALUES ($1, $2, $3, $4)	
+		INSERT INTO transactions (user_id, account_id, action, amount, performed_by)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at
 	`
 	args := []any{
 		transaction.UserID,
+		transaction.AccountID,
 		transaction.Action,
 		transaction.Amount,
 		transaction.PerformedBy,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	return r.DB.QueryRowContext(ctx, query, args...).Scan(
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
 		&transaction.ID,
 		&transaction.CreatedAt,
 	)
+	if err != nil {
+		return err
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForUser returns a page of the deposits and withdrawals on the accounts of the user.
+// deposits are in and withdrawals are out
+func (r *Repository) GetAllForUser(
+	ctx context.Context, userID int64, f filter.Filters,
+) ([]*Transaction, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, user_id, account_id, action, amount, performed_by
+		FROM transactions
+		WHERE user_id = $1
+		AND ($2::timestamptz IS NULL OR created_at >= $2)
+		AND ($3::timestamptz IS NULL OR created_at < $3)
+		AND ($4::decimal IS NULL OR amount >= $4)
+		AND ($5::decimal IS NULL OR amount <= $5)
+		AND ($6 = '' OR ($6 = 'in' AND action = 'DEPOSIT') OR ($6 = 'out' AND action = 'WITHDRAW'))
+		ORDER BY %s %s, id ASC
+		LIMIT $7 OFFSET $8
+	`, f.SortColumn(), f.SortDirection())
+	args := []any{
+		userID,
+		f.From,
+		f.To,
+		f.MinAmount,
+		f.MaxAmount,
+		f.Direction,
+		f.Limit(),
+		f.Offset(),
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	transactions := []*Transaction{}
+	for rows.Next() {
+		var transaction Transaction
+		err = rows.Scan(
+			&totalRecords,
+			&transaction.ID,
+			&transaction.CreatedAt,
+			&transaction.UserID,
+			&transaction.AccountID,
+			&transaction.Action,
+			&transaction.Amount,
+			&transaction.PerformedBy,
+		)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+
+		transactions = append(transactions, &transaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return transactions, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
 }
@@ -1,92 +1,237 @@
 package transaction
 
 import (
-	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/Yusufdot101/goBankBackend/internal/webhook"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/transaction")
+
 type Repo interface {
-	Insert(transaction *Transaction) error
+	Insert(
+		ctx context.Context, transaction *Transaction, entry *ledger.Entry, then database.TxFunc,
+	) error
+	GetAllForUser(
+		ctx context.Context, userID int64, f filter.Filters,
+	) ([]*Transaction, filter.Metadata, error)
+}
+
+type AccountService interface {
+	GetAccountByNumber(
+		ctx context.Context, v *validator.Validator, number string,
+	) (*account.Account, error)
+}
+
+// Publisher queues the webhooks of deposits and withdrawals, in the transaction that makes them
+type Publisher interface {
+	PublishTx(ctx context.Context, tx *sql.Tx, event string, data map[string]any) error
+}
+
+// Notifier tells users about deposits and withdrawals, inside the transaction that makes them
+type Notifier interface {
+	NotifyTx(
+		ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+	) error
 }
 
-type UserService interface {
-	GetUser(userID int64) (*user.User, error)
-	UpdateUser(
-		userID int64, userName, userEmail string, userPasswordHash []byte,
-		userAccountBalance float64, userActivated bool,
-	) (*user.User, error)
+// Auditor records who made a deposit or withdrawal, in the transaction that makes it
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
 }
 
 type Service struct {
-	Repo        Repo
-	UserService UserService
+	Repo           Repo
+	AccountService AccountService
+	Webhooks       Publisher // optional
+	Notifier       Notifier  // optional
+	Auditor        Auditor   // optional
+	// MaxAmounts is the most the one performing the transaction can deposit or withdraw at once in
+	// each currency they are limited in, they can't move any other currency. nil is no limit
+	MaxAmounts map[money.Currency]money.Amount
 }
 
+// events are what a deposit or withdrawal is known as to notifications, webhooks and the audit log
+type events struct {
+	notification, webhook, audit string
+}
+
+var (
+	depositEvents    = events{notification.EventDeposit, webhook.EventDeposit, audit.ActionDeposit}
+	withdrawalEvents = events{
+		notification.EventWithdrawal, webhook.EventWithdrawal, audit.ActionWithdrawal,
+	}
+)
+
+// recordedTx is run in the transaction of the deposit or withdrawal into the account a once it is
+// recorded. the owner of a is told about it, its webhooks are queued and it is audited
+func (s *Service) recordedTx(
+	e events, transaction *Transaction, a *account.Account,
+) database.TxFunc {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		if s.Notifier != nil {
+			data := notification.AmountData(transaction.Amount, a.Number)
+			err := s.Notifier.NotifyTx(ctx, tx, transaction.UserID, e.notification, data)
+			if err != nil {
+				return err
+			}
+		}
+
+		if s.Webhooks != nil {
+			err := s.Webhooks.PublishTx(ctx, tx, e.webhook, map[string]any{
+				"transaction_id": transaction.ID,
+				"created_at":     transaction.CreatedAt,
+				"user_id":        transaction.UserID,
+				"account_number": a.Number,
+				"amount":         transaction.Amount.String(),
+				"currency":       transaction.Amount.Currency(),
+				"performed_by":   transaction.PerformedBy,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if s.Auditor == nil {
+			return nil
+		}
+		return s.Auditor.RecordTx(
+			ctx, tx, e.audit, audit.TargetAccount, a.Number, nil, transaction,
+		)
+	}
+}
+
+// checkLimit adds an error to v if the amount is more than s.MaxAmounts allows in its currency, or
+// in a currency there is no limit for. a limit is never applied to an amount in another currency
+func (s *Service) checkLimit(v *validator.Validator, amount money.Amount) {
+	if s.MaxAmounts == nil {
+		return
+	}
+
+	maxAmount, ok := s.MaxAmounts[amount.Currency()]
+	if !ok {
+		v.AddError("amount", "not in a currency you are allowed to move")
+		return
+	}
+	v.CheckAddError(!maxAmount.LessThan(amount), "amount", "more than you are allowed to move")
+}
+
+// Deposit puts the amount, in the currency of the account, into the account with the number
 func (s *Service) Deposit(
-	v *validator.Validator, userID int64, amount float64, performedBy string,
+	ctx context.Context, v *validator.Validator, accountNumber string, amount money.Amount,
+	performedBy string,
 ) (*Transaction, error) {
+	ctx, span := tracer.Start(ctx, "transaction.Deposit")
+	defer span.End()
+
+	a, err := s.AccountService.GetAccountByNumber(ctx, v, accountNumber)
+	if err != nil {
+		return nil, err
+	}
+
 	transaction := &Transaction{
-		UserID:      userID,
-		Amount:      amount,
+		UserID:      a.UserID,
+		AccountID:   a.ID,
+		Amount:      amount.WithCurrency(a.Currency),
 		Action:      "DEPOSIT",
 		PerformedBy: performedBy,
 	}
+	v.CheckAddError(a.IsActive(), "account", "not active")
+	s.checkLimit(v, transaction.Amount)
 	if ValidateTransaction(v, transaction); !v.IsValid() {
 		return nil, validator.ErrFailedValidation
 	}
 
-	u, err := s.UserService.GetUser(userID)
-	if err != nil {
-		return nil, err
-	}
-
-	err = s.Repo.Insert(transaction)
-	if err != nil {
-		return nil, err
+	entry := ledger.Move(
+		ledger.KindDeposit, fmt.Sprintf("deposit into account %s", a.Number),
+		ledger.AccountCash, ledger.CustomerAccount(a.ID), transaction.Amount,
+	)
+	if ledger.ValidateEntry(v, entry); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
 	}
 
-	u.AccountBalance += transaction.Amount
-	_, err = s.UserService.UpdateUser(
-		u.ID, u.Name, u.Email, u.Password.Hash, u.AccountBalance, u.Activated,
-	)
+	err = s.Repo.Insert(ctx, transaction, entry, s.recordedTx(depositEvents, transaction, a))
 	if err != nil {
+		if ledger.AddInsertError(v, err) {
+			return nil, validator.ErrFailedValidation
+		}
 		return nil, err
 	}
 
 	return transaction, nil
 }
 
+// Withdraw takes the amount, in the currency of the account, out of the account with the number
 func (s *Service) Withdraw(
-	v *validator.Validator, userID int64, amount float64, performedBy string,
+	ctx context.Context, v *validator.Validator, accountNumber string, amount money.Amount,
+	performedBy string,
 ) (*Transaction, error) {
-	transaction := &Transaction{
-		UserID:      userID,
-		Amount:      amount,
-		Action:      "WITHDRAW",
-		PerformedBy: performedBy,
-	}
-	u, err := s.UserService.GetUser(userID)
+	ctx, span := tracer.Start(ctx, "transaction.Withdraw")
+	defer span.End()
+
+	a, err := s.AccountService.GetAccountByNumber(ctx, v, accountNumber)
 	if err != nil {
 		return nil, err
 	}
 
-	v.CheckAddError(u.AccountBalance >= amount, "account balance", "insufficient funds")
+	transaction := &Transaction{
+		UserID:      a.UserID,
+		AccountID:   a.ID,
+		Amount:      amount.WithCurrency(a.Currency),
+		Action:      "WITHDRAW",
+		PerformedBy: performedBy,
+	}
+	v.CheckAddError(a.IsActive(), "account", "not active")
+	s.checkLimit(v, transaction.Amount)
+	v.CheckAddError(
+		!a.Balance.LessThan(transaction.Amount), "account balance", "insufficient funds",
+	)
 	if ValidateTransaction(v, transaction); !v.IsValid() {
 		return nil, validator.ErrFailedValidation
 	}
 
-	err = s.Repo.Insert(transaction)
-	if err != nil {
-		return nil, err
+	entry := ledger.Move(
+		ledger.KindWithdrawal, fmt.Sprintf("withdrawal from account %s", a.Number),
+		ledger.CustomerAccount(a.ID), ledger.AccountCash, transaction.Amount,
+	)
+	if ledger.ValidateEntry(v, entry); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
 	}
 
-	u.AccountBalance -= transaction.Amount
-	_, err = s.UserService.UpdateUser(
-		u.ID, u.Name, u.Email, u.Password.Hash, u.AccountBalance, u.Activated,
-	)
+	err = s.Repo.Insert(ctx, transaction, entry, s.recordedTx(withdrawalEvents, transaction, a))
 	if err != nil {
+		if ledger.AddInsertError(v, err) {
+			return nil, validator.ErrFailedValidation
+		}
 		return nil, err
 	}
 
 	return transaction, nil
 }
+
+// GetAllForUser returns a page of the deposits and withdrawals of the user
+func (s *Service) GetAllForUser(
+	ctx context.Context, v *validator.Validator, userID int64, f filter.Filters,
+) ([]*Transaction, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "transaction.GetAllForUser")
+	defer span.End()
+
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetAllForUser(ctx, userID, f)
+}
@@ -1,61 +1,99 @@
 package transaction
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"testing"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
+// MockRepo applies the postings of the entries inserted to Account, so the tests can check the
+// balance moved
 type MockRepo struct {
+	Account   *account.Account
 	InsertErr error
 }
 
-func (r *MockRepo) Insert(transaction *Transaction) error {
-	return r.InsertErr
+func (r *MockRepo) Insert(
+	ctx context.Context, transaction *Transaction, entry *ledger.Entry, then database.TxFunc,
+) error {
+	if r.InsertErr != nil {
+		return r.InsertErr
+	}
+	if then != nil {
+		err := then(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range entry.Postings {
+		if r.Account != nil && p.Account == ledger.CustomerAccount(r.Account.ID) {
+			r.Account.Balance, _ = r.Account.Balance.Add(p.Amount)
+		}
+	}
+	return nil
 }
 
-type MockUserService struct {
-	GetUserResult *user.User
-	GetUserErr    error
+func (r *MockRepo) GetAllForUser(
+	ctx context.Context, userID int64, f filter.Filters,
+) ([]*Transaction, filter.Metadata, error) {
+	return nil, filter.Metadata{}, nil
+}
 
-	UpdateUserResult *user.User
-	UpdateUserErr    error
+type MockAccountService struct {
+	GetAccountByNumberResult *account.Account
+	GetAccountByNumberErr    error
 }
 
-func (us *MockUserService) GetUser(userID int64) (*user.User, error) {
-	if us.GetUserErr != nil {
-		return nil, us.GetUserErr
+func (as *MockAccountService) GetAccountByNumber(
+	ctx context.Context, v *validator.Validator, number string,
+) (*account.Account, error) {
+	if as.GetAccountByNumberErr != nil {
+		return nil, as.GetAccountByNumberErr
 	}
-	return us.GetUserResult, nil
+	return as.GetAccountByNumberResult, nil
 }
 
-func (us *MockUserService) UpdateUser(
-	userID int64, userName, userEmail string, userPasswordHash []byte,
-	userAccountBalance float64, userActivated bool,
-) (*user.User, error) {
-	if us.UpdateUserErr != nil {
-		return nil, us.UpdateUserErr
-	}
-	return us.UpdateUserResult, nil
+// MockNotifier records the events users are notified of
+type MockNotifier struct {
+	Events []string
+}
+
+func (n *MockNotifier) NotifyTx(
+	ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+) error {
+	n.Events = append(n.Events, event)
+	return nil
 }
 
 func TestDeposit(t *testing.T) {
-	mockUser := &user.User{
-		ID:             1,
-		Name:           "yusuf",
-		Email:          "ym@gmail.com",
-		AccountBalance: 0,
+	mockAccount := &account.Account{
+		ID:       1,
+		UserID:   1,
+		Number:   "1000000009",
+		Currency: "USD",
+		Status:   account.StatusActive,
+		Balance:  money.MustParse("0"),
 	}
 	tests := []struct {
-		name             string
-		setupRepo        func(*MockRepo)
-		setupUserService func(*MockUserService)
-		input            struct {
+		name            string
+		setupRepo       func(*MockRepo)
+		setupAccountSvc func(*MockAccountService)
+		maxAmounts      map[money.Currency]money.Amount
+		input           struct {
 			v           *validator.Validator
-			userID      int64
-			amount      float64
+			number      string
+			amount      money.Amount
 			performedBy string
 		}
 		expectedErr error
@@ -63,56 +101,124 @@ func TestDeposit(t *testing.T) {
 		{
 			name:      "valid",
 			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 100, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
 		},
 		{
 			name:      "amount = 0",
 			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 0, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("0"),
+				performedBy: "yusuf",
+			},
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
 			name:      "amount < 0",
 			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
+			},
+			input: struct {
+				v           *validator.Validator
+				number      string
+				amount      money.Amount
+				performedBy string
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("-100"),
+				performedBy: "yusuf",
+			},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:      "more than the limit",
+			setupRepo: func(r *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
+			},
+			maxAmounts: map[money.Currency]money.Amount{"USD": money.MustParse("99.99")},
+			input: struct {
+				v           *validator.Validator
+				number      string
+				amount      money.Amount
+				performedBy string
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:      "up to the limit",
+			setupRepo: func(r *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
+			},
+			maxAmounts: map[money.Currency]money.Amount{"USD": money.MustParse("100")},
+			input: struct {
+				v           *validator.Validator
+				number      string
+				amount      money.Amount
+				performedBy string
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
+		},
+		{
+			// a limit in euros says nothing about how many dollars can be moved
+			name:      "limit in another currency",
+			setupRepo: func(r *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
+			},
+			maxAmounts: map[money.Currency]money.Amount{
+				"EUR": money.MustParse("1000").WithCurrency("EUR"),
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: -100, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
-			name:      "GetUser failure",
+			name:      "GetAccountByNumber failure",
 			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserErr = user.ErrNoRecord
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberErr = user.ErrNoRecord
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 100, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
 			expectedErr: user.ErrNoRecord,
 		},
 		{
@@ -120,48 +226,60 @@ func TestDeposit(t *testing.T) {
 			setupRepo: func(r *MockRepo) {
 				r.InsertErr = errors.New("db Insert error")
 			},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 100, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
 			expectedErr: errors.New("db Insert error"),
 		},
 		{
-			name:      "UpdateUser failure",
-			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
-				us.UpdateUserErr = errors.New("db UpdateUser error")
+			name: "account frozen before the insert",
+			setupRepo: func(r *MockRepo) {
+				r.InsertErr = ledger.ErrAccountInactive
+			},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 100, performedBy: "yusuf"},
-			expectedErr: errors.New("db UpdateUser error"),
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
+			expectedErr: validator.ErrFailedValidation,
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			repo := &MockRepo{}
-			userService := &MockUserService{}
+			// each deposit that goes through is made into an empty account
+			mockAccount.Balance = money.MustParse("0")
+			repo := &MockRepo{Account: mockAccount}
+			accountService := &MockAccountService{}
 			tc.setupRepo(repo)
-			tc.setupUserService(userService)
+			tc.setupAccountSvc(accountService)
 
+			notifier := &MockNotifier{}
 			svc := Service{
-				Repo:        repo,
-				UserService: userService,
+				Repo:           repo,
+				AccountService: accountService,
+				Notifier:       notifier,
+				MaxAmounts:     tc.maxAmounts,
 			}
 
 			transaction, gotErr := svc.Deposit(
-				tc.input.v, tc.input.userID, tc.input.amount, tc.input.performedBy,
+				context.Background(), tc.input.v, tc.input.number, tc.input.amount, tc.input.performedBy,
 			)
 
 			if tc.expectedErr != nil {
@@ -173,6 +291,10 @@ func TestDeposit(t *testing.T) {
 				t.Fatalf("unexpected error %v", gotErr)
 			}
 
+			if len(notifier.Events) != 1 || notifier.Events[0] != notification.EventDeposit {
+				t.Errorf("expected the user notified of the deposit, got %v", notifier.Events)
+			}
+
 			depositAction := "DEPOSIT"
 			if transaction.Action != depositAction {
 				t.Errorf(
@@ -181,16 +303,16 @@ func TestDeposit(t *testing.T) {
 				)
 			}
 
-			if transaction.Amount != tc.input.amount {
+			if transaction.Amount.Cmp(tc.input.amount) != 0 {
 				t.Errorf(
-					"expected transaction amount=%f, got amount=%f", tc.input.amount,
+					"expected transaction amount=%s, got amount=%s", tc.input.amount,
 					transaction.Amount,
 				)
 			}
-			if mockUser.AccountBalance != transaction.Amount {
+			if mockAccount.Balance.Cmp(transaction.Amount) != 0 {
 				t.Errorf(
-					"expected user account balance=%f, got account balance=%f", transaction.Amount,
-					user.AnonymousUser.AccountBalance,
+					"expected account balance=%s, got account balance=%s", transaction.Amount,
+					mockAccount.Balance,
 				)
 			}
 		})
@@ -198,20 +320,22 @@ func TestDeposit(t *testing.T) {
 }
 
 func TestWithdraw(t *testing.T) {
-	mockUser := &user.User{
-		ID:             1,
-		Name:           "yusuf",
-		Email:          "ym@gmail.com",
-		AccountBalance: 100,
+	mockAccount := &account.Account{
+		ID:       1,
+		UserID:   1,
+		Number:   "1000000009",
+		Currency: "USD",
+		Status:   account.StatusActive,
+		Balance:  money.MustParse("100"),
 	}
 	tests := []struct {
-		name             string
-		setupRepo        func(*MockRepo)
-		setupUserService func(*MockUserService)
-		input            struct {
+		name            string
+		setupRepo       func(*MockRepo)
+		setupAccountSvc func(*MockAccountService)
+		input           struct {
 			v           *validator.Validator
-			userID      int64
-			amount      float64
+			number      string
+			amount      money.Amount
 			performedBy string
 		}
 		expectedErr error
@@ -219,70 +343,105 @@ func TestWithdraw(t *testing.T) {
 		{
 			name:      "valid",
 			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 100, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
 		},
 		{
 			name:      "amount = 0",
 			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 0, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("0"),
+				performedBy: "yusuf",
+			},
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
 			name:      "amount < 0",
 			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: -100, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("-100"),
+				performedBy: "yusuf",
+			},
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
-			name:      "amount > user account balance",
+			name:      "amount > account balance",
 			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 200, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("200"),
+				performedBy: "yusuf",
+			},
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
-			name:      "GetUser failure",
+			name:      "frozen account",
 			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserErr = user.ErrNoRecord
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = &account.Account{
+					ID: 1, Currency: "USD", Status: account.StatusFrozen,
+					Balance: money.MustParse("100"),
+				}
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 100, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:      "GetAccountByNumber failure",
+			setupRepo: func(r *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberErr = user.ErrNoRecord
+			},
+			input: struct {
+				v           *validator.Validator
+				number      string
+				amount      money.Amount
+				performedBy string
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
 			expectedErr: user.ErrNoRecord,
 		},
 		{
@@ -290,52 +449,61 @@ func TestWithdraw(t *testing.T) {
 			setupRepo: func(r *MockRepo) {
 				r.InsertErr = errors.New("db Insert error")
 			},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 100, performedBy: "yusuf"},
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
 			expectedErr: errors.New("db Insert error"),
 		},
 		{
-			name:      "UpdateUser failure",
-			setupRepo: func(r *MockRepo) {},
-			setupUserService: func(us *MockUserService) {
-				us.GetUserResult = mockUser
-				us.UpdateUserErr = errors.New("db UpdateUser error")
+			name: "account frozen before the insert",
+			setupRepo: func(r *MockRepo) {
+				r.InsertErr = ledger.ErrAccountInactive
+			},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetAccountByNumberResult = mockAccount
 			},
 			input: struct {
 				v           *validator.Validator
-				userID      int64
-				amount      float64
+				number      string
+				amount      money.Amount
 				performedBy string
-			}{v: validator.New(), userID: 1, amount: 100, performedBy: "yusuf"},
-			expectedErr: errors.New("db UpdateUser error"),
+			}{
+				v: validator.New(), number: "1000000009", amount: money.MustParse("100"),
+				performedBy: "yusuf",
+			},
+			expectedErr: validator.ErrFailedValidation,
 		},
 	}
 
-	resetUser := func(u *user.User) {
-		u.AccountBalance = 100
+	resetAccount := func(a *account.Account) {
+		a.Balance = money.MustParse("100")
 	}
 	for _, tc := range tests {
-		resetUser(mockUser)
+		resetAccount(mockAccount)
 		t.Run(tc.name, func(t *testing.T) {
-			repo := &MockRepo{}
-			userService := &MockUserService{}
+			repo := &MockRepo{Account: mockAccount}
+			accountService := &MockAccountService{}
 			tc.setupRepo(repo)
-			tc.setupUserService(userService)
+			tc.setupAccountSvc(accountService)
 
+			notifier := &MockNotifier{}
 			svc := Service{
-				Repo:        repo,
-				UserService: userService,
+				Repo:           repo,
+				AccountService: accountService,
+				Notifier:       notifier,
 			}
 
 			transaction, gotErr := svc.Withdraw(
-				tc.input.v, tc.input.userID, tc.input.amount, tc.input.performedBy,
+				context.Background(), tc.input.v, tc.input.number, tc.input.amount, tc.input.performedBy,
 			)
 
 			if tc.expectedErr != nil {
@@ -347,6 +515,10 @@ func TestWithdraw(t *testing.T) {
 				t.Fatalf("unexpected error %v", gotErr)
 			}
 
+			if len(notifier.Events) != 1 || notifier.Events[0] != notification.EventWithdrawal {
+				t.Errorf("expected the user notified of the withdrawal, got %v", notifier.Events)
+			}
+
 			withdrawAction := "WITHDRAW"
 			if transaction.Action != withdrawAction {
 				t.Errorf(
@@ -355,17 +527,14 @@ func TestWithdraw(t *testing.T) {
 				)
 			}
 
-			if transaction.Amount != tc.input.amount {
+			if transaction.Amount.Cmp(tc.input.amount) != 0 {
 				t.Errorf(
-					"expected transaction amount=%f, got amount=%f", tc.input.amount,
+					"expected transaction amount=%s, got amount=%s", tc.input.amount,
 					transaction.Amount,
 				)
 			}
-			if mockUser.AccountBalance != 0 {
-				t.Errorf(
-					"expected user account balance=%f, got account balance=%f", transaction.Amount,
-					user.AnonymousUser.AccountBalance,
-				)
+			if !mockAccount.Balance.IsZero() {
+				t.Errorf("expected account balance=0, got account balance=%s", mockAccount.Balance)
 			}
 		})
 	}
@@ -3,22 +3,39 @@ package transfer
 import (
 	"time"
 
-	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
+// SortSafelist are the values the transfers of a user can be sorted by
+var SortSafelist = []string{"id", "created_at", "amount", "-id", "-created_at", "-amount"}
+
 type Transfer struct {
-	ID         int64
-	CreatedAd  time.Time
-	FromUserID int64
-	ToUserID   int64
-	Amount     float64
+	ID            int64
+	CreatedAd     time.Time
+	FromUserID    int64
+	ToUserID      int64
+	FromAccountID int64
+	ToAccountID   int64
+	Amount        money.Amount
 }
 
-func ValidateTransfer(v *validator.Validator, transfer *Transfer, fromUser *user.User) {
-	v.CheckAddError(transfer.Amount != 0, "amount", "must be given")
-	v.CheckAddError(transfer.Amount > 0, "amount", "must be greater than 0")
+func ValidateTransfer(
+	v *validator.Validator, transfer *Transfer, fromAccount, toAccount *account.Account,
+) {
+	v.CheckAddError(!transfer.Amount.IsZero(), "amount", "must be given")
+	v.CheckAddError(transfer.Amount.IsPositive(), "amount", "must be greater than 0")
+
+	v.CheckAddError(fromAccount.ID != toAccount.ID, "to account", "must be a different account")
+	v.CheckAddError(fromAccount.IsActive(), "from account", "not active")
+	v.CheckAddError(toAccount.IsActive(), "to account", "not active")
+	v.CheckAddError(
+		fromAccount.Currency == toAccount.Currency, "to account",
+		"must be in the same currency",
+	)
+	// the amount is always in the currency of the sending account, so this can't mismatch
 	v.CheckAddError(
-		fromUser.AccountBalance >= transfer.Amount, "account balance", "insufficient funds",
+		!fromAccount.Balance.LessThan(transfer.Amount), "account balance", "insufficient funds",
 	)
 }
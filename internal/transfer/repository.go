@@ -3,27 +3,146 @@ package transfer
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
 )
 
 type Repository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (r *Repository) Insert(transfer *Transfer) error {
+// Insert records the transfer together with the ledger entry that moves the money, in one
+// transaction. either both balances change and the transfer is recorded, or nothing happens. from,
+// the sending account, is brought up to date with the balance the transfer left it with. then, if
+// given, is run last in the transaction
+func (r *Repository) Insert(
+	ctx context.Context, transfer *Transfer, entry *ledger.Entry, from *account.Account,
+	then database.TxFunc,
+) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the entry goes first because it locks both accounts, the foreign keys on the transfers row
+	// would otherwise take their own locks on the accounts in whatever order they come
+	err = ledger.InsertTx(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO transfers (from_user_id, to_user_id, amount)
-		VALUES ($1, $2, $3)
-		RETURNING id
+		INSERT INTO transfers (from_user_id, to_user_id, from_account_id, to_account_id, amount)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	return r.DB.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx, query,
 		transfer.FromUserID,
 		transfer.ToUserID,
+		transfer.FromAccountID,
+		transfer.ToAccountID,
 		transfer.Amount,
-	).Scan(&transfer.ID)
+	).Scan(&transfer.ID, &transfer.CreatedAd)
+	if err != nil {
+		return err
+	}
+
+	// read before the commit, so that nothing is left to fail once the money has moved
+	query = `
+		SELECT balance, version
+		FROM accounts
+		WHERE id = $1
+	`
+	err = tx.QueryRowContext(ctx, query, from.ID).Scan(&from.Balance, &from.Version)
+	if err != nil {
+		return err
+	}
+	from.Balance = from.Balance.WithCurrency(from.Currency)
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForUser returns a page of the transfers sent or received by the user. out are the transfers
+// the user sent and in the ones they received, a transfer between their own accounts is both
+func (r *Repository) GetAllForUser(
+	ctx context.Context, userID int64, f filter.Filters,
+) ([]*Transfer, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, from_user_id, to_user_id, from_account_id,
+			to_account_id, amount
+		FROM transfers
+		WHERE (from_user_id = $1 OR to_user_id = $1)
+		AND ($2::timestamptz IS NULL OR created_at >= $2)
+		AND ($3::timestamptz IS NULL OR created_at < $3)
+		AND ($4::decimal IS NULL OR amount >= $4)
+		AND ($5::decimal IS NULL OR amount <= $5)
+		AND ($6 = '' OR ($6 = 'out' AND from_user_id = $1) OR ($6 = 'in' AND to_user_id = $1))
+		ORDER BY %s %s, id ASC
+		LIMIT $7 OFFSET $8
+	`, f.SortColumn(), f.SortDirection())
+	args := []any{
+		userID,
+		f.From,
+		f.To,
+		f.MinAmount,
+		f.MaxAmount,
+		f.Direction,
+		f.Limit(),
+		f.Offset(),
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	transfers := []*Transfer{}
+	for rows.Next() {
+		var transfer Transfer
+		err = rows.Scan(
+			&totalRecords,
+			&transfer.ID,
+			&transfer.CreatedAd,
+			&transfer.FromUserID,
+			&transfer.ToUserID,
+			&transfer.FromAccountID,
+			&transfer.ToAccountID,
+			&transfer.Amount,
+		)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+
+		transfers = append(transfers, &transfer)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return transfers, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
 }
@@ -1,54 +1,171 @@
 package transfer
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/Yusufdot101/goBankBackend/internal/webhook"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/transfer")
+
 type TransferRepo interface {
-	Insert(transfer *Transfer) error
+	Insert(
+		ctx context.Context, transfer *Transfer, entry *ledger.Entry, from *account.Account,
+		then database.TxFunc,
+	) error
+	GetAllForUser(
+		ctx context.Context, userID int64, f filter.Filters,
+	) ([]*Transfer, filter.Metadata, error)
+}
+
+type AccountService interface {
+	GetAccountByNumber(
+		ctx context.Context, v *validator.Validator, number string,
+	) (*account.Account, error)
+	GetUserAccount(
+		ctx context.Context, v *validator.Validator, userID int64, number string,
+	) (*account.Account, error)
 }
 
-type UserService interface {
-	TransferMoney(fromUser, toUser *user.User, amount float64) (*user.User, error)
-	GetUserByEmail(email string) (*user.User, error)
+// Publisher queues the webhooks of a transfer in its transaction
+type Publisher interface {
+	PublishTx(ctx context.Context, tx *sql.Tx, event string, data map[string]any) error
+}
+
+// Notifier tells the sender and the receiver of a transfer about it, inside its transaction
+type Notifier interface {
+	NotifyTx(
+		ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+	) error
 }
 
 type Service struct {
-	Repo        TransferRepo
-	UserService UserService
+	Repo           TransferRepo
+	AccountService AccountService
+	Webhooks       Publisher // optional
+	Notifier       Notifier  // optional
+}
+
+// recordedTx is run in the transaction of the transfer once it is recorded. the sender and the
+// receiver are told about it and its webhook is queued
+func (s *Service) recordedTx(transfer *Transfer, from, to *account.Account) database.TxFunc {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		if s.Notifier != nil {
+			sent := notification.AmountData(transfer.Amount, from.Number)
+			sent["toAccountNumber"] = to.Number
+			err := s.Notifier.NotifyTx(
+				ctx, tx, transfer.FromUserID, notification.EventTransferSent, sent,
+			)
+			if err != nil {
+				return err
+			}
+
+			received := notification.AmountData(transfer.Amount, to.Number)
+			err = s.Notifier.NotifyTx(
+				ctx, tx, transfer.ToUserID, notification.EventTransferReceived, received,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if s.Webhooks == nil {
+			return nil
+		}
+		return s.Webhooks.PublishTx(ctx, tx, webhook.EventTransfer, map[string]any{
+			"transfer_id":         transfer.ID,
+			"created_at":          transfer.CreatedAd,
+			"from_user_id":        transfer.FromUserID,
+			"to_user_id":          transfer.ToUserID,
+			"from_account_number": from.Number,
+			"to_account_number":   to.Number,
+			"amount":              transfer.Amount.String(),
+			"currency":            transfer.Amount.Currency(),
+		})
+	}
 }
 
+// TransferMoney moves the amount, in the currency of the sending account, from the account of
+// fromUser with the number fromNumber to the account with the number toNumber. the balances and the
+// transfer record are written in a single transaction, so a failure part way through leaves both
+// accounts as they were, and nothing fails after it commits. the sending account is returned with
+// its new balance
 func (s *Service) TransferMoney(
-	v *validator.Validator, fromUser *user.User, toUserEmail string, amount float64,
-) (*Transfer, *user.User, error) {
-	toUser, err := s.UserService.GetUserByEmail(toUserEmail)
+	ctx context.Context, v *validator.Validator, fromUser *user.User, fromNumber, toNumber string,
+	amount money.Amount,
+) (*Transfer, *account.Account, error) {
+	ctx, span := tracer.Start(ctx, "transfer.TransferMoney")
+	defer span.End()
+
+	fromAccount, err := s.AccountService.GetUserAccount(ctx, v, fromUser.ID, fromNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toAccount, err := s.AccountService.GetAccountByNumber(ctx, v, toNumber)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	transfer := Transfer{
-		CreatedAd:  time.Now(),
-		FromUserID: fromUser.ID,
-		ToUserID:   toUser.ID,
-		Amount:     amount,
+		CreatedAd:     time.Now(),
+		FromUserID:    fromUser.ID,
+		ToUserID:      toAccount.UserID,
+		FromAccountID: fromAccount.ID,
+		ToAccountID:   toAccount.ID,
+		Amount:        amount.WithCurrency(fromAccount.Currency),
 	}
 
-	if ValidateTransfer(v, &transfer, fromUser); !v.IsValid() {
+	if ValidateTransfer(v, &transfer, fromAccount, toAccount); !v.IsValid() {
 		return nil, nil, validator.ErrFailedValidation
 	}
 
-	fromUser, err = s.UserService.TransferMoney(fromUser, toUser, transfer.Amount)
-	if err != nil {
-		return nil, nil, err
+	entry := ledger.Move(
+		ledger.KindTransfer,
+		fmt.Sprintf("transfer from account %s to account %s", fromAccount.Number, toAccount.Number),
+		ledger.CustomerAccount(fromAccount.ID), ledger.CustomerAccount(toAccount.ID),
+		transfer.Amount,
+	)
+	if ledger.ValidateEntry(v, entry); !v.IsValid() {
+		return nil, nil, validator.ErrFailedValidation
 	}
 
-	err = s.Repo.Insert(&transfer)
+	err = s.Repo.Insert(
+		ctx, &transfer, entry, fromAccount, s.recordedTx(&transfer, fromAccount, toAccount),
+	)
 	if err != nil {
+		// the balances and statuses checked above can be stale by the time the rows are locked
+		if ledger.AddInsertError(v, err) {
+			return nil, nil, validator.ErrFailedValidation
+		}
 		return nil, nil, err
 	}
 
-	return &transfer, fromUser, nil
+	return &transfer, fromAccount, nil
+}
+
+// GetAllForUser returns a page of the transfers of the user
+func (s *Service) GetAllForUser(
+	ctx context.Context, v *validator.Validator, userID int64, f filter.Filters,
+) ([]*Transfer, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "transfer.GetAllForUser")
+	defer span.End()
+
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetAllForUser(ctx, userID, f)
 }
@@ -1,148 +1,407 @@
 package transfer
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"testing"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/Yusufdot101/goBankBackend/internal/webhook"
 )
 
 // ---MOCKS---
 
 type MockRepo struct {
 	InsertErr error
+
+	// the entry passed to the last successful Insert
+	Entry *ledger.Entry
+
+	GetAllForUserResult []*Transfer
+	GetAllForUserErr    error
 }
 
-func (r *MockRepo) Insert(transfer *Transfer) error {
-	return r.InsertErr
+func (r *MockRepo) Insert(
+	ctx context.Context, transfer *Transfer, entry *ledger.Entry, from *account.Account,
+	then database.TxFunc,
+) error {
+	if r.InsertErr != nil {
+		return r.InsertErr
+	}
+	if then != nil {
+		err := then(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.Entry = entry
+	for _, p := range entry.Postings {
+		if p.Account == ledger.CustomerAccount(from.ID) {
+			from.Balance, _ = from.Balance.Add(p.Amount)
+		}
+	}
+	return nil
+}
+
+func (r *MockRepo) GetAllForUser(
+	ctx context.Context, userID int64, f filter.Filters,
+) ([]*Transfer, filter.Metadata, error) {
+	if r.GetAllForUserErr != nil {
+		return nil, filter.Metadata{}, r.GetAllForUserErr
+	}
+	return r.GetAllForUserResult, filter.CalculateMetadata(
+		len(r.GetAllForUserResult), f.Page, f.PageSize,
+	), nil
 }
 
-type MockUserService struct {
-	GetUserByEmailResult *user.User
-	GetUserByEmailErr    error
+type MockAccountService struct {
+	GetAccountByNumberResult *account.Account
+	GetAccountByNumberErr    error
+
+	GetUserAccountResult *account.Account
+	GetUserAccountErr    error
+}
 
-	TransferMoneyResult *user.User
-	TransferMoneyErr    error
+func (as *MockAccountService) GetAccountByNumber(
+	ctx context.Context, v *validator.Validator, number string,
+) (*account.Account, error) {
+	if as.GetAccountByNumberErr != nil {
+		return nil, as.GetAccountByNumberErr
+	}
+	return as.GetAccountByNumberResult, nil
 }
 
-func (us *MockUserService) GetUserByEmail(email string) (*user.User, error) {
-	if us.GetUserByEmailErr != nil {
-		return nil, us.GetUserByEmailErr
+func (as *MockAccountService) GetUserAccount(
+	ctx context.Context, v *validator.Validator, userID int64, number string,
+) (*account.Account, error) {
+	if as.GetUserAccountErr != nil {
+		return nil, as.GetUserAccountErr
 	}
-	return us.GetUserByEmailResult, nil
+	return as.GetUserAccountResult, nil
+}
+
+// MockPublisher records the events published
+type MockPublisher struct {
+	Events     []string
+	Data       []map[string]any
+	PublishErr error
 }
 
-func (us *MockUserService) TransferMoney(
-	fromUser, toUser *user.User, amount float64,
-) (*user.User, error) {
-	if us.TransferMoneyErr != nil {
-		return nil, us.TransferMoneyErr
+func (p *MockPublisher) PublishTx(
+	ctx context.Context, tx *sql.Tx, event string, data map[string]any,
+) error {
+	if p.PublishErr != nil {
+		return p.PublishErr
 	}
+	p.Events = append(p.Events, event)
+	p.Data = append(p.Data, data)
+	return nil
+}
 
-	toUser.AccountBalance += amount
-	return us.TransferMoneyResult, us.TransferMoneyErr
+// MockNotifier records the events users are notified of
+type MockNotifier struct {
+	Events    []string
+	NotifyErr error
+}
+
+func (n *MockNotifier) NotifyTx(
+	ctx context.Context, tx *sql.Tx, userID int64, event string, data map[string]any,
+) error {
+	if n.NotifyErr != nil {
+		return n.NotifyErr
+	}
+	n.Events = append(n.Events, event)
+	return nil
 }
 
 func TestTransferMoney(t *testing.T) {
-	fromUser := &user.User{
-		ID: 1, Name: "yusuf", Email: "a@b.com", AccountBalance: 100,
+	fromUser := &user.User{ID: 1, Name: "yusuf", Email: "a@b.com"}
+	fromAccount := &account.Account{
+		ID: 1, UserID: 1, Number: "1000000009", Currency: "USD", Status: account.StatusActive,
+		Balance: money.MustParse("100"),
 	}
-	toUser := &user.User{
-		ID: 2, Name: "mohamed", Email: "b@a.com", AccountBalance: 50,
+	toAccount := &account.Account{
+		ID: 2, UserID: 2, Number: "2000000007", Currency: "USD", Status: account.StatusActive,
+		Balance: money.MustParse("50"),
 	}
 
 	tests := []struct {
-		name         string
-		setupRepo    func(*MockRepo)
-		setupUserSvc func(*MockUserService)
-		input        struct {
-			v           *validator.Validator
-			fromUser    *user.User
-			toUserEmail string
-			amount      float64
-		}
-		finalFrom   float64
-		finalTo     float64
-		expectedErr error
+		name            string
+		setupRepo       func(*MockRepo)
+		setupAccountSvc func(*MockAccountService)
+		amount          money.Amount
+		notifyErr       error
+		publishErr      error
+		finalFrom       money.Amount
+		expectedErr     error
 	}{
 		{
 			name:      "valid input",
 			setupRepo: func(m *MockRepo) {},
-			setupUserSvc: func(us *MockUserService) {
-				us.GetUserByEmailResult = toUser
-				us.TransferMoneyResult = &user.User{AccountBalance: 90}
-			},
-			input: struct {
-				v           *validator.Validator
-				fromUser    *user.User
-				toUserEmail string
-				amount      float64
-			}{v: validator.New(), fromUser: fromUser, toUserEmail: toUser.Email, amount: 10},
-			finalFrom:   90,
-			finalTo:     60,
-			expectedErr: nil,
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberResult = toAccount
+			},
+			amount:    money.MustParse("10"),
+			finalFrom: money.MustParse("90"),
 		},
 		{
 			name:      "insuffient funds",
 			setupRepo: func(m *MockRepo) {},
-			setupUserSvc: func(us *MockUserService) {
-				us.GetUserByEmailResult = toUser
-			},
-			input: struct {
-				v           *validator.Validator
-				fromUser    *user.User
-				toUserEmail string
-				amount      float64
-			}{v: validator.New(), fromUser: fromUser, toUserEmail: toUser.Email, amount: 1000},
-			finalFrom:   100,
-			finalTo:     50,
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberResult = toAccount
+			},
+			amount:      money.MustParse("1000"),
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:      "same account",
+			setupRepo: func(m *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberResult = fromAccount
+			},
+			amount:      money.MustParse("10"),
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:      "other currency",
+			setupRepo: func(m *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberResult = &account.Account{
+					ID: 3, UserID: 2, Currency: "EUR", Status: account.StatusActive,
+				}
+			},
+			amount:      money.MustParse("10"),
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:      "frozen recipient",
+			setupRepo: func(m *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberResult = &account.Account{
+					ID: 3, UserID: 2, Currency: "USD", Status: account.StatusFrozen,
+				}
+			},
+			amount:      money.MustParse("10"),
 			expectedErr: validator.ErrFailedValidation,
 		},
 		{
-			name:      "to user not found",
+			name: "balance spent before the rows were locked",
+			setupRepo: func(m *MockRepo) {
+				m.InsertErr = ledger.ErrInsufficientFunds
+			},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberResult = toAccount
+			},
+			amount:      money.MustParse("10"),
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:      "notification failure",
+			setupRepo: func(m *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberResult = toAccount
+			},
+			amount:      money.MustParse("10"),
+			notifyErr:   errors.New("db notification error"),
+			expectedErr: errors.New("db notification error"),
+		},
+		{
+			name:      "webhook failure",
+			setupRepo: func(m *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberResult = toAccount
+			},
+			amount:      money.MustParse("10"),
+			publishErr:  errors.New("db webhook error"),
+			expectedErr: errors.New("db webhook error"),
+		},
+		{
+			name: "Insert failure",
+			setupRepo: func(m *MockRepo) {
+				m.InsertErr = errors.New("db Insert error")
+			},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberResult = toAccount
+			},
+			amount:      money.MustParse("10"),
+			expectedErr: errors.New("db Insert error"),
+		},
+		{
+			name:      "from account not the user's",
 			setupRepo: func(m *MockRepo) {},
-			setupUserSvc: func(us *MockUserService) {
-				us.GetUserByEmailErr = user.ErrNoRecord
-			},
-			input: struct {
-				v           *validator.Validator
-				fromUser    *user.User
-				toUserEmail string
-				amount      float64
-			}{v: validator.New(), fromUser: fromUser, toUserEmail: "random@email.gmail", amount: 10},
-			finalFrom:   100,
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountErr = user.ErrNoRecord
+			},
+			amount:      money.MustParse("10"),
+			expectedErr: user.ErrNoRecord,
+		},
+		{
+			name:      "to account not found",
+			setupRepo: func(m *MockRepo) {},
+			setupAccountSvc: func(as *MockAccountService) {
+				as.GetUserAccountResult = fromAccount
+				as.GetAccountByNumberErr = user.ErrNoRecord
+			},
+			amount:      money.MustParse("10"),
 			expectedErr: user.ErrNoRecord,
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
+			fromAccount.Balance = money.MustParse("100")
 			repo := &MockRepo{}
-			userSvc := &MockUserService{}
+			accountSvc := &MockAccountService{}
 			tc.setupRepo(repo)
-			tc.setupUserSvc(userSvc)
+			tc.setupAccountSvc(accountSvc)
+			publisher := &MockPublisher{PublishErr: tc.publishErr}
+			notifier := &MockNotifier{NotifyErr: tc.notifyErr}
 			svc := Service{
-				Repo:        repo,
-				UserService: userSvc,
+				Repo:           repo,
+				AccountService: accountSvc,
+				Webhooks:       publisher,
+				Notifier:       notifier,
 			}
 
-			_, gotUser, gotErr := svc.TransferMoney(
-				tc.input.v, tc.input.fromUser, tc.input.toUserEmail, tc.input.amount,
+			_, gotAccount, gotErr := svc.TransferMoney(
+				context.Background(), validator.New(), fromUser, fromAccount.Number, toAccount.Number,
+				tc.amount,
 			)
 
-			if gotErr != tc.expectedErr {
-				t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
-			}
-			if gotErr != nil {
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				if len(publisher.Events) != 0 {
+					t.Errorf("expected no webhook for a failed transfer, got %v", publisher.Events)
+				}
 				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error %v", gotErr)
+			}
+
+			if len(publisher.Events) != 1 || publisher.Events[0] != webhook.EventTransfer {
+				t.Fatalf("expected a %s webhook, got %v", webhook.EventTransfer, publisher.Events)
+			}
+			if publisher.Data[0]["amount"] != tc.amount.String() {
+				t.Errorf("expected amount %s, got %v", tc.amount, publisher.Data[0]["amount"])
+			}
+
+			if len(notifier.Events) != 2 ||
+				notifier.Events[0] != notification.EventTransferSent ||
+				notifier.Events[1] != notification.EventTransferReceived {
+				t.Errorf("expected the sender and receiver notified, got %v", notifier.Events)
 			}
 
-			if toUser.AccountBalance != tc.finalTo {
+			if gotAccount.Balance.Cmp(tc.finalFrom) != 0 {
 				t.Errorf(
-					"expected balances from=%v, to=%v; got from=%v, to=%v", tc.finalFrom, tc.finalTo,
-					gotUser.AccountBalance, toUser.AccountBalance,
+					"expected balance from=%s, got from=%s", tc.finalFrom, gotAccount.Balance,
 				)
 			}
+
+			if repo.Entry == nil {
+				t.Fatal("expected the ledger entry to be inserted with the transfer")
+			}
+			for _, p := range repo.Entry.Postings {
+				switch p.Account {
+				case ledger.CustomerAccount(fromAccount.ID):
+					if p.Amount.Cmp(tc.amount.Neg()) != 0 {
+						t.Errorf("expected sender posting %s, got %s", tc.amount.Neg(), p.Amount)
+					}
+				case ledger.CustomerAccount(toAccount.ID):
+					if p.Amount.Cmp(tc.amount) != 0 {
+						t.Errorf("expected recipient posting %s, got %s", tc.amount, p.Amount)
+					}
+				default:
+					t.Errorf("unexpected posting to account %s", p.Account)
+				}
+			}
+		})
+	}
+}
+
+func TestGetAllForUser(t *testing.T) {
+	transfers := []*Transfer{{ID: 1}, {ID: 2}}
+
+	tests := []struct {
+		name        string
+		setupRepo   func(*MockRepo)
+		filters     filter.Filters
+		wantLen     int
+		expectedErr error
+	}{
+		{
+			name: "valid",
+			setupRepo: func(r *MockRepo) {
+				r.GetAllForUserResult = transfers
+			},
+			filters: filter.Filters{
+				Page: 1, PageSize: 20, Sort: "-created_at", SortSafelist: SortSafelist,
+			},
+			wantLen: 2,
+		},
+		{
+			name:      "sort by a column that isn't safe",
+			setupRepo: func(r *MockRepo) {},
+			filters: filter.Filters{
+				Page: 1, PageSize: 20, Sort: "from_user_id", SortSafelist: SortSafelist,
+			},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name: "GetAllForUser failure",
+			setupRepo: func(r *MockRepo) {
+				r.GetAllForUserErr = errors.New("db GetAllForUser error")
+			},
+			filters: filter.Filters{
+				Page: 1, PageSize: 20, Sort: "-created_at", SortSafelist: SortSafelist,
+			},
+			expectedErr: errors.New("db GetAllForUser error"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tc.setupRepo(repo)
+			svc := Service{Repo: repo}
+
+			gotTransfers, metadata, gotErr := svc.GetAllForUser(
+				context.Background(), validator.New(), 1, tc.filters,
+			)
+			if tc.expectedErr != nil {
+				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, gotErr)
+				}
+				return
+			} else if gotErr != nil {
+				t.Fatalf("unexpected error %v", gotErr)
+			}
+
+			if len(gotTransfers) != tc.wantLen {
+				t.Errorf("expected %d transfers, got %d", tc.wantLen, len(gotTransfers))
+			}
+			if metadata.TotalRecords != tc.wantLen {
+				t.Errorf("expected %d total records, got %d", tc.wantLen, metadata.TotalRecords)
+			}
 		})
 	}
 }
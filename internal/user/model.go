@@ -9,14 +9,13 @@ import (
 
 // User is custom struct to hold the user information and details
 type User struct {
-	ID             int64     `json:"id"`
-	CreatedAt      time.Time `json:"created_at"`
-	Name           string    `json:"name"`
-	Email          string    `json:"email"`
-	Password       password  `json:"-"`
-	Activated      bool      `json:"activated"`
-	AccountBalance float64   `json:"account_balance"`
-	Version        int32     `json:"version"`
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  password  `json:"-"`
+	Activated bool      `json:"activated"`
+	Version   int32     `json:"version"`
 }
 
 // AnonymousUser is for use not signed in
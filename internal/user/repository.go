@@ -6,6 +6,10 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+	"github.com/Yusufdot101/goBankBackend/internal/token"
 )
 
 var (
@@ -14,26 +18,105 @@ var (
 )
 
 type Repository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// querier is what inserting a user needs, so it can be done on its own or inside a transaction
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (r *Repository) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	return insert(ctx, r.DB, user)
 }
 
-func (r *Repository) Insert(user *User) error {
+// RegisterTx inserts the user together with an activation token for them and the welcome email
+// made by welcome, in one transaction. a user is never left without a way to activate their
+// account, and the email is never queued for a user that wasn't created
+func (r *Repository) RegisterTx(
+	ctx context.Context, user *User, activationTTL time.Duration, welcome func(
+		t *token.Token,
+	) *outbox.Message,
+) (*token.Token, error) {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = insert(ctx, tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := token.NewTx(ctx, tx, user.ID, activationTTL, token.ScopeActivation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = outbox.InsertTx(ctx, tx, welcome(t))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// NewTokenTx makes a token with the scope for the user and queues the email made by message with
+// it, in one transaction, so there is never an email with a token that wasn't made or a token
+// that nobody was sent
+func (r *Repository) NewTokenTx(
+	ctx context.Context, userID int64, ttl time.Duration, scope string, message func(
+		t *token.Token,
+	) *outbox.Message,
+) (*token.Token, error) {
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := token.NewTx(ctx, tx, userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = outbox.InsertTx(ctx, tx, message(t))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func insert(ctx context.Context, q querier, user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, account_balance)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (name, email, password_hash)
+		VALUES ($1, $2, $3)
 		RETURNING id, created_at, activated, version
 	`
 
-	// create a 3 sec context so that the request doesnt take too long and hold the resources
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := r.DB.QueryRowContext(
-		ctx, query, user.Name, user.Email, user.Password.Hash, user.AccountBalance,
-	).Scan(
+	err := q.QueryRowContext(ctx, query, user.Name, user.Email, user.Password.Hash).Scan(
 		&user.ID,
 		&user.CreatedAt,
-		// &user.AccountBalance,
 		&user.Activated,
 		&user.Version,
 	)
@@ -51,14 +134,14 @@ func (r *Repository) Insert(user *User) error {
 	return nil
 }
 
-func (r *Repository) Get(userID int64) (*User, error) {
+func (r *Repository) Get(ctx context.Context, userID int64) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, account_balance, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
 		WHERE id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	var user User
@@ -69,7 +152,6 @@ func (r *Repository) Get(userID int64) (*User, error) {
 		&user.Name,
 		&user.Email,
 		&user.Password.Hash,
-		&user.AccountBalance,
 		&user.Activated,
 		&user.Version,
 	)
@@ -85,14 +167,14 @@ func (r *Repository) Get(userID int64) (*User, error) {
 	return &user, nil
 }
 
-func (r *Repository) GetByEmail(email string) (*User, error) {
+func (r *Repository) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, account_balance, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
 		WHERE email = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	var user User
@@ -103,7 +185,6 @@ func (r *Repository) GetByEmail(email string) (*User, error) {
 		&user.Name,
 		&user.Email,
 		&user.Password.Hash,
-		&user.AccountBalance,
 		&user.Activated,
 		&user.Version,
 	)
@@ -119,10 +200,10 @@ func (r *Repository) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (r *Repository) GetForToken(tokenPlaintext, scope string) (*User, error) {
+func (r *Repository) GetForToken(ctx context.Context, tokenPlaintext, scope string) (*User, error) {
 	query := `
 		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, 
-			users.account_balance, users.activated, users.version
+			users.activated, users.version
 		FROM users
 		INNER JOIN tokens 
 		ON users.id = tokens.user_id
@@ -138,7 +219,7 @@ func (r *Repository) GetForToken(tokenPlaintext, scope string) (*User, error) {
 		time.Now(),
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	var user User
@@ -149,7 +230,6 @@ func (r *Repository) GetForToken(tokenPlaintext, scope string) (*User, error) {
 		&user.Name,
 		&user.Email,
 		&user.Password.Hash,
-		&user.AccountBalance,
 		&user.Activated,
 		&user.Version,
 	)
@@ -166,9 +246,9 @@ func (r *Repository) GetForToken(tokenPlaintext, scope string) (*User, error) {
 }
 
 func (r *Repository) UpdateTx(
-	userID int64, name, email string, passwordHash []byte, accountBalance float64, activated bool,
+	ctx context.Context, userID int64, name, email string, passwordHash []byte, activated bool,
 ) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	tx, err := r.DB.BeginTx(ctx, nil)
@@ -178,7 +258,7 @@ func (r *Repository) UpdateTx(
 	defer tx.Rollback()
 
 	query := `
-		SELECT id, created_at, name, email, password_hash, account_balance, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
 		WHERE id = $1
 		FOR UPDATE
@@ -191,7 +271,6 @@ func (r *Repository) UpdateTx(
 		&user.Name,
 		&user.Email,
 		&user.Password.Hash,
-		&user.AccountBalance,
 		&user.Activated,
 		&user.Version,
 	)
@@ -201,17 +280,15 @@ func (r *Repository) UpdateTx(
 
 	updateQuery := `
 		UPDATE users
-		set name = $1, email = $2, password_hash = $3, account_balance = $4, activated = $5, 
-			version = version + 1
-		WHERE id = $6
-		RETURNING name, email, password_hash, account_balance, activated
+		set name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+		WHERE id = $5
+		RETURNING name, email, password_hash, activated
 	`
 
 	args := []any{
 		name,
 		email,
 		passwordHash,
-		accountBalance,
 		activated,
 		userID,
 	}
@@ -220,7 +297,6 @@ func (r *Repository) UpdateTx(
 		&user.Name,
 		&user.Email,
 		&user.Password.Hash,
-		&user.AccountBalance,
 		&user.Activated,
 	)
 	if err != nil {
@@ -1,31 +1,53 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
 	"github.com/Yusufdot101/goBankBackend/internal/token"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/user")
+
 type UserRepo interface {
-	Insert(user *User) error
-	Get(userID int64) (*User, error)
-	GetByEmail(email string) (*User, error)
-	GetForToken(tokenPlaintext, scope string) (*User, error)
+	Insert(ctx context.Context, user *User) error
+	RegisterTx(
+		ctx context.Context, user *User, activationTTL time.Duration, welcome func(
+			t *token.Token,
+		) *outbox.Message,
+	) (*token.Token, error)
+	NewTokenTx(
+		ctx context.Context, userID int64, ttl time.Duration, scope string, message func(
+			t *token.Token,
+		) *outbox.Message,
+	) (*token.Token, error)
+	Get(ctx context.Context, userID int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetForToken(ctx context.Context, tokenPlaintext, scope string) (*User, error)
 	UpdateTx(
-		userID int64, name, email string, passwordHash []byte,
-		accountBalance float64, activated bool,
+		ctx context.Context, userID int64, name, email string, passwordHash []byte, activated bool,
 	) (*User, error)
 }
 
+// ActivationTTL is how long the activation token a new user is emailed can be used for
+const ActivationTTL = 3 * 24 * time.Hour
+
+// PasswordResetTTL is how long a password reset token can be used for
+const PasswordResetTTL = 45 * time.Minute
+
 type Mailer interface {
-	Send(to, template string, data map[string]any) error
+	Send(ctx context.Context, to, template string, data map[string]any) error
 }
 
 type TokenService interface {
-	New(userID int64, timeToLive time.Duration, scope string) (*token.Token, error)
-	DeleteAllForUser(userID int64, scope string) error
+	New(
+		ctx context.Context, userID int64, timeToLive time.Duration, scope string,
+	) (*token.Token, error)
+	DeleteAllForUser(ctx context.Context, userID int64, scope string) error
 }
 
 type Service struct {
@@ -34,8 +56,11 @@ type Service struct {
 	TokenService TokenService
 }
 
-func (s *Service) GetUser(userID int64) (*User, error) {
-	user, err := s.Repo.Get(userID)
+func (s *Service) GetUser(ctx context.Context, userID int64) (*User, error) {
+	ctx, span := tracer.Start(ctx, "user.GetUser")
+	defer span.End()
+
+	user, err := s.Repo.Get(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -43,8 +68,11 @@ func (s *Service) GetUser(userID int64) (*User, error) {
 	return user, nil
 }
 
-func (s *Service) GetUserByEmail(email string) (*User, error) {
-	user, err := s.Repo.GetByEmail(email)
+func (s *Service) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := tracer.Start(ctx, "user.GetUserByEmail")
+	defer span.End()
+
+	user, err := s.Repo.GetByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
@@ -53,16 +81,22 @@ func (s *Service) GetUserByEmail(email string) (*User, error) {
 }
 
 func (s *Service) UpdateUser(
-	userID int64, name, email string, passwordHash []byte, accountBalance float64, activated bool,
+	ctx context.Context, userID int64, name, email string, passwordHash []byte, activated bool,
 ) (*User, error) {
-	user, err := s.Repo.UpdateTx(userID, name, email, passwordHash, accountBalance, activated)
+	ctx, span := tracer.Start(ctx, "user.UpdateUser")
+	defer span.End()
+
+	user, err := s.Repo.UpdateTx(ctx, userID, name, email, passwordHash, activated)
 	return user, err
 }
 
 func (s *Service) Register(
-	v *validator.Validator,
+	ctx context.Context, v *validator.Validator,
 	name, email, passwordPlaintext string,
 ) (*User, *token.Token, error) {
+	ctx, span := tracer.Start(ctx, "user.Register")
+	defer span.End()
+
 	user := &User{
 		Name:  name,
 		Email: email,
@@ -77,13 +111,18 @@ func (s *Service) Register(
 		return nil, nil, validator.ErrFailedValidation
 	}
 
-	err = s.Repo.Insert(user)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	// get the activation token and send it to the user
-	t, err := s.TokenService.New(user.ID, 3*24*time.Hour, token.ScopeActivation)
+	// the user is emailed their activation token through the outbox, queued along with the user
+	t, err := s.Repo.RegisterTx(ctx, user, ActivationTTL, func(t *token.Token) *outbox.Message {
+		return &outbox.Message{
+			Recipient: user.Email,
+			Template:  "user_welcome.html",
+			Data: map[string]any{
+				"userName": user.Name,
+				"userID":   user.ID,
+				"token":    t.Plaintext,
+			},
+		}
+	})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -91,8 +130,13 @@ func (s *Service) Register(
 	return user, t, nil
 }
 
-func (s *Service) GetUserForToken(tokenPlaintext, scope string) (*User, error) {
-	user, err := s.Repo.GetForToken(tokenPlaintext, scope)
+func (s *Service) GetUserForToken(
+	ctx context.Context, tokenPlaintext, scope string,
+) (*User, error) {
+	ctx, span := tracer.Start(ctx, "user.GetUserForToken")
+	defer span.End()
+
+	user, err := s.Repo.GetForToken(ctx, tokenPlaintext, scope)
 	if err != nil {
 		return nil, err
 	}
@@ -100,8 +144,11 @@ func (s *Service) GetUserForToken(tokenPlaintext, scope string) (*User, error) {
 	return user, nil
 }
 
-func (s *Service) Activate(tokenPlaintext string) (*User, error) {
-	u, err := s.Repo.GetForToken(tokenPlaintext, token.ScopeActivation)
+func (s *Service) Activate(ctx context.Context, tokenPlaintext string) (*User, error) {
+	ctx, span := tracer.Start(ctx, "user.Activate")
+	defer span.End()
+
+	u, err := s.Repo.GetForToken(ctx, tokenPlaintext, token.ScopeActivation)
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrNoRecord):
@@ -114,12 +161,12 @@ func (s *Service) Activate(tokenPlaintext string) (*User, error) {
 
 	u.Activated = true
 
-	u, err = s.Repo.UpdateTx(u.ID, u.Name, u.Email, u.Password.Hash, u.AccountBalance, u.Activated)
+	u, err = s.Repo.UpdateTx(ctx, u.ID, u.Name, u.Email, u.Password.Hash, u.Activated)
 	if err != nil {
 		return u, err
 	}
 
-	err = s.TokenService.DeleteAllForUser(u.ID, token.ScopeActivation)
+	err = s.TokenService.DeleteAllForUser(ctx, u.ID, token.ScopeActivation)
 	if err != nil {
 		return nil, err
 	}
@@ -127,32 +174,94 @@ func (s *Service) Activate(tokenPlaintext string) (*User, error) {
 	return u, nil
 }
 
-func (s *Service) TransferMoney(fromUser, toUser *User, amount float64) (*User, error) {
-	fromUser.AccountBalance -= amount
-	fromUser, err := s.Repo.UpdateTx(
-		fromUser.ID, fromUser.Name, fromUser.Email, fromUser.Password.Hash,
-		fromUser.AccountBalance, fromUser.Activated,
-	)
+// RequestPasswordReset makes a password reset token for the user with the email and queues the
+// email that sends it to them. a user that
+// doesn't exist is not an error, nil is returned for both the user and the token so that callers
+// can respond the same either way and not give away which emails have accounts
+func (s *Service) RequestPasswordReset(
+	ctx context.Context, v *validator.Validator, email string,
+) (*User, *token.Token, error) {
+	ctx, span := tracer.Start(ctx, "user.RequestPasswordReset")
+	defer span.End()
+
+	if ValidateEmail(v, email); !v.IsValid() {
+		return nil, nil, validator.ErrFailedValidation
+	}
+
+	u, err := s.Repo.GetByEmail(ctx, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoRecord):
+			return nil, nil, nil
+
+		default:
+			return nil, nil, err
+		}
+	}
+
+	// the reset email is queued with the token, a token is never made without it
+	resetEmail := func(t *token.Token) *outbox.Message {
+		return &outbox.Message{
+			Recipient: u.Email,
+			Template:  "password_reset.html",
+			Data: map[string]any{
+				"userName": u.Name,
+				"token":    t.Plaintext,
+			},
+		}
+	}
+	t, err := s.Repo.NewTokenTx(ctx, u.ID, PasswordResetTTL, token.ScopePasswordReset, resetEmail)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, t, nil
+}
+
+// ResetPassword sets the password of the user the reset token was made for. the reset tokens of
+// the user are used up and every access and refresh token they have is revoked, so whoever might
+// have known the old password is signed out
+func (s *Service) ResetPassword(
+	ctx context.Context, v *validator.Validator, tokenPlaintext, passwordPlaintext string,
+) (*User, error) {
+	ctx, span := tracer.Start(ctx, "user.ResetPassword")
+	defer span.End()
+
+	token.ValidateToken(v, tokenPlaintext)
+	ValidatePasswordPlaintext(v, passwordPlaintext)
+	if !v.IsValid() {
+		return nil, validator.ErrFailedValidation
+	}
+
+	u, err := s.Repo.GetForToken(ctx, tokenPlaintext, token.ScopePasswordReset)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoRecord):
+			v.AddError("token", "invalid or expired password reset token")
+			return nil, validator.ErrFailedValidation
+
+		default:
+			return nil, err
+		}
+	}
+
+	err = u.Password.Set(passwordPlaintext, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err = s.Repo.UpdateTx(ctx, u.ID, u.Name, u.Email, u.Password.Hash, u.Activated)
 	if err != nil {
 		return nil, err
 	}
 
-	// update the recipient account
-	toUser.AccountBalance += amount
-	_, err = s.Repo.UpdateTx(
-		toUser.ID, toUser.Name, toUser.Email, toUser.Password.Hash,
-		toUser.AccountBalance, toUser.Activated,
-	)
-	// if no error, return the updated state of the sender account
-	if err == nil {
-		return fromUser, nil
-	}
-
-	// in case of an error transferring the money, try to put the amount taken from the sender back
-	fromUser.AccountBalance += amount
-	_, err = s.Repo.UpdateTx(
-		fromUser.ID, fromUser.Name, fromUser.Email, fromUser.Password.Hash,
-		fromUser.AccountBalance, fromUser.Activated,
-	)
-	return nil, err
+	scopes := []string{token.ScopePasswordReset, token.ScopeAuthorization, token.ScopeRefresh}
+	for _, scope := range scopes {
+		err = s.TokenService.DeleteAllForUser(ctx, u.ID, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return u, nil
 }
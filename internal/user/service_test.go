@@ -1,10 +1,12 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
 	"github.com/Yusufdot101/goBankBackend/internal/token"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
@@ -13,6 +15,13 @@ import (
 
 type MockRepo struct {
 	InsertErr error
+	Welcome   *outbox.Message
+
+	NewTokenTxErr error
+	Message       *outbox.Message // the email NewTokenTx would queue
+
+	GetByEmailResult *User
+	GetByEmailErr    error
 
 	GetForTokenResult *User
 	GetForTokenErr    error
@@ -21,24 +30,53 @@ type MockRepo struct {
 	UpdateTxErr    error
 }
 
-func (r *MockRepo) Insert(user *User) error {
+func (r *MockRepo) Insert(ctx context.Context, user *User) error {
 	return r.InsertErr
 }
 
-func (r *MockRepo) Get(userID int64) (*User, error) {
-	return nil, nil
+// RegisterTx fails with InsertErr like Insert, the welcome email it would queue is kept in Welcome
+func (r *MockRepo) RegisterTx(
+	ctx context.Context, user *User, activationTTL time.Duration, welcome func(
+		t *token.Token,
+	) *outbox.Message,
+) (*token.Token, error) {
+	if r.InsertErr != nil {
+		return nil, r.InsertErr
+	}
+
+	t := &token.Token{Plaintext: "mock-token", Scope: token.ScopeActivation}
+	r.Welcome = welcome(t)
+	return t, nil
 }
 
-func (r *MockRepo) GetByEmail(email string) (*User, error) {
+func (r *MockRepo) NewTokenTx(
+	ctx context.Context, userID int64, ttl time.Duration, scope string, message func(
+		t *token.Token,
+	) *outbox.Message,
+) (*token.Token, error) {
+	if r.NewTokenTxErr != nil {
+		return nil, r.NewTokenTxErr
+	}
+
+	t := &token.Token{Plaintext: "mock-token", Scope: scope}
+	r.Message = message(t)
+	return t, nil
+}
+
+func (r *MockRepo) Get(ctx context.Context, userID int64) (*User, error) {
 	return nil, nil
 }
 
-func (r *MockRepo) GetForToken(tokenPlaintext, scope string) (*User, error) {
+func (r *MockRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return r.GetByEmailResult, r.GetByEmailErr
+}
+
+func (r *MockRepo) GetForToken(ctx context.Context, tokenPlaintext, scope string) (*User, error) {
 	return r.GetForTokenResult, r.GetForTokenErr
 }
 
 func (r *MockRepo) UpdateTx(
-	userID int64, name, email string, passwordHash []byte, balance float64, activate bool,
+	ctx context.Context, userID int64, name, email string, passwordHash []byte, activate bool,
 ) (*User, error) {
 	return r.UpdateTxResult, r.UpdateTxErr
 }
@@ -48,11 +86,12 @@ type MockTokenService struct {
 	NewResult *token.Token
 	NewErr    error
 
-	DeleteAllErr error
+	DeleteAllErr  error
+	DeletedScopes []string
 }
 
 func (ts *MockTokenService) New(
-	userID int64, timeToLive time.Duration, scope string,
+	ctx context.Context, userID int64, timeToLive time.Duration, scope string,
 ) (*token.Token, error) {
 	if ts.NewErr != nil {
 		return nil, ts.NewErr
@@ -61,8 +100,15 @@ func (ts *MockTokenService) New(
 	return ts.NewResult, nil
 }
 
-func (ts *MockTokenService) DeleteAllForUser(userID int64, scope string) error {
-	return ts.DeleteAllErr
+func (ts *MockTokenService) DeleteAllForUser(
+	ctx context.Context, userID int64, scope string,
+) error {
+	if ts.DeleteAllErr != nil {
+		return ts.DeleteAllErr
+	}
+
+	ts.DeletedScopes = append(ts.DeletedScopes, scope)
+	return nil
 }
 
 func TestRegister(t *testing.T) {
@@ -124,7 +170,9 @@ func TestRegister(t *testing.T) {
 			}
 			v := validator.New()
 
-			user, tkn, err := svc.Register(v, tc.input.Name, tc.input.Email, tc.input.Password)
+			user, tkn, err := svc.Register(
+				context.Background(), v, tc.input.Name, tc.input.Email, tc.input.Password,
+			)
 			if tc.expectedErr != nil {
 				if err == nil || err.Error() != tc.expectedErr.Error() {
 					t.Fatalf("expected err %v, got %v", tc.expectedErr, err)
@@ -137,6 +185,13 @@ func TestRegister(t *testing.T) {
 			if user == nil || tkn == nil {
 				t.Fatal("expected user and token to be returned")
 			}
+
+			// the welcome email carries the activation token
+			if repo.Welcome == nil || repo.Welcome.Recipient != tc.input.Email ||
+				repo.Welcome.Data["token"] != tkn.Plaintext {
+				t.Errorf("expected the activation token queued for %s, got %+v", tc.input.Email,
+					repo.Welcome)
+			}
 		})
 	}
 }
@@ -208,7 +263,7 @@ func TestActivate(t *testing.T) {
 				TokenService: tokenSvc,
 			}
 
-			gotUser, gotErr := svc.Activate("token")
+			gotUser, gotErr := svc.Activate(context.Background(), "token")
 
 			if tc.expectedErr != nil {
 				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
@@ -229,41 +284,43 @@ func TestActivate(t *testing.T) {
 	}
 }
 
-func TestTransferMoney(t *testing.T) {
-	fromUser := &User{
-		ID: 1, Name: "yusuf", Email: "a@b.com", AccountBalance: 100,
-	}
-	toUser := &User{
-		ID: 2, Name: "mohamed", Email: "b@a.com", AccountBalance: 50,
-	}
-
+func TestRequestPasswordReset(t *testing.T) {
 	tests := []struct {
 		name        string
-		amount      float64
+		email       string
 		setupRepo   func(*MockRepo)
+		wantToken   bool
 		expectedErr error
-		finalFrom   float64
-		finalTo     float64
 	}{
 		{
-			name:   "valid input",
-			amount: 10,
+			name:  "existing user",
+			email: "a@b.com",
 			setupRepo: func(r *MockRepo) {
-				// after deduct
-				r.UpdateTxResult = &User{ID: 1, AccountBalance: 90}
+				r.GetByEmailResult = &User{ID: 1, Email: "a@b.com"}
 			},
-			finalFrom:   90,
-			finalTo:     60,
-			expectedErr: nil,
+			wantToken: true,
+		},
+		{
+			name:  "unknown email",
+			email: "a@b.com",
+			setupRepo: func(r *MockRepo) {
+				r.GetByEmailErr = ErrNoRecord
+			},
+			wantToken: false,
+		},
+		{
+			name:        "invalid email",
+			email:       "not an email",
+			setupRepo:   func(r *MockRepo) {},
+			expectedErr: validator.ErrFailedValidation,
 		},
 		{
-			name:   "update failure",
-			amount: 10,
+			name:  "NewTokenTx failure",
+			email: "a@b.com",
 			setupRepo: func(r *MockRepo) {
-				r.UpdateTxErr = errors.New("db error")
+				r.GetByEmailResult = &User{ID: 1, Email: "a@b.com"}
+				r.NewTokenTxErr = errors.New("db error")
 			},
-			finalFrom:   100,
-			finalTo:     50,
 			expectedErr: errors.New("db error"),
 		},
 	}
@@ -272,25 +329,119 @@ func TestTransferMoney(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			repo := &MockRepo{}
 			tc.setupRepo(repo)
-			svc := Service{
-				Repo: repo,
+			svc := &Service{Repo: repo}
+
+			_, tkn, err := svc.RequestPasswordReset(context.Background(), validator.New(), tc.email)
+			if tc.expectedErr != nil {
+				if err == nil || err.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected err %v, got %v", tc.expectedErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error %s", err)
 			}
 
-			gotUser, gotErr := svc.TransferMoney(fromUser, toUser, tc.amount)
+			if (tkn != nil) != tc.wantToken {
+				t.Errorf("expected token=%v, got %v", tc.wantToken, tkn)
+			}
+			if !tc.wantToken {
+				return
+			}
+			msg := repo.Message
+			if msg == nil || msg.Template != "password_reset.html" ||
+				msg.Data["token"] != tkn.Plaintext {
+				t.Errorf("expected the reset email queued with the token, got %+v", msg)
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	validToken := "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	tests := []struct {
+		name          string
+		token         string
+		password      string
+		setupRepo     func(*MockRepo)
+		setupTokenSvc func(*MockTokenService)
+		expectedErr   error
+	}{
+		{
+			name:     "valid token",
+			token:    validToken,
+			password: "new-password",
+			setupRepo: func(r *MockRepo) {
+				r.GetForTokenResult = &User{ID: 1, Name: "yusuf", Email: "a@b.com"}
+				r.UpdateTxResult = &User{ID: 1, Name: "yusuf", Email: "a@b.com"}
+			},
+			setupTokenSvc: func(ts *MockTokenService) {},
+		},
+		{
+			name:     "invalid token",
+			token:    validToken,
+			password: "new-password",
+			setupRepo: func(r *MockRepo) {
+				r.GetForTokenErr = ErrNoRecord
+			},
+			setupTokenSvc: func(ts *MockTokenService) {},
+			expectedErr:   validator.ErrFailedValidation,
+		},
+		{
+			name:          "password too short",
+			token:         validToken,
+			password:      "short",
+			setupRepo:     func(r *MockRepo) {},
+			setupTokenSvc: func(ts *MockTokenService) {},
+			expectedErr:   validator.ErrFailedValidation,
+		},
+		{
+			name:     "delete tokens failure",
+			token:    validToken,
+			password: "new-password",
+			setupRepo: func(r *MockRepo) {
+				r.GetForTokenResult = &User{ID: 1, Name: "yusuf", Email: "a@b.com"}
+				r.UpdateTxResult = &User{ID: 1, Name: "yusuf", Email: "a@b.com"}
+			},
+			setupTokenSvc: func(ts *MockTokenService) {
+				ts.DeleteAllErr = errors.New("db fail")
+			},
+			expectedErr: errors.New("db fail"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{}
+			tokenSvc := &MockTokenService{}
+			tc.setupRepo(repo)
+			tc.setupTokenSvc(tokenSvc)
+			svc := &Service{
+				Repo:         repo,
+				TokenService: tokenSvc,
+			}
+
+			_, err := svc.ResetPassword(context.Background(), validator.New(), tc.token, tc.password)
 			if tc.expectedErr != nil {
-				if gotErr == nil || gotErr.Error() != tc.expectedErr.Error() {
-					t.Fatalf("expected error %v, got error %v", tc.expectedErr, gotErr)
+				if err == nil || err.Error() != tc.expectedErr.Error() {
+					t.Fatalf("expected err %v, got %v", tc.expectedErr, err)
 				}
 				return
-			} else if gotErr != nil {
-				t.Fatalf("unexpected error %v", gotErr)
+			} else if err != nil {
+				t.Fatalf("unexpected error %s", err)
 			}
 
-			if gotUser.AccountBalance != tc.finalFrom {
-				t.Fatalf(
-					"expected balances from=%v, to=%v; got from=%v, to=%v", tc.finalFrom,
-					tc.finalTo, fromUser.AccountBalance, toUser.AccountBalance,
-				)
+			// the reset tokens are used up and every session is signed out
+			want := []string{
+				token.ScopePasswordReset, token.ScopeAuthorization, token.ScopeRefresh,
+			}
+			if len(tokenSvc.DeletedScopes) != len(want) {
+				t.Fatalf("expected tokens deleted for %v, got %v", want, tokenSvc.DeletedScopes)
+			}
+			for i, scope := range want {
+				if tokenSvc.DeletedScopes[i] != scope {
+					t.Errorf("expected tokens deleted for %v, got %v", want, tokenSvc.DeletedScopes)
+				}
 			}
 		})
 	}
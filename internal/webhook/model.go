@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// the events endpoints can subscribe to, they are sent once what they are about is committed
+const (
+	EventTransfer     = "TRANSFER"
+	EventDeposit      = "DEPOSIT"
+	EventWithdrawal   = "WITHDRAWAL"
+	EventLoanAccepted = "LOAN_ACCEPTED"
+	EventLoanPayment  = "LOAN_PAYMENT"
+)
+
+var Events = []string{
+	EventTransfer, EventDeposit, EventWithdrawal, EventLoanAccepted, EventLoanPayment,
+}
+
+// the states a delivery moves through, like the messages of the outbox. a delivery is PENDING
+// until the endpoint accepts it or it has failed MaxAttempts times, at which point it is DEAD
+const (
+	StatusPending   = "PENDING"
+	StatusDelivered = "DELIVERED"
+	StatusDead      = "DEAD"
+)
+
+var Statuses = []string{StatusPending, StatusDelivered, StatusDead}
+
+// the headers every delivery is sent with. the signature is the hex HMAC-SHA256, keyed with the
+// secret of the endpoint, of the timestamp and the body joined by a dot, e.g. "1700000000.{...}".
+// receivers should check it and reject timestamps too far from their own clock, so a delivery that
+// was seen can't be sent to them again by someone else
+const (
+	HeaderID        = "X-Webhook-ID"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// SortSafelist is what endpoints and deliveries can be listed by
+var SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+var ErrNotFinished = errors.New("only delivered or dead deliveries can be replayed")
+
+// Endpoint is a URL registered by an admin to be sent the events it subscribed to. the secret
+// deliveries are signed with is only shown once, when the endpoint is registered
+type Endpoint struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	CreatedBy   int64     `json:"created_by"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	Active      bool      `json:"active"`
+	Secret      string    `json:"-"`
+}
+
+// Delivery is an event sent, or to be sent, to an endpoint. the payload is sent as it was when the
+// event happened, however many times it is tried. a replay is a new delivery of the same payload
+type Delivery struct {
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	EndpointID     int64      `json:"endpoint_id"`
+	Event          string     `json:"event"`
+	Payload        []byte     `json:"-"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	ResponseStatus int        `json:"response_status,omitempty"` // of the last attempt
+	LastError      string     `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	ReplayOf       *int64     `json:"replay_of,omitempty"`
+	// where to send it and what to sign it with, only set on claimed deliveries
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+func ValidateEndpoint(v *validator.Validator, endpoint *Endpoint) {
+	u, err := url.Parse(endpoint.URL)
+	v.CheckAddError(endpoint.URL != "", "url", "must be given")
+	v.CheckAddError(
+		err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "url",
+		"must be an absolute http or https URL",
+	)
+	v.CheckAddError(
+		len(endpoint.Description) <= 500, "description", "must not be more than 500 bytes",
+	)
+
+	v.CheckAddError(len(endpoint.Events) > 0, "events", "must be given")
+	seen := map[string]bool{}
+	for _, event := range endpoint.Events {
+		v.CheckAddError(validator.ValueInList(event, Events...), "events", "unknown event "+event)
+		v.CheckAddError(!seen[event], "events", "must not have duplicates")
+		seen[event] = true
+	}
+}
+
+// Sign returns the signature of the body sent at timestamp, a unix time in seconds
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"TRANSFER"}`)
+
+	// worked out with: printf '1700000000.{"event":"TRANSFER"}' | openssl sha256 -hmac whsec_test
+	want := "sha256=ecfdd4cb6ae1a56258904fd26f4d0c6326886bebd41b5cd60b547e8d1c25bcd9"
+	got := Sign("whsec_test", 1700000000, body)
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	if Sign("whsec_other", 1700000000, body) == want {
+		t.Errorf("expected a different signature for another secret")
+	}
+	if Sign("whsec_test", 1700000001, body) == want {
+		t.Errorf("expected a different signature for another timestamp")
+	}
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint Endpoint
+		valid    bool
+	}{
+		{
+			name:     "valid",
+			endpoint: Endpoint{URL: "https://crm.example.com/hooks", Events: []string{EventDeposit}},
+			valid:    true,
+		},
+		{
+			name:     "no url",
+			endpoint: Endpoint{Events: []string{EventDeposit}},
+		},
+		{
+			name:     "relative url",
+			endpoint: Endpoint{URL: "/hooks", Events: []string{EventDeposit}},
+		},
+		{
+			name:     "not http",
+			endpoint: Endpoint{URL: "ftp://example.com/hooks", Events: []string{EventDeposit}},
+		},
+		{
+			name:     "no events",
+			endpoint: Endpoint{URL: "https://example.com/hooks"},
+		},
+		{
+			name:     "unknown event",
+			endpoint: Endpoint{URL: "https://example.com/hooks", Events: []string{"REFUND"}},
+		},
+		{
+			name: "duplicate events",
+			endpoint: Endpoint{
+				URL: "https://example.com/hooks", Events: []string{EventDeposit, EventDeposit},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateEndpoint(v, &tc.endpoint)
+			if v.IsValid() != tc.valid {
+				t.Errorf("expected valid %v, got %v: %v", tc.valid, v.IsValid(), v.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,486 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/lib/pq"
+)
+
+type Repository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// the columns of an endpoint, in the order scanEndpoint reads them
+const endpointColumns = `id, created_at, COALESCE(created_by, 0), url, description, events, active,
+	secret`
+
+// scanEndpoint reads a row of endpointColumns, after any columns selected before them into leading
+func scanEndpoint(row interface{ Scan(...any) error }, leading ...any) (*Endpoint, error) {
+	var endpoint Endpoint
+	err := row.Scan(append(
+		leading,
+		&endpoint.ID,
+		&endpoint.CreatedAt,
+		&endpoint.CreatedBy,
+		&endpoint.URL,
+		&endpoint.Description,
+		pq.Array(&endpoint.Events),
+		&endpoint.Active,
+		&endpoint.Secret,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &endpoint, nil
+}
+
+// the columns of a delivery, in the order scanDelivery reads them
+const deliveryColumns = `webhook_deliveries.id, webhook_deliveries.created_at, endpoint_id, event,
+	payload, status, attempts, next_attempt_at, response_status, last_error, delivered_at, replay_of`
+
+// scanDelivery reads a row of deliveryColumns, after any columns selected before them into leading
+func scanDelivery(row interface{ Scan(...any) error }, leading ...any) (*Delivery, error) {
+	var delivery Delivery
+	err := row.Scan(append(
+		leading,
+		&delivery.ID,
+		&delivery.CreatedAt,
+		&delivery.EndpointID,
+		&delivery.Event,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.ResponseStatus,
+		&delivery.LastError,
+		&delivery.DeliveredAt,
+		&delivery.ReplayOf,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// InsertEndpoint adds the endpoint, then runs then in the same transaction if it is given
+func (r *Repository) InsertEndpoint(
+	ctx context.Context, endpoint *Endpoint, then database.TxFunc,
+) error {
+	query := `
+		INSERT INTO webhook_endpoints (created_by, url, description, events, secret)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, active
+	`
+	args := []any{
+		endpoint.CreatedBy,
+		endpoint.URL,
+		endpoint.Description,
+		pq.Array(endpoint.Events),
+		endpoint.Secret,
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
+		&endpoint.ID,
+		&endpoint.CreatedAt,
+		&endpoint.Active,
+	)
+	if err != nil {
+		return err
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) GetEndpoint(ctx context.Context, endpointID int64) (*Endpoint, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM webhook_endpoints
+		WHERE id = $1
+	`, endpointColumns)
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	endpoint, err := scanEndpoint(r.DB.QueryRowContext(ctx, query, endpointID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, user.ErrNoRecord
+
+		default:
+			return nil, err
+		}
+	}
+
+	return endpoint, nil
+}
+
+// GetAllEndpoints returns a page of the registered endpoints
+func (r *Repository) GetAllEndpoints(
+	ctx context.Context, f filter.Filters,
+) ([]*Endpoint, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM webhook_endpoints
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2
+	`, endpointColumns, f.SortColumn(), f.SortDirection())
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, f.Limit(), f.Offset())
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	endpoints := []*Endpoint{}
+	for rows.Next() {
+		endpoint, err := scanEndpoint(rows, &totalRecords)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return endpoints, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+// UpdateEndpoint saves the endpoint, then runs then in the same transaction if it is given
+func (r *Repository) UpdateEndpoint(
+	ctx context.Context, endpoint *Endpoint, then database.TxFunc,
+) error {
+	query := `
+		UPDATE webhook_endpoints
+		SET url = $1, description = $2, events = $3, active = $4
+		WHERE id = $5
+	`
+	args := []any{
+		endpoint.URL,
+		endpoint.Description,
+		pq.Array(endpoint.Events),
+		endpoint.Active,
+		endpoint.ID,
+	}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return user.ErrNoRecord
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteEndpoint removes the endpoint, with its deliveries, then runs then in the same
+// transaction if it is given
+func (r *Repository) DeleteEndpoint(
+	ctx context.Context, endpointID int64, then database.TxFunc,
+) error {
+	query := `
+		DELETE FROM webhook_endpoints
+		WHERE id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, endpointID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return user.ErrNoRecord
+	}
+
+	if then != nil {
+		err = then(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PublishPayloadTx queues a delivery of the payload to every active endpoint subscribed to the
+// event and returns how many were queued. it is done inside a transaction owned by the caller, so
+// the deliveries are only queued if what they are about is committed
+func PublishPayloadTx(
+	ctx context.Context, tx *sql.Tx, event string, payload []byte,
+) (int64, error) {
+	query := `
+		INSERT INTO webhook_deliveries (endpoint_id, event, payload)
+		SELECT id, $1, $2
+		FROM webhook_endpoints
+		WHERE active
+		AND $1 = ANY(events)
+	`
+
+	result, err := tx.ExecContext(ctx, query, event, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Claim takes up to limit pending deliveries due by now to active endpoints, for the caller to
+// send, counting the attempt and holding them until lease has passed. deliveries claimed by someone
+// else are skipped, so any number of workers can claim at once
+func (r *Repository) Claim(
+	ctx context.Context, limit int, now time.Time, lease time.Duration,
+) ([]*Delivery, error) {
+	query := fmt.Sprintf(`
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, next_attempt_at = $2
+		FROM webhook_endpoints
+		WHERE webhook_endpoints.id = webhook_deliveries.endpoint_id
+		AND webhook_deliveries.id IN (
+			SELECT webhook_deliveries.id
+			FROM webhook_deliveries
+			INNER JOIN webhook_endpoints ON webhook_endpoints.id = webhook_deliveries.endpoint_id
+			WHERE status = 'PENDING'
+			AND next_attempt_at <= $1
+			AND webhook_endpoints.active
+			ORDER BY next_attempt_at, webhook_deliveries.id
+			LIMIT $3
+			FOR UPDATE OF webhook_deliveries SKIP LOCKED
+		)
+		RETURNING webhook_endpoints.url, webhook_endpoints.secret, %s
+	`, deliveryColumns)
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		var url, secret string
+		delivery, err := scanDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, err
+		}
+		delivery.URL, delivery.Secret = url, secret
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// MarkDelivered records that the endpoint accepted the delivery with the response status
+func (r *Repository) MarkDelivered(
+	ctx context.Context, deliveryID int64, responseStatus int, now time.Time,
+) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'DELIVERED', response_status = $2, delivered_at = $3, last_error = ''
+		WHERE id = $1
+	`
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, deliveryID, responseStatus, now)
+	return err
+}
+
+// MarkFailed records why the delivery failed, with the response status if the endpoint responded,
+// and when to try it next. a dead delivery is not tried again
+func (r *Repository) MarkFailed(
+	ctx context.Context, deliveryID int64, responseStatus int, lastError string, dead bool,
+	nextAttemptAt time.Time,
+) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = CASE WHEN $4 THEN 'DEAD' ELSE 'PENDING' END, response_status = $2,
+			last_error = $3, next_attempt_at = $5
+		WHERE id = $1
+	`
+	args := []any{deliveryID, responseStatus, lastError, dead, nextAttemptAt}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAllDeliveries returns a page of the deliveries to the endpoint, or to all of them if
+// endpointID is 0, with the status and of the event if they are given
+func (r *Repository) GetAllDeliveries(
+	ctx context.Context, endpointID int64, status, event string, f filter.Filters,
+) ([]*Delivery, filter.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM webhook_deliveries
+		WHERE (endpoint_id = $1 OR $1 = 0)
+		AND (status = $2 OR $2 = '')
+		AND (event = $3 OR $3 = '')
+		AND ($4::timestamptz IS NULL OR created_at >= $4)
+		AND ($5::timestamptz IS NULL OR created_at < $5)
+		ORDER BY %s %s, id ASC
+		LIMIT $6 OFFSET $7
+	`, deliveryColumns, f.SortColumn(), f.SortDirection())
+	args := []any{endpointID, status, event, f.From, f.To, f.Limit(), f.Offset()}
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, filter.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows, &totalRecords)
+		if err != nil {
+			return nil, filter.Metadata{}, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, filter.Metadata{}, err
+	}
+
+	return deliveries, filter.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+// ReplayedFunc is run with the new delivery in the transaction that queues a replay
+type ReplayedFunc func(ctx context.Context, tx *sql.Tx, delivery *Delivery) error
+
+// Replay queues a new delivery of the payload of a delivered or dead one, to the same endpoint.
+// then is run in the same transaction if it is given. ErrNotFinished is returned for a delivery
+// that is still pending
+func (r *Repository) Replay(
+	ctx context.Context, deliveryID int64, then ReplayedFunc,
+) (*Delivery, error) {
+	query := fmt.Sprintf(`
+		INSERT INTO webhook_deliveries (endpoint_id, event, payload, replay_of)
+		SELECT endpoint_id, event, payload, id
+		FROM webhook_deliveries
+		WHERE id = $1
+		AND status IN ('DELIVERED', 'DEAD')
+		RETURNING %s
+	`, deliveryColumns)
+
+	ctx, cancel := database.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	delivery, err := scanDelivery(tx.QueryRowContext(ctx, query, deliveryID))
+	if err == nil {
+		if then != nil {
+			err = then(ctx, tx, delivery)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		return delivery, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// tell a delivery that doesn't exist apart from one that is still pending
+	var exists bool
+	err = r.DB.QueryRowContext(
+		ctx, `SELECT EXISTS(SELECT 1 FROM webhook_deliveries WHERE id = $1)`, deliveryID,
+	).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, user.ErrNoRecord
+	}
+
+	return nil, ErrNotFinished
+}
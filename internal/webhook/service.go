@@ -0,0 +1,339 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Yusufdot101/goBankBackend/internal/webhook")
+
+type Repo interface {
+	InsertEndpoint(ctx context.Context, endpoint *Endpoint, then database.TxFunc) error
+	GetEndpoint(ctx context.Context, endpointID int64) (*Endpoint, error)
+	GetAllEndpoints(ctx context.Context, f filter.Filters) ([]*Endpoint, filter.Metadata, error)
+	UpdateEndpoint(ctx context.Context, endpoint *Endpoint, then database.TxFunc) error
+	DeleteEndpoint(ctx context.Context, endpointID int64, then database.TxFunc) error
+	Claim(ctx context.Context, limit int, now time.Time, lease time.Duration) ([]*Delivery, error)
+	MarkDelivered(ctx context.Context, deliveryID int64, responseStatus int, now time.Time) error
+	MarkFailed(
+		ctx context.Context, deliveryID int64, responseStatus int, lastError string, dead bool,
+		nextAttemptAt time.Time,
+	) error
+	GetAllDeliveries(
+		ctx context.Context, endpointID int64, status, event string, f filter.Filters,
+	) ([]*Delivery, filter.Metadata, error)
+	Replay(ctx context.Context, deliveryID int64, then ReplayedFunc) (*Delivery, error)
+}
+
+// Client is what deliveries are sent with, an *http.Client outside of tests
+type Client interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Auditor records changes to the endpoints and replays, in the transaction that makes them
+type Auditor interface {
+	RecordTx(
+		ctx context.Context, tx *sql.Tx, action, targetType string, targetID, before, after any,
+	) error
+}
+
+type Service struct {
+	Repo    Repo
+	Client  Client        // only needed to deliver
+	Policy  outbox.Policy // how failed deliveries are retried, the same as queued emails
+	Auditor Auditor       // optional
+}
+
+// auditTx returns the hook recording the change to an endpoint, nil when there is no one to record
+// it
+func (s *Service) auditTx(action string, endpointID int64, before, after any) database.TxFunc {
+	if s.Auditor == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, tx *sql.Tx) error {
+		return s.Auditor.RecordTx(ctx, tx, action, audit.TargetWebhook, endpointID, before, after)
+	}
+}
+
+// generateSecret returns 32 random bytes, base64 encoded, for an endpoint to sign deliveries with
+func generateSecret() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// CreateEndpoint registers the endpoint with a new secret, which is only ever returned here
+func (s *Service) CreateEndpoint(
+	ctx context.Context, v *validator.Validator, endpoint *Endpoint,
+) (string, error) {
+	ctx, span := tracer.Start(ctx, "webhook.CreateEndpoint")
+	defer span.End()
+
+	if ValidateEndpoint(v, endpoint); !v.IsValid() {
+		return "", validator.ErrFailedValidation
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return "", err
+	}
+	endpoint.Secret = secret
+
+	// the id is only known once it is inserted
+	var created database.TxFunc
+	if s.Auditor != nil {
+		created = func(ctx context.Context, tx *sql.Tx) error {
+			return s.Auditor.RecordTx(
+				ctx, tx, audit.ActionWebhookCreated, audit.TargetWebhook, endpoint.ID, nil,
+				endpoint,
+			)
+		}
+	}
+
+	err = s.Repo.InsertEndpoint(ctx, endpoint, created)
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+func (s *Service) GetEndpoint(ctx context.Context, endpointID int64) (*Endpoint, error) {
+	ctx, span := tracer.Start(ctx, "webhook.GetEndpoint")
+	defer span.End()
+
+	return s.Repo.GetEndpoint(ctx, endpointID)
+}
+
+// EndpointUpdate is what can be changed on an endpoint, fields left nil are kept
+type EndpointUpdate struct {
+	URL         *string
+	Description *string
+	Events      []string
+	Active      *bool
+}
+
+// UpdateEndpoint changes what is given in update on the endpoint. deliveries already queued keep
+// going to it, whatever events it is subscribed to now
+func (s *Service) UpdateEndpoint(
+	ctx context.Context, v *validator.Validator, endpointID int64, update EndpointUpdate,
+) (*Endpoint, error) {
+	ctx, span := tracer.Start(ctx, "webhook.UpdateEndpoint")
+	defer span.End()
+
+	endpoint, err := s.Repo.GetEndpoint(ctx, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	before := *endpoint
+
+	if update.URL != nil {
+		endpoint.URL = *update.URL
+	}
+	if update.Description != nil {
+		endpoint.Description = *update.Description
+	}
+	if update.Events != nil {
+		endpoint.Events = update.Events
+	}
+	if update.Active != nil {
+		endpoint.Active = *update.Active
+	}
+
+	if ValidateEndpoint(v, endpoint); !v.IsValid() {
+		return nil, validator.ErrFailedValidation
+	}
+
+	err = s.Repo.UpdateEndpoint(
+		ctx, endpoint, s.auditTx(audit.ActionWebhookUpdated, endpoint.ID, before, endpoint),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return endpoint, nil
+}
+
+func (s *Service) DeleteEndpoint(ctx context.Context, endpointID int64) error {
+	ctx, span := tracer.Start(ctx, "webhook.DeleteEndpoint")
+	defer span.End()
+
+	endpoint, err := s.Repo.GetEndpoint(ctx, endpointID)
+	if err != nil {
+		return err
+	}
+
+	return s.Repo.DeleteEndpoint(
+		ctx, endpointID, s.auditTx(audit.ActionWebhookDeleted, endpointID, endpoint, nil),
+	)
+}
+
+func (s *Service) GetAllEndpoints(
+	ctx context.Context, v *validator.Validator, f filter.Filters,
+) ([]*Endpoint, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "webhook.GetAllEndpoints")
+	defer span.End()
+
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetAllEndpoints(ctx, f)
+}
+
+// PublishTx queues the event, with data as what it is about, to every endpoint subscribed to it,
+// inside tx, the transaction of what the event is about. the deliveries are committed or rolled
+// back with it. the payload is {"event": ..., "created_at": ..., "data": {...}} and is sent the
+// same way every time it is tried
+func PublishTx(
+	ctx context.Context, tx *sql.Tx, event string, data map[string]any,
+) (int64, error) {
+	ctx, span := tracer.Start(ctx, "webhook.PublishTx")
+	defer span.End()
+
+	payload, err := newPayload(event, data)
+	if err != nil {
+		return 0, err
+	}
+
+	return PublishPayloadTx(ctx, tx, event, payload)
+}
+
+func newPayload(event string, data map[string]any) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"event":      event,
+		"created_at": time.Now().UTC(),
+		"data":       data,
+	})
+}
+
+// send posts the delivery to its endpoint, signed at now, and returns the status it responded with,
+// 0 if it didn't
+func (s *Service) send(ctx context.Context, delivery *Delivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// read some of the body so the connection can be reused, the rest is thrown away
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint responded %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
+
+// Deliver claims up to limit deliveries due by now and sends them. a delivery the endpoint doesn't
+// accept with a 2xx is tried again after the back-off of the policy, or is dead once it has been
+// tried MaxAttempts times. it returns how many were delivered and how many failed
+func (s *Service) Deliver(
+	ctx context.Context, limit int, now time.Time,
+) (delivered, failed int, err error) {
+	ctx, span := tracer.Start(ctx, "webhook.Deliver")
+	defer span.End()
+
+	deliveries, err := s.Repo.Claim(ctx, limit, now, s.Policy.Lease)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, delivery := range deliveries {
+		responseStatus, sendErr := s.send(ctx, delivery, time.Now())
+		if sendErr == nil {
+			delivered++
+			err = s.Repo.MarkDelivered(ctx, delivery.ID, responseStatus, time.Now())
+			if err != nil {
+				return delivered, failed, err
+			}
+			continue
+		}
+
+		failed++
+		dead := delivery.Attempts >= s.Policy.MaxAttempts
+		nextAttemptAt := now.Add(s.Policy.Backoff(delivery.Attempts))
+		err = s.Repo.MarkFailed(ctx, delivery.ID, responseStatus, sendErr.Error(), dead, nextAttemptAt)
+		if err != nil {
+			return delivered, failed, err
+		}
+	}
+
+	return delivered, failed, nil
+}
+
+// GetAllDeliveries returns a page of the delivery log, of the endpoint if endpointID isn't 0 and
+// with the status and of the event if they are given
+func (s *Service) GetAllDeliveries(
+	ctx context.Context, v *validator.Validator, endpointID int64, status, event string,
+	f filter.Filters,
+) ([]*Delivery, filter.Metadata, error) {
+	ctx, span := tracer.Start(ctx, "webhook.GetAllDeliveries")
+	defer span.End()
+
+	if status != "" {
+		v.CheckAddError(validator.ValueInList(status, Statuses...), "status", "invalid")
+	}
+	if event != "" {
+		v.CheckAddError(validator.ValueInList(event, Events...), "event", "invalid")
+	}
+	if filter.ValidateFilters(v, f); !v.IsValid() {
+		return nil, filter.Metadata{}, validator.ErrFailedValidation
+	}
+
+	return s.Repo.GetAllDeliveries(ctx, endpointID, status, event, f)
+}
+
+// Replay sends the payload of a delivery to its endpoint again, as a new delivery
+func (s *Service) Replay(ctx context.Context, deliveryID int64) (*Delivery, error) {
+	ctx, span := tracer.Start(ctx, "webhook.Replay")
+	defer span.End()
+
+	var replayed ReplayedFunc
+	if s.Auditor != nil {
+		replayed = func(ctx context.Context, tx *sql.Tx, delivery *Delivery) error {
+			return s.Auditor.RecordTx(
+				ctx, tx, audit.ActionWebhookReplayed, audit.TargetWebhookDelivery, deliveryID, nil,
+				delivery,
+			)
+		}
+	}
+
+	return s.Repo.Replay(ctx, deliveryID, replayed)
+}
@@ -0,0 +1,343 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/database"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// ---MOCKS---
+type MockRepo struct {
+	Endpoint       *Endpoint
+	GetEndpointErr error
+	Updated        *Endpoint
+
+	ClaimResult []*Delivery
+	ClaimErr    error
+
+	Delivered map[int64]int
+	Failed    map[int64]failure
+}
+
+// failure is what MarkFailed was called with for a delivery
+type failure struct {
+	responseStatus int
+	lastError      string
+	dead           bool
+	nextAttemptAt  time.Time
+}
+
+func (r *MockRepo) InsertEndpoint(
+	ctx context.Context, endpoint *Endpoint, then database.TxFunc,
+) error {
+	r.Endpoint = endpoint
+	return nil
+}
+
+func (r *MockRepo) GetEndpoint(ctx context.Context, endpointID int64) (*Endpoint, error) {
+	if r.GetEndpointErr != nil {
+		return nil, r.GetEndpointErr
+	}
+	return r.Endpoint, nil
+}
+
+func (r *MockRepo) GetAllEndpoints(
+	ctx context.Context, f filter.Filters,
+) ([]*Endpoint, filter.Metadata, error) {
+	return []*Endpoint{}, filter.Metadata{}, nil
+}
+
+func (r *MockRepo) UpdateEndpoint(
+	ctx context.Context, endpoint *Endpoint, then database.TxFunc,
+) error {
+	r.Updated = endpoint
+	return nil
+}
+
+func (r *MockRepo) DeleteEndpoint(
+	ctx context.Context, endpointID int64, then database.TxFunc,
+) error {
+	return nil
+}
+
+func (r *MockRepo) Claim(
+	ctx context.Context, limit int, now time.Time, lease time.Duration,
+) ([]*Delivery, error) {
+	return r.ClaimResult, r.ClaimErr
+}
+
+func (r *MockRepo) MarkDelivered(
+	ctx context.Context, deliveryID int64, responseStatus int, now time.Time,
+) error {
+	if r.Delivered == nil {
+		r.Delivered = map[int64]int{}
+	}
+	r.Delivered[deliveryID] = responseStatus
+	return nil
+}
+
+func (r *MockRepo) MarkFailed(
+	ctx context.Context, deliveryID int64, responseStatus int, lastError string, dead bool,
+	nextAttemptAt time.Time,
+) error {
+	if r.Failed == nil {
+		r.Failed = map[int64]failure{}
+	}
+	r.Failed[deliveryID] = failure{responseStatus, lastError, dead, nextAttemptAt}
+	return nil
+}
+
+func (r *MockRepo) GetAllDeliveries(
+	ctx context.Context, endpointID int64, status, event string, f filter.Filters,
+) ([]*Delivery, filter.Metadata, error) {
+	return []*Delivery{}, filter.Metadata{}, nil
+}
+
+func (r *MockRepo) Replay(
+	ctx context.Context, deliveryID int64, then ReplayedFunc,
+) (*Delivery, error) {
+	return nil, nil
+}
+
+func TestCreateEndpoint(t *testing.T) {
+	repo := &MockRepo{}
+	svc := Service{Repo: repo}
+
+	endpoint := &Endpoint{URL: "https://crm.example.com/hooks", Events: []string{EventTransfer}}
+	secret, err := svc.CreateEndpoint(context.Background(), validator.New(), endpoint)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !strings.HasPrefix(secret, "whsec_") || repo.Endpoint.Secret != secret {
+		t.Fatalf("expected a secret stored with the endpoint, got %q", secret)
+	}
+
+	other, err := svc.CreateEndpoint(context.Background(), validator.New(), &Endpoint{
+		URL: "https://crm.example.com/hooks", Events: []string{EventTransfer},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if other == secret {
+		t.Errorf("expected every endpoint to get its own secret")
+	}
+
+	_, err = svc.CreateEndpoint(
+		context.Background(), validator.New(), &Endpoint{URL: "https://crm.example.com"},
+	)
+	if !errors.Is(err, validator.ErrFailedValidation) {
+		t.Errorf("expected error %v, got %v", validator.ErrFailedValidation, err)
+	}
+}
+
+func TestUpdateEndpoint(t *testing.T) {
+	active := false
+	badURL := "not a url"
+
+	tests := []struct {
+		name           string
+		update         EndpointUpdate
+		getEndpointErr error
+		expectedErr    error
+	}{
+		{name: "deactivate", update: EndpointUpdate{Active: &active}},
+		{name: "change events", update: EndpointUpdate{Events: []string{EventLoanPayment}}},
+		{
+			name:        "invalid url",
+			update:      EndpointUpdate{URL: &badURL},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:        "no events",
+			update:      EndpointUpdate{Events: []string{}},
+			expectedErr: validator.ErrFailedValidation,
+		},
+		{
+			name:           "no endpoint",
+			update:         EndpointUpdate{Active: &active},
+			getEndpointErr: user.ErrNoRecord,
+			expectedErr:    user.ErrNoRecord,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepo{
+				Endpoint: &Endpoint{
+					ID: 1, URL: "https://crm.example.com/hooks",
+					Events: []string{EventTransfer}, Active: true,
+				},
+				GetEndpointErr: tc.getEndpointErr,
+			}
+			svc := Service{Repo: repo}
+
+			_, err := svc.UpdateEndpoint(context.Background(), validator.New(), 1, tc.update)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr != nil {
+				if repo.Updated != nil {
+					t.Errorf("expected nothing saved, got %+v", repo.Updated)
+				}
+				return
+			}
+			if repo.Updated == nil {
+				t.Fatal("expected the endpoint saved")
+			}
+			if tc.update.Active != nil && repo.Updated.Active != *tc.update.Active {
+				t.Errorf("expected active %v, got %v", *tc.update.Active, repo.Updated.Active)
+			}
+			if repo.Updated.URL != "https://crm.example.com/hooks" {
+				t.Errorf("expected the url kept, got %q", repo.Updated.URL)
+			}
+		})
+	}
+}
+
+// TestNewPayload checks what PublishTx queues, the deliveries themselves are in the integration
+// tests
+func TestNewPayload(t *testing.T) {
+	body, err := newPayload(EventDeposit, map[string]any{"amount": "10.00"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var payload struct {
+		Event     string         `json:"event"`
+		CreatedAt time.Time      `json:"created_at"`
+		Data      map[string]any `json:"data"`
+	}
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if payload.Event != EventDeposit || payload.CreatedAt.IsZero() {
+		t.Errorf("expected a %s event with its time, got %+v", EventDeposit, payload)
+	}
+	if payload.Data["amount"] != "10.00" {
+		t.Errorf("expected the data in the payload, got %v", payload.Data)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	// the endpoint accepts deliveries with a good signature and fails the rest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if err != nil || r.Header.Get(HeaderSignature) != Sign("whsec_good", timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(HeaderEvent) != EventTransfer || r.Header.Get(HeaderID) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	payload := []byte(`{"event":"TRANSFER"}`)
+	repo := &MockRepo{
+		ClaimResult: []*Delivery{
+			{ID: 1, Event: EventTransfer, Payload: payload, Attempts: 1,
+				URL: server.URL, Secret: "whsec_good"},
+			{ID: 2, Event: EventTransfer, Payload: payload, Attempts: 2,
+				URL: server.URL, Secret: "whsec_bad"},
+			{ID: 3, Event: EventTransfer, Payload: payload, Attempts: 3,
+				URL: server.URL, Secret: "whsec_bad"},
+		},
+	}
+	svc := Service{
+		Repo:   repo,
+		Client: server.Client(),
+		Policy: outbox.Policy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour},
+	}
+
+	delivered, failed, err := svc.Deliver(context.Background(), 10, now)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if delivered != 1 || failed != 2 {
+		t.Fatalf("expected 1 delivered and 2 failed, got %d and %d", delivered, failed)
+	}
+	if repo.Delivered[1] != http.StatusNoContent {
+		t.Errorf("expected delivery 1 delivered with 204, got %v", repo.Delivered)
+	}
+
+	retried := repo.Failed[2]
+	if retried.dead || !retried.nextAttemptAt.Equal(now.Add(2*time.Minute)) {
+		t.Errorf("expected delivery 2 retried in 2 minutes, got %+v", retried)
+	}
+	if retried.responseStatus != http.StatusUnauthorized {
+		t.Errorf("expected the response status kept, got %d", retried.responseStatus)
+	}
+	if !repo.Failed[3].dead {
+		t.Errorf("expected delivery 3 dead, got %+v", repo.Failed[3])
+	}
+}
+
+func TestDeliverUnreachable(t *testing.T) {
+	repo := &MockRepo{
+		ClaimResult: []*Delivery{
+			{ID: 1, Event: EventTransfer, Payload: []byte(`{}`), Attempts: 1,
+				URL: "http://127.0.0.1:1/hooks", Secret: "whsec_good"},
+		},
+	}
+	svc := Service{
+		Repo:   repo,
+		Client: &http.Client{Timeout: time.Second},
+		Policy: outbox.Policy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour},
+	}
+
+	_, failed, err := svc.Deliver(context.Background(), 10, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if failed != 1 || repo.Failed[1].responseStatus != 0 || repo.Failed[1].lastError == "" {
+		t.Errorf("expected the delivery failed with no response, got %+v", repo.Failed[1])
+	}
+}
+
+func TestGetAllDeliveries(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		event       string
+		expectedErr error
+	}{
+		{name: "everything"},
+		{name: "dead transfers", status: StatusDead, event: EventTransfer},
+		{name: "unknown status", status: "LOST", expectedErr: validator.ErrFailedValidation},
+		{name: "unknown event", event: "REFUND", expectedErr: validator.ErrFailedValidation},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := Service{Repo: &MockRepo{}}
+			f := filter.Filters{
+				Page: 1, PageSize: 20, Sort: "-created_at", SortSafelist: SortSafelist,
+			}
+
+			_, _, err := svc.GetAllDeliveries(
+				context.Background(), validator.New(), 0, tc.status, tc.event, f,
+			)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS ledger_postings_account_idx;
+
+ALTER TABLE ledger_postings DROP CONSTRAINT IF EXISTS amount_check;
+
+DROP TABLE IF EXISTS ledger_postings;
+
+DROP TABLE IF EXISTS ledger_entries;
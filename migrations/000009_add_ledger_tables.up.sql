@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    kind TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT REFERENCES ledger_entries NOT NULL,
+    account TEXT NOT NULL, -- 'user:<id>' for customer balances, 'bank:<name>' for the bank's own
+    amount DECIMAL(12, 2) NOT NULL -- negative debits the account, positive credits it
+);
+
+ALTER TABLE ledger_postings ADD CONSTRAINT amount_check CHECK(amount <> 0);
+
+CREATE INDEX IF NOT EXISTS ledger_postings_account_idx ON ledger_postings (account);
+
+-- carry the balances that already exist over to the ledger so that every user balance can be
+-- rebuilt from its postings
+WITH opening AS (
+    INSERT INTO ledger_entries (kind, description)
+    VALUES ('OPENING_BALANCE', 'balances carried over from users.account_balance')
+    RETURNING id
+)
+INSERT INTO ledger_postings (entry_id, account, amount)
+SELECT opening.id, 'user:' || users.id, users.account_balance
+FROM opening, users
+WHERE users.account_balance <> 0
+UNION ALL
+SELECT opening.id, 'bank:equity', -SUM(users.account_balance)
+FROM opening, users
+GROUP BY opening.id
+HAVING SUM(users.account_balance) <> 0;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idempotency_keys_expiry_idx;
+
+DROP TABLE IF EXISTS idempotency_keys;
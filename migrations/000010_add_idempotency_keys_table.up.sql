@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id BIGINT REFERENCES users ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expiry TIMESTAMPTZ NOT NULL,
+    request_hash BYTEA NOT NULL, -- sha256 of the method, path and body of the first request
+    status_code INTEGER, -- null until the first request has a response
+    response_body BYTEA,
+    PRIMARY KEY(user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expiry_idx ON idempotency_keys (expiry);
@@ -0,0 +1,29 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS account_balance DECIMAL(12, 2) NOT NULL DEFAULT 0.00;
+
+-- users only had one balance, so whatever is left in all of their accounts is added up
+UPDATE users
+SET account_balance = totals.balance
+FROM (SELECT user_id, SUM(balance) AS balance FROM accounts GROUP BY user_id) AS totals
+WHERE totals.user_id = users.id;
+
+ALTER TABLE loan_requests DROP COLUMN IF EXISTS account_id;
+
+ALTER TABLE loans DROP COLUMN IF EXISTS account_id;
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS account_id;
+
+ALTER TABLE transfers DROP COLUMN IF EXISTS to_account_id;
+ALTER TABLE transfers DROP COLUMN IF EXISTS from_account_id;
+
+UPDATE ledger_postings
+SET account = 'user:' || accounts.user_id
+FROM accounts
+WHERE ledger_postings.account = 'account:' || accounts.id;
+
+ALTER TABLE ledger_postings DROP COLUMN IF EXISTS currency;
+
+DROP INDEX IF EXISTS accounts_user_id_idx;
+
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS balance_check;
+
+DROP TABLE IF EXISTS accounts;
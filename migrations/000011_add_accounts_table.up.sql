@@ -0,0 +1,78 @@
+CREATE TABLE IF NOT EXISTS accounts (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id BIGINT REFERENCES users ON DELETE CASCADE NOT NULL,
+    number TEXT UNIQUE NOT NULL, -- 9 digits followed by a Luhn check digit
+    type TEXT NOT NULL, -- 'CURRENT', 'SAVINGS' or 'LOAN_SETTLEMENT'
+    currency TEXT NOT NULL DEFAULT 'USD',
+    status TEXT NOT NULL DEFAULT 'ACTIVE', -- 'ACTIVE', 'FROZEN' or 'CLOSED'
+    balance DECIMAL(12, 2) NOT NULL DEFAULT 0.00,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+ALTER TABLE accounts ADD CONSTRAINT balance_check CHECK(balance >= 0);
+
+CREATE INDEX IF NOT EXISTS accounts_user_id_idx ON accounts (user_id);
+
+-- the Luhn check digit of a string of digits, the same as checkDigit in internal/account
+CREATE FUNCTION pg_temp.luhn_check_digit(digits TEXT) RETURNS TEXT AS $$
+    SELECT ((10 - SUM(
+        CASE WHEN (length(digits) - i) % 2 = 0
+            THEN (substr(digits, i, 1)::INT * 2) / 10 + (substr(digits, i, 1)::INT * 2) % 10
+            ELSE substr(digits, i, 1)::INT
+        END
+    ) % 10) % 10)::TEXT
+    FROM generate_series(1, length(digits)) AS i
+$$ LANGUAGE SQL;
+
+-- every existing user gets a current account holding their balance. the numbers are the user ID
+-- padded with zeros, generated numbers never start with 0 so they can't collide
+INSERT INTO accounts (user_id, number, type, balance)
+SELECT id, lpad(id::TEXT, 9, '0') || pg_temp.luhn_check_digit(lpad(id::TEXT, 9, '0')), 'CURRENT',
+    account_balance
+FROM users;
+
+-- customer postings move from the user to their new account
+ALTER TABLE ledger_postings ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+
+UPDATE ledger_postings
+SET account = 'account:' || accounts.id
+FROM accounts
+WHERE ledger_postings.account = 'user:' || accounts.user_id;
+
+-- money now moves between accounts, the user columns are kept to tell who did it
+ALTER TABLE transfers ADD COLUMN from_account_id BIGINT REFERENCES accounts;
+ALTER TABLE transfers ADD COLUMN to_account_id BIGINT REFERENCES accounts;
+
+UPDATE transfers
+SET from_account_id = from_account.id, to_account_id = to_account.id
+FROM accounts AS from_account, accounts AS to_account
+WHERE from_account.user_id = transfers.from_user_id AND to_account.user_id = transfers.to_user_id;
+
+ALTER TABLE transfers ALTER COLUMN from_account_id SET NOT NULL;
+ALTER TABLE transfers ALTER COLUMN to_account_id SET NOT NULL;
+
+ALTER TABLE transactions ADD COLUMN account_id BIGINT REFERENCES accounts;
+
+UPDATE transactions
+SET account_id = accounts.id
+FROM accounts
+WHERE accounts.user_id = transactions.user_id;
+
+ALTER TABLE transactions ALTER COLUMN account_id SET NOT NULL;
+
+ALTER TABLE loans ADD COLUMN account_id BIGINT REFERENCES accounts;
+
+UPDATE loans
+SET account_id = accounts.id
+FROM accounts
+WHERE accounts.user_id = loans.user_id;
+
+ALTER TABLE loan_requests ADD COLUMN account_id BIGINT REFERENCES accounts;
+
+UPDATE loan_requests
+SET account_id = accounts.id
+FROM accounts
+WHERE accounts.user_id = loan_requests.user_id;
+
+ALTER TABLE users DROP COLUMN IF EXISTS account_balance;
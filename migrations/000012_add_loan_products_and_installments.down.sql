@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS loan_installments;
+
+ALTER TABLE loans DROP COLUMN IF EXISTS product_id;
+ALTER TABLE loan_requests DROP COLUMN IF EXISTS product_id;
+
+DROP TABLE IF EXISTS loan_products;
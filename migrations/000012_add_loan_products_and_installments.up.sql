@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS loan_products (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name TEXT UNIQUE NOT NULL,
+    annual_interest_rate DECIMAL(6, 3) NOT NULL,
+    term INTEGER NOT NULL, -- the number of installments
+    frequency TEXT NOT NULL, -- can be 'WEEKLY', 'BIWEEKLY' or 'MONTHLY'
+    amortization TEXT NOT NULL -- can be 'EQUAL_INSTALLMENT' or 'EQUAL_PRINCIPAL'
+);
+
+ALTER TABLE loan_products ADD CONSTRAINT annual_interest_rate_check
+    CHECK(annual_interest_rate >= 0);
+ALTER TABLE loan_products ADD CONSTRAINT term_check CHECK(term BETWEEN 1 AND 360);
+ALTER TABLE loan_products ADD CONSTRAINT frequency_check
+    CHECK(frequency IN ('WEEKLY', 'BIWEEKLY', 'MONTHLY'));
+ALTER TABLE loan_products ADD CONSTRAINT amortization_check
+    CHECK(amortization IN ('EQUAL_INSTALLMENT', 'EQUAL_PRINCIPAL'));
+
+INSERT INTO loan_products (name, annual_interest_rate, term, frequency, amortization)
+VALUES
+    ('personal-12-months', 12, 12, 'MONTHLY', 'EQUAL_INSTALLMENT'),
+    ('personal-24-months', 10.5, 24, 'MONTHLY', 'EQUAL_PRINCIPAL'),
+    ('short-term-8-weeks', 8, 8, 'WEEKLY', 'EQUAL_INSTALLMENT');
+
+-- loans and requests from before products have none, they keep accruing daily interest
+ALTER TABLE loan_requests ADD COLUMN IF NOT EXISTS product_id BIGINT REFERENCES loan_products;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS product_id BIGINT REFERENCES loan_products;
+
+CREATE TABLE IF NOT EXISTS loan_installments (
+    id BIGSERIAL PRIMARY KEY,
+    loan_id BIGINT NOT NULL REFERENCES loans ON DELETE CASCADE,
+    number INTEGER NOT NULL,
+    due_date DATE NOT NULL,
+    principal DECIMAL(12, 2) NOT NULL,
+    interest DECIMAL(12, 2) NOT NULL,
+    principal_paid DECIMAL(12, 2) NOT NULL DEFAULT 0.00,
+    interest_paid DECIMAL(12, 2) NOT NULL DEFAULT 0.00,
+    paid_at TIMESTAMPTZ, -- null until the installment is paid in full
+    UNIQUE(loan_id, number)
+);
+
+ALTER TABLE loan_installments ADD CONSTRAINT paid_check
+    CHECK(principal_paid BETWEEN 0 AND principal AND interest_paid BETWEEN 0 AND interest);
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS job_runs;
+
+ALTER TABLE loan_installments DROP CONSTRAINT IF EXISTS paid_check;
+ALTER TABLE loan_installments ADD CONSTRAINT paid_check
+    CHECK(principal_paid BETWEEN 0 AND principal AND interest_paid BETWEEN 0 AND interest);
+ALTER TABLE loan_installments DROP COLUMN IF EXISTS late_fee_charged_at;
+ALTER TABLE loan_installments DROP COLUMN IF EXISTS fees_paid;
+ALTER TABLE loan_installments DROP COLUMN IF EXISTS fees;
+
+ALTER TABLE loans DROP COLUMN IF EXISTS accrued_through;
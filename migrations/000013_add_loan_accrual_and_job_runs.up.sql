@@ -0,0 +1,32 @@
+-- the last day the nightly accrual charged the loan for, null if it never has
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS accrued_through DATE;
+
+-- late fees and penalty interest charged on an installment once it is overdue
+ALTER TABLE loan_installments ADD COLUMN IF NOT EXISTS fees DECIMAL(12, 2) NOT NULL DEFAULT 0.00;
+ALTER TABLE loan_installments ADD COLUMN IF NOT EXISTS fees_paid DECIMAL(12, 2) NOT NULL
+    DEFAULT 0.00;
+ALTER TABLE loan_installments ADD COLUMN IF NOT EXISTS late_fee_charged_at TIMESTAMPTZ;
+
+ALTER TABLE loan_installments DROP CONSTRAINT IF EXISTS paid_check;
+ALTER TABLE loan_installments ADD CONSTRAINT paid_check
+    CHECK(
+        principal_paid BETWEEN 0 AND principal AND interest_paid BETWEEN 0 AND interest AND
+        fees_paid BETWEEN 0 AND fees
+    );
+
+-- one row per job per day, so a job that already succeeded for a day is never run for it again
+CREATE TABLE IF NOT EXISTS job_runs (
+    id BIGSERIAL PRIMARY KEY,
+    job TEXT NOT NULL,
+    day DATE NOT NULL,
+    status TEXT NOT NULL, -- can be 'RUNNING', 'SUCCEEDED' or 'FAILED'
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ,
+    processed INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    UNIQUE(job, day)
+);
+
+ALTER TABLE job_runs ADD CONSTRAINT status_check
+    CHECK(status IN ('RUNNING', 'SUCCEEDED', 'FAILED'));
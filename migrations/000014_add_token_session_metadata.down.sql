@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS tokens_hash_idx;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE tokens DROP COLUMN IF EXISTS ip;
+ALTER TABLE tokens DROP COLUMN IF EXISTS last_used_at;
@@ -0,0 +1,7 @@
+-- where and when an authorization token was last used, so users can see their sessions
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+
+-- every authenticated request looks its token up by hash
+CREATE INDEX IF NOT EXISTS tokens_hash_idx ON tokens (hash);
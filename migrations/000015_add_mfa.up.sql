@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS mfa_enrolments (
+    user_id BIGINT PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    secret BYTEA NOT NULL, -- the TOTP secret, encrypted with AES-GCM
+    confirmed_at TIMESTAMPTZ, -- null until the user has entered a code from their app
+    last_used_step BIGINT NOT NULL DEFAULT 0 -- so that a code can't be used twice
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash BYTEA NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_id_idx ON mfa_recovery_codes (user_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS tokens_family_id_idx;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS used_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS family_id;
+
+DROP SEQUENCE IF EXISTS token_families_id_seq;
@@ -0,0 +1,13 @@
+-- the access and refresh tokens of one sign in share a family, which a refresh token that is used
+-- twice revokes as a whole
+CREATE SEQUENCE IF NOT EXISTS token_families_id_seq;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family_id BIGINT;
+UPDATE tokens SET family_id = nextval('token_families_id_seq') WHERE family_id IS NULL;
+ALTER TABLE tokens ALTER COLUMN family_id SET DEFAULT nextval('token_families_id_seq');
+ALTER TABLE tokens ALTER COLUMN family_id SET NOT NULL;
+
+-- set once a refresh token has been swapped for new tokens, it is kept to catch it being reused
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS tokens_family_id_idx ON tokens (family_id);
@@ -0,0 +1,11 @@
+-- failed logins counted against an email or an IP, for back-off and temporary lockouts
+CREATE TABLE IF NOT EXISTS login_failures (
+    kind TEXT NOT NULL, -- can be 'account' or 'ip'
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (kind, key)
+);
+
+ALTER TABLE login_failures ADD CONSTRAINT kind_check CHECK(kind IN ('account', 'ip'));
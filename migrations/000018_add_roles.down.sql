@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id BIGINT REFERENCES users ON DELETE CASCADE,
+    permission_id BIGINT REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY(user_id, permission_id)
+);
+
+-- users are granted the permissions their roles gave them directly
+INSERT INTO users_permissions
+SELECT users_roles.user_id, roles_permissions.permission_id
+FROM users_roles
+INNER JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS users_roles;
+
+DROP TABLE IF EXISTS roles_inherits;
+
+DROP TABLE IF EXISTS roles_permissions;
+
+DROP TABLE IF EXISTS roles;
+
+ALTER TABLE permissions DROP COLUMN IF EXISTS description;
@@ -0,0 +1,95 @@
+-- the permission catalog lives here rather than in the code, so new permissions don't need a deploy
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+
+INSERT INTO permissions (code)
+VALUES
+    ('DEPOSIT'),
+    ('WITHDRAW')
+ON CONFLICT (code) DO NOTHING;
+
+UPDATE permissions SET description = CASE code
+    WHEN 'APPROVE_LOANS' THEN 'approve and decline loan requests'
+    WHEN 'DELETE_LOANS' THEN 'delete loans'
+    WHEN 'ADMIN' THEN 'manage accounts, users and their sessions'
+    WHEN 'SUPERUSER' THEN 'manage permissions and roles'
+    WHEN 'DEPOSIT' THEN 'deposit money into accounts'
+    WHEN 'WITHDRAW' THEN 'withdraw money from accounts'
+    ELSE description
+END;
+
+-- a role bundles permissions, and has all the permissions of the roles it inherits as well
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name TEXT UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id BIGINT REFERENCES roles ON DELETE CASCADE,
+    permission_id BIGINT REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY(role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS roles_inherits (
+    role_id BIGINT REFERENCES roles ON DELETE CASCADE,
+    inherits_id BIGINT REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY(role_id, inherits_id),
+    CONSTRAINT roles_inherits_self_check CHECK (role_id <> inherits_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id BIGINT REFERENCES users ON DELETE CASCADE,
+    role_id BIGINT REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY(user_id, role_id)
+);
+
+INSERT INTO roles (name, description)
+VALUES
+    ('teller', 'handles deposits and withdrawals'),
+    ('loan_officer', 'decides on loan requests'),
+    ('loan_manager', 'a loan officer that can also delete loans'),
+    ('admin', 'runs the day to day of the bank'),
+    ('superuser', 'can do everything, including managing roles')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM (VALUES
+    ('teller', 'DEPOSIT'),
+    ('teller', 'WITHDRAW'),
+    ('loan_officer', 'APPROVE_LOANS'),
+    ('loan_manager', 'DELETE_LOANS'),
+    ('admin', 'ADMIN'),
+    ('superuser', 'SUPERUSER')
+) AS grants (role, code)
+INNER JOIN roles ON roles.name = grants.role
+INNER JOIN permissions ON permissions.code = grants.code
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles_inherits
+SELECT roles.id, inherits.id
+FROM (VALUES
+    ('loan_manager', 'loan_officer'),
+    ('admin', 'loan_manager'),
+    ('admin', 'teller'),
+    ('superuser', 'admin')
+) AS hierarchy (role, inherits)
+INNER JOIN roles ON roles.name = hierarchy.role
+INNER JOIN roles AS inherits ON inherits.name = hierarchy.inherits
+ON CONFLICT DO NOTHING;
+
+-- users keep what they could do, through the role that grants each permission they had
+INSERT INTO users_roles
+SELECT users_permissions.user_id, roles.id
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+INNER JOIN roles ON roles.name = CASE permissions.code
+    WHEN 'APPROVE_LOANS' THEN 'loan_officer'
+    WHEN 'DELETE_LOANS' THEN 'loan_manager'
+    WHEN 'ADMIN' THEN 'admin'
+    WHEN 'SUPERUSER' THEN 'superuser'
+END
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS users_permissions;
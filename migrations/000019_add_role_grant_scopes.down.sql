@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS role_grant_events;
+
+DROP INDEX IF EXISTS users_roles_expires_at_idx;
+
+ALTER TABLE users_roles DROP CONSTRAINT IF EXISTS users_roles_max_amount_check;
+
+ALTER TABLE users_roles DROP COLUMN IF EXISTS max_amount;
+ALTER TABLE users_roles DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE users_roles DROP COLUMN IF EXISTS reason;
+ALTER TABLE users_roles DROP COLUMN IF EXISTS granted_by;
+ALTER TABLE users_roles DROP COLUMN IF EXISTS granted_at;
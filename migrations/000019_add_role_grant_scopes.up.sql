@@ -0,0 +1,29 @@
+-- a role can be granted for a while, for a reason, and with a limit on the amounts it can be used
+-- for. max_amount applies to the role and everything it inherits, NULL is no limit
+ALTER TABLE users_roles ADD COLUMN IF NOT EXISTS granted_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE users_roles
+ADD COLUMN IF NOT EXISTS granted_by BIGINT REFERENCES users ON DELETE SET NULL;
+ALTER TABLE users_roles ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE users_roles ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE users_roles ADD COLUMN IF NOT EXISTS max_amount DECIMAL(12, 2);
+
+ALTER TABLE users_roles ADD CONSTRAINT users_roles_max_amount_check CHECK (max_amount > 0);
+
+CREATE INDEX IF NOT EXISTS users_roles_expires_at_idx ON users_roles (expires_at)
+WHERE expires_at IS NOT NULL;
+
+-- every change to what roles users have, kept after the grant itself is gone
+CREATE TABLE IF NOT EXISTS role_grant_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    action TEXT NOT NULL,
+    user_id BIGINT NOT NULL,
+    role TEXT NOT NULL,
+    performed_by BIGINT, -- NULL when the grant expired
+    reason TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ,
+    max_amount DECIMAL(12, 2),
+    CONSTRAINT role_grant_events_action_check CHECK (action IN ('ASSIGN', 'REVOKE', 'EXPIRE'))
+);
+
+CREATE INDEX IF NOT EXISTS role_grant_events_user_id_idx ON role_grant_events (user_id);
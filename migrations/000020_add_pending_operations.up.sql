@@ -0,0 +1,23 @@
+-- operations over their threshold wait here for a second user to approve them
+CREATE TABLE IF NOT EXISTS pending_operations (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    kind TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    requested_by BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'PENDING',
+    decided_by BIGINT REFERENCES users ON DELETE SET NULL,
+    decided_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    CONSTRAINT pending_operations_kind_check
+        CHECK (kind IN ('DEPOSIT', 'LOAN_APPROVAL', 'LOAN_DELETION')),
+    CONSTRAINT pending_operations_status_check
+        CHECK (status IN ('PENDING', 'APPROVED', 'REJECTED', 'EXPIRED', 'FAILED')),
+    -- the one who asked for an operation can never be the one to decide on it
+    CONSTRAINT pending_operations_decided_by_check CHECK (decided_by <> requested_by)
+);
+
+CREATE INDEX IF NOT EXISTS pending_operations_status_expires_at_idx
+ON pending_operations (status, expires_at);
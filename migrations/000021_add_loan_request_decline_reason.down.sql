@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS loan_requests_status_created_at_idx;
+
+ALTER TABLE loan_requests DROP CONSTRAINT IF EXISTS loan_requests_status_check;
+
+ALTER TABLE loan_requests DROP COLUMN IF EXISTS decline_reason;
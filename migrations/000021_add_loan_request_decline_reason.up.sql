@@ -0,0 +1,9 @@
+ALTER TABLE loan_requests ADD COLUMN IF NOT EXISTS decline_reason TEXT NOT NULL DEFAULT '';
+
+-- borrowers can now withdraw a request that is still pending
+ALTER TABLE loan_requests ADD CONSTRAINT loan_requests_status_check
+    CHECK (status IN ('PENDING', 'ACCEPTED', 'DECLINED', 'WITHDRAWN'));
+
+-- approvers list the queue by status, newest or oldest first
+CREATE INDEX IF NOT EXISTS loan_requests_status_created_at_idx
+ON loan_requests (status, created_at);
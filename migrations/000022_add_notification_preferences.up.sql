@@ -0,0 +1,7 @@
+-- users are notified of every event unless they have a row here turning it off
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    PRIMARY KEY (user_id, event)
+);
@@ -0,0 +1,18 @@
+-- emails are queued here, in the same transaction as whatever they are about, and sent by workers
+CREATE TABLE IF NOT EXISTS outbox_messages (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    recipient TEXT NOT NULL,
+    template TEXT NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'PENDING',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT NOT NULL DEFAULT '',
+    sent_at TIMESTAMPTZ,
+    CONSTRAINT outbox_messages_status_check CHECK (status IN ('PENDING', 'SENT', 'DEAD'))
+);
+
+-- the workers only ever look for pending messages that are due
+CREATE INDEX IF NOT EXISTS outbox_messages_pending_idx
+ON outbox_messages (next_attempt_at) WHERE status = 'PENDING';
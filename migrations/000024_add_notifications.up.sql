@@ -0,0 +1,19 @@
+-- in-app notifications, unread until read_at is set
+CREATE TABLE IF NOT EXISTS notifications (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    read_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_created_at_idx
+ON notifications (user_id, created_at);
+
+-- the number a user is texted on, users without one are never texted
+CREATE TABLE IF NOT EXISTS notification_phones (
+    user_id BIGINT PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    phone TEXT NOT NULL
+);
@@ -0,0 +1,35 @@
+-- endpoints registered by admins to be sent the events they subscribed to
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_by BIGINT REFERENCES users ON DELETE SET NULL,
+    url TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    events TEXT[] NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    secret TEXT NOT NULL
+);
+
+-- every event sent to an endpoint, the log of what was sent and how it went
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    endpoint_id BIGINT NOT NULL REFERENCES webhook_endpoints ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'PENDING',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    response_status INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    delivered_at TIMESTAMPTZ,
+    replay_of BIGINT REFERENCES webhook_deliveries ON DELETE SET NULL,
+    CONSTRAINT webhook_deliveries_status_check CHECK (status IN ('PENDING', 'DELIVERED', 'DEAD'))
+);
+
+-- the workers only ever look for pending deliveries that are due
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx
+ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_endpoint_id_idx
+ON webhook_deliveries (endpoint_id, created_at);
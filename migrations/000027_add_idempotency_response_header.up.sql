@@ -0,0 +1,2 @@
+-- the headers the first request was answered with, so that a replay has the same Content-Type
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS response_header JSONB;
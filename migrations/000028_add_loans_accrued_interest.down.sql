@@ -0,0 +1,2 @@
+ALTER TABLE loans DROP CONSTRAINT IF EXISTS accrued_interest_check;
+ALTER TABLE loans DROP COLUMN IF EXISTS accrued_interest;
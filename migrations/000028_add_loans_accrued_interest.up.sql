@@ -0,0 +1,4 @@
+-- the interest the nightly accrual charged on a loan without a schedule that hasn't been paid yet.
+-- it is kept apart from remaining_amount so that interest is never charged on interest
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS accrued_interest DECIMAL(12, 2) NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD CONSTRAINT accrued_interest_check CHECK(accrued_interest >= 0);
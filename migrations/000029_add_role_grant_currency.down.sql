@@ -0,0 +1,4 @@
+ALTER TABLE role_grant_events DROP COLUMN IF EXISTS max_amount_currency;
+
+ALTER TABLE users_roles DROP CONSTRAINT IF EXISTS users_roles_max_amount_currency_check;
+ALTER TABLE users_roles DROP COLUMN IF EXISTS max_amount_currency;
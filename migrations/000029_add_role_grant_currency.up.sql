@@ -0,0 +1,9 @@
+-- the currency max_amount is in, a limit only applies to amounts in the same currency. the limits
+-- given before there was one were in the default currency
+ALTER TABLE users_roles ADD COLUMN IF NOT EXISTS max_amount_currency TEXT;
+UPDATE users_roles SET max_amount_currency = 'USD' WHERE max_amount IS NOT NULL;
+ALTER TABLE users_roles ADD CONSTRAINT users_roles_max_amount_currency_check
+CHECK ((max_amount IS NULL) = (max_amount_currency IS NULL));
+
+ALTER TABLE role_grant_events ADD COLUMN IF NOT EXISTS max_amount_currency TEXT;
+UPDATE role_grant_events SET max_amount_currency = 'USD' WHERE max_amount IS NOT NULL;
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/approval"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestApprovals(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	approvalSvc := &approval.Service{
+		Repo: &approval.Repository{DB: testDB},
+		TTL:  time.Hour,
+	}
+
+	maker := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	checker := &user.User{Name: "mohamed", Email: "m@gmail.com", Activated: true}
+	for _, u := range []*user.User{maker, checker} {
+		u.Password.Set("12345678", 12)
+		if err := userRepo.Insert(context.Background(), u); err != nil {
+			t.Fatalf("Insert: unexpected error %v", err)
+		}
+	}
+
+	payload := map[string]any{"account_number": "1000000009", "amount": "20000.00"}
+	op, err := approvalSvc.Submit(
+		context.Background(), validator.New(), approval.KindDeposit, payload, money.MustParse(
+			"20000",
+		), maker.ID,
+	)
+	if err != nil {
+		t.Fatalf("Submit: unexpected error %v", err)
+	}
+
+	// the maker can't be the checker
+	_, err = approvalSvc.Approve(context.Background(), op.ID, maker.ID)
+	checkErr(t, err, approval.ErrSameUser, "Approve")
+
+	approved, err := approvalSvc.Approve(context.Background(), op.ID, checker.ID)
+	if err != nil {
+		t.Fatalf("Approve: unexpected error %v", err)
+	}
+	if approved.Status != approval.StatusApproved || *approved.DecidedBy != checker.ID {
+		t.Errorf("expected approved by %d, got %+v", checker.ID, approved)
+	}
+	if approved.Amount.Cmp(money.MustParse("20000")) != 0 {
+		t.Errorf("expected amount 20000.00, got %s", approved.Amount)
+	}
+
+	// an operation is only ever decided once
+	_, err = approvalSvc.Reject(context.Background(), op.ID, checker.ID)
+	checkErr(t, err, approval.ErrNotPending, "Reject")
+
+	err = approvalSvc.Fail(context.Background(), approved, errors.New("account not active"))
+	if err != nil {
+		t.Fatalf("Fail: unexpected error %v", err)
+	}
+	failed, err := approvalSvc.Get(context.Background(), op.ID)
+	if err != nil || failed.Status != approval.StatusFailed || failed.Error != "account not active" {
+		t.Errorf("expected the operation failed, got %+v, %v", failed, err)
+	}
+
+	// pending operations past their expiry can't be approved, and are swept
+	approvalSvc.TTL = time.Millisecond
+	op, err = approvalSvc.Submit(
+		context.Background(), validator.New(), approval.KindLoanDeletion, payload, money.MustParse(
+			"5000",
+		), maker.ID,
+	)
+	if err != nil {
+		t.Fatalf("Submit: unexpected error %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	_, err = approvalSvc.Approve(context.Background(), op.ID, checker.ID)
+	checkErr(t, err, approval.ErrNotPending, "Approve")
+
+	expired, err := approvalSvc.Expire(context.Background())
+	if err != nil || expired != 1 {
+		t.Errorf("expected 1 operation expired, got %d, %v", expired, err)
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/audit"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestAuditLog(t *testing.T) {
+	resetDB()
+
+	svc := audit.Service{Repo: &audit.Repository{DB: testDB}}
+
+	// events are appended in the transactions of their actions, one rolled back takes its event
+	// with it and leaves the chain as it was
+	for _, role := range []string{"ADMIN", "TELLER", "DEPOSIT", "SUPERUSER"} {
+		tx, err := testDB.BeginTx(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("BeginTx: unexpected error %v", err)
+		}
+		event := &audit.Event{
+			ActorID:    1,
+			Action:     audit.ActionRoleAssigned,
+			TargetType: audit.TargetUser,
+			TargetID:   "2",
+			IP:         "127.0.0.1",
+			RequestID:  "req-" + role,
+		}
+		err = audit.RecordTx(
+			context.Background(), tx, event, nil,
+			map[string]any{"role": role, "max_amount": "10.00"},
+		)
+		if err != nil {
+			t.Fatalf("RecordTx: unexpected error %v", err)
+		}
+
+		if role == "TELLER" {
+			err = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatalf("ending the transaction: unexpected error %v", err)
+		}
+	}
+
+	f := filter.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafelist: audit.SortSafelist}
+	events, _, err := svc.GetAll(
+		context.Background(), validator.New(), 1, audit.ActionRoleAssigned, "", "", f,
+	)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("expected 3 events, got %v, %v", events, err)
+	}
+	if events[0].PrevHash != "" || events[1].PrevHash != events[0].Hash {
+		t.Errorf("expected the events chained, got %+v", events)
+	}
+
+	// the hashes still match once the changes have been through JSONB
+	report, err := svc.Verify(context.Background(), 2)
+	if err != nil || !report.OK() || report.Checked != 3 || report.Head != events[2].Hash {
+		t.Fatalf("expected the chain to check out, got %+v, %v", report, err)
+	}
+
+	// the log can't be changed, short of turning off the trigger that stops it
+	_, err = testDB.Exec(`UPDATE audit_events SET actor_id = 9 WHERE id = $1`, events[1].ID)
+	if err == nil {
+		t.Fatal("expected updating an event to fail")
+	}
+	_, err = testDB.Exec(`DELETE FROM audit_events WHERE id = $1`, events[1].ID)
+	if err == nil {
+		t.Fatal("expected deleting an event to fail")
+	}
+
+	for _, query := range []string{
+		`ALTER TABLE audit_events DISABLE TRIGGER audit_events_append_only`,
+		fmt.Sprintf(`UPDATE audit_events SET actor_id = 9 WHERE id = %d`, events[1].ID),
+		`ALTER TABLE audit_events ENABLE TRIGGER audit_events_append_only`,
+	} {
+		_, err = testDB.Exec(query)
+		if err != nil {
+			t.Fatalf("tampering: unexpected error %v", err)
+		}
+	}
+
+	report, err = svc.Verify(context.Background(), 2)
+	if err != nil || report.OK() || report.BrokenAt != events[1].ID {
+		t.Fatalf("expected the chain broken at %d, got %+v, %v", events[1].ID, report, err)
+	}
+}
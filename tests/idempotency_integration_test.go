@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/idempotency"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+)
+
+// TestIdempotencyKeyTakeover claims a key again once it has expired, nothing of the response the
+// first request got may be left for the second to replay
+func TestIdempotencyKeyTakeover(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com"}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+
+	repo := &idempotency.Repository{DB: testDB}
+	first := &idempotency.Key{
+		UserID:      u.ID,
+		Key:         "transfer-1",
+		Expiry:      time.Now().Add(-time.Minute),
+		RequestHash: idempotency.Fingerprint(http.MethodPost, "/v1/transfers", []byte(`{}`)),
+	}
+	claimed, err := repo.Claim(context.Background(), first)
+	if err != nil || !claimed {
+		t.Fatalf("expected the key claimed, got %v, %v", claimed, err)
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	err = repo.Complete(
+		context.Background(), u.ID, first.Key, http.StatusCreated, header, []byte(`{"id":1}`),
+	)
+	if err != nil {
+		t.Fatalf("Complete: unexpected error %v", err)
+	}
+
+	second := &idempotency.Key{
+		UserID:      u.ID,
+		Key:         first.Key,
+		Expiry:      time.Now().Add(time.Hour),
+		RequestHash: idempotency.Fingerprint(http.MethodPost, "/v1/transfers", []byte(`{"a":1}`)),
+	}
+	claimed, err = repo.Claim(context.Background(), second)
+	if err != nil || !claimed {
+		t.Fatalf("expected the expired key taken over, got %v, %v", claimed, err)
+	}
+
+	k, err := repo.Get(context.Background(), u.ID, first.Key)
+	if err != nil {
+		t.Fatalf("Get: unexpected error %v", err)
+	}
+	if k.IsComplete() || len(k.ResponseHeader) != 0 || k.ResponseBody != nil {
+		t.Errorf("expected no response left from the first request, got %+v", k)
+	}
+	if string(k.RequestHash) != string(second.RequestHash) {
+		t.Errorf("expected the request hash of the second request, got %x", k.RequestHash)
+	}
+
+	// the key is live now, so it can't be taken over again
+	claimed, err = repo.Claim(context.Background(), second)
+	if err != nil || claimed {
+		t.Errorf("expected the live key kept, got %v, %v", claimed, err)
+	}
+}
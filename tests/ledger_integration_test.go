@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// TestLedgerHistory reads back the entries of an account that isn't in the default currency, the
+// postings have to come back in the currency they were made in
+func TestLedgerHistory(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com"}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+
+	a, err := accountSvc.Open(
+		context.Background(), validator.New(), u.ID, account.TypeCurrent, "EUR",
+	)
+	if err != nil {
+		t.Fatalf("Open: unexpected error %v", err)
+	}
+	seedBalance(a, money.MustParse("100").WithCurrency("EUR"))
+
+	withdrawal := ledger.Move(
+		ledger.KindWithdrawal, "test withdrawal", ledger.CustomerAccount(a.ID), ledger.AccountCash,
+		money.MustParse("40").WithCurrency("EUR"),
+	)
+	if err = ledgerSvc.Post(context.Background(), validator.New(), withdrawal); err != nil {
+		t.Fatalf("Post: unexpected error %v", err)
+	}
+
+	entries, err := ledgerSvc.History(context.Background(), ledger.CustomerAccount(a.ID))
+	if err != nil {
+		t.Fatalf("History: unexpected error %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+
+	// oldest first, each with both sides of the movement
+	wantKinds := []string{ledger.KindDeposit, ledger.KindWithdrawal}
+	for i, entry := range entries {
+		if entry.Kind != wantKinds[i] {
+			t.Errorf("expected entry %d to be a %s, got %s", i, wantKinds[i], entry.Kind)
+		}
+		if len(entry.Postings) != 2 {
+			t.Fatalf("expected 2 postings in entry %d, got %d", i, len(entry.Postings))
+		}
+		for _, posting := range entry.Postings {
+			if posting.EntryID != entry.ID || posting.Amount.Currency() != "EUR" {
+				t.Errorf("expected a EUR posting of entry %d, got %+v", entry.ID, posting)
+			}
+		}
+	}
+
+	balance, err := ledgerSvc.Reconcile(context.Background(), a.ID)
+	if err != nil {
+		t.Fatalf("Reconcile: unexpected error %v", err)
+	}
+	if want := money.MustParse("60").WithCurrency("EUR"); balance.Cmp(want) != 0 {
+		t.Errorf("expected balance=%s, got balance=%s", want, balance)
+	}
+}
@@ -1,9 +1,16 @@
 package tests
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/jobs"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
 	"github.com/Yusufdot101/goBankBackend/internal/loan"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 	_ "github.com/lib/pq"
@@ -17,10 +24,9 @@ func TestLoanLifecycle(t *testing.T) {
 	}
 
 	user1 = &user.User{
-		ID:             1,
-		Name:           "yusuf",
-		Email:          "y@gmail.com",
-		AccountBalance: 100, // needed to make the payment in the test
+		ID:    1,
+		Name:  "yusuf",
+		Email: "y@gmail.com",
 	}
 	user1.Password.Set("12345678", 12)
 
@@ -31,19 +37,25 @@ func TestLoanLifecycle(t *testing.T) {
 	}
 	user2.Password.Set("12345678", 12)
 
+	// the account the loan is paid out to and paid back from
+	var a *account.Account
 	setupUserSevice := func(us *user.Service) {
 		// seed the users table
-		us.Repo.Insert(user1)
-		us.Repo.Insert(user2)
+		us.Repo.Insert(context.Background(), user1)
+		us.Repo.Insert(context.Background(), user2)
+		a = openAccount(user1)
+		seedBalance(a, money.MustParse("100")) // needed to make the payment in the test
 	}
 
 	tests := []struct {
 		name  string
 		input struct {
-			user                               *user.User
-			reason                             string
-			loanID, userID, deletedByID        int64
-			amount, dailyInterestRate, payment float64
+			user                        *user.User
+			reason                      string
+			loanID, userID, deletedByID int64
+			amount                      money.Amount
+			dailyInterestRate           float64
+			payment                     money.Amount
 		}
 		expectedErr error
 	}{
@@ -55,18 +67,18 @@ func TestLoanLifecycle(t *testing.T) {
 				loanID            int64
 				userID            int64
 				deletedByID       int64
-				amount            float64
+				amount            money.Amount
 				dailyInterestRate float64
-				payment           float64
+				payment           money.Amount
 			}{
 				user:              user1,
 				reason:            "why not",
 				loanID:            1,
 				userID:            user1.ID,
 				deletedByID:       user2.ID,
-				amount:            100,
+				amount:            money.MustParse("100"),
 				dailyInterestRate: 5,
-				payment:           50,
+				payment:           money.MustParse("50"),
 			},
 		},
 		{
@@ -77,18 +89,18 @@ func TestLoanLifecycle(t *testing.T) {
 				loanID            int64
 				userID            int64
 				deletedByID       int64
-				amount            float64
+				amount            money.Amount
 				dailyInterestRate float64
-				payment           float64
+				payment           money.Amount
 			}{
 				user:              user1,
 				reason:            "why not",
 				loanID:            1,
 				userID:            user1.ID,
 				deletedByID:       user2.ID,
-				amount:            100,
+				amount:            money.MustParse("100"),
 				dailyInterestRate: 5,
-				payment:           0,
+				payment:           money.MustParse("0"),
 			},
 			expectedErr: validator.ErrFailedValidation,
 		},
@@ -103,47 +115,52 @@ func TestLoanLifecycle(t *testing.T) {
 			setupUserSevice(userSvc)
 
 			loanSvc = &loan.Service{
-				Repo:        loanRepo,
-				UserService: userSvc,
+				Repo:           loanRepo,
+				AccountService: accountSvc,
 			}
 			v := validator.New()
 			// step 1: create loan
-			gotErr := loanSvc.GetLoan(tc.input.user, tc.input.amount, tc.input.dailyInterestRate)
+			gotErr := loanSvc.GetLoan(
+				context.Background(), a, 0, tc.input.amount, tc.input.dailyInterestRate,
+			)
 			if !checkErr(t, gotErr, tc.expectedErr, "GetLoan") {
 				return
 			}
 
 			// fetch the loan from the DB
-			dbLoan, gotErr := loanRepo.GetByID(tc.input.loanID, tc.input.userID)
+			dbLoan, gotErr := loanRepo.GetByID(context.Background(), tc.input.loanID, tc.input.userID)
 			if !checkErr(t, gotErr, tc.expectedErr, "GetByID") {
 				return
 			}
-			if dbLoan.RemainingAmount != tc.input.amount {
-				t.Errorf("expected remaining = %f, got %f", tc.input.amount, dbLoan.RemainingAmount)
+			if dbLoan.RemainingAmount.Cmp(tc.input.amount) != 0 {
+				t.Errorf("expected remaining = %s, got %s", tc.input.amount, dbLoan.RemainingAmount)
 			}
 			if dbLoan.UserID != tc.input.userID {
 				t.Errorf("expected user id=%d, got %d", tc.input.userID, dbLoan.UserID)
 			}
 
 			// step 2: make payment
-			_, gotErr = loanSvc.MakePayment(v, tc.input.loanID, tc.input.userID, tc.input.payment)
+			_, gotErr = loanSvc.MakePayment(
+				context.Background(), v, tc.input.loanID, tc.input.userID, a.Number, tc.input.payment,
+			)
 			if !checkErr(t, gotErr, tc.expectedErr, "MakePayment") {
 				return
 			}
 
 			// fetch again to check
-			dbLoan, gotErr = loanRepo.GetByID(tc.input.loanID, tc.input.userID)
+			dbLoan, gotErr = loanRepo.GetByID(context.Background(), tc.input.loanID, tc.input.userID)
 			if !checkErr(t, gotErr, tc.expectedErr, "GetByID 2") {
 				return
 			}
 
-			if dbLoan.RemainingAmount >= tc.input.amount {
-				t.Errorf("expected remaining reduced, got %f", dbLoan.RemainingAmount)
+			if !dbLoan.RemainingAmount.LessThan(tc.input.amount) {
+				t.Errorf("expected remaining reduced, got %s", dbLoan.RemainingAmount)
 			}
 
 			// step 3: delete loan
 			loanDeletion, gotErr := loanSvc.DeleteLoan(
-				v, tc.input.loanID, tc.input.userID, tc.input.deletedByID, tc.input.reason,
+				context.Background(), v, tc.input.loanID, tc.input.userID, tc.input.deletedByID,
+				tc.input.reason,
 			)
 			if !checkErr(t, gotErr, tc.expectedErr, "DeleteLoan") {
 				return
@@ -153,8 +170,17 @@ func TestLoanLifecycle(t *testing.T) {
 				t.Errorf("expected deleted loan id %d, got %d", dbLoan.ID, loanDeletion.LoanID)
 			}
 
+			// what was left of the loan is written off rather than left on the bank's loans
+			entries, gotErr := ledgerSvc.History(context.Background(), ledger.AccountWriteOffs)
+			if gotErr != nil {
+				t.Fatalf("History: unexpected error %v", gotErr)
+			}
+			if len(entries) != 1 || entries[0].Kind != ledger.KindLoanWriteOff {
+				t.Errorf("expected one %s entry, got %v", ledger.KindLoanWriteOff, entries)
+			}
+
 			// verify loan is gone
-			_, gotErr = loanRepo.GetByID(dbLoan.ID, tc.input.userID)
+			_, gotErr = loanRepo.GetByID(context.Background(), dbLoan.ID, tc.input.userID)
 			if gotErr == nil {
 				t.Errorf("expected error fetching deleted loan, got nil")
 			}
@@ -166,3 +192,166 @@ func TestLoanLifecycle(t *testing.T) {
 		})
 	}
 }
+
+// TestScheduledLoan takes a loan under one of the seeded products and pays more than one
+// installment, the first should be paid off and the rest of the payment go to the next one
+func TestScheduledLoan(t *testing.T) {
+	resetDB()
+
+	loanRepo = &loan.Repository{DB: testDB}
+	loanSvc = &loan.Service{
+		Repo:           loanRepo,
+		AccountService: accountSvc,
+	}
+	userRepo = &user.Repository{DB: testDB}
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com"}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+	a := openAccount(u)
+	seedBalance(a, money.MustParse("100"))
+
+	// the first product is 12 monthly installments of equal size
+	err := loanSvc.GetLoan(context.Background(), a, 1, money.MustParse("1000"), 0)
+	if err != nil {
+		t.Fatalf("GetLoan: unexpected error %v", err)
+	}
+
+	schedule, err := loanSvc.GetSchedule(context.Background(), 1, u.ID)
+	if err != nil {
+		t.Fatalf("GetSchedule: unexpected error %v", err)
+	}
+	if len(schedule) != 12 {
+		t.Fatalf("expected 12 installments, got %d", len(schedule))
+	}
+
+	_, err = loanSvc.MakePayment(
+		context.Background(), validator.New(), 1, u.ID, a.Number, money.MustParse("100"),
+	)
+	if err != nil {
+		t.Fatalf("MakePayment: unexpected error %v", err)
+	}
+
+	schedule, err = loanSvc.GetSchedule(context.Background(), 1, u.ID)
+	if err != nil {
+		t.Fatalf("GetSchedule: unexpected error %v", err)
+	}
+	if schedule[0].PaidAt == nil {
+		t.Errorf("expected the first installment to be paid off")
+	}
+	if schedule[1].PaidAt != nil {
+		t.Errorf("expected the second installment to be partly paid")
+	}
+	if schedule[1].InterestPaid.Cmp(schedule[1].Interest) != 0 {
+		t.Errorf(
+			"expected the interest of the second installment paid first, got %s of %s",
+			schedule[1].InterestPaid, schedule[1].Interest,
+		)
+	}
+
+	principalPaid, _ := schedule[0].PrincipalPaid.Add(schedule[1].PrincipalPaid)
+	wantRemaining, _ := money.MustParse("1000").Sub(principalPaid)
+	dbLoan, err := loanRepo.GetByID(context.Background(), 1, u.ID)
+	if err != nil {
+		t.Fatalf("GetByID: unexpected error %v", err)
+	}
+	if dbLoan.RemainingAmount.Cmp(wantRemaining) != 0 {
+		t.Errorf("expected remaining=%s, got %s", wantRemaining, dbLoan.RemainingAmount)
+	}
+
+	balance, err := ledgerSvc.Reconcile(context.Background(), a.ID)
+	if err != nil {
+		t.Fatalf("Reconcile: unexpected error %v", err)
+	}
+	if !balance.IsZero() {
+		t.Errorf("expected the whole balance paid in, got %s left", balance)
+	}
+}
+
+func TestLoanAccrual(t *testing.T) {
+	resetDB()
+
+	loanRepo = &loan.Repository{DB: testDB}
+	loanSvc = &loan.Service{
+		Repo:           loanRepo,
+		AccountService: accountSvc,
+	}
+	userRepo = &user.Repository{DB: testDB}
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com"}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+	a := openAccount(u)
+
+	err := loanSvc.GetLoan(context.Background(), a, 1, money.MustParse("1000"), 0)
+	if err != nil {
+		t.Fatalf("GetLoan: unexpected error %v", err)
+	}
+	schedule, err := loanSvc.GetSchedule(context.Background(), 1, u.ID)
+	if err != nil {
+		t.Fatalf("GetSchedule: unexpected error %v", err)
+	}
+
+	// ten days after the first installment was due, past the grace period
+	day := schedule[0].DueDate.AddDate(0, 0, 10)
+	policy := loan.Policy{
+		LateFee:          money.MustParse("25"),
+		GraceDays:        5,
+		DailyPenaltyRate: 0.1,
+	}
+	accrue := func(ctx context.Context, day time.Time) (processed, failed int, err error) {
+		loanIDs, err := loanSvc.LoansToAccrue(ctx, day)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, loanID := range loanIDs {
+			processed++
+			if err := loanSvc.Accrue(ctx, loanID, day, policy); err != nil {
+				failed++
+			}
+		}
+		return processed, failed, nil
+	}
+
+	jobSvc := &jobs.Service{Repo: &jobs.Repository{DB: testDB}}
+	run, err := jobSvc.Run(context.Background(), "loan-accrual", day, accrue)
+	if err != nil {
+		t.Fatalf("Run: unexpected error %v", err)
+	}
+	if run.Status != jobs.StatusSucceeded || run.Processed != 1 {
+		t.Errorf("expected 1 loan processed successfully, got %+v", run)
+	}
+
+	_, err = jobSvc.Run(context.Background(), "loan-accrual", day, accrue)
+	if !errors.Is(err, jobs.ErrAlreadyRun) {
+		t.Errorf("expected error %v running twice for a day, got %v", jobs.ErrAlreadyRun, err)
+	}
+
+	// accruing the loan again for the same day must not charge it twice
+	err = loanSvc.Accrue(context.Background(), 1, day, policy)
+	if err != nil {
+		t.Fatalf("Accrue: unexpected error %v", err)
+	}
+
+	schedule, err = loanSvc.GetSchedule(context.Background(), 1, u.ID)
+	if err != nil {
+		t.Fatalf("GetSchedule: unexpected error %v", err)
+	}
+	// ten days of penalty interest on the first installment, plus the late fee
+	overdue, _ := schedule[0].Principal.Add(schedule[0].Interest)
+	penalty, _ := overdue.Mul(money.Rate(1), money.RoundHalfEven)
+	wantFees, _ := penalty.Add(policy.LateFee)
+	if schedule[0].Fees.Cmp(wantFees) != 0 {
+		t.Errorf("expected fees=%s on the first installment, got %s", wantFees, schedule[0].Fees)
+	}
+	if schedule[0].LateFeeChargedAt == nil {
+		t.Errorf("expected the late fee charge to be recorded")
+	}
+	if !schedule[1].Fees.IsZero() {
+		t.Errorf("expected no fees on the second installment, got %s", schedule[1].Fees)
+	}
+}
@@ -1,10 +1,13 @@
 package tests
 
 import (
+	"context"
 	"testing"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
 	"github.com/Yusufdot101/goBankBackend/internal/loan"
 	"github.com/Yusufdot101/goBankBackend/internal/loanrequests"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 	_ "github.com/lib/pq"
@@ -21,9 +24,9 @@ func TestLoanRequest(t *testing.T) {
 		Repo: &user.Repository{DB: loanrequestRepo.DB},
 	}
 	loanrequestSvc = &loanrequests.Service{
-		Repo:        loanrequestRepo,
-		LoanService: loanSvc,
-		UserService: userSvc,
+		Repo:           loanrequestRepo,
+		LoanService:    loanSvc,
+		AccountService: accountSvc,
 	}
 
 	user1 = &user.User{
@@ -32,9 +35,12 @@ func TestLoanRequest(t *testing.T) {
 		Email: "y@gmail.com",
 	}
 
+	// the account the loans are paid out to
+	var a *account.Account
 	seedUsersTable := func(us *user.Service) {
 		// seed the users table, this will be used in transferring of money
-		us.Repo.Insert(user1)
+		us.Repo.Insert(context.Background(), user1)
+		a = openAccount(user1)
 	}
 
 	tests := []struct {
@@ -42,8 +48,9 @@ func TestLoanRequest(t *testing.T) {
 		setup           func()
 		setupUserSevice func(*user.Service)
 		input           struct {
-			u                         *user.User
-			amount, dailyInterestRate float64
+			u                 *user.User
+			amount            money.Amount
+			dailyInterestRate float64
 		}
 		expectedErr error
 	}{
@@ -57,11 +64,11 @@ func TestLoanRequest(t *testing.T) {
 			},
 			input: struct {
 				u                 *user.User
-				amount            float64
+				amount            money.Amount
 				dailyInterestRate float64
 			}{
 				u:                 user1,
-				amount:            100,
+				amount:            money.MustParse("100"),
 				dailyInterestRate: 5,
 			},
 		},
@@ -75,11 +82,11 @@ func TestLoanRequest(t *testing.T) {
 			},
 			input: struct {
 				u                 *user.User
-				amount            float64
+				amount            money.Amount
 				dailyInterestRate float64
 			}{
 				u:                 user1,
-				amount:            0,
+				amount:            money.MustParse("0"),
 				dailyInterestRate: 5,
 			},
 			expectedErr: validator.ErrFailedValidation,
@@ -94,11 +101,11 @@ func TestLoanRequest(t *testing.T) {
 			},
 			input: struct {
 				u                 *user.User
-				amount            float64
+				amount            money.Amount
 				dailyInterestRate float64
 			}{
 				u:                 user1,
-				amount:            -100,
+				amount:            money.MustParse("-100"),
 				dailyInterestRate: 5,
 			},
 			expectedErr: validator.ErrFailedValidation,
@@ -112,14 +119,16 @@ func TestLoanRequest(t *testing.T) {
 			v := validator.New()
 			// step 1: loan creation
 			loanRequest, gotErr := loanrequestSvc.New(
-				v, tc.input.u, tc.input.amount, tc.input.dailyInterestRate,
+				context.Background(), v, tc.input.u, a.Number, 0, tc.input.amount, tc.input.dailyInterestRate,
 			)
 			if !checkErr(t, gotErr, tc.expectedErr, "New") {
 				return
 			}
 
 			// fetch the loan
-			loanRequest, gotErr = loanrequestSvc.Repo.Get(loanRequest.ID, tc.input.u.ID)
+			loanRequest, gotErr = loanrequestSvc.Repo.Get(
+				context.Background(), loanRequest.ID, tc.input.u.ID,
+			)
 			if !checkErr(t, gotErr, tc.expectedErr, "New") {
 				return
 			}
@@ -132,13 +141,17 @@ func TestLoanRequest(t *testing.T) {
 			}
 
 			// step 2: accept the loan
-			loanRequest, gotErr = loanrequestSvc.AcceptLoanRequest(loanRequest.ID, tc.input.u.ID)
+			loanRequest, gotErr = loanrequestSvc.AcceptLoanRequest(
+				context.Background(), validator.New(), loanRequest.ID, tc.input.u.ID,
+			)
 			if !checkErr(t, gotErr, tc.expectedErr, "New") {
 				return
 			}
 
 			// fetch the loan
-			loanRequest, gotErr = loanrequestSvc.Repo.Get(loanRequest.ID, tc.input.u.ID)
+			loanRequest, gotErr = loanrequestSvc.Repo.Get(
+				context.Background(), loanRequest.ID, tc.input.u.ID,
+			)
 			if !checkErr(t, gotErr, tc.expectedErr, "New") {
 				return
 			}
@@ -151,7 +164,7 @@ func TestLoanRequest(t *testing.T) {
 			}
 
 			// check if the loan was added to the loans table
-			gotLoan, gotErr := loanSvc.Repo.GetByID(1, tc.input.u.ID)
+			gotLoan, gotErr := loanSvc.Repo.GetByID(context.Background(), 1, tc.input.u.ID)
 			if !checkErr(t, gotErr, tc.expectedErr, "New") {
 				return
 			}
@@ -162,34 +175,38 @@ func TestLoanRequest(t *testing.T) {
 				return
 			}
 
-			// check if the money was added to the users account balance
-			gotUser, gotErr := userSvc.GetUser(tc.input.u.ID)
+			// check if the money was added to the account balance
+			gotAccount, gotErr := accountSvc.GetAccount(context.Background(), a.ID)
 			if !checkErr(t, gotErr, tc.expectedErr, "New") {
 				return
 			}
-			if gotUser.AccountBalance != tc.input.amount {
+			if gotAccount.Balance.Cmp(tc.input.amount) != 0 {
 				t.Errorf(
-					"expected user account balance=%f, got account balance=%f",
-					tc.input.amount, gotUser.AccountBalance,
+					"expected account balance=%s, got account balance=%s",
+					tc.input.amount, gotAccount.Balance,
 				)
 			}
 
 			// step 3: new loan request
 			loanRequest, gotErr = loanrequestSvc.New(
-				v, tc.input.u, tc.input.amount, tc.input.dailyInterestRate,
+				context.Background(), v, tc.input.u, a.Number, 0, tc.input.amount, tc.input.dailyInterestRate,
 			)
 			if !checkErr(t, gotErr, tc.expectedErr, "New") {
 				return
 			}
 
 			// decline it
-			loanRequest, gotErr = loanrequestSvc.DeclineLoanRequest(loanRequest.ID, tc.input.u.ID)
+			loanRequest, gotErr = loanrequestSvc.DeclineLoanRequest(
+				context.Background(), v, loanRequest.ID, tc.input.u.ID, "income too low",
+			)
 			if !checkErr(t, gotErr, tc.expectedErr, "New") {
 				return
 			}
 
 			// fetch it
-			loanRequest, gotErr = loanrequestSvc.Repo.Get(loanRequest.ID, tc.input.u.ID)
+			loanRequest, gotErr = loanrequestSvc.Repo.Get(
+				context.Background(), loanRequest.ID, tc.input.u.ID,
+			)
 			if !checkErr(t, gotErr, tc.expectedErr, "New") {
 				return
 			}
@@ -202,7 +219,7 @@ func TestLoanRequest(t *testing.T) {
 			}
 
 			// make sure its not added to the loans table
-			gotLoan, gotErr = loanSvc.Repo.GetByID(1, tc.input.u.ID)
+			gotLoan, gotErr = loanSvc.Repo.GetByID(context.Background(), 1, tc.input.u.ID)
 			if !checkErr(t, gotErr, user.ErrNoRecord, "New") {
 				return
 			}
@@ -213,16 +230,16 @@ func TestLoanRequest(t *testing.T) {
 				return
 			}
 
-			// make sure the money was not added to the users account balance
-			gotUser, gotErr = userSvc.GetUser(tc.input.u.ID)
+			// make sure the money was not added to the account balance
+			gotAccount, gotErr = accountSvc.GetAccount(context.Background(), a.ID)
 			if !checkErr(t, gotErr, tc.expectedErr, "New 2") {
 				return
 			}
 			// it should only have the balance from the first loan
-			if gotUser.AccountBalance > tc.input.amount {
+			if tc.input.amount.LessThan(gotAccount.Balance) {
 				t.Errorf(
-					"expected user account balance=%f, got account balance=%f",
-					tc.input.amount, gotUser.AccountBalance,
+					"expected account balance=%s, got account balance=%s",
+					tc.input.amount, gotAccount.Balance,
 				)
 			}
 
@@ -235,16 +252,17 @@ func TestLoanRequest(t *testing.T) {
 }
 
 func checkLoan(
-	t *testing.T, gotLoan *loan.Loan, userID int64, amount, dailyInterestRate float64, msg string,
+	t *testing.T, gotLoan *loan.Loan, userID int64, amount money.Amount, dailyInterestRate float64,
+	msg string,
 ) bool {
 	passed := true
 	if gotLoan.UserID != userID {
 		t.Errorf("%s: expected user id=%d, got id=%d", msg, userID, gotLoan.UserID)
 		passed = false
 	}
-	if gotLoan.Amount != amount {
+	if gotLoan.Amount.Cmp(amount) != 0 {
 		t.Errorf(
-			"%s: expected account balance=%f, got account balance=%f", msg, amount,
+			"%s: expected account balance=%s, got account balance=%s", msg, amount,
 			gotLoan.Amount,
 		)
 		passed = false
@@ -260,7 +278,8 @@ func checkLoan(
 }
 
 func checkLoanRequest(
-	t *testing.T, gotLoanRequest *loanrequests.LoanRequest, userID int64, amount, dailyInterestRate float64,
+	t *testing.T, gotLoanRequest *loanrequests.LoanRequest, userID int64,
+	amount money.Amount, dailyInterestRate float64,
 	status, msg string,
 ) bool {
 	passed := true
@@ -268,9 +287,9 @@ func checkLoanRequest(
 		t.Errorf("%s: expected user id=%d, got id=%d", msg, userID, gotLoanRequest.UserID)
 		passed = false
 	}
-	if gotLoanRequest.Amount != amount {
+	if gotLoanRequest.Amount.Cmp(amount) != 0 {
 		t.Errorf(
-			"%s: expected account balance=%f, got account balance=%f", msg, amount,
+			"%s: expected account balance=%s, got account balance=%s", msg, amount,
 			gotLoanRequest.Amount,
 		)
 		passed = false
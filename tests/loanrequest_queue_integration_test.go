@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/loanrequests"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestLoanRequestQueue(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	svc := &loanrequests.Service{
+		Repo:           &loanrequests.Repository{DB: testDB},
+		AccountService: accountSvc,
+	}
+
+	borrower := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	other := &user.User{Name: "mohamed", Email: "m@gmail.com", Activated: true}
+	requests := map[int64][]*loanrequests.LoanRequest{}
+	for _, u := range []*user.User{borrower, other} {
+		u.Password.Set("12345678", 12)
+		if err := userRepo.Insert(context.Background(), u); err != nil {
+			t.Fatalf("Insert: unexpected error %v", err)
+		}
+		a := openAccount(u)
+		for _, amount := range []string{"100", "5000"} {
+			loanRequest, err := svc.New(
+				context.Background(), validator.New(), u, a.Number, 0, money.MustParse(amount), 5,
+			)
+			if err != nil {
+				t.Fatalf("New: unexpected error %v", err)
+			}
+			requests[u.ID] = append(requests[u.ID], loanRequest)
+		}
+	}
+
+	f := filter.Filters{
+		Page: 1, PageSize: 20, Sort: "-created_at", SortSafelist: loanrequests.SortSafelist,
+	}
+	list := func(userID int64, status string, f filter.Filters) []*loanrequests.LoanRequest {
+		t.Helper()
+		loanRequests, metadata, err := svc.GetAll(
+			context.Background(), validator.New(), userID, status, f,
+		)
+		if err != nil {
+			t.Fatalf("GetAll: unexpected error %v", err)
+		}
+		if metadata.TotalRecords != len(loanRequests) {
+			t.Errorf("expected %d total records, got %d", len(loanRequests), metadata.TotalRecords)
+		}
+		return loanRequests
+	}
+
+	if got := list(borrower.ID, "", f); len(got) != 2 {
+		t.Errorf("expected the borrower to see 2 requests, got %d", len(got))
+	}
+	if got := list(0, loanrequests.StatusPending, f); len(got) != 4 {
+		t.Errorf("expected 4 pending requests, got %d", len(got))
+	}
+	minAmount := money.MustParse("1000")
+	large := f
+	large.MinAmount = &minAmount
+	if got := list(0, "", large); len(got) != 2 {
+		t.Errorf("expected 2 requests of at least 1000, got %d", len(got))
+	}
+
+	// a borrower can only withdraw their own requests, and only while they are pending
+	withdrawn := requests[borrower.ID][0]
+	_, err := svc.Withdraw(context.Background(), withdrawn.ID, other.ID)
+	checkErr(t, err, user.ErrNoRecord, "Withdraw")
+
+	got, err := svc.Withdraw(context.Background(), withdrawn.ID, borrower.ID)
+	if err != nil || got.Status != loanrequests.StatusWithdrawn {
+		t.Fatalf("expected the request withdrawn, got %+v, %v", got, err)
+	}
+	_, err = svc.Withdraw(context.Background(), withdrawn.ID, borrower.ID)
+	checkErr(t, err, loanrequests.ErrNotPending, "Withdraw")
+	_, err = svc.DeclineLoanRequest(
+		context.Background(), validator.New(), withdrawn.ID, borrower.ID, "",
+	)
+	checkErr(t, err, loanrequests.ErrNotPending, "DeclineLoanRequest")
+
+	// the reason a request was declined is kept for the borrower
+	declined := requests[borrower.ID][1]
+	_, err = svc.DeclineLoanRequest(
+		context.Background(), validator.New(), declined.ID, borrower.ID, "income too low",
+	)
+	if err != nil {
+		t.Fatalf("DeclineLoanRequest: unexpected error %v", err)
+	}
+	got, err = svc.Get(context.Background(), declined.ID, borrower.ID)
+	if err != nil || got.Status != loanrequests.StatusDeclined ||
+		got.DeclineReason != "income too low" {
+		t.Errorf("expected the request declined with its reason, got %+v, %v", got, err)
+	}
+
+	if got := list(0, loanrequests.StatusPending, f); len(got) != 2 {
+		t.Errorf("expected 2 pending requests left, got %d", len(got))
+	}
+
+	// a request is read back in the currency of the account it would be paid out to, that's what
+	// its approval threshold is looked up by
+	euros, err := accountSvc.Open(
+		context.Background(), validator.New(), other.ID, account.TypeCurrent, "EUR",
+	)
+	if err != nil {
+		t.Fatalf("Open: unexpected error %v", err)
+	}
+	loanRequest, err := svc.New(
+		context.Background(), validator.New(), other, euros.Number, 0, money.MustParse("250"), 5,
+	)
+	if err != nil {
+		t.Fatalf("New: unexpected error %v", err)
+	}
+	got, err = svc.Get(context.Background(), loanRequest.ID, other.ID)
+	if err != nil || got.Amount.Currency() != "EUR" {
+		t.Errorf("expected the request in EUR, got %+v, %v", got, err)
+	}
+}
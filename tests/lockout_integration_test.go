@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/lockout"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestLockout(t *testing.T) {
+	resetDB()
+
+	lockoutSvc := &lockout.Service{
+		Repo: &lockout.Repository{DB: testDB},
+		Policy: lockout.Policy{
+			MaxFailures:   3,
+			IPMaxFailures: 10,
+			Duration:      time.Hour,
+			BaseDelay:     time.Millisecond,
+			MaxDelay:      time.Millisecond,
+		},
+	}
+
+	// the email is counted however it is typed
+	emails := []string{"y@gmail.com", "Y@gmail.com", " y@GMAIL.com"}
+	for i, email := range emails {
+		locked, err := lockoutSvc.Fail(context.Background(), email, "10.0.0.1", nil)
+		if err != nil {
+			t.Fatalf("Fail: unexpected error %v", err)
+		}
+		if locked != (i == len(emails)-1) {
+			t.Errorf("Fail %d: expected locked=%v, got %v", i, i == len(emails)-1, locked)
+		}
+	}
+
+	// the account is locked from any IP
+	retryAfter, err := lockoutSvc.Check(context.Background(), "y@gmail.com", "10.0.0.2")
+	checkErr(t, err, lockout.ErrLocked, "Check")
+	if retryAfter < 59*time.Minute {
+		t.Errorf("expected to wait about an hour, got %v", retryAfter)
+	}
+
+	f := filter.Filters{
+		Page: 1, PageSize: 10, Sort: "-failures", SortSafelist: lockout.SortSafelist,
+	}
+	entries, _, err := lockoutSvc.GetAll(context.Background(), validator.New(), f)
+	if err != nil {
+		t.Fatalf("GetAll: unexpected error %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected an entry for the account and one for the IP, got %d", len(entries))
+	}
+
+	cleared, err := lockoutSvc.Clear(
+		context.Background(), validator.New(), lockout.KindAccount, "y@gmail.com",
+	)
+	if err != nil || !cleared {
+		t.Fatalf("Clear: expected cleared, got %v, %v", cleared, err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	_, err = lockoutSvc.Check(context.Background(), "y@gmail.com", "10.0.0.2")
+	if err != nil {
+		t.Errorf("Check: expected no lockout after clearing, got %v", err)
+	}
+
+	cleared, err = lockoutSvc.Clear(
+		context.Background(), validator.New(), lockout.KindAccount, "y@gmail.com",
+	)
+	if err != nil || cleared {
+		t.Errorf("Clear: expected nothing to clear, got %v, %v", cleared, err)
+	}
+
+	_, err = lockoutSvc.Clear(context.Background(), validator.New(), "DEVICE", "x")
+	if !errors.Is(err, validator.ErrFailedValidation) {
+		t.Errorf("Clear: expected a validation error for an unknown kind, got %v", err)
+	}
+}
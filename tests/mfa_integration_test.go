@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/mfa"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestMFA(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	mfaSvc := &mfa.Service{
+		Repo:   &mfa.Repository{DB: testDB},
+		Key:    bytes.Repeat([]byte{7}, 32),
+		Issuer: "goBank",
+	}
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+
+	secret, _, err := mfaSvc.Enrol(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Enrol: unexpected error %v", err)
+	}
+	enrolled, err := mfaSvc.IsEnrolled(context.Background(), u.ID)
+	if err != nil || enrolled {
+		t.Fatalf("expected the user not enrolled before confirming, got %v, %v", enrolled, err)
+	}
+
+	code, _ := mfa.Code(secret, time.Now())
+	recoveryCodes, err := mfaSvc.Confirm(context.Background(), validator.New(), u.ID, code)
+	if err != nil {
+		t.Fatalf("Confirm: unexpected error %v", err)
+	}
+	enrolled, err = mfaSvc.IsEnrolled(context.Background(), u.ID)
+	if err != nil || !enrolled {
+		t.Fatalf("expected the user enrolled, got %v, %v", enrolled, err)
+	}
+
+	_, _, err = mfaSvc.Enrol(context.Background(), u)
+	checkErr(t, err, mfa.ErrAlreadyEnrolled, "Enrol")
+
+	// the code used to confirm can't be used again to sign in
+	err = mfaSvc.Verify(context.Background(), validator.New(), u.ID, code)
+	checkErr(t, err, validator.ErrFailedValidation, "Verify")
+
+	err = mfaSvc.Verify(context.Background(), validator.New(), u.ID, recoveryCodes[0])
+	if err != nil {
+		t.Fatalf("Verify: unexpected error %v", err)
+	}
+	err = mfaSvc.Verify(context.Background(), validator.New(), u.ID, recoveryCodes[0])
+	checkErr(t, err, validator.ErrFailedValidation, "Verify")
+}
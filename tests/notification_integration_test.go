@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestNotificationPreferences(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	notificationRepo := &notification.Repository{DB: testDB}
+	notificationSvc := &notification.Service{Repo: notificationRepo}
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+
+	// every event is on until the user turns it off
+	enabled, err := notificationRepo.Enabled(context.Background(), u.ID, notification.EventDeposit)
+	if err != nil || !enabled {
+		t.Fatalf("expected deposits enabled by default, got %v, %v", enabled, err)
+	}
+
+	preferences, _, err := notificationSvc.UpdatePreferences(
+		context.Background(), validator.New(), u.ID,
+		notification.Preferences{notification.EventDeposit: false}, nil,
+	)
+	if err != nil {
+		t.Fatalf("UpdatePreferences: unexpected error %v", err)
+	}
+	if preferences[notification.EventDeposit] || !preferences[notification.EventWithdrawal] {
+		t.Errorf("expected only deposits turned off, got %v", preferences)
+	}
+
+	enabled, err = notificationRepo.Enabled(context.Background(), u.ID, notification.EventDeposit)
+	if err != nil || enabled {
+		t.Errorf("expected deposits turned off, got %v, %v", enabled, err)
+	}
+
+	// turning it back on updates the same row
+	_, _, err = notificationSvc.UpdatePreferences(
+		context.Background(), validator.New(), u.ID,
+		notification.Preferences{notification.EventDeposit: true}, nil,
+	)
+	if err != nil {
+		t.Fatalf("UpdatePreferences: unexpected error %v", err)
+	}
+	enabled, err = notificationRepo.Enabled(context.Background(), u.ID, notification.EventDeposit)
+	if err != nil || !enabled {
+		t.Errorf("expected deposits turned back on, got %v, %v", enabled, err)
+	}
+
+	// the phone can be set on its own and removed again
+	phone := "+254712345678"
+	_, gotPhone, err := notificationSvc.UpdatePreferences(
+		context.Background(), validator.New(), u.ID, nil, &phone,
+	)
+	if err != nil || gotPhone != phone {
+		t.Fatalf("expected the phone set, got %q, %v", gotPhone, err)
+	}
+	_, gotPhone, err = notificationSvc.UpdatePreferences(
+		context.Background(), validator.New(), u.ID, nil, new(string),
+	)
+	if err != nil || gotPhone != "" {
+		t.Errorf("expected the phone removed, got %q, %v", gotPhone, err)
+	}
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/mailer"
+	"github.com/Yusufdot101/goBankBackend/internal/notification"
+	"github.com/Yusufdot101/goBankBackend/internal/notify"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestInAppNotifications(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	notifyRepo := &notify.Repository{DB: testDB}
+	outboxRepo := &outbox.Repository{DB: testDB}
+	notifySvc := &notify.Service{
+		Repo: notifyRepo,
+		Channels: map[string]notify.Channel{
+			notify.ChannelEmail: &notify.Email{Mailer: &outbox.Service{Repo: outboxRepo}},
+			notify.ChannelInApp: &notify.InApp{Repo: notifyRepo},
+		},
+		Routes:  notify.Routes{notification.EventWithdrawal: {notify.ChannelInApp}},
+		Default: []string{notify.ChannelEmail, notify.ChannelInApp},
+		Render:  mailer.Render,
+	}
+	notificationSvc := &notification.Service{
+		Repo:        &notification.Repository{DB: testDB},
+		Notifier:    notifySvc,
+		UserService: &user.Service{Repo: userRepo},
+	}
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+
+	data := map[string]any{"amount": "10.00", "currency": "USD", "accountNumber": "1"}
+	send := func(event string, commit bool) {
+		tx, err := testDB.BeginTx(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("BeginTx: unexpected error %v", err)
+		}
+		defer tx.Rollback()
+
+		_, err = notificationSvc.SendTx(context.Background(), tx, u.ID, event, data)
+		if err != nil {
+			t.Fatalf("SendTx %s: unexpected error %v", event, err)
+		}
+		if commit {
+			if err = tx.Commit(); err != nil {
+				t.Fatalf("Commit: unexpected error %v", err)
+			}
+		}
+	}
+	send(notification.EventDeposit, true)
+	send(notification.EventWithdrawal, true)
+	// nothing is left of a notification about something that was rolled back
+	send(notification.EventDeposit, false)
+
+	// withdrawals are only routed in the app, deposits are emailed too
+	f := filter.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafelist: outbox.SortSafelist}
+	queued, _, err := outboxRepo.GetAll(context.Background(), outbox.StatusPending, f)
+	if err != nil || len(queued) != 1 || queued[0].Template != "deposit.html" {
+		t.Fatalf("expected only the deposit emailed, got %v, %v", queued, err)
+	}
+
+	f.SortSafelist = notify.SortSafelist
+	notifications, unread, _, err := notifySvc.GetAll(
+		context.Background(), validator.New(), u.ID, "", f,
+	)
+	if err != nil || len(notifications) != 2 || unread != 2 {
+		t.Fatalf("expected 2 unread notifications, got %v, %d, %v", notifications, unread, err)
+	}
+	if notifications[0].Subject != "10.00 USD was deposited" || notifications[0].Body == "" {
+		t.Errorf("expected the deposit rendered, got %+v", notifications[0])
+	}
+
+	read := true
+	n, err := notifySvc.SetRead(
+		context.Background(), validator.New(), notifications[0].ID, u.ID, &read,
+	)
+	if err != nil || n.ReadAt == nil {
+		t.Fatalf("expected the notification read, got %+v, %v", n, err)
+	}
+
+	// someone else's notification can't be touched
+	_, err = notifySvc.SetRead(
+		context.Background(), validator.New(), notifications[1].ID, u.ID+1, &read,
+	)
+	checkErr(t, err, user.ErrNoRecord, "SetRead")
+
+	notifications, unread, _, err = notifySvc.GetAll(
+		context.Background(), validator.New(), u.ID, "false", f,
+	)
+	if err != nil || len(notifications) != 1 || unread != 1 {
+		t.Fatalf("expected 1 unread notification, got %v, %d, %v", notifications, unread, err)
+	}
+
+	marked, err := notifySvc.MarkAllRead(context.Background(), u.ID)
+	if err != nil || marked != 1 {
+		t.Errorf("expected 1 notification marked read, got %d, %v", marked, err)
+	}
+}
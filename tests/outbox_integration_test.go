@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/outbox"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestOutbox(t *testing.T) {
+	resetDB()
+
+	outboxRepo := &outbox.Repository{DB: testDB}
+	policy := outbox.Policy{MaxAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	svc := &user.Service{Repo: &user.Repository{DB: testDB}}
+
+	// the welcome email is queued with the user
+	u, tkn, err := svc.Register(
+		context.Background(), validator.New(), "yusuf", "y@gmail.com", "12345678",
+	)
+	if err != nil {
+		t.Fatalf("Register: unexpected error %v", err)
+	}
+
+	now := time.Now()
+	claimed, err := outboxRepo.Claim(context.Background(), 10, now, time.Minute)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("expected the welcome email claimed, got %v, %v", claimed, err)
+	}
+	msg := claimed[0]
+	if msg.Recipient != u.Email || msg.Template != "user_welcome.html" || msg.Attempts != 1 {
+		t.Errorf("expected the welcome email to %s, got %+v", u.Email, msg)
+	}
+	if msg.Data["token"] != tkn.Plaintext || msg.Data["userID"].(json.Number).String() != "1" {
+		t.Errorf("expected the token and user id in the data, got %v", msg.Data)
+	}
+
+	// a claimed message isn't handed to anyone else until its lease is up
+	claimed, err = outboxRepo.Claim(context.Background(), 10, now, time.Minute)
+	if err != nil || len(claimed) != 0 {
+		t.Errorf("expected nothing left to claim, got %v, %v", claimed, err)
+	}
+
+	// a registration that fails queues nothing
+	_, _, err = svc.Register(context.Background(), validator.New(), "yusuf", "y@gmail.com", "12345678")
+	checkErr(t, err, user.ErrDuplicateEmail, "Register")
+
+	// the message fails until it is dead, then an admin retries it
+	err = outboxRepo.MarkFailed(
+		context.Background(), msg.ID, "smtp down", msg.Attempts >= policy.MaxAttempts, now,
+	)
+	if err != nil {
+		t.Fatalf("MarkFailed: unexpected error %v", err)
+	}
+	claimed, _ = outboxRepo.Claim(context.Background(), 10, now, time.Minute)
+	if len(claimed) != 1 || claimed[0].Attempts != 2 {
+		t.Fatalf("expected the message claimed a second time, got %v", claimed)
+	}
+	err = outboxRepo.MarkFailed(
+		context.Background(), msg.ID, "smtp down", claimed[0].Attempts >= policy.MaxAttempts, now,
+	)
+	if err != nil {
+		t.Fatalf("MarkFailed: unexpected error %v", err)
+	}
+
+	f := filter.Filters{
+		Page: 1, PageSize: 10, Sort: "id", SortSafelist: outbox.SortSafelist,
+	}
+	dead, _, err := outboxRepo.GetAll(context.Background(), outbox.StatusDead, f)
+	if err != nil || len(dead) != 1 || dead[0].LastError != "smtp down" {
+		t.Fatalf("expected the message dead, got %v, %v", dead, err)
+	}
+
+	_, err = outboxRepo.Retry(context.Background(), msg.ID+1, nil)
+	checkErr(t, err, outbox.ErrNoRecord, "Retry")
+	retried, err := outboxRepo.Retry(context.Background(), msg.ID, nil)
+	if err != nil || retried.Status != outbox.StatusPending || retried.Attempts != 0 {
+		t.Fatalf("expected the message pending again, got %+v, %v", retried, err)
+	}
+	_, err = outboxRepo.Retry(context.Background(), msg.ID, nil)
+	checkErr(t, err, outbox.ErrNotDead, "Retry")
+
+	// once sent, its data is cleared
+	err = outboxRepo.MarkSent(context.Background(), msg.ID, time.Now())
+	if err != nil {
+		t.Fatalf("MarkSent: unexpected error %v", err)
+	}
+	sent, _, err := outboxRepo.GetAll(context.Background(), outbox.StatusSent, f)
+	if err != nil || len(sent) != 1 || len(sent[0].Data) != 0 || sent[0].SentAt == nil {
+		t.Errorf("expected the message sent with its data cleared, got %v, %v", sent, err)
+	}
+}
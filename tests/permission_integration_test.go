@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/permission"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+func TestRoles(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	permissionSvc := &permission.Service{
+		Repo:        &permission.Repository{DB: testDB},
+		UserService: &user.Service{Repo: userRepo},
+	}
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+
+	// the codes the routes ask for are all in the catalog
+	catalog, err := permissionSvc.Permissions(context.Background())
+	if err != nil {
+		t.Fatalf("Permissions: unexpected error %v", err)
+	}
+	if len(catalog) != 6 {
+		t.Errorf("expected 6 seeded permissions, got %d", len(catalog))
+	}
+
+	has, err := permissionSvc.UserHas(context.Background(), validator.New(), u, "DEPOSIT")
+	if err != nil || has {
+		t.Fatalf("expected no permissions before a role is assigned, got %v, %v", has, err)
+	}
+
+	// admin inherits teller and loan_manager, which inherits loan_officer
+	grant := &permission.Grant{UserID: u.ID, Role: "admin", Reason: "runs the branch"}
+	if err = permissionSvc.AssignRole(context.Background(), validator.New(), grant); err != nil {
+		t.Fatalf("AssignRole: unexpected error %v", err)
+	}
+	permissions, err := permissionSvc.UserAllPermissions(context.Background(), u.ID)
+	if err != nil {
+		t.Fatalf("UserAllPermissions: unexpected error %v", err)
+	}
+	for _, code := range []string{"ADMIN", "DELETE_LOANS", "APPROVE_LOANS", "DEPOSIT", "WITHDRAW"} {
+		if !permission.Includes(permissions, code) {
+			t.Errorf("expected admin to have %s, got %v", code, permissions)
+		}
+	}
+	if permission.Includes(permissions, "SUPERUSER") {
+		t.Errorf("expected admin not to have SUPERUSER")
+	}
+
+	err = permissionSvc.AddNewPermission(
+		context.Background(), validator.New(), "VIEW_LEDGER", "read account ledgers",
+	)
+	if err != nil {
+		t.Fatalf("AddNewPermission: unexpected error %v", err)
+	}
+	auditor := &permission.Role{
+		Name: "auditor", Permissions: []permission.Permission{"VIEW_LEDGER"},
+		Inherits: []string{"loan_officer"},
+	}
+	if err = permissionSvc.CreateRole(context.Background(), validator.New(), auditor); err != nil {
+		t.Fatalf("CreateRole: unexpected error %v", err)
+	}
+	err = permissionSvc.CreateRole(context.Background(), validator.New(), &permission.Role{
+		Name: "auditor", Permissions: []permission.Permission{"VIEW_LEDGER"},
+	})
+	checkErr(t, err, permission.ErrDuplicateRole, "CreateRole")
+
+	grant = &permission.Grant{UserID: u.ID, Role: "auditor", Reason: "yearly audit"}
+	if err = permissionSvc.AssignRole(context.Background(), validator.New(), grant); err != nil {
+		t.Fatalf("AssignRole: unexpected error %v", err)
+	}
+	if err = permissionSvc.RevokeRole(
+		context.Background(), validator.New(), u.ID, "admin", u.ID,
+	); err != nil {
+		t.Fatalf("RevokeRole: unexpected error %v", err)
+	}
+	err = permissionSvc.RevokeRole(context.Background(), validator.New(), u.ID, "admin", u.ID)
+	checkErr(t, err, user.ErrNoRecord, "RevokeRole")
+
+	has, err = permissionSvc.UserHas(
+		context.Background(), validator.New(), u, "ADMIN", "APPROVE_LOANS",
+	)
+	if err != nil || !has {
+		t.Errorf("expected APPROVE_LOANS through auditor, got %v, %v", has, err)
+	}
+	has, err = permissionSvc.UserHas(context.Background(), validator.New(), u, "DEPOSIT")
+	if err != nil || has {
+		t.Errorf("expected DEPOSIT to go with the admin role, got %v, %v", has, err)
+	}
+
+	roles, err := permissionSvc.Roles(context.Background())
+	if err != nil {
+		t.Fatalf("Roles: unexpected error %v", err)
+	}
+	if len(roles) != 6 {
+		t.Errorf("expected 6 roles, got %d", len(roles))
+	}
+}
+
+func TestScopedGrants(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	permissionSvc := &permission.Service{
+		Repo:        &permission.Repository{DB: testDB},
+		UserService: &user.Service{Repo: userRepo},
+	}
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+
+	limit := money.MustParse("500")
+	grant := &permission.Grant{
+		UserID: u.ID, Role: "teller", Reason: "covering the desk", MaxAmount: &limit,
+	}
+	if err := permissionSvc.AssignRole(context.Background(), validator.New(), grant); err != nil {
+		t.Fatalf("AssignRole: unexpected error %v", err)
+	}
+
+	// the limit is in the default currency, it doesn't let anything else be moved
+	scope, err := permissionSvc.UserScope(context.Background(), validator.New(), u, "DEPOSIT")
+	if err != nil || scope == nil || len(scope.MaxAmounts) != 1 {
+		t.Fatalf("expected DEPOSIT limited in one currency, got %+v, %v", scope, err)
+	}
+	if maxAmount, ok := scope.MaxAmounts[money.DefaultCurrency]; !ok || maxAmount.Cmp(limit) != 0 {
+		t.Fatalf("expected DEPOSIT limited to %s, got %+v", limit, scope.MaxAmounts)
+	}
+
+	// a grant without a limit lifts it, even one that expires soon
+	expiresAt := time.Now().Add(time.Second)
+	grant = &permission.Grant{
+		UserID: u.ID, Role: "admin", Reason: "one off", ExpiresAt: &expiresAt,
+	}
+	if err = permissionSvc.AssignRole(context.Background(), validator.New(), grant); err != nil {
+		t.Fatalf("AssignRole: unexpected error %v", err)
+	}
+	scope, err = permissionSvc.UserScope(context.Background(), validator.New(), u, "DEPOSIT")
+	if err != nil || scope == nil || scope.MaxAmounts != nil {
+		t.Fatalf("expected DEPOSIT without a limit, got %+v, %v", scope, err)
+	}
+
+	// once the grant expires it stops counting, before it's swept
+	time.Sleep(1100 * time.Millisecond)
+	scope, err = permissionSvc.UserScope(context.Background(), validator.New(), u, "ADMIN")
+	if err != nil || scope != nil {
+		t.Fatalf("expected ADMIN gone with the expired grant, got %+v, %v", scope, err)
+	}
+
+	deleted, err := permissionSvc.DeleteExpiredGrants(context.Background())
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 expired grant deleted, got %d, %v", deleted, err)
+	}
+
+	var events int
+	err = testDB.QueryRow(
+		`SELECT COUNT(*) FROM role_grant_events WHERE user_id = $1`, u.ID,
+	).Scan(&events)
+	if err != nil || events != 3 {
+		t.Errorf("expected 2 assignments and an expiry logged, got %d, %v", events, err)
+	}
+
+	grants, err := permissionSvc.Grants(context.Background(), u.ID)
+	if err != nil || len(grants) != 1 || grants[0].Role != "teller" {
+		t.Fatalf("expected only the teller grant left, got %v, %v", grants, err)
+	}
+	if grants[0].Currency != money.DefaultCurrency || grants[0].MaxAmount.Cmp(limit) != 0 {
+		t.Errorf("expected the teller grant limited to %s, got %+v", limit, grants[0])
+	}
+
+	// granting it again in euros replaces the limit in dollars
+	grant = &permission.Grant{
+		UserID: u.ID, Role: "teller", Reason: "covering the desk", MaxAmount: &limit,
+		Currency: "EUR",
+	}
+	if err = permissionSvc.AssignRole(context.Background(), validator.New(), grant); err != nil {
+		t.Fatalf("AssignRole: unexpected error %v", err)
+	}
+	scope, err = permissionSvc.UserScope(context.Background(), validator.New(), u, "DEPOSIT")
+	if err != nil || scope == nil || len(scope.MaxAmounts) != 1 {
+		t.Fatalf("expected DEPOSIT limited in one currency, got %+v, %v", scope, err)
+	}
+	if maxAmount, ok := scope.MaxAmounts["EUR"]; !ok || maxAmount.Currency() != "EUR" {
+		t.Errorf("expected DEPOSIT limited in euros, got %+v", scope.MaxAmounts)
+	}
+}
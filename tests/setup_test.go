@@ -8,10 +8,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
 	"github.com/Yusufdot101/goBankBackend/internal/loan"
 	"github.com/Yusufdot101/goBankBackend/internal/loanrequests"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/token"
+	"github.com/Yusufdot101/goBankBackend/internal/transfer"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
 )
 
 var (
@@ -22,7 +27,9 @@ var (
 	// permissionRepo  *permission.Repository
 	loanrequestRepo *loanrequests.Repository
 	loanRepo        *loan.Repository
-	// transferRepo    *transfer.Repository
+	ledgerRepo      *ledger.Repository
+	accountRepo     *account.Repository
+	transferRepo    *transfer.Repository
 	// transactionRepo *transaction.Repository
 
 	userSvc  *user.Service
@@ -30,7 +37,9 @@ var (
 	// permissionSvc  *permission.Service
 	loanrequestSvc *loanrequests.Service
 	loanSvc        *loan.Service
-	// transferSvc    *transfer.Service
+	ledgerSvc      *ledger.Service
+	accountSvc     *account.Service
+	transferSvc    *transfer.Service
 	// transactionSvc *transaction.Service
 
 	user1 *user.User
@@ -49,6 +58,11 @@ func TestMain(m *testing.M) {
 		log.Fatalf("failed to connect to test DB: %v", err)
 	}
 
+	ledgerRepo = &ledger.Repository{DB: testDB}
+	ledgerSvc = &ledger.Service{Repo: ledgerRepo}
+	accountRepo = &account.Repository{DB: testDB}
+	accountSvc = &account.Service{Repo: accountRepo}
+
 	resetDB()
 
 	code := m.Run()
@@ -58,8 +72,20 @@ func TestMain(m *testing.M) {
 
 func resetDB() {
 	query := `
-		TRUNCATE loans, deleted_loans, loan_requests, permissions, users_permissions, tokens, 
-			transactions, transfers, users RESTART IDENTITY CASCADE
+		TRUNCATE loan_installments, loans, deleted_loans, loan_requests, users_roles,
+			tokens, transactions, transfers, ledger_postings, ledger_entries,
+			accounts, job_runs, login_failures, role_grant_events, pending_operations,
+			notification_preferences, outbox_messages, notifications, notification_phones,
+			webhook_deliveries, webhook_endpoints, audit_events, users
+			RESTART IDENTITY CASCADE;
+
+		-- the catalog and roles seeded by the migrations are kept, only what tests added goes
+		DELETE FROM roles
+		WHERE name NOT IN ('teller', 'loan_officer', 'loan_manager', 'admin', 'superuser');
+		DELETE FROM permissions
+		WHERE code NOT IN (
+			'APPROVE_LOANS', 'DELETE_LOANS', 'ADMIN', 'SUPERUSER', 'DEPOSIT', 'WITHDRAW'
+		);
 	`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -69,6 +95,36 @@ func resetDB() {
 	}
 }
 
+// openAccount opens a current account in the default currency for the user
+func openAccount(u *user.User) *account.Account {
+	a, err := accountSvc.Open(
+		context.Background(), validator.New(), u.ID, account.TypeCurrent, money.DefaultCurrency,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return a
+}
+
+// seedBalance deposits the amount into the account. balances can only be changed through the
+// ledger, so inserting an account with a balance is not enough
+func seedBalance(a *account.Account, amount money.Amount) {
+	entry := ledger.Move(
+		ledger.KindDeposit, "test seed", ledger.AccountCash, ledger.CustomerAccount(a.ID),
+		amount.WithCurrency(a.Currency),
+	)
+	err := ledgerSvc.Post(context.Background(), validator.New(), entry)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a.Balance, err = a.Balance.Add(amount)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func checkErr(t *testing.T, got, expected error, msg string) bool {
 	if expected != nil {
 		if got != nil && got.Error() != expected.Error() {
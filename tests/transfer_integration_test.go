@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/ledger"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
+	"github.com/Yusufdot101/goBankBackend/internal/transfer"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+)
+
+// TestConcurrentTransfers sends money back and forth between two accounts at the same time. the
+// transfers lock the accounts in the same order, so none of them should deadlock, and no money
+// should be created or destroyed along the way
+func TestConcurrentTransfers(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	userSvc = &user.Service{Repo: userRepo}
+	transferRepo = &transfer.Repository{DB: testDB}
+	transferSvc = &transfer.Service{
+		Repo:           transferRepo,
+		AccountService: accountSvc,
+	}
+
+	users := []*user.User{
+		{Name: "yusuf", Email: "y@gmail.com"},
+		{Name: "mohamed", Email: "m@gmail.com"},
+	}
+	accounts := []*account.Account{}
+	for _, u := range users {
+		u.Password.Set("12345678", 12)
+		err := userRepo.Insert(context.Background(), u)
+		if err != nil {
+			t.Fatalf("Insert: unexpected error %v", err)
+		}
+		a := openAccount(u)
+		seedBalance(a, money.MustParse("100"))
+		accounts = append(accounts, a)
+	}
+
+	const transfersPerUser = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*transfersPerUser)
+	for i := range users {
+		from, fromAccount, toAccount := users[i], accounts[i], accounts[1-i]
+		for range transfersPerUser {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := transferSvc.TransferMoney(
+					context.Background(), validator.New(), from, fromAccount.Number, toAccount.Number,
+					money.MustParse("1.25"),
+				)
+				errs <- err
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("TransferMoney: unexpected error %v", err)
+		}
+	}
+
+	total := money.Amount{}
+	for _, a := range accounts {
+		balance, err := ledgerSvc.Reconcile(context.Background(), a.ID)
+		if err != nil {
+			t.Fatalf("Reconcile: unexpected error %v", err)
+		}
+
+		total, err = total.Add(balance)
+		if err != nil {
+			t.Fatalf("Add: unexpected error %v", err)
+		}
+	}
+
+	if want := money.MustParse("200"); total.Cmp(want) != 0 {
+		t.Errorf("expected total balance=%s, got total balance=%s", want, total)
+	}
+
+	// every transfer has to be on the ledger as well, and the other way round
+	for _, a := range accounts {
+		entries, err := ledgerSvc.History(context.Background(), ledger.CustomerAccount(a.ID))
+		if err != nil {
+			t.Fatalf("History: unexpected error %v", err)
+		}
+
+		// the seed deposit plus one entry for every transfer in or out
+		if want := 1 + 2*transfersPerUser; len(entries) != want {
+			t.Errorf("expected %d ledger entries, got %d", want, len(entries))
+		}
+	}
+}
+
+// TestListTransfers pages through the transfers of a user, in and out, with and without filters
+func TestListTransfers(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	transferRepo = &transfer.Repository{DB: testDB}
+	transferSvc = &transfer.Service{
+		Repo:           transferRepo,
+		AccountService: accountSvc,
+	}
+
+	sender := &user.User{Name: "yusuf", Email: "y@gmail.com"}
+	receiver := &user.User{Name: "mohamed", Email: "m@gmail.com"}
+	for _, u := range []*user.User{sender, receiver} {
+		u.Password.Set("12345678", 12)
+		if err := userRepo.Insert(context.Background(), u); err != nil {
+			t.Fatalf("Insert: unexpected error %v", err)
+		}
+	}
+	from, to := openAccount(sender), openAccount(receiver)
+	seedBalance(from, money.MustParse("100"))
+
+	// five transfers of 1, 2, 3, 4 and 5
+	for i := range 5 {
+		_, _, err := transferSvc.TransferMoney(
+			context.Background(), validator.New(), sender, from.Number, to.Number, money.New(
+				int64(i+1)*100, "USD",
+			),
+		)
+		if err != nil {
+			t.Fatalf("TransferMoney: unexpected error %v", err)
+		}
+	}
+
+	minAmount := money.MustParse("2")
+	tests := []struct {
+		name         string
+		userID       int64
+		filters      filter.Filters
+		wantAmounts  []string
+		wantMetadata filter.Metadata
+	}{
+		{
+			name:        "first page, newest first",
+			userID:      sender.ID,
+			filters:     filter.Filters{Page: 1, PageSize: 2, Sort: "-created_at"},
+			wantAmounts: []string{"5.00", "4.00"},
+			wantMetadata: filter.Metadata{
+				CurrentPage: 1, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5,
+			},
+		},
+		{
+			name:        "last page",
+			userID:      sender.ID,
+			filters:     filter.Filters{Page: 3, PageSize: 2, Sort: "-created_at"},
+			wantAmounts: []string{"1.00"},
+			wantMetadata: filter.Metadata{
+				CurrentPage: 3, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5,
+			},
+		},
+		{
+			name:   "min amount, smallest first",
+			userID: sender.ID,
+			filters: filter.Filters{
+				Page: 1, PageSize: 20, Sort: "amount", MinAmount: &minAmount,
+			},
+			wantAmounts: []string{"2.00", "3.00", "4.00", "5.00"},
+			wantMetadata: filter.Metadata{
+				CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 4,
+			},
+		},
+		{
+			name:   "sender has nothing coming in",
+			userID: sender.ID,
+			filters: filter.Filters{
+				Page: 1, PageSize: 20, Sort: "id", Direction: filter.DirectionIn,
+			},
+			wantAmounts:  []string{},
+			wantMetadata: filter.Metadata{},
+		},
+		{
+			name:   "receiver sees them coming in",
+			userID: receiver.ID,
+			filters: filter.Filters{
+				Page: 1, PageSize: 20, Sort: "id", Direction: filter.DirectionIn,
+			},
+			wantAmounts: []string{"1.00", "2.00", "3.00", "4.00", "5.00"},
+			wantMetadata: filter.Metadata{
+				CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 5,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.filters.SortSafelist = transfer.SortSafelist
+			transfers, metadata, err := transferSvc.GetAllForUser(
+				context.Background(), validator.New(), tc.userID, tc.filters,
+			)
+			if err != nil {
+				t.Fatalf("GetAllForUser: unexpected error %v", err)
+			}
+
+			if len(transfers) != len(tc.wantAmounts) {
+				t.Fatalf("expected %d transfers, got %d", len(tc.wantAmounts), len(transfers))
+			}
+			for i, tr := range transfers {
+				if tr.Amount.String() != tc.wantAmounts[i] {
+					t.Errorf("expected amount=%s, got amount=%s", tc.wantAmounts[i], tr.Amount)
+				}
+			}
+			if metadata != tc.wantMetadata {
+				t.Errorf("expected metadata=%+v, got metadata=%+v", tc.wantMetadata, metadata)
+			}
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package tests
 
 import (
+	"context"
 	"testing"
 
+	"github.com/Yusufdot101/goBankBackend/internal/account"
+	"github.com/Yusufdot101/goBankBackend/internal/money"
 	"github.com/Yusufdot101/goBankBackend/internal/token"
+	"github.com/Yusufdot101/goBankBackend/internal/transfer"
 	"github.com/Yusufdot101/goBankBackend/internal/user"
 	"github.com/Yusufdot101/goBankBackend/internal/validator"
 
@@ -23,6 +27,11 @@ func TestUser(t *testing.T) {
 		Repo:         userRepo,
 		TokenService: tokenSvc,
 	}
+	transferRepo = &transfer.Repository{DB: testDB}
+	transferSvc = &transfer.Service{
+		Repo:           transferRepo,
+		AccountService: accountSvc,
+	}
 
 	user1 = &user.User{
 		ID:    1,
@@ -32,16 +41,18 @@ func TestUser(t *testing.T) {
 	user1.Password.Set("12345678", 12)
 
 	user2 = &user.User{
-		ID:             2,
-		Name:           "mohamed",
-		Email:          "m@gmail.com",
-		AccountBalance: 100, // needed to make the tranfer money in the test
+		ID:    2,
+		Name:  "mohamed",
+		Email: "m@gmail.com",
 	}
 	user2.Password.Set("12345678", 12)
 
-	setupUserSevice := func(us *user.Service, user *user.User) {
+	setupUserSevice := func(us *user.Service, user *user.User) *account.Account {
 		// seed the users table, this will be used in transferring of money
-		us.Repo.Insert(user)
+		us.Repo.Insert(context.Background(), user)
+		a := openAccount(user)
+		seedBalance(a, money.MustParse("100")) // needed to make the tranfer money in the test
+		return a
 	}
 
 	tests := []struct {
@@ -51,7 +62,7 @@ func TestUser(t *testing.T) {
 			v              *validator.Validator
 			user, fromUser *user.User
 			userPassword   string
-			amount         float64
+			amount         money.Amount
 		}
 		expectedErr error
 	}{
@@ -65,12 +76,12 @@ func TestUser(t *testing.T) {
 				user         *user.User
 				fromUser     *user.User
 				userPassword string
-				amount       float64
+				amount       money.Amount
 			}{
 				user:         user1,
 				userPassword: "12345678",
 				fromUser:     user2,
-				amount:       100,
+				amount:       money.MustParse("100"),
 			},
 		},
 		{
@@ -81,12 +92,12 @@ func TestUser(t *testing.T) {
 				user         *user.User
 				fromUser     *user.User
 				userPassword string
-				amount       float64
+				amount       money.Amount
 			}{
 				user:         user1,
 				fromUser:     user2,
 				userPassword: "12345678",
-				amount:       100,
+				amount:       money.MustParse("100"),
 			},
 			expectedErr: user.ErrDuplicateEmail,
 		},
@@ -98,14 +109,14 @@ func TestUser(t *testing.T) {
 			v := validator.New()
 			// step 1: register the user
 			_, tkn, gotErr := userSvc.Register(
-				v, tc.input.user.Name, tc.input.user.Email, tc.input.userPassword,
+				context.Background(), v, tc.input.user.Name, tc.input.user.Email, tc.input.userPassword,
 			)
 			if !checkErr(t, gotErr, tc.expectedErr, "Register") {
 				return
 			}
 
 			// fetch and check the user
-			gotUser, gotErr := userSvc.GetUser(tc.input.user.ID)
+			gotUser, gotErr := userSvc.GetUser(context.Background(), tc.input.user.ID)
 			if !checkErr(t, gotErr, tc.expectedErr, "GetUser") {
 				return
 			}
@@ -114,7 +125,7 @@ func TestUser(t *testing.T) {
 			}
 
 			// fetch the user by email and check
-			gotUser, gotErr = userSvc.GetUserByEmail(tc.input.user.Email)
+			gotUser, gotErr = userSvc.GetUserByEmail(context.Background(), tc.input.user.Email)
 			if !checkErr(t, gotErr, tc.expectedErr, "GetUser") {
 				return
 			}
@@ -123,13 +134,13 @@ func TestUser(t *testing.T) {
 			}
 
 			// step 2: activate the account
-			_, gotErr = userSvc.Activate(tkn.Plaintext)
+			_, gotErr = userSvc.Activate(context.Background(), tkn.Plaintext)
 			if !checkErr(t, gotErr, tc.expectedErr, "Activate") {
 				return
 			}
 
 			// fetch and check the user
-			gotUser, gotErr = userSvc.GetUser(tc.input.user.ID)
+			gotUser, gotErr = userSvc.GetUser(context.Background(), tc.input.user.ID)
 			if !checkErr(t, gotErr, tc.expectedErr, "GetUser 2") {
 				return
 			}
@@ -137,25 +148,27 @@ func TestUser(t *testing.T) {
 				return
 			}
 
-			// step 3: transfer money into the user account
+			// step 3: transfer money into an account of the user
 			// add new account to transfer from
-			setupUserSevice(userSvc, user2)
-			gotUser, gotErr = userSvc.TransferMoney(tc.input.fromUser, tc.input.user, tc.input.amount)
+			fromAccount := setupUserSevice(userSvc, user2)
+			toAccount := openAccount(tc.input.user)
+			_, gotAccount, gotErr := transferSvc.TransferMoney(
+				context.Background(), v, tc.input.fromUser, fromAccount.Number, toAccount.Number,
+				tc.input.amount,
+			)
 			if !checkErr(t, gotErr, tc.expectedErr, "TransferMoney") {
 				return
 			}
-			if !checkFromUserAfterTransfer(t, gotUser, tc.input.fromUser, "TransferMoney") {
+			if !checkAccountBalance(t, gotAccount, money.MustParse("0"), "TransferMoney") {
 				return
 			}
 
-			// fetch and check the user
-			gotUser, gotErr = userSvc.GetUser(tc.input.user.ID)
-			if !checkErr(t, gotErr, tc.expectedErr, "GetUser 3") {
+			// fetch and check the account of the user
+			gotAccount, gotErr = accountSvc.GetAccount(context.Background(), toAccount.ID)
+			if !checkErr(t, gotErr, tc.expectedErr, "GetAccount") {
 				return
 			}
-			if !checkToUserAfterTransfer(
-				t, gotUser, tc.input.user, tc.input.amount, "TransferMoney 2",
-			) {
+			if !checkAccountBalance(t, gotAccount, tc.input.amount, "TransferMoney 2") {
 				return
 			}
 
@@ -194,29 +207,181 @@ func checkActivatedUser(t *testing.T, got, expected *user.User, msg string) bool
 	return passed
 }
 
-func checkFromUserAfterTransfer(
-	t *testing.T, got, expected *user.User, msg string,
-) bool {
-	passed := checkUser(t, got, expected, msg)
-	if got.AccountBalance != 0 {
-		t.Errorf(
-			"%s: expected user account balance=0, got account balance=%f", msg, got.AccountBalance,
-		)
-		passed = false
+func checkAccountBalance(t *testing.T, got *account.Account, want money.Amount, msg string) bool {
+	if got.Balance.Cmp(want) != 0 {
+		t.Errorf("%s: expected account balance=%s, got account balance=%s", msg, want, got.Balance)
+		return false
 	}
-	return passed
+	return true
 }
 
-func checkToUserAfterTransfer(
-	t *testing.T, got, expected *user.User, amount float64, msg string,
-) bool {
-	passed := checkUser(t, got, expected, msg)
-	if got.AccountBalance != amount {
-		t.Errorf(
-			"%s: expected user account balance=%f, got account balance=%f", msg,
-			amount, got.AccountBalance,
-		)
-		passed = false
+func TestPasswordReset(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	tokenRepo = &token.Repository{DB: testDB}
+	tokenSvc = &token.Service{Repo: tokenRepo}
+	userSvc = &user.Service{
+		Repo:         userRepo,
+		TokenService: tokenSvc,
 	}
-	return passed
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+	authToken, refreshToken, err := tokenSvc.AuthorizationToken(
+		context.Background(), u.ID, "127.0.0.1", "go-test",
+	)
+	if err != nil {
+		t.Fatalf("AuthorizationToken: unexpected error %v", err)
+	}
+
+	// an unknown email is not an error, there is just nothing to send
+	_, resetToken, err := userSvc.RequestPasswordReset(
+		context.Background(), validator.New(), "nobody@gmail.com",
+	)
+	if err != nil || resetToken != nil {
+		t.Fatalf("expected no token and no error for an unknown email, got %v, %v", resetToken, err)
+	}
+
+	_, resetToken, err = userSvc.RequestPasswordReset(context.Background(), validator.New(), u.Email)
+	if err != nil {
+		t.Fatalf("RequestPasswordReset: unexpected error %v", err)
+	}
+
+	_, err = userSvc.ResetPassword(
+		context.Background(), validator.New(), resetToken.Plaintext, "new-password",
+	)
+	if err != nil {
+		t.Fatalf("ResetPassword: unexpected error %v", err)
+	}
+
+	got, err := userRepo.Get(context.Background(), u.ID)
+	if err != nil {
+		t.Fatalf("Get: unexpected error %v", err)
+	}
+	if matches, _ := got.Password.Matches("new-password"); !matches {
+		t.Errorf("expected the new password to be set")
+	}
+
+	// the old session is signed out and the reset token can't be used again
+	_, err = userRepo.GetForToken(context.Background(), authToken.Plaintext, token.ScopeAuthorization)
+	checkErr(t, err, user.ErrNoRecord, "GetForToken")
+	_, _, err = tokenSvc.Refresh(context.Background(), refreshToken.Plaintext, "127.0.0.1", "go-test")
+	checkErr(t, err, token.ErrInvaildToken, "Refresh")
+
+	_, err = userSvc.ResetPassword(
+		context.Background(), validator.New(), resetToken.Plaintext, "another-password",
+	)
+	checkErr(t, err, validator.ErrFailedValidation, "ResetPassword")
+}
+
+func TestSessions(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	tokenRepo = &token.Repository{DB: testDB}
+	tokenSvc = &token.Service{Repo: tokenRepo}
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+
+	laptop, _, err := tokenSvc.AuthorizationToken(context.Background(), u.ID, "10.0.0.1", "laptop")
+	if err != nil {
+		t.Fatalf("AuthorizationToken: unexpected error %v", err)
+	}
+	phone, _, err := tokenSvc.AuthorizationToken(context.Background(), u.ID, "10.0.0.2", "phone")
+	if err != nil {
+		t.Fatalf("AuthorizationToken: unexpected error %v", err)
+	}
+	if err = tokenSvc.Touch(context.Background(), phone.Plaintext, "10.0.0.3", "phone"); err != nil {
+		t.Fatalf("Touch: unexpected error %v", err)
+	}
+
+	sessions, err := tokenSvc.Sessions(context.Background(), u.ID, laptop.Plaintext)
+	if err != nil {
+		t.Fatalf("Sessions: unexpected error %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+	// the phone was used last so it comes first
+	if sessions[0].IP != "10.0.0.3" || sessions[0].LastUsedAt == nil || sessions[0].Current {
+		t.Errorf("expected the phone session used from 10.0.0.3 first, got %+v", sessions[0])
+	}
+	if sessions[1].UserAgent != "laptop" || !sessions[1].Current {
+		t.Errorf("expected the laptop session marked current, got %+v", sessions[1])
+	}
+
+	if err = tokenSvc.Revoke(context.Background(), u.ID, laptop.Plaintext); err != nil {
+		t.Fatalf("Revoke: unexpected error %v", err)
+	}
+	err = tokenSvc.Revoke(context.Background(), u.ID, laptop.Plaintext)
+	checkErr(t, err, token.ErrInvaildToken, "Revoke")
+
+	if err = tokenSvc.RevokeAll(context.Background(), u.ID); err != nil {
+		t.Fatalf("RevokeAll: unexpected error %v", err)
+	}
+	sessions, err = tokenSvc.Sessions(context.Background(), u.ID, "")
+	if err != nil {
+		t.Fatalf("Sessions: unexpected error %v", err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("expected no sessions left, got %d", len(sessions))
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	resetDB()
+
+	userRepo = &user.Repository{DB: testDB}
+	tokenRepo = &token.Repository{DB: testDB}
+	tokenSvc = &token.Service{Repo: tokenRepo}
+
+	u := &user.User{Name: "yusuf", Email: "y@gmail.com", Activated: true}
+	u.Password.Set("12345678", 12)
+	if err := userRepo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("Insert: unexpected error %v", err)
+	}
+
+	access, refresh, err := tokenSvc.AuthorizationToken(
+		context.Background(), u.ID, "10.0.0.1", "laptop",
+	)
+	if err != nil {
+		t.Fatalf("AuthorizationToken: unexpected error %v", err)
+	}
+
+	newAccess, newRefresh, err := tokenSvc.Refresh(
+		context.Background(), refresh.Plaintext, "10.0.0.1", "laptop",
+	)
+	if err != nil {
+		t.Fatalf("Refresh: unexpected error %v", err)
+	}
+	if newAccess.FamilyID != access.FamilyID || newRefresh.FamilyID != access.FamilyID {
+		t.Errorf("expected the new tokens to stay in family %d", access.FamilyID)
+	}
+
+	// the access token the refresh token was issued with is replaced
+	_, err = userRepo.GetForToken(context.Background(), access.Plaintext, token.ScopeAuthorization)
+	checkErr(t, err, user.ErrNoRecord, "GetForToken")
+	got, err := userRepo.GetForToken(
+		context.Background(), newAccess.Plaintext, token.ScopeAuthorization,
+	)
+	if err != nil || got.ID != u.ID {
+		t.Fatalf("expected the new access token to sign in user %d, got %v, %v", u.ID, got, err)
+	}
+
+	// using the first refresh token again means it was stolen, the whole session is signed out
+	_, _, err = tokenSvc.Refresh(context.Background(), refresh.Plaintext, "10.6.6.6", "thief")
+	checkErr(t, err, token.ErrTokenReused, "Refresh")
+
+	_, err = userRepo.GetForToken(context.Background(), newAccess.Plaintext, token.ScopeAuthorization)
+	checkErr(t, err, user.ErrNoRecord, "GetForToken")
+	_, _, err = tokenSvc.Refresh(context.Background(), newRefresh.Plaintext, "10.0.0.1", "laptop")
+	checkErr(t, err, token.ErrInvaildToken, "Refresh")
 }
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Yusufdot101/goBankBackend/internal/filter"
+	"github.com/Yusufdot101/goBankBackend/internal/user"
+	"github.com/Yusufdot101/goBankBackend/internal/validator"
+	"github.com/Yusufdot101/goBankBackend/internal/webhook"
+)
+
+func TestWebhooks(t *testing.T) {
+	resetDB()
+
+	webhookRepo := &webhook.Repository{DB: testDB}
+	svc := webhook.Service{Repo: webhookRepo}
+	userSvc := &user.Service{Repo: &user.Repository{DB: testDB}}
+
+	admin, _, err := userSvc.Register(
+		context.Background(), validator.New(), "yusuf", "y@gmail.com", "12345678",
+	)
+	if err != nil {
+		t.Fatalf("Register: unexpected error %v", err)
+	}
+
+	endpoint := &webhook.Endpoint{
+		CreatedBy: admin.ID,
+		URL:       "https://crm.example.com/hooks",
+		Events:    []string{webhook.EventTransfer, webhook.EventDeposit},
+	}
+	_, err = svc.CreateEndpoint(context.Background(), validator.New(), endpoint)
+	if err != nil {
+		t.Fatalf("CreateEndpoint: unexpected error %v", err)
+	}
+
+	// an endpoint that isn't active, or isn't subscribed to the event, is sent nothing
+	inactive := &webhook.Endpoint{
+		CreatedBy: admin.ID,
+		URL:       "https://erp.example.com/hooks",
+		Events:    []string{webhook.EventTransfer},
+	}
+	_, err = svc.CreateEndpoint(context.Background(), validator.New(), inactive)
+	if err != nil {
+		t.Fatalf("CreateEndpoint: unexpected error %v", err)
+	}
+	active := false
+	_, err = svc.UpdateEndpoint(
+		context.Background(), validator.New(), inactive.ID, webhook.EndpointUpdate{Active: &active},
+	)
+	if err != nil {
+		t.Fatalf("UpdateEndpoint: unexpected error %v", err)
+	}
+
+	tx, err := testDB.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("BeginTx: unexpected error %v", err)
+	}
+	queued, err := webhook.PublishTx(
+		context.Background(), tx, webhook.EventTransfer, map[string]any{"transfer_id": 1},
+	)
+	if err != nil || queued != 1 {
+		t.Fatalf("expected the transfer queued for one endpoint, got %d, %v", queued, err)
+	}
+	queued, err = webhook.PublishTx(
+		context.Background(), tx, webhook.EventLoanPayment, map[string]any{"loan_id": 1},
+	)
+	if err != nil || queued != 0 {
+		t.Fatalf("expected the loan payment queued for no one, got %d, %v", queued, err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Commit: unexpected error %v", err)
+	}
+
+	// deliveries queued in a transaction that is rolled back go with it
+	tx, err = testDB.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("BeginTx: unexpected error %v", err)
+	}
+	queued, err = webhook.PublishTx(
+		context.Background(), tx, webhook.EventDeposit, map[string]any{"transaction_id": 1},
+	)
+	if err != nil || queued != 1 {
+		t.Fatalf("expected the deposit queued for one endpoint, got %d, %v", queued, err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("Rollback: unexpected error %v", err)
+	}
+
+	// the claimed delivery carries where to send it and what to sign it with
+	now := time.Now()
+	claimed, err := webhookRepo.Claim(context.Background(), 10, now, time.Minute)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("expected the transfer claimed, got %v, %v", claimed, err)
+	}
+	delivery := claimed[0]
+	if delivery.URL != endpoint.URL || delivery.Secret != endpoint.Secret ||
+		delivery.Event != webhook.EventTransfer || delivery.Attempts != 1 {
+		t.Errorf("expected the delivery to %s, got %+v", endpoint.URL, delivery)
+	}
+	claimed, err = webhookRepo.Claim(context.Background(), 10, now, time.Minute)
+	if err != nil || len(claimed) != 0 {
+		t.Errorf("expected nothing left to claim, got %v, %v", claimed, err)
+	}
+
+	// a pending delivery can't be replayed, a dead one can
+	_, err = svc.Replay(context.Background(), delivery.ID)
+	checkErr(t, err, webhook.ErrNotFinished, "Replay")
+	_, err = svc.Replay(context.Background(), delivery.ID+100)
+	checkErr(t, err, user.ErrNoRecord, "Replay")
+
+	err = webhookRepo.MarkFailed(
+		context.Background(), delivery.ID, 500, "endpoint responded 500", true, now,
+	)
+	if err != nil {
+		t.Fatalf("MarkFailed: unexpected error %v", err)
+	}
+
+	f := filter.Filters{
+		Page: 1, PageSize: 10, Sort: "id", SortSafelist: webhook.SortSafelist,
+	}
+	dead, _, err := svc.GetAllDeliveries(
+		context.Background(), validator.New(), endpoint.ID, webhook.StatusDead, "", f,
+	)
+	if err != nil || len(dead) != 1 || dead[0].ResponseStatus != 500 {
+		t.Fatalf("expected the delivery dead, got %v, %v", dead, err)
+	}
+
+	replay, err := svc.Replay(context.Background(), delivery.ID)
+	if err != nil {
+		t.Fatalf("Replay: unexpected error %v", err)
+	}
+	if replay.Status != webhook.StatusPending || replay.ReplayOf == nil ||
+		*replay.ReplayOf != delivery.ID {
+		t.Fatalf("expected a pending replay of %d, got %+v", delivery.ID, replay)
+	}
+
+	claimed, err = webhookRepo.Claim(context.Background(), 10, now, time.Minute)
+	if err != nil || len(claimed) != 1 || string(claimed[0].Payload) != string(delivery.Payload) {
+		t.Fatalf("expected the replay claimed with the same payload, got %v, %v", claimed, err)
+	}
+	err = webhookRepo.MarkDelivered(context.Background(), claimed[0].ID, 204, time.Now())
+	if err != nil {
+		t.Fatalf("MarkDelivered: unexpected error %v", err)
+	}
+
+	// deleting the endpoint takes its deliveries with it
+	err = svc.DeleteEndpoint(context.Background(), endpoint.ID)
+	if err != nil {
+		t.Fatalf("DeleteEndpoint: unexpected error %v", err)
+	}
+	all, _, err := svc.GetAllDeliveries(context.Background(), validator.New(), 0, "", "", f)
+	if err != nil || len(all) != 0 {
+		t.Errorf("expected no deliveries left, got %v, %v", all, err)
+	}
+	err = svc.DeleteEndpoint(context.Background(), endpoint.ID)
+	checkErr(t, err, user.ErrNoRecord, "DeleteEndpoint")
+}